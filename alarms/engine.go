@@ -0,0 +1,423 @@
+package alarms
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"iot-gateway/topics"
+
+	MQTT "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	checkInterval  = time.Second // Prüfintervall für Stale-Regeln, Verzögerungen und Shelving
+	valueQueueSize = 1000        // Gepufferte Werte zwischen MQTT-Callback und Auswertung
+)
+
+// dataValue ist ein empfangener Wert auf data/#
+type dataValue struct {
+	topic   string
+	payload []byte
+}
+
+var (
+	mu       sync.Mutex
+	db       *sql.DB
+	server   *MQTT.Server
+	states   = make(map[int64]*ruleState)    // Regel-ID -> Zustand
+	bySource = make(map[string][]*ruleState) // Source -> Regeln
+	stopChan chan struct{}
+)
+
+// StartAlarmEngine lädt die Regeln, stellt offene Alarme wieder her und wertet ab dann alle
+// Werte auf data/# aus.
+func StartAlarmEngine(dbF *sql.DB, serverF *MQTT.Server) {
+	mu.Lock()
+	db = dbF
+	server = serverF
+	mu.Unlock()
+
+	if err := ReloadRules(); err != nil {
+		logrus.Errorf("ALARM: Error loading alarm rules: %v", err)
+	}
+
+	// Die Auswertung (inkl. DB-Zugriffen) läuft im Worker, damit der Publisher nicht blockiert wird
+	values := make(chan dataValue, valueQueueSize)
+	stop := make(chan struct{})
+	if err := server.Subscribe("data/#", topics.AlarmSubscriptionID, func(cl *MQTT.Client, sub packets.Subscription, pk packets.Packet) {
+		select {
+		case values <- dataValue{topic: pk.TopicName, payload: pk.Payload}:
+		default:
+			logrus.Warnf("ALARM: Queue full, dropping value on %s", pk.TopicName)
+		}
+	}); err != nil {
+		logrus.Errorf("ALARM: Error subscribing to topic data/#: %v", err)
+		return
+	}
+
+	mu.Lock()
+	stopChan = stop
+	mu.Unlock()
+	go valueWorker(values, stop)
+	go checkLoop(stop)
+
+	logrus.Info("ALARM: Alarm engine started.")
+}
+
+// StopAlarmEngine beendet die Auswertung
+func StopAlarmEngine() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if server != nil {
+		server.Unsubscribe("data/#", topics.AlarmSubscriptionID)
+	}
+	if stopChan != nil {
+		close(stopChan)
+		stopChan = nil
+	}
+	logrus.Info("ALARM: Alarm engine stopped.")
+}
+
+// engineDB liefert die Datenbank der gestarteten Engine für Aufrufe außerhalb von mu
+func engineDB() (*sql.DB, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if db == nil {
+		return nil, fmt.Errorf("alarm engine not started")
+	}
+	return db, nil
+}
+
+// ReloadRules liest die Regeln neu ein. Der Zustand unveränderter Regeln bleibt erhalten,
+// offene Alarme gelöschter oder deaktivierter Regeln werden beendet.
+func ReloadRules() error {
+	conn, err := engineDB()
+	if err != nil {
+		return err
+	}
+
+	rules, err := loadRules(conn)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	newStates := make(map[int64]*ruleState, len(rules))
+	newBySource := make(map[string][]*ruleState)
+	for _, rule := range rules {
+		st, ok := states[rule.ID]
+		if !ok {
+			st = &ruleState{lastUpdate: now}
+		}
+		// Bei geänderter Quelle oder Art beginnt die Auswertung neu
+		if ok && (st.rule.Source != rule.Source || st.rule.RuleType != rule.RuleType) {
+			st.hasLast = false
+			st.pendingSince = time.Time{}
+			st.lastUpdate = now
+		}
+		st.rule = rule
+		newStates[rule.ID] = st
+		newBySource[rule.Source] = append(newBySource[rule.Source], st)
+	}
+
+	// Offene Alarme aus der Datenbank übernehmen (z.B. nach einem Neustart)
+	open, err := loadOpenAlarms(conn)
+	if err != nil {
+		return err
+	}
+	for _, alarm := range open {
+		st, ok := newStates[alarm.RuleID]
+		if !ok {
+			clearAlarm(&ruleState{alarmID: alarm.ID, rule: Rule{ID: alarm.RuleID, Source: alarm.Source}}, now)
+			continue
+		}
+		st.alarmID = alarm.ID
+		st.alarmState = alarm.State
+	}
+
+	states = newStates
+	bySource = newBySource
+	logrus.Infof("ALARM: %d alarm rules loaded.", len(rules))
+	return nil
+}
+
+// valueWorker wertet die empfangenen Werte nacheinander aus
+func valueWorker(values chan dataValue, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case v := <-values:
+			handleValue(v.topic, v.payload)
+		}
+	}
+}
+
+// handleValue wertet einen Wert gegen alle Regeln der Quelle aus
+func handleValue(topic string, payload []byte) {
+	source := topics.DataSource(topic)
+	if source == "" {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	rules := bySource[source]
+	if len(rules) == 0 {
+		return
+	}
+
+	value := parseSample(payload)
+	now := time.Now()
+	for _, st := range rules {
+		evaluate(st, value, now)
+	}
+}
+
+// evaluate prüft die Bedingung einer Regel und steuert Aktivierung (mit Verzögerung) und Rücksetzen
+func evaluate(st *ruleState, value sample, now time.Time) {
+	rule := &st.rule
+	st.lastUpdate = now
+	active := st.alarmID != 0
+
+	var condition bool
+	switch rule.RuleType {
+	case RuleHigh:
+		if !value.isNumber {
+			return
+		}
+		limit := rule.Setpoint
+		if active {
+			limit -= rule.Hysteresis
+		}
+		condition = value.number > limit
+	case RuleLow:
+		if !value.isNumber {
+			return
+		}
+		limit := rule.Setpoint
+		if active {
+			limit += rule.Hysteresis
+		}
+		condition = value.number < limit
+	case RuleRateOfChange:
+		if !value.isNumber {
+			return
+		}
+		if st.hasLast {
+			dt := now.Sub(st.lastTime).Seconds()
+			if dt > 0 {
+				rate := math.Abs(value.number-st.lastValue) / dt
+				limit := rule.Setpoint
+				if active {
+					limit -= rule.Hysteresis
+				}
+				condition = rate > limit
+			}
+		}
+		st.lastValue, st.lastTime, st.hasLast = value.number, now, true
+	case RuleBoolean:
+		if !value.isBool {
+			return
+		}
+		condition = value.boolean == rule.ExpectedState
+	case RuleStale:
+		// Ein neuer Wert beendet einen Stale-Alarm
+		condition = false
+	}
+
+	applyCondition(st, condition, value.raw, now)
+}
+
+// applyCondition setzt das Ergebnis einer Auswertung um
+func applyCondition(st *ruleState, condition bool, value string, now time.Time) {
+	if !condition {
+		st.pendingSince = time.Time{}
+		if st.alarmID != 0 {
+			clearAlarm(st, now)
+		}
+		return
+	}
+
+	if st.alarmID != 0 {
+		return
+	}
+	if st.pendingSince.IsZero() {
+		st.pendingSince = now
+	}
+	st.pendingValue = value
+	if now.Sub(st.pendingSince) >= time.Duration(st.rule.DelaySeconds)*time.Second {
+		activateAlarm(st, value, now)
+	}
+}
+
+// checkLoop prüft periodisch Stale-Regeln, abgelaufene Verzögerungen und Shelving
+func checkLoop(stop chan struct{}) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			mu.Lock()
+			for _, st := range states {
+				switch {
+				case st.rule.RuleType == RuleStale:
+					if now.Sub(st.lastUpdate) >= time.Duration(st.rule.StaleSeconds)*time.Second {
+						applyCondition(st, true, fmt.Sprintf("no update since %s", st.lastUpdate.Format(time.RFC3339)), now)
+					}
+				case !st.pendingSince.IsZero() && st.alarmID == 0:
+					applyCondition(st, true, st.pendingValue, now)
+				}
+
+				// Abgelaufenes Shelving aufheben und den vorherigen Zustand wiederherstellen
+				if st.rule.ShelvedUntil != nil && !st.rule.isShelved(now) {
+					if err := unshelve(st); err != nil {
+						logrus.Errorf("ALARM: Error unshelving rule %s: %v", st.rule.Name, err)
+					}
+				}
+			}
+			mu.Unlock()
+		}
+	}
+}
+
+// activateAlarm legt einen neuen Alarm an, sofern die Regel nicht zurückgestellt ist
+func activateAlarm(st *ruleState, value string, now time.Time) {
+	if st.rule.isShelved(now) {
+		return
+	}
+
+	message := st.rule.Message
+	if message == "" {
+		message = fmt.Sprintf("%s: %s alarm on %s", st.rule.Name, st.rule.RuleType, st.rule.Source)
+	}
+
+	alarm := Alarm{
+		RuleID:      st.rule.ID,
+		RuleName:    st.rule.Name,
+		Source:      st.rule.Source,
+		State:       StateActive,
+		Severity:    st.rule.Severity,
+		Message:     message,
+		Value:       value,
+		ActivatedAt: now,
+	}
+	id, err := insertAlarm(db, &alarm)
+	if err != nil {
+		logrus.Errorf("ALARM: Error storing alarm for rule %s: %v", st.rule.Name, err)
+		return
+	}
+	alarm.ID = id
+
+	st.alarmID = id
+	st.alarmState = StateActive
+	st.pendingSince = time.Time{}
+	logrus.Warnf("ALARM: %s (value: %s)", message, value)
+	publishAlarm(&alarm)
+}
+
+// clearAlarm beendet den offenen Alarm einer Regel
+func clearAlarm(st *ruleState, now time.Time) {
+	if err := updateAlarmCleared(db, st.alarmID, now); err != nil {
+		logrus.Errorf("ALARM: Error clearing alarm %d: %v", st.alarmID, err)
+	}
+	if alarm, err := getAlarm(db, st.alarmID); err == nil {
+		publishAlarm(alarm)
+	}
+	logrus.Infof("ALARM: Alarm %d for rule %s cleared.", st.alarmID, st.rule.Name)
+	st.alarmID = 0
+	st.alarmState = ""
+}
+
+// setAlarmState ändert den Zustand des offenen Alarms einer Regel und veröffentlicht ihn
+func setAlarmState(st *ruleState, state string) {
+	if err := updateAlarmState(db, st.alarmID, state); err != nil {
+		logrus.Errorf("ALARM: Error updating alarm %d: %v", st.alarmID, err)
+		return
+	}
+	st.alarmState = state
+	if alarm, err := getAlarm(db, st.alarmID); err == nil {
+		publishAlarm(alarm)
+	}
+}
+
+// unshelve hebt das Zurückstellen einer Regel auf. Ein zurückgestellter Alarm kehrt in den
+// Zustand vor dem Shelving zurück (quittiert, falls er bereits quittiert war, sonst aktiv).
+func unshelve(st *ruleState) error {
+	if err := clearRuleShelved(db, st.rule.ID); err != nil {
+		return err
+	}
+	st.rule.ShelvedUntil = nil
+	if st.alarmID == 0 || st.alarmState != StateShelved {
+		return nil
+	}
+
+	state := StateActive
+	if alarm, err := getAlarm(db, st.alarmID); err == nil && alarm.AcknowledgedAt != nil {
+		state = StateAcknowledged
+	}
+	setAlarmState(st, state)
+	return nil
+}
+
+// publishAlarm veröffentlicht den aktuellen Zustand eines Alarms auf alarms/<source>/<ruleId>
+func publishAlarm(alarm *Alarm) {
+	if server == nil {
+		return
+	}
+	payload, err := json.Marshal(alarm)
+	if err != nil {
+		logrus.Errorf("ALARM: Error marshalling alarm %d: %v", alarm.ID, err)
+		return
+	}
+	topic := fmt.Sprintf("alarms/%s/%d", alarm.Source, alarm.RuleID)
+	if err := server.Publish(topic, payload, true, 1); err != nil {
+		logrus.Errorf("ALARM: Error publishing alarm %d: %v", alarm.ID, err)
+	}
+}
+
+// parseSample interpretiert einen JSON-Payload als Zahl oder Boolean
+func parseSample(payload []byte) sample {
+	s := sample{raw: strings.TrimSpace(string(payload))}
+
+	var decoded interface{}
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		decoded = s.raw
+	}
+
+	switch v := decoded.(type) {
+	case float64:
+		s.number, s.isNumber = v, true
+		s.boolean, s.isBool = v != 0, true
+	case bool:
+		s.boolean, s.isBool = v, true
+		if v {
+			s.number = 1
+		}
+		s.isNumber = true
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			s.number, s.isNumber = f, true
+		}
+		if b, err := strconv.ParseBool(v); err == nil {
+			s.boolean, s.isBool = b, true
+		}
+	}
+	return s
+}
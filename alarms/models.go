@@ -0,0 +1,118 @@
+package alarms
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Regeltypen
+const (
+	RuleHigh         = "high"
+	RuleLow          = "low"
+	RuleRateOfChange = "rate_of_change"
+	RuleStale        = "stale"
+	RuleBoolean      = "boolean"
+)
+
+// Alarmzustände
+const (
+	StateActive       = "active"
+	StateAcknowledged = "acknowledged"
+	StateShelved      = "shelved"
+	StateCleared      = "cleared"
+)
+
+var (
+	ErrAlarmNotFound = errors.New("alarm not found")
+	ErrRuleNotFound  = errors.New("alarm rule not found")
+	ErrInvalidState  = errors.New("alarm is not in a valid state for this action")
+	ErrInvalidRule   = errors.New("invalid alarm rule")
+)
+
+// Rule beschreibt eine Alarmregel auf einem Datenpunkt.
+// Source hat die Form <type>/<deviceId>/<datapointId>, z.B. "s7/3/10030001".
+type Rule struct {
+	ID            int64      `json:"id"`
+	Name          string     `json:"name"`
+	Source        string     `json:"source"`
+	RuleType      string     `json:"ruleType"`
+	Setpoint      float64    `json:"setpoint"`
+	Hysteresis    float64    `json:"hysteresis"`
+	DelaySeconds  int        `json:"delaySeconds"`
+	StaleSeconds  int        `json:"staleSeconds"`
+	ExpectedState bool       `json:"expectedState"`
+	Severity      int        `json:"severity"`
+	Message       string     `json:"message"`
+	Enabled       bool       `json:"enabled"`
+	ShelvedUntil  *time.Time `json:"shelvedUntil,omitempty"`
+}
+
+// Alarm ist ein einzelnes Auftreten einer Regelverletzung mit seinem Lebenszyklus.
+type Alarm struct {
+	ID             int64      `json:"id"`
+	RuleID         int64      `json:"ruleId"`
+	RuleName       string     `json:"ruleName,omitempty"`
+	Source         string     `json:"source"`
+	State          string     `json:"state"`
+	Severity       int        `json:"severity"`
+	Message        string     `json:"message"`
+	Value          string     `json:"value"`
+	ActivatedAt    time.Time  `json:"activatedAt"`
+	AcknowledgedAt *time.Time `json:"acknowledgedAt,omitempty"`
+	AcknowledgedBy string     `json:"acknowledgedBy,omitempty"`
+	AckComment     string     `json:"ackComment,omitempty"`
+	ShelvedUntil   *time.Time `json:"shelvedUntil,omitempty"`
+	ClearedAt      *time.Time `json:"clearedAt,omitempty"`
+}
+
+// Validate prüft eine Regel auf vollständige Angaben
+func (r *Rule) Validate() error {
+	if r.Name == "" || r.Source == "" {
+		return fmt.Errorf("%w: %s", ErrInvalidRule, "name and source are required")
+	}
+	switch r.RuleType {
+	case RuleHigh, RuleLow, RuleBoolean:
+	case RuleRateOfChange:
+		if r.Setpoint <= 0 {
+			return fmt.Errorf("%w: %s", ErrInvalidRule, "rate_of_change requires a positive setpoint (change per second)")
+		}
+	case RuleStale:
+		if r.StaleSeconds <= 0 {
+			return fmt.Errorf("%w: %s", ErrInvalidRule, "stale requires staleSeconds > 0")
+		}
+	default:
+		return fmt.Errorf("%w: unknown rule type %q", ErrInvalidRule, r.RuleType)
+	}
+	if r.Hysteresis < 0 || r.DelaySeconds < 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidRule, "hysteresis and delaySeconds must not be negative")
+	}
+	return nil
+}
+
+// isShelved prüft, ob die Regel aktuell zurückgestellt ist
+func (r *Rule) isShelved(now time.Time) bool {
+	return r.ShelvedUntil != nil && now.Before(*r.ShelvedUntil)
+}
+
+// ruleState hält den Auswertungszustand einer Regel im Speicher
+type ruleState struct {
+	rule         Rule
+	pendingSince time.Time // Bedingung erfüllt, Verzögerung läuft
+	pendingValue string
+	alarmID      int64 // Offener Alarm (0 = keiner)
+	alarmState   string
+	lastValue    float64
+	lastTime     time.Time
+	hasLast      bool
+	lastUpdate   time.Time
+}
+
+// sample ist ein geparster Wert aus einem data/#-Payload
+type sample struct {
+	number   float64
+	isNumber bool
+	boolean  bool
+	isBool   bool
+	raw      string
+}
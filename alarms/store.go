@@ -0,0 +1,340 @@
+package alarms
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const alarmColumns = `a.id, a.rule_id, COALESCE(r.name, ''), a.source, a.state, a.severity, a.message, a.value, a.activated_at,
+	a.acknowledged_at, a.acknowledged_by, a.ack_comment, a.shelved_until, a.cleared_at`
+
+// loadRules liest alle aktiven Regeln
+func loadRules(db *sql.DB) ([]Rule, error) {
+	return queryRules(db, `WHERE enabled = 1`)
+}
+
+// ListRules liefert alle Regeln
+func ListRules() ([]Rule, error) {
+	conn, err := engineDB()
+	if err != nil {
+		return nil, err
+	}
+	return queryRules(conn, "")
+}
+
+// queryRules liest Regeln mit optionaler WHERE-Klausel
+func queryRules(db *sql.DB, where string, args ...interface{}) ([]Rule, error) {
+	rows, err := db.Query(`SELECT id, name, source, rule_type, COALESCE(setpoint, 0), COALESCE(hysteresis, 0), COALESCE(delay_seconds, 0),
+		COALESCE(stale_seconds, 0), COALESCE(expected_state, 1), COALESCE(severity, 500), COALESCE(message, ''), enabled, shelved_until
+		FROM alarm_rules `+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []Rule{}
+	for rows.Next() {
+		var rule Rule
+		var shelvedUntil sql.NullString
+		if err := rows.Scan(&rule.ID, &rule.Name, &rule.Source, &rule.RuleType, &rule.Setpoint, &rule.Hysteresis, &rule.DelaySeconds,
+			&rule.StaleSeconds, &rule.ExpectedState, &rule.Severity, &rule.Message, &rule.Enabled, &shelvedUntil); err != nil {
+			return nil, err
+		}
+		rule.ShelvedUntil = parseTime(shelvedUntil)
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// CreateRule legt eine Regel an und lädt die Regeln neu
+func CreateRule(rule *Rule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	conn, err := engineDB()
+	if err != nil {
+		return err
+	}
+	now := formatTime(time.Now())
+	res, err := conn.Exec(`INSERT INTO alarm_rules (name, source, rule_type, setpoint, hysteresis, delay_seconds, stale_seconds, expected_state, severity, message, enabled, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rule.Name, rule.Source, rule.RuleType, rule.Setpoint, rule.Hysteresis, rule.DelaySeconds, rule.StaleSeconds,
+		rule.ExpectedState, rule.Severity, rule.Message, rule.Enabled, now, now)
+	if err != nil {
+		return err
+	}
+	rule.ID, _ = res.LastInsertId()
+	return ReloadRules()
+}
+
+// UpdateRule ändert eine Regel und lädt die Regeln neu
+func UpdateRule(rule *Rule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	conn, err := engineDB()
+	if err != nil {
+		return err
+	}
+	res, err := conn.Exec(`UPDATE alarm_rules SET name = ?, source = ?, rule_type = ?, setpoint = ?, hysteresis = ?, delay_seconds = ?, stale_seconds = ?,
+		expected_state = ?, severity = ?, message = ?, enabled = ?, updated_at = ? WHERE id = ?`,
+		rule.Name, rule.Source, rule.RuleType, rule.Setpoint, rule.Hysteresis, rule.DelaySeconds, rule.StaleSeconds,
+		rule.ExpectedState, rule.Severity, rule.Message, rule.Enabled, formatTime(time.Now()), rule.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrRuleNotFound
+	}
+	return ReloadRules()
+}
+
+// DeleteRule löscht eine Regel. Offene Alarme der Regel werden beim Neuladen beendet.
+func DeleteRule(id int64) error {
+	conn, err := engineDB()
+	if err != nil {
+		return err
+	}
+	res, err := conn.Exec(`DELETE FROM alarm_rules WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrRuleNotFound
+	}
+	return ReloadRules()
+}
+
+// ListAlarms liefert Alarme, optional gefiltert nach Zustand ("open" = alle nicht beendeten)
+func ListAlarms(state string, limit int) ([]Alarm, error) {
+	where := ""
+	var args []interface{}
+	switch state {
+	case "":
+	case "open":
+		where = "WHERE a.state != ?"
+		args = append(args, StateCleared)
+	default:
+		where = "WHERE a.state = ?"
+		args = append(args, state)
+	}
+	args = append(args, limit)
+
+	conn, err := engineDB()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := conn.Query(`SELECT `+alarmColumns+` FROM alarms a LEFT JOIN alarm_rules r ON r.id = a.rule_id `+where+` ORDER BY a.activated_at DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alarms := []Alarm{}
+	for rows.Next() {
+		alarm, err := scanAlarm(rows)
+		if err != nil {
+			return nil, err
+		}
+		alarms = append(alarms, *alarm)
+	}
+	return alarms, rows.Err()
+}
+
+// AcknowledgeAlarm quittiert einen aktiven oder zurückgestellten Alarm
+func AcknowledgeAlarm(id int64, user, comment string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	alarm, err := getAlarm(db, id)
+	if err != nil {
+		return err
+	}
+	if alarm.State != StateActive && alarm.State != StateShelved {
+		return ErrInvalidState
+	}
+
+	_, err = db.Exec(`UPDATE alarms SET state = ?, acknowledged_at = ?, acknowledged_by = ?, ack_comment = ? WHERE id = ?`,
+		StateAcknowledged, formatTime(time.Now()), user, comment, id)
+	if err != nil {
+		return err
+	}
+
+	if st, ok := states[alarm.RuleID]; ok && st.alarmID == id {
+		st.alarmState = StateAcknowledged
+	}
+	if alarm, err = getAlarm(db, id); err == nil {
+		publishAlarm(alarm)
+	}
+	logrus.Infof("ALARM: Alarm %d acknowledged by %s", id, user)
+	return nil
+}
+
+// ShelveAlarm stellt einen offenen Alarm und seine Regel für die angegebene Dauer zurück.
+// Während dieser Zeit werden für die Regel keine neuen Alarme ausgelöst.
+func ShelveAlarm(id int64, duration time.Duration, user string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	alarm, err := getAlarm(db, id)
+	if err != nil {
+		return err
+	}
+	if alarm.State == StateCleared {
+		return ErrInvalidState
+	}
+
+	until := time.Now().Add(duration)
+	if _, err := db.Exec(`UPDATE alarms SET state = ?, shelved_until = ? WHERE id = ?`, StateShelved, formatTime(until), id); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`UPDATE alarm_rules SET shelved_until = ? WHERE id = ?`, formatTime(until), alarm.RuleID); err != nil {
+		return err
+	}
+
+	if st, ok := states[alarm.RuleID]; ok {
+		st.rule.ShelvedUntil = &until
+		if st.alarmID == id {
+			st.alarmState = StateShelved
+		}
+	}
+	if alarm, err = getAlarm(db, id); err == nil {
+		publishAlarm(alarm)
+	}
+	logrus.Infof("ALARM: Alarm %d shelved by %s until %s", id, user, until.Format(time.RFC3339))
+	return nil
+}
+
+// UnshelveAlarm hebt das Zurückstellen eines Alarms und seiner Regel auf
+func UnshelveAlarm(id int64) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	alarm, err := getAlarm(db, id)
+	if err != nil {
+		return err
+	}
+	if alarm.State != StateShelved {
+		return ErrInvalidState
+	}
+
+	st, ok := states[alarm.RuleID]
+	if ok && st.alarmID == id {
+		return unshelve(st)
+	}
+
+	// Regel nicht geladen (z.B. deaktiviert): nur die Datensätze aktualisieren
+	if err := clearRuleShelved(db, alarm.RuleID); err != nil {
+		return err
+	}
+	state := StateActive
+	if alarm.AcknowledgedAt != nil {
+		state = StateAcknowledged
+	}
+	return updateAlarmState(db, id, state)
+}
+
+// loadOpenAlarms liest alle nicht beendeten Alarme
+func loadOpenAlarms(db *sql.DB) ([]Alarm, error) {
+	rows, err := db.Query(`SELECT `+alarmColumns+` FROM alarms a LEFT JOIN alarm_rules r ON r.id = a.rule_id WHERE a.state != ?`, StateCleared)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alarms []Alarm
+	for rows.Next() {
+		alarm, err := scanAlarm(rows)
+		if err != nil {
+			return nil, err
+		}
+		alarms = append(alarms, *alarm)
+	}
+	return alarms, rows.Err()
+}
+
+// getAlarm liest einen Alarm
+func getAlarm(db *sql.DB, id int64) (*Alarm, error) {
+	rows, err := db.Query(`SELECT `+alarmColumns+` FROM alarms a LEFT JOIN alarm_rules r ON r.id = a.rule_id WHERE a.id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, ErrAlarmNotFound
+	}
+	return scanAlarm(rows)
+}
+
+// insertAlarm speichert einen neuen Alarm
+func insertAlarm(db *sql.DB, alarm *Alarm) (int64, error) {
+	res, err := db.Exec(`INSERT INTO alarms (rule_id, source, state, severity, message, value, activated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		alarm.RuleID, alarm.Source, alarm.State, alarm.Severity, alarm.Message, alarm.Value, formatTime(alarm.ActivatedAt))
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// updateAlarmState setzt den Zustand eines Alarms (Shelving wird dabei aufgehoben)
+func updateAlarmState(db *sql.DB, id int64, state string) error {
+	_, err := db.Exec(`UPDATE alarms SET state = ?, shelved_until = NULL WHERE id = ?`, state, id)
+	return err
+}
+
+// clearRuleShelved hebt das Zurückstellen einer Regel auf
+func clearRuleShelved(db *sql.DB, ruleID int64) error {
+	_, err := db.Exec(`UPDATE alarm_rules SET shelved_until = NULL WHERE id = ?`, ruleID)
+	return err
+}
+
+// updateAlarmCleared beendet einen Alarm
+func updateAlarmCleared(db *sql.DB, id int64, now time.Time) error {
+	_, err := db.Exec(`UPDATE alarms SET state = ?, cleared_at = ? WHERE id = ?`, StateCleared, formatTime(now), id)
+	return err
+}
+
+// scanAlarm liest eine Zeile mit alarmColumns
+func scanAlarm(rows *sql.Rows) (*Alarm, error) {
+	var alarm Alarm
+	var severity sql.NullInt64
+	var message, value, activatedAt, ackedAt, ackedBy, ackComment, shelvedUntil, clearedAt sql.NullString
+	if err := rows.Scan(&alarm.ID, &alarm.RuleID, &alarm.RuleName, &alarm.Source, &alarm.State, &severity, &message, &value, &activatedAt,
+		&ackedAt, &ackedBy, &ackComment, &shelvedUntil, &clearedAt); err != nil {
+		return nil, err
+	}
+
+	alarm.Severity = int(severity.Int64)
+	alarm.Message = message.String
+	alarm.Value = value.String
+	if t := parseTime(activatedAt); t != nil {
+		alarm.ActivatedAt = *t
+	}
+	alarm.AcknowledgedAt = parseTime(ackedAt)
+	alarm.AcknowledgedBy = ackedBy.String
+	alarm.AckComment = ackComment.String
+	alarm.ShelvedUntil = parseTime(shelvedUntil)
+	alarm.ClearedAt = parseTime(clearedAt)
+	return &alarm, nil
+}
+
+// formatTime speichert Zeitpunkte einheitlich als RFC3339 in UTC
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// parseTime liest einen gespeicherten Zeitpunkt (NULL -> nil)
+func parseTime(value sql.NullString) *time.Time {
+	if !value.Valid || strings.TrimSpace(value.String) == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value.String)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,90 @@
+package dataforwarding
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFluxString(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`temp`, `"temp"`},
+		{`a"b`, `"a\"b"`},
+		{`a\b`, `"a\\b"`},
+		{`a\"b`, `"a\\\"b"`},
+		{`${token}`, `"\${token}"`},
+		{`$1 and $ {x}`, `"$1 and $ {x}"`},
+		{"line\nbreak\r\ttab", `"line\nbreak\r\ttab"`},
+		// Ein Ausbruch aus dem Literal bleibt Teil des Strings
+		{`") |> drop() //`, `"\") |> drop() //"`},
+		{`\") |> yield() //`, `"\\\") |> yield() //"`},
+		{`x" + string(v: ${secret}) + "`, `"x\" + string(v: \${secret}) + \""`},
+	}
+	for _, tt := range tests {
+		got := FluxString(tt.in)
+		if got != tt.want {
+			t.Errorf("FluxString(%q) = %s, want %s", tt.in, got, tt.want)
+			continue
+		}
+		if unquoted, ok := unquoteFlux(got); !ok || unquoted != tt.in {
+			t.Errorf("FluxString(%q) = %s does not round-trip (got %q, closed=%v)", tt.in, got, unquoted, ok)
+		}
+	}
+}
+
+// unquoteFlux liest ein Flux-String-Literal wie der Flux-Parser. ok ist false, wenn das Literal
+// vorzeitig endet, eine Interpolation ${ enthält oder nach dem schließenden " weiterer Text folgt.
+func unquoteFlux(s string) (string, bool) {
+	if len(s) < 2 || s[0] != '"' {
+		return "", false
+	}
+	var out []byte
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			i++
+			if i >= len(s) {
+				return "", false
+			}
+			switch s[i] {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			default:
+				out = append(out, s[i])
+			}
+		case '"':
+			return string(out), i == len(s)-1
+		case '$':
+			if i+1 < len(s) && s[i+1] == '{' {
+				return "", false
+			}
+			out = append(out, c)
+		default:
+			out = append(out, c)
+		}
+	}
+	return "", false
+}
+
+func TestFluxDuration(t *testing.T) {
+	tests := []struct {
+		in   time.Duration
+		want string
+	}{
+		{48 * time.Hour, "2d"},
+		{90 * time.Minute, "90m"},
+		{time.Hour, "1h"},
+		{1500 * time.Millisecond, "1500ms"},
+		{-30 * 24 * time.Hour, "-30d"},
+	}
+	for _, tt := range tests {
+		if got := FluxDuration(tt.in); got != tt.want {
+			t.Errorf("FluxDuration(%v) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,524 @@
+package dataforwarding
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+	"github.com/sirupsen/logrus"
+)
+
+// Einstellungen (system_settings) der Bucket-Verwaltung
+const (
+	SettingManageBuckets   = "influxdb_manage_buckets"
+	SettingRetentionDays   = "influxdb_retention_days"
+	SettingDownsampling    = "influxdb_downsampling"
+	SettingMinuteRetention = "influxdb_downsampling_1m_days"
+	SettingHourRetention   = "influxdb_downsampling_1h_days"
+)
+
+// downsamplingTaskPrefix kennzeichnet die vom Gateway verwalteten Flux-Tasks
+const downsamplingTaskPrefix = "iot-gateway downsampling "
+
+// downsamplingStartsTTL begrenzt das Alter der zwischengespeicherten Startzeitpunkte der Tasks
+const downsamplingStartsTTL = 5 * time.Minute
+
+// RetentionConfig enthält die Einstellungen zu Aufbewahrung und Downsampling in der InfluxDB
+type RetentionConfig struct {
+	Manage          bool          // Buckets und Tasks vom Gateway verwalten
+	Retention       time.Duration // Aufbewahrung der Rohdaten, 0 = unbegrenzt
+	RetentionSet    bool          // influxdb_retention_days gesetzt, sonst bleibt ein bestehender Bucket unverändert
+	Downsampling    bool          // 1-Minuten- und 1-Stunden-Buckets befüllen
+	MinuteRetention time.Duration
+	HourRetention   time.Duration
+}
+
+// BucketTier ist ein Bucket einer Auflösung: die Rohdaten (Every = 0) oder eine Downsampling-Stufe
+type BucketTier struct {
+	Bucket    string
+	Every     time.Duration
+	Retention time.Duration // 0 = unbegrenzt
+	Since     time.Time     // Downsampling-Stufen: Start des Tasks, ältere Zeiträume fehlen; leer = keine Daten
+}
+
+// Resolution liefert die Bezeichnung der Auflösung (raw, 1m, 1h)
+func (t BucketTier) Resolution() string {
+	if t.Every == 0 {
+		return "raw"
+	}
+	return FluxDuration(t.Every)
+}
+
+// BucketStatus ist der Zustand eines verwalteten Buckets
+type BucketStatus struct {
+	Name                string      `json:"name"`
+	ID                  string      `json:"id,omitempty"`
+	Resolution          string      `json:"resolution"`
+	Exists              bool        `json:"exists"`
+	Retention           int64       `json:"retentionSeconds"`                     // Laut InfluxDB, 0 = unbegrenzt
+	ConfiguredRetention *int64      `json:"configuredRetentionSeconds,omitempty"` // Laut Einstellungen, fehlt bei abgeschalteten Stufen
+	SizeBytes           *int64      `json:"sizeBytes,omitempty"`                  // Belegter Speicher, falls bekannt
+	Task                *TaskStatus `json:"task,omitempty"`
+}
+
+// TaskStatus ist der Zustand eines Downsampling-Tasks
+type TaskStatus struct {
+	ID              string     `json:"id"`
+	Name            string     `json:"name"`
+	Status          string     `json:"status"`
+	LastRunStatus   string     `json:"lastRunStatus,omitempty"`
+	LastRunError    string     `json:"lastRunError,omitempty"`
+	LatestCompleted *time.Time `json:"latestCompleted,omitempty"`
+	CreatedAt       *time.Time `json:"createdAt,omitempty"` // Ab hier enthält der Bucket der Stufe Daten
+}
+
+// RetentionStatus ist der Zustand der Bucket-Verwaltung
+type RetentionStatus struct {
+	Managed        bool           `json:"managed"`
+	Downsampling   bool           `json:"downsampling"`
+	Buckets        []BucketStatus `json:"buckets"`
+	SizeError      string         `json:"sizeError,omitempty"`
+	LastApply      *time.Time     `json:"lastApply,omitempty"`
+	LastApplyError string         `json:"lastApplyError,omitempty"`
+}
+
+var (
+	retentionMu    sync.Mutex // Nur ein Abgleich gleichzeitig
+	lastApply      time.Time
+	lastApplyError string
+)
+
+// downsamplingStarts speichert die Startzeitpunkte der Downsampling-Tasks je Bucket zwischen
+var downsamplingStarts struct {
+	sync.Mutex
+	starts map[string]time.Time
+	loaded time.Time
+}
+
+// LoadRetentionConfig lädt die Einstellungen aus system_settings, fehlende oder ungültige Werte
+// werden durch die Standardwerte ersetzt
+func LoadRetentionConfig(db *sql.DB) RetentionConfig {
+	config := RetentionConfig{
+		Retention:       7 * 24 * time.Hour,
+		MinuteRetention: 90 * 24 * time.Hour,
+		HourRetention:   5 * 365 * 24 * time.Hour,
+	}
+
+	if val := getSetting(db, SettingManageBuckets); val != "" {
+		config.Manage = val == "true"
+	}
+	if val := getSetting(db, SettingDownsampling); val != "" {
+		config.Downsampling = val == "true"
+	}
+	if val := getSetting(db, SettingRetentionDays); val != "" {
+		if intVal, err := strconv.Atoi(val); err == nil && intVal >= 0 {
+			config.Retention = time.Duration(intVal) * 24 * time.Hour
+			config.RetentionSet = true
+		}
+	}
+	for key, target := range map[string]*time.Duration{
+		SettingMinuteRetention: &config.MinuteRetention,
+		SettingHourRetention:   &config.HourRetention,
+	} {
+		if val := getSetting(db, key); val != "" {
+			if intVal, err := strconv.Atoi(val); err == nil && intVal >= 0 {
+				*target = time.Duration(intVal) * 24 * time.Hour
+			}
+		}
+	}
+	return config
+}
+
+func getSetting(db *sql.DB, key string) string {
+	var value sql.NullString
+	db.QueryRow("SELECT setting_value FROM system_settings WHERE setting_key = ?", key).Scan(&value)
+	return strings.TrimSpace(value.String)
+}
+
+// Tiers liefert die Buckets von fein nach grob. Ohne Verwaltung durch das Gateway ist nur der Bucket der
+// Rohdaten mit unbekannter (unbegrenzter) Aufbewahrung bekannt.
+func (c RetentionConfig) Tiers(bucket string) []BucketTier {
+	if !c.Manage {
+		return []BucketTier{{Bucket: bucket}}
+	}
+	tiers := []BucketTier{{Bucket: bucket, Retention: c.Retention}}
+	if c.Downsampling {
+		tiers = append(tiers,
+			BucketTier{Bucket: bucket + "_1m", Every: time.Minute, Retention: c.MinuteRetention},
+			BucketTier{Bucket: bucket + "_1h", Every: time.Hour, Retention: c.HourRetention},
+		)
+	}
+	return tiers
+}
+
+// downsamplingFlux erzeugt den Task, der die Rohdaten eines Fensters in den Bucket einer Stufe schreibt.
+// Er fasst jeweils die letzten drei Fenster neu zusammen, damit auch kurz verspätete bzw. nachgeholte
+// Werte enthalten sind; die Punkte werden dabei überschrieben. Je Fenster entstehen die Felder mean,
+// min, max (nur Zahlen), count und last.
+func downsamplingFlux(name, source string, tier BucketTier) string {
+	return fmt.Sprintf(`import "types"
+
+option task = {name: %s, every: %s, offset: %s}
+
+data = from(bucket: %s)
+	|> range(start: -%s)
+	|> filter(fn: (r) => r._field == "value")
+
+numbers = data
+	|> filter(fn: (r) => not types.isType(v: r._value, type: "string"))
+	|> toFloat()
+
+downsample = (tables=<-, fn, field) => tables
+	|> aggregateWindow(every: task.every, fn: fn, createEmpty: false, timeSrc: "_start")
+	|> set(key: "_field", value: field)
+	|> to(bucket: %s)
+
+numbers |> downsample(fn: mean, field: "mean")
+numbers |> downsample(fn: min, field: "min")
+numbers |> downsample(fn: max, field: "max")
+data |> downsample(fn: count, field: "count")
+data |> downsample(fn: last, field: "last")
+`, FluxString(name), FluxDuration(tier.Every), FluxDuration(tier.Every/4), FluxString(source),
+		FluxDuration(3*tier.Every), FluxString(tier.Bucket))
+}
+
+// ApplyRetention gleicht die Buckets und Downsampling-Tasks mit den Einstellungen ab: fehlende Buckets
+// werden mit ihrer Aufbewahrung angelegt, abweichende Aufbewahrungen angepasst (beim Rohdaten-Bucket nur bei
+// gesetztem influxdb_retention_days) und die Tasks angelegt,
+// aktualisiert oder bei abgeschaltetem Downsampling gelöscht. Die Downsampling-Buckets bleiben dabei
+// erhalten, bis ihre Daten abgelaufen sind.
+func ApplyRetention(ctx context.Context, db *sql.DB) error {
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+
+	err := applyRetention(ctx, db)
+	lastApply, lastApplyError = time.Now(), ""
+	if err != nil {
+		lastApplyError = err.Error()
+		logrus.Errorf("InfluxDB: Abgleich der Buckets fehlgeschlagen: %v", err)
+	}
+	return err
+}
+
+func applyRetention(ctx context.Context, db *sql.DB) error {
+	config := LoadRetentionConfig(db)
+	if !config.Manage {
+		logrus.Info("InfluxDB: Buckets werden nicht vom Gateway verwaltet")
+		return nil
+	}
+	influx, err := GetInfluxConfig(db)
+	if err != nil {
+		return err
+	}
+	client := influxdb2.NewClient(influx.URL, influx.Token)
+	defer client.Close()
+
+	org, err := client.OrganizationsAPI().FindOrganizationByName(ctx, influx.Org)
+	if err != nil {
+		return fmt.Errorf("organization %s: %v", influx.Org, err)
+	}
+	buckets, err := findBuckets(ctx, client, *org.Id)
+	if err != nil {
+		return err
+	}
+
+	// Die Rohdaten-Aufbewahrung eines bestehenden Buckets nur bei ausdrücklich gesetzter Einstellung ändern,
+	// die Downsampling-Buckets gehören dem Gateway
+	for _, tier := range config.Tiers(influx.Bucket) {
+		if err := ensureBucket(ctx, client, org, buckets, tier, tier.Every > 0 || config.RetentionSet); err != nil {
+			return err
+		}
+	}
+
+	// Tasks aller Stufen, auch bei abgeschaltetem Downsampling, um sie zu löschen
+	defer resetDownsamplingStarts()
+	all := RetentionConfig{Manage: true, Downsampling: true}.Tiers(influx.Bucket)
+	for _, tier := range all[1:] {
+		name := downsamplingTaskPrefix + tier.Bucket
+		if config.Downsampling {
+			err = ensureTask(ctx, client, *org.Id, name, downsamplingFlux(name, influx.Bucket, tier))
+		} else {
+			err = removeTask(ctx, client, *org.Id, name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DownsamplingStarts liefert je Downsampling-Bucket den Anlagezeitpunkt seines Tasks. Die Tasks fassen
+// nur laufend die letzten Fenster zusammen, Zeiträume vor dem Anlegen fehlen in den Buckets. Buckets
+// ohne Task fehlen in der Map. Das Ergebnis wird für downsamplingStartsTTL zwischengespeichert.
+func DownsamplingStarts(ctx context.Context, influx *InfluxConfig) (map[string]time.Time, error) {
+	downsamplingStarts.Lock()
+	defer downsamplingStarts.Unlock()
+	if downsamplingStarts.starts != nil && time.Since(downsamplingStarts.loaded) < downsamplingStartsTTL {
+		return downsamplingStarts.starts, nil
+	}
+
+	client := influxdb2.NewClient(influx.URL, influx.Token)
+	defer client.Close()
+	org, err := client.OrganizationsAPI().FindOrganizationByName(ctx, influx.Org)
+	if err != nil {
+		return nil, fmt.Errorf("organization %s: %v", influx.Org, err)
+	}
+
+	starts := make(map[string]time.Time)
+	all := RetentionConfig{Manage: true, Downsampling: true}.Tiers(influx.Bucket)
+	for _, tier := range all[1:] {
+		task, err := findTask(ctx, client, *org.Id, downsamplingTaskPrefix+tier.Bucket)
+		if err != nil {
+			return nil, err
+		}
+		if task != nil && task.CreatedAt != nil {
+			starts[tier.Bucket] = *task.CreatedAt
+		}
+	}
+	downsamplingStarts.starts, downsamplingStarts.loaded = starts, time.Now()
+	return starts, nil
+}
+
+// resetDownsamplingStarts verwirft die zwischengespeicherten Startzeitpunkte nach einem Abgleich
+func resetDownsamplingStarts() {
+	downsamplingStarts.Lock()
+	downsamplingStarts.starts = nil
+	downsamplingStarts.Unlock()
+}
+
+// findBuckets liefert die Buckets der Organisation nach Name
+func findBuckets(ctx context.Context, client influxdb2.Client, orgID string) (map[string]domain.Bucket, error) {
+	list, err := client.BucketsAPI().FindBucketsByOrgID(ctx, orgID, api.PagingWithLimit(100))
+	if err != nil {
+		return nil, fmt.Errorf("list buckets: %v", err)
+	}
+	buckets := make(map[string]domain.Bucket, len(*list))
+	for _, b := range *list {
+		buckets[b.Name] = b
+	}
+	return buckets, nil
+}
+
+// bucketRetention liefert die Aufbewahrung eines Buckets in Sekunden, 0 = unbegrenzt
+func bucketRetention(b domain.Bucket) int64 {
+	for _, rule := range b.RetentionRules {
+		return rule.EverySeconds
+	}
+	return 0
+}
+
+// ensureBucket legt einen fehlenden Bucket an und passt die Aufbewahrung eines bestehenden nur an, wenn
+// update gesetzt ist. Eine Verkürzung löscht die älteren Daten in der InfluxDB und wird daher gewarnt.
+func ensureBucket(ctx context.Context, client influxdb2.Client, org *domain.Organization, buckets map[string]domain.Bucket, tier BucketTier, update bool) error {
+	seconds := int64(tier.Retention / time.Second)
+	bucket, ok := buckets[tier.Bucket]
+	if !ok {
+		if _, err := client.BucketsAPI().CreateBucketWithName(ctx, org, tier.Bucket, domain.RetentionRule{EverySeconds: seconds}); err != nil {
+			return fmt.Errorf("create bucket %s: %v", tier.Bucket, err)
+		}
+		logrus.Infof("InfluxDB: Bucket %s angelegt (Aufbewahrung %s)", tier.Bucket, retentionText(seconds))
+		return nil
+	}
+	current := bucketRetention(bucket)
+	if current == seconds {
+		return nil
+	}
+	if !update {
+		logrus.Infof("InfluxDB: Aufbewahrung von Bucket %s bleibt bei %s (influxdb_retention_days nicht gesetzt)", tier.Bucket, retentionText(current))
+		return nil
+	}
+	if seconds != 0 && (current == 0 || seconds < current) {
+		logrus.Warnf("InfluxDB: Aufbewahrung von Bucket %s wird von %s auf %s verkürzt, ältere Daten werden gelöscht",
+			tier.Bucket, retentionText(current), retentionText(seconds))
+	}
+
+	// Die Shard-Dauer bestimmt die InfluxDB passend zur neuen Aufbewahrung
+	bucket.RetentionRules = domain.RetentionRules{{EverySeconds: seconds}}
+	if _, err := client.BucketsAPI().UpdateBucket(ctx, &bucket); err != nil {
+		return fmt.Errorf("update bucket %s: %v", tier.Bucket, err)
+	}
+	logrus.Infof("InfluxDB: Aufbewahrung von Bucket %s auf %s geändert", tier.Bucket, retentionText(seconds))
+	return nil
+}
+
+func retentionText(seconds int64) string {
+	if seconds == 0 {
+		return "unbegrenzt"
+	}
+	return FluxDuration(time.Duration(seconds) * time.Second)
+}
+
+func findTask(ctx context.Context, client influxdb2.Client, orgID, name string) (*domain.Task, error) {
+	tasks, err := client.TasksAPI().FindTasks(ctx, &api.TaskFilter{OrgID: orgID, Name: name})
+	if err != nil {
+		return nil, fmt.Errorf("find task %s: %v", name, err)
+	}
+	if len(tasks) == 0 {
+		return nil, nil
+	}
+	return &tasks[0], nil
+}
+
+func ensureTask(ctx context.Context, client influxdb2.Client, orgID, name, flux string) error {
+	task, err := findTask(ctx, client, orgID, name)
+	if err != nil {
+		return err
+	}
+	if task == nil {
+		if _, err := client.TasksAPI().CreateTaskByFlux(ctx, flux, orgID); err != nil {
+			return fmt.Errorf("create task %s: %v", name, err)
+		}
+		logrus.Infof("InfluxDB: Task %q angelegt", name)
+		return nil
+	}
+	if task.Flux == flux {
+		return nil
+	}
+	task.Flux = flux
+	if _, err := client.TasksAPI().UpdateTask(ctx, task); err != nil {
+		return fmt.Errorf("update task %s: %v", name, err)
+	}
+	logrus.Infof("InfluxDB: Task %q aktualisiert", name)
+	return nil
+}
+
+func removeTask(ctx context.Context, client influxdb2.Client, orgID, name string) error {
+	task, err := findTask(ctx, client, orgID, name)
+	if err != nil || task == nil {
+		return err
+	}
+	if err := client.TasksAPI().DeleteTask(ctx, task); err != nil {
+		return fmt.Errorf("delete task %s: %v", name, err)
+	}
+	logrus.Infof("InfluxDB: Task %q gelöscht", name)
+	return nil
+}
+
+// GetRetentionStatus liefert Aufbewahrung, Größe und Downsampling-Task der verwalteten Buckets
+func GetRetentionStatus(ctx context.Context, db *sql.DB) (*RetentionStatus, error) {
+	config := LoadRetentionConfig(db)
+	influx, err := GetInfluxConfig(db)
+	if err != nil {
+		return nil, err
+	}
+	client := influxdb2.NewClient(influx.URL, influx.Token)
+	defer client.Close()
+
+	org, err := client.OrganizationsAPI().FindOrganizationByName(ctx, influx.Org)
+	if err != nil {
+		return nil, fmt.Errorf("organization %s: %v", influx.Org, err)
+	}
+	buckets, err := findBuckets(ctx, client, *org.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &RetentionStatus{Managed: config.Manage, Downsampling: config.Downsampling, Buckets: []BucketStatus{}}
+	retentionMu.Lock()
+	if !lastApply.IsZero() {
+		t := lastApply
+		status.LastApply = &t
+	}
+	status.LastApplyError = lastApplyError
+	retentionMu.Unlock()
+
+	sizes, err := bucketSizes(ctx, influx)
+	if err != nil {
+		status.SizeError = err.Error()
+	}
+
+	// Auch abgeschaltete Stufen, deren Buckets noch Daten enthalten
+	all := RetentionConfig{Manage: true, Downsampling: true}.Tiers(influx.Bucket)
+	configured := config.Tiers(influx.Bucket)
+	for i, tier := range all {
+		bucket, exists := buckets[tier.Bucket]
+		if i >= len(configured) && !exists {
+			continue
+		}
+		bs := BucketStatus{Name: tier.Bucket, Resolution: tier.Resolution(), Exists: exists}
+		if i < len(configured) && (i > 0 || config.RetentionSet || !exists) {
+			seconds := int64(configured[i].Retention / time.Second)
+			bs.ConfiguredRetention = &seconds
+		}
+		if exists {
+			bs.ID = *bucket.Id
+			bs.Retention = bucketRetention(bucket)
+			if size, ok := sizes[bs.ID]; ok {
+				bs.SizeBytes = &size
+			}
+		}
+		if tier.Every > 0 {
+			task, err := findTask(ctx, client, *org.Id, downsamplingTaskPrefix+tier.Bucket)
+			if err != nil {
+				return nil, err
+			}
+			if task != nil {
+				bs.Task = taskStatus(task)
+			}
+		}
+		status.Buckets = append(status.Buckets, bs)
+	}
+	return status, nil
+}
+
+func taskStatus(task *domain.Task) *TaskStatus {
+	ts := &TaskStatus{ID: task.Id, Name: task.Name, LatestCompleted: task.LatestCompleted, CreatedAt: task.CreatedAt}
+	if task.Status != nil {
+		ts.Status = string(*task.Status)
+	}
+	if task.LastRunStatus != nil {
+		ts.LastRunStatus = string(*task.LastRunStatus)
+	}
+	if task.LastRunError != nil {
+		ts.LastRunError = *task.LastRunError
+	}
+	return ts
+}
+
+// bucketSizes liest den belegten Speicher je Bucket-ID aus den Prometheus-Metriken der InfluxDB
+// (storage_shard_disk_size, Summe über alle Shards). Die API selbst liefert keine Bucket-Größen.
+func bucketSizes(ctx context.Context, influx *InfluxConfig) (map[string]int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(influx.URL, "/")+"/metrics", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Token "+influx.Token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metrics: HTTP %d", resp.StatusCode)
+	}
+
+	sizes := make(map[string]int64)
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "storage_shard_disk_size{") {
+			continue
+		}
+		end := strings.LastIndex(line, "}")
+		labels, value := line[:end], strings.Fields(line[end+1:])
+		_, id, found := strings.Cut(strings.Replace(labels, "{", ",", 1), `,bucket="`)
+		id, _, closed := strings.Cut(id, `"`)
+		if !found || !closed || len(value) == 0 {
+			continue
+		}
+		if size, err := strconv.ParseFloat(value[0], 64); err == nil {
+			sizes[id] += int64(size)
+		}
+	}
+	return sizes, scanner.Err()
+}
@@ -3,8 +3,8 @@ package dataforwarding
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
-	"math/rand"
 	"os"
 	"strconv"
 	"strings"
@@ -12,6 +12,8 @@ import (
 	"sync/atomic"
 	"time"
 
+	"iot-gateway/topics"
+
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/influxdata/influxdb-client-go/v2/api"
 	"github.com/influxdata/influxdb-client-go/v2/api/write"
@@ -84,6 +86,10 @@ var (
 	// Konfiguration
 	writerConfig *WriterConfig
 
+	// writerMu schützt writerConfig und die Komponenten-Zeiger beim Start und Stop gegenüber
+	// GetWriterStats, das parallel aus /metrics und /readyz aufgerufen wird
+	writerMu sync.RWMutex
+
 	// Topic-Parser-Cache
 	topicCache = &TopicParserCache{
 		cache: make(map[string]*ParsedTopic),
@@ -110,13 +116,17 @@ var (
 		flushOperations   int64
 		avgProcessingTime int64
 		lastFlushTime     time.Time
+		writeLatency      int64 // Dauer des letzten erfolgreichen Flush in μs
+		writeLatencyTotal int64 // Summe aller erfolgreichen Flush-Dauern in μs
+		writeCount        int64 // Anzahl erfolgreicher Flushes
 	}{}
 
 	// InfluxDB Client
-	client         influxdb2.Client
-	writeAPI       api.WriteAPI
-	influxConfig   *InfluxConfig
-	subscriptionID = rand.Intn(100)
+	client                 influxdb2.Client
+	writeAPI               api.WriteAPI
+	influxConfig           *InfluxConfig
+	subscriptionID         = topics.NextSubscriptionID()
+	backfillSubscriptionID = topics.NextSubscriptionID()
 
 	// Worker Pool
 	workerPool chan struct{}
@@ -126,6 +136,11 @@ var (
 	// Selbstheilung
 	lastMessageReceived time.Time
 	healthCheckTicker   *time.Ticker
+
+	// Geordnetes Stoppen
+	writerServer *MQTT.Server
+	writerWg     sync.WaitGroup // Flush-, Metrik- und Health-Check-Goroutinen
+	carryOver    []*write.Point // Punkte, die beim letzten Stop nicht geschrieben werden konnten
 )
 
 // Topic-Parser-Cache Methoden
@@ -138,14 +153,16 @@ func (tpc *TopicParserCache) GetParsedTopic(topic string) *ParsedTopic {
 	tpc.mu.RUnlock()
 
 	// Parse und cache
-	parsed := parseTopic(topic)
+	parsed := ParseTopic(topic)
 	tpc.mu.Lock()
 	tpc.cache[topic] = parsed
 	tpc.mu.Unlock()
 	return parsed
 }
 
-func parseTopic(topic string) *ParsedTopic {
+// ParseTopic zerlegt ein Daten-Topic data/<type>/<deviceId>/[<datapointId>] <name>. Der eingebettete
+// Historian verwendet dieselbe Zuordnung wie der InfluxDB-Writer.
+func ParseTopic(topic string) *ParsedTopic {
 	parts := strings.Split(topic, "/")
 	if len(parts) < 4 {
 		return &ParsedTopic{IsValid: false}
@@ -479,8 +496,13 @@ func processDataPoint(pk packets.Packet) (*write.Point, error) {
 		return nil, fmt.Errorf("ungültiges Topic-Format: %s", pk.TopicName)
 	}
 
+	// Nachgeholte Werte (backfill/...) tragen ihren Quellzeitstempel im Payload
+	if strings.HasPrefix(pk.TopicName, "backfill/") {
+		return processBackfillPoint(parsedTopic, pk.Payload)
+	}
+
 	// Effiziente Payload-Verarbeitung
-	fieldValue := processPayloadOptimized(pk.Payload)
+	fieldValue := ParsePayload(pk.Payload)
 
 	// Erstelle InfluxDB-Punkt
 	point := influxdb2.NewPointWithMeasurement(parsedTopic.Measurement).
@@ -493,8 +515,33 @@ func processDataPoint(pk packets.Packet) (*write.Point, error) {
 	return point, nil
 }
 
-// Optimierte Payload-Verarbeitung
-func processPayloadOptimized(payload []byte) interface{} {
+// processBackfillPoint erstellt einen Punkt aus einem nachgeholten Wert ({"value":..., "timestamp":...}).
+// Das Feld "backfilled" kennzeichnet die Punkte, ohne eine neue Serie zu erzeugen.
+func processBackfillPoint(parsedTopic *ParsedTopic, payload []byte) (*write.Point, error) {
+	var sample struct {
+		Value     json.RawMessage `json:"value"`
+		Timestamp time.Time       `json:"timestamp"`
+	}
+	if err := json.Unmarshal(payload, &sample); err != nil {
+		return nil, fmt.Errorf("ungültiger Backfill-Payload: %v", err)
+	}
+	if sample.Timestamp.IsZero() {
+		return nil, fmt.Errorf("backfill-Payload ohne Zeitstempel")
+	}
+
+	point := influxdb2.NewPointWithMeasurement(parsedTopic.Measurement).
+		AddTag("deviceId", parsedTopic.DeviceID).
+		AddTag("datapointId", parsedTopic.DatapointID).
+		AddField("value", ParsePayload(sample.Value)).
+		AddField("backfilled", true).
+		SetTime(sample.Timestamp)
+
+	atomic.AddInt64(&metrics.processedPoints, 1)
+	return point, nil
+}
+
+// ParsePayload wandelt einen Payload in eine Zahl (float64) oder einen Text um
+func ParsePayload(payload []byte) interface{} {
 	payloadStr := strings.TrimSpace(string(payload))
 
 	// Schnelle Integer-Prüfung
@@ -525,6 +572,8 @@ func flushBufferWithRetry(db *sql.DB) error {
 	}
 
 	return circuitBreaker.Execute(func() error {
+		start := time.Now()
+
 		// Stelle sicher, dass Client verfügbar ist
 		if err := initializeClient(); err != nil {
 			return err
@@ -550,6 +599,10 @@ func flushBufferWithRetry(db *sql.DB) error {
 		}
 
 		systemHealth.RecordSuccess()
+		latency := time.Since(start).Microseconds()
+		atomic.StoreInt64(&metrics.writeLatency, latency)
+		atomic.AddInt64(&metrics.writeLatencyTotal, latency)
+		atomic.AddInt64(&metrics.writeCount, 1)
 		// logrus.Infof("Erfolgreich %d Punkte in InfluxDB geschrieben", len(points))
 		return nil
 	})
@@ -558,7 +611,9 @@ func flushBufferWithRetry(db *sql.DB) error {
 // Selbstheilungs-Mechanismus
 func startSelfHealing() {
 	healthCheckTicker = time.NewTicker(writerConfig.HealthCheckInterval)
+	writerWg.Add(1)
 	go func() {
+		defer writerWg.Done()
 		for {
 			select {
 			case <-healthCheckTicker.C:
@@ -649,11 +704,14 @@ func influxMessageCallback(db *sql.DB) func(client *MQTT.Client, sub packets.Sub
 // Hauptfunktionen
 func StartInfluxDBWriter(db *sql.DB, server *MQTT.Server) {
 	// Lade und validiere Konfiguration
-	writerConfig = LoadConfig()
-	if err := writerConfig.ValidateConfig(); err != nil {
+	config := LoadConfig()
+	if err := config.ValidateConfig(); err != nil {
 		logrus.Fatalf("Ungültige Konfiguration: %v", err)
 	}
 
+	writerMu.Lock()
+	writerConfig = config
+
 	// Initialisiere Komponenten mit Konfiguration
 	adaptiveBuffer = &AdaptiveBuffer{
 		capacity:    writerConfig.InitialBufferCapacity,
@@ -662,15 +720,22 @@ func StartInfluxDBWriter(db *sql.DB, server *MQTT.Server) {
 		points:      make([]*write.Point, 0, writerConfig.InitialBufferCapacity),
 	}
 
+	// Beim letzten Stop nicht geschriebene Punkte werden übernommen
 	retryQueue = &RetryQueue{
 		maxRetries: writerConfig.MaxRetries,
+		queue:      carryOver,
+	}
+	if len(carryOver) > 0 {
+		logrus.Infof("InfluxDB-Writer: %d Punkte aus dem letzten Lauf in die Retry-Queue übernommen", len(carryOver))
 	}
+	carryOver = nil
 
 	circuitBreaker = &CircuitBreaker{
 		threshold: writerConfig.FailureThreshold,
 		timeout:   writerConfig.RecoveryTimeout,
 		state:     Closed,
 	}
+	writerMu.Unlock()
 
 	ctx, cancel = context.WithCancel(context.Background())
 
@@ -684,12 +749,19 @@ func StartInfluxDBWriter(db *sql.DB, server *MQTT.Server) {
 		logrus.Errorf("Fehler beim Initialisieren des InfluxDB-Clients: %v", err)
 	}
 
+	// Buckets und Downsampling-Tasks gemäß den Einstellungen anlegen
+	go ApplyRetention(ctx, db)
+
 	// MQTT-Subscription
+	writerServer = server
 	server.Subscribe("data/#", subscriptionID, influxMessageCallback(db))
+	server.Subscribe("backfill/#", backfillSubscriptionID, influxMessageCallback(db))
 	lastMessageReceived = time.Now()
 
 	// Periodischer Flush für verbleibende Punkte
+	writerWg.Add(2)
 	go func() {
+		defer writerWg.Done()
 		ticker := time.NewTicker(writerConfig.TargetFlushInterval)
 		defer ticker.Stop()
 
@@ -707,6 +779,7 @@ func StartInfluxDBWriter(db *sql.DB, server *MQTT.Server) {
 
 	// Periodisches Logging der Metriken
 	go func() {
+		defer writerWg.Done()
 		metricsTicker := time.NewTicker(60 * time.Second) // Alle 60 Sekunden
 		defer metricsTicker.Stop()
 
@@ -724,27 +797,126 @@ func StartInfluxDBWriter(db *sql.DB, server *MQTT.Server) {
 		writerConfig.WorkerCount, writerConfig.MinBufferCapacity, writerConfig.MaxBufferCapacity)
 }
 
-func StopInfluxDBWriter() {
+// StopInfluxDBWriter beendet die Subscriptions, wartet auf laufende Flushes und schreibt Buffer und
+// Retry-Queue ein letztes Mal. Was dabei nicht geschrieben werden kann, übernimmt der nächste Start.
+func StopInfluxDBWriter(stopCtx context.Context) error {
+	if writerConfig == nil {
+		return nil
+	}
+
+	// Keine neuen Punkte mehr annehmen
+	if writerServer != nil {
+		writerServer.Unsubscribe("data/#", subscriptionID)
+		writerServer.Unsubscribe("backfill/#", backfillSubscriptionID)
+		writerServer = nil
+	}
+
 	if cancel != nil {
 		cancel()
 	}
-
 	if healthCheckTicker != nil {
 		healthCheckTicker.Stop()
 	}
 
-	// Finaler Flush
-	if err := flushBufferWithRetry(nil); err != nil {
-		logrus.Errorf("Finaler Flush-Fehler: %v", err)
+	// Alle Worker einsammeln, damit kein Flush mehr läuft
+collect:
+	for i := 0; i < writerConfig.WorkerCount; i++ {
+		select {
+		case <-workerPool:
+		case <-stopCtx.Done():
+			logrus.Warn("InfluxDB-Writer: Timeout beim Warten auf laufende Flushes")
+			break collect
+		}
 	}
+	writerWg.Wait()
+
+	// Finaler Flush
+	err := finalFlush(stopCtx)
 
 	if client != nil {
 		client.Close()
 		client = nil
 		writeAPI = nil
 	}
+	writerMu.Lock()
+	writerConfig = nil
+	writerMu.Unlock()
 
 	logrus.Info("InfluxDB-Writer gestoppt")
+	return err
+}
+
+// finalFlush schreibt Buffer und Retry-Queue blockierend (ohne Circuit Breaker)
+func finalFlush(stopCtx context.Context) error {
+	points := append(retryQueue.GetPoints(), adaptiveBuffer.GetPoints()...)
+	if len(points) == 0 {
+		return nil
+	}
+
+	err := initializeClient()
+	if err == nil {
+		err = client.WriteAPIBlocking(influxConfig.Org, influxConfig.Bucket).WritePoint(stopCtx, points...)
+	}
+	if err != nil {
+		carryOver = points
+		return fmt.Errorf("finaler Flush von %d Punkten fehlgeschlagen: %v", len(points), err)
+	}
+	logrus.Infof("InfluxDB-Writer: Finaler Flush von %d Punkten erfolgreich", len(points))
+	return nil
+}
+
+// WriterStats sind die Kennzahlen des InfluxDB-Writers für den Metrics-Endpoint
+type WriterStats struct {
+	ProcessedPoints   int64
+	FailedPoints      int64
+	FlushOperations   int64
+	BufferSize        int
+	RetryQueueSize    int
+	BreakerState      CircuitState
+	WriteLatency      time.Duration // Letzter erfolgreicher Flush
+	WriteLatencyTotal time.Duration
+	WriteCount        int64
+	Running           bool // Writer wurde gestartet
+	Healthy           bool // SystemHealth.IsHealthy
+	ConsecutiveErrors int
+}
+
+// GetWriterStats liefert die aktuellen Kennzahlen des InfluxDB-Writers
+func GetWriterStats() WriterStats {
+	stats := WriterStats{
+		ProcessedPoints:   atomic.LoadInt64(&metrics.processedPoints),
+		FailedPoints:      atomic.LoadInt64(&metrics.failedPoints),
+		FlushOperations:   atomic.LoadInt64(&metrics.flushOperations),
+		WriteLatency:      time.Duration(atomic.LoadInt64(&metrics.writeLatency)) * time.Microsecond,
+		WriteLatencyTotal: time.Duration(atomic.LoadInt64(&metrics.writeLatencyTotal)) * time.Microsecond,
+		WriteCount:        atomic.LoadInt64(&metrics.writeCount),
+		Healthy:           systemHealth.IsHealthy(),
+	}
+	systemHealth.mu.RLock()
+	stats.ConsecutiveErrors = systemHealth.consecutiveErrors
+	systemHealth.mu.RUnlock()
+
+	writerMu.RLock()
+	stats.Running = writerConfig != nil
+	buffer, queue, breaker := adaptiveBuffer, retryQueue, circuitBreaker
+	writerMu.RUnlock()
+
+	if buffer != nil {
+		buffer.mu.Lock()
+		stats.BufferSize = len(buffer.points)
+		buffer.mu.Unlock()
+	}
+	if queue != nil {
+		queue.mu.Lock()
+		stats.RetryQueueSize = len(queue.queue)
+		queue.mu.Unlock()
+	}
+	if breaker != nil {
+		breaker.mu.RLock()
+		stats.BreakerState = breaker.state
+		breaker.mu.RUnlock()
+	}
+	return stats
 }
 
 // LogMetrics gibt aktuelle Metriken über Logrus aus
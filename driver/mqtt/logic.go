@@ -1 +1,214 @@
 package mqtt_driver
+
+import (
+	"fmt"
+	"iot-gateway/driver/opcua"
+	"iot-gateway/topics"
+	"sort"
+	"strings"
+
+	MQTT "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	queueSize = 256 // Gepufferte Nachrichten, bevor Nachrichten verworfen werden
+)
+
+// mapping ist eine übersetzte Zuordnung Topic-Filter + Feldausdruck -> Datenpunkt
+type mapping struct {
+	id       string
+	name     string
+	filter   string // Relativ zu data/mqtt/<name>/
+	path     *Path
+	datatype string
+	publish  opcua.PublishSettings
+	topic    string // Kanonisches Daten-Topic
+}
+
+// message ist eine empfangene Nachricht eines MQTT-Geräts
+type message struct {
+	topic   string
+	payload []byte
+}
+
+// ValidateDeviceName prüft den Namen eines MQTT-Geräts. Rohdaten liegen unter data/mqtt/<name>/, die
+// Ergebnisse aller MQTT-Geräte unter data/mqtt/<deviceId>/. Ein numerischer Name könnte daher Werte eines
+// anderen Geräts vortäuschen und dessen Ergebnisse auswerten; erlaubt ist er nur als eigene Geräte-ID
+// (id leer = Gerät noch nicht angelegt).
+func ValidateDeviceName(name, id string) error {
+	if name == "" || strings.ContainsAny(name, "/+#") {
+		return fmt.Errorf("invalid device name %q", name)
+	}
+	if strings.Trim(name, "0123456789") == "" && name != id {
+		return fmt.Errorf("device name %q must not be numeric, it would collide with the topics of device ID %s", name, name)
+	}
+	return nil
+}
+
+// ValidateMapping prüft Topic-Filter, Feldausdruck und Datentyp einer Zuordnung
+func ValidateMapping(dp opcua.Datapoint) error {
+	if !MQTT.IsValidFilter("data/mqtt/device/"+filterOrAll(dp.Topic), false) {
+		return fmt.Errorf("invalid topic filter %q", dp.Topic)
+	}
+	if _, err := CompilePath(dp.Address); err != nil {
+		return fmt.Errorf("invalid expression %q: %v", dp.Address, err)
+	}
+	if !ValidDatatype(dp.Datatype) {
+		return fmt.Errorf("unknown datatype %q", dp.Datatype)
+	}
+	return dp.Publish.Validate()
+}
+
+// filterOrAll liefert den Topic-Filter einer Zuordnung, leer = alle Topics des Geräts
+func filterOrAll(filter string) string {
+	filter = strings.Trim(filter, "/")
+	if filter == "" {
+		return "#"
+	}
+	return filter
+}
+
+// Run wertet die Nachrichten eines MQTT-Geräts auf data/mqtt/<name>/# anhand der Zuordnungen aus.
+// Ergebnisse werden unter data/mqtt/<deviceId>/[<datapointId>] <name> veröffentlicht. Ändern sich die
+// Fehler der Zuordnungen, wird report mit den Fehlermeldungen aufgerufen (leer = keine Fehler).
+func Run(device opcua.DeviceConfig, stopChan chan struct{}, server *MQTT.Server, report func(mappingError string)) error {
+	if err := ValidateDeviceName(device.Name, device.ID); err != nil {
+		return err
+	}
+	mappings, err := compileMappings(device)
+	if err != nil {
+		return err
+	}
+	for _, m := range mappings {
+		opcua.PublishMetadata(server, "mqtt", device.ID, fmt.Sprintf("[%s] %s", m.id, m.name), m.publish)
+	}
+
+	inputPrefix := "data/mqtt/" + device.Name + "/"
+	outputPrefix := "data/mqtt/" + device.ID + "/["
+	queue := make(chan message, queueSize)
+
+	subscriptionID := topics.NextSubscriptionID()
+	err = server.Subscribe(inputPrefix+"#", subscriptionID, func(cl *MQTT.Client, sub packets.Subscription, pk packets.Packet) {
+		// Eigene Ergebnisse nicht erneut auswerten (Gerätename = Geräte-ID)
+		if strings.HasPrefix(pk.TopicName, outputPrefix) {
+			return
+		}
+		select {
+		case queue <- message{topic: strings.TrimPrefix(pk.TopicName, inputPrefix), payload: pk.Payload}:
+		default:
+			logrus.Warnf("MQTT-DRIVER: Queue of device %s is full, dropping message on %s", device.Name, pk.TopicName)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("error subscribing to %s#: %v", inputPrefix, err)
+	}
+	defer server.Unsubscribe(inputPrefix+"#", subscriptionID)
+
+	state := &mappingState{deviceID: device.ID, errors: make(map[string]string)}
+	logrus.Infof("MQTT-DRIVER: Device %s started with %d mappings.", device.Name, len(mappings))
+
+	filter := opcua.NewPublishFilter()
+	for {
+		select {
+		case <-stopChan:
+			logrus.Infof("MQTT-DRIVER: Stopping device %s.", device.Name)
+			return nil
+		case msg := <-queue:
+			doc, isJSON := decodePayload(msg.payload)
+			changed := false
+			for _, m := range mappings {
+				if !matchTopic(m.filter, msg.topic) {
+					continue
+				}
+				err := apply(server, filter, m, doc, isJSON)
+				if state.set(m, err) {
+					changed = true
+				}
+			}
+			if changed {
+				report(state.message())
+			}
+		}
+	}
+}
+
+// compileMappings übersetzt die Zuordnungen eines Geräts. Ungültige Zuordnungen werden übersprungen.
+func compileMappings(device opcua.DeviceConfig) ([]*mapping, error) {
+	var mappings []*mapping
+	for _, dp := range device.Datapoint {
+		if err := ValidateMapping(dp); err != nil {
+			logrus.Errorf("MQTT-DRIVER: Invalid mapping for datapoint %s of device %s: %v", dp.Name, device.Name, err)
+			continue
+		}
+		path, _ := CompilePath(dp.Address)
+		mappings = append(mappings, &mapping{
+			id:       dp.ID,
+			name:     dp.Name,
+			filter:   filterOrAll(dp.Topic),
+			path:     path,
+			datatype: dp.Datatype,
+			publish:  dp.Publish,
+			topic:    fmt.Sprintf("data/mqtt/%s/[%s] %s", device.ID, dp.ID, dp.Name),
+		})
+	}
+	if len(mappings) == 0 {
+		return nil, fmt.Errorf("no valid mappings for device %s", device.Name)
+	}
+	return mappings, nil
+}
+
+// apply extrahiert den Wert einer Zuordnung und veröffentlicht ihn
+func apply(server *MQTT.Server, filter *opcua.PublishFilter, m *mapping, doc interface{}, isJSON bool) error {
+	if !isJSON && !m.path.IsRoot() {
+		return fmt.Errorf("payload is not valid JSON")
+	}
+	raw, err := m.path.Eval(doc)
+	if err != nil {
+		return err
+	}
+	value, err := convert(raw, m.datatype)
+	if err != nil {
+		return err
+	}
+	if _, err := opcua.PublishValue(server, filter, m.topic, value, m.publish); err != nil {
+		return fmt.Errorf("publish failed: %v", err)
+	}
+	return nil
+}
+
+// mappingState hält die aktuellen Fehler der Zuordnungen eines Geräts
+type mappingState struct {
+	deviceID string
+	errors   map[string]string // Datenpunkt-ID -> Fehlermeldung
+}
+
+// set merkt das Ergebnis einer Zuordnung und meldet, ob sich der Fehlerzustand geändert hat
+func (s *mappingState) set(m *mapping, err error) bool {
+	if err == nil {
+		if _, ok := s.errors[m.id]; !ok {
+			return false
+		}
+		delete(s.errors, m.id)
+		logrus.Infof("MQTT-DRIVER: Mapping %s of device %s recovered", m.name, s.deviceID)
+		return true
+	}
+	msg := fmt.Sprintf("%s: %v", m.name, err)
+	if s.errors[m.id] == msg {
+		return false
+	}
+	s.errors[m.id] = msg
+	logrus.Warnf("MQTT-DRIVER: Mapping error on device %s: %s", s.deviceID, msg)
+	return true
+}
+
+// message fasst die Fehler der Zuordnungen zusammen (leer = keine Fehler)
+func (s *mappingState) message() string {
+	messages := make([]string, 0, len(s.errors))
+	for _, msg := range s.errors {
+		messages = append(messages, msg)
+	}
+	sort.Strings(messages)
+	return strings.Join(messages, "; ")
+}
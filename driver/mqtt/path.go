@@ -0,0 +1,209 @@
+package mqtt_driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Datentypen der Zuordnungen. Leer übernimmt den JSON-Typ des Werts.
+const (
+	TypeFloat  = "float"
+	TypeInt    = "int"
+	TypeBool   = "bool"
+	TypeString = "string"
+)
+
+// pathSegment ist ein Schritt eines Pfads: Feldname oder Array-Index
+type pathSegment struct {
+	key   string
+	index int
+	isIdx bool
+}
+
+// Path ist ein übersetzter Feldausdruck (Teilmenge von JSONPath)
+type Path struct {
+	expr     string
+	segments []pathSegment
+}
+
+// CompilePath übersetzt einen Feldausdruck wie $.sensors.t, sensors.t, $.values[0] oder $['a b'].
+// Ein leerer Ausdruck bzw. "$" liefert den gesamten Payload.
+func CompilePath(expr string) (*Path, error) {
+	p := &Path{expr: expr}
+	s := strings.TrimSpace(expr)
+	s = strings.TrimPrefix(s, "$")
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '.':
+			i++
+			end := i
+			for end < len(s) && s[end] != '.' && s[end] != '[' {
+				end++
+			}
+			if end == i {
+				return nil, fmt.Errorf("empty field name at position %d", i)
+			}
+			p.segments = append(p.segments, pathSegment{key: s[i:end]})
+			i = end
+		case '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("missing ] at position %d", i)
+			}
+			inner := strings.TrimSpace(s[i+1 : i+end])
+			i += end + 1
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				p.segments = append(p.segments, pathSegment{key: inner[1 : len(inner)-1]})
+				continue
+			}
+			idx, err := strconv.Atoi(inner)
+			if err != nil || idx < 0 {
+				return nil, fmt.Errorf("invalid index [%s]", inner)
+			}
+			p.segments = append(p.segments, pathSegment{index: idx, isIdx: true})
+		default:
+			// Ausdruck ohne führendes $ bzw. Punkt (z.B. sensors.t)
+			if i != 0 {
+				return nil, fmt.Errorf("unexpected %q at position %d", s[i], i)
+			}
+			s = "." + s
+		}
+	}
+	return p, nil
+}
+
+// String liefert den ursprünglichen Ausdruck
+func (p *Path) String() string {
+	return p.expr
+}
+
+// IsRoot meldet, ob der Ausdruck den gesamten Payload liefert
+func (p *Path) IsRoot() bool {
+	return len(p.segments) == 0
+}
+
+// Eval liefert den Wert des Ausdrucks in einem dekodierten JSON-Dokument
+func (p *Path) Eval(doc interface{}) (interface{}, error) {
+	v := doc
+	for _, seg := range p.segments {
+		if seg.isIdx {
+			arr, ok := v.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: [%d] applied to non-array", p.expr, seg.index)
+			}
+			if seg.index >= len(arr) {
+				return nil, fmt.Errorf("%s: index %d out of range", p.expr, seg.index)
+			}
+			v = arr[seg.index]
+			continue
+		}
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: field %q applied to non-object", p.expr, seg.key)
+		}
+		if v, ok = obj[seg.key]; !ok {
+			return nil, fmt.Errorf("%s: field %q not found", p.expr, seg.key)
+		}
+	}
+	if v == nil {
+		return nil, fmt.Errorf("%s: value is null", p.expr)
+	}
+	return v, nil
+}
+
+// decodePayload dekodiert einen Payload als JSON. Ist das nicht möglich, wird er als Text übernommen.
+func decodePayload(payload []byte) (interface{}, bool) {
+	var doc interface{}
+	if err := json.Unmarshal(payload, &doc); err != nil {
+		return strings.TrimSpace(string(payload)), false
+	}
+	return doc, true
+}
+
+// ValidDatatype prüft einen Datentyp
+func ValidDatatype(datatype string) bool {
+	switch datatype {
+	case "", TypeFloat, TypeInt, TypeBool, TypeString:
+		return true
+	}
+	return false
+}
+
+// convert wandelt einen extrahierten Wert in den Datentyp der Zuordnung um
+func convert(v interface{}, datatype string) (interface{}, error) {
+	switch datatype {
+	case "":
+		switch v.(type) {
+		case float64, bool, string:
+			return v, nil
+		}
+		return nil, fmt.Errorf("value is an object or array")
+	case TypeFloat:
+		switch x := v.(type) {
+		case float64:
+			return x, nil
+		case bool:
+			if x {
+				return 1.0, nil
+			}
+			return 0.0, nil
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(x), 64); err == nil {
+				return f, nil
+			}
+		}
+	case TypeInt:
+		f, err := convert(v, TypeFloat)
+		if err != nil {
+			return nil, err
+		}
+		if f.(float64) != math.Trunc(f.(float64)) {
+			return nil, fmt.Errorf("%v is not an integer", f)
+		}
+		return int64(f.(float64)), nil
+	case TypeBool:
+		switch x := v.(type) {
+		case bool:
+			return x, nil
+		case float64:
+			return x != 0, nil
+		case string:
+			if b, err := strconv.ParseBool(strings.TrimSpace(x)); err == nil {
+				return b, nil
+			}
+		}
+	case TypeString:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	default:
+		return nil, fmt.Errorf("unknown datatype %q", datatype)
+	}
+	return nil, fmt.Errorf("cannot convert %v to %s", v, datatype)
+}
+
+// matchTopic prüft ein Topic gegen einen Filter mit den Platzhaltern + und #
+func matchTopic(filter, topic string) bool {
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(t) {
+			return false
+		}
+		if level != "+" && level != t[i] {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
@@ -0,0 +1,490 @@
+package opcua
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/awcullen/opcua/client"
+	awcullenua "github.com/awcullen/opcua/ua"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNoSession wird zurückgegeben, wenn für ein Gerät keine aktive Treiber-Session existiert.
+var ErrNoSession = errors.New("no active OPC-UA session for device")
+
+const (
+	browseCacheTTL         = 5 * time.Minute
+	browseTimeout          = 15 * time.Second
+	defaultMaxReferences   = 200
+	defaultSearchMaxDepth  = 5
+	defaultSearchMaxResult = 100
+	searchMaxVisitedNodes  = 5000
+)
+
+// BrowseNode beschreibt eine Referenz (Kind-Knoten) aus einem Browse-Ergebnis.
+type BrowseNode struct {
+	NodeID         string `json:"nodeId"`
+	BrowseName     string `json:"browseName"`
+	DisplayName    string `json:"displayName"`
+	NodeClass      string `json:"nodeClass"`
+	TypeDefinition string `json:"typeDefinition,omitempty"`
+	Path           string `json:"path,omitempty"`
+}
+
+// BrowsePage ist eine Seite von Kind-Knoten. Ist ContinuationPoint gesetzt,
+// liefert ein weiterer Aufruf mit diesem Wert die nächste Seite.
+type BrowsePage struct {
+	NodeID            string       `json:"nodeId"`
+	Children          []BrowseNode `json:"children"`
+	ContinuationPoint string       `json:"continuationPoint,omitempty"`
+	Cached            bool         `json:"cached"`
+}
+
+// NodeAttributes enthält die Attribute eines Knotens für die Detailansicht.
+type NodeAttributes struct {
+	NodeID           string      `json:"nodeId"`
+	BrowseName       string      `json:"browseName"`
+	DisplayName      string      `json:"displayName"`
+	Description      string      `json:"description,omitempty"`
+	NodeClass        string      `json:"nodeClass"`
+	DataType         string      `json:"dataType,omitempty"`
+	DataTypeName     string      `json:"dataTypeName,omitempty"`
+	AccessLevel      byte        `json:"accessLevel"`
+	Readable         bool        `json:"readable"`
+	Writable         bool        `json:"writable"`
+	Historizing      bool        `json:"historizing"`
+	Value            interface{} `json:"value,omitempty"`
+	StatusCode       string      `json:"statusCode,omitempty"`
+	SourceTimestamp  *time.Time  `json:"sourceTimestamp,omitempty"`
+	EngineeringUnits string      `json:"engineeringUnits,omitempty"`
+}
+
+// browseCache hält Browse-Ergebnisse pro Gerät und Knoten vor.
+type browseCacheEntry struct {
+	children []BrowseNode
+	expires  time.Time
+}
+
+var browseCache = struct {
+	sync.RWMutex
+	devices map[string]map[string]browseCacheEntry
+}{
+	devices: make(map[string]map[string]browseCacheEntry),
+}
+
+// builtinDataTypes ordnet den Standard-Datentypen (ns=0) einen lesbaren Namen zu.
+var builtinDataTypes = map[uint32]string{
+	1: "Boolean", 2: "SByte", 3: "Byte", 4: "Int16", 5: "UInt16", 6: "Int32", 7: "UInt32",
+	8: "Int64", 9: "UInt64", 10: "Float", 11: "Double", 12: "String", 13: "DateTime",
+	14: "Guid", 15: "ByteString", 16: "XmlElement", 17: "NodeId", 18: "ExpandedNodeId",
+	19: "StatusCode", 20: "QualifiedName", 21: "LocalizedText", 22: "Structure", 24: "BaseDataType",
+	26: "Number", 27: "Integer", 28: "UInteger", 29: "Enumeration",
+}
+
+// getCachedChildren liefert gecachte Kind-Knoten, falls vorhanden und nicht abgelaufen.
+func getCachedChildren(deviceID, key string) ([]BrowseNode, bool) {
+	browseCache.RLock()
+	defer browseCache.RUnlock()
+
+	entry, ok := browseCache.devices[deviceID][key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.children, true
+}
+
+// setCachedChildren speichert Kind-Knoten im Cache des Geräts.
+func setCachedChildren(deviceID, key string, children []BrowseNode) {
+	browseCache.Lock()
+	defer browseCache.Unlock()
+
+	if browseCache.devices[deviceID] == nil {
+		browseCache.devices[deviceID] = make(map[string]browseCacheEntry)
+	}
+	browseCache.devices[deviceID][key] = browseCacheEntry{
+		children: children,
+		expires:  time.Now().Add(browseCacheTTL),
+	}
+}
+
+// invalidateBrowseCache verwirft alle Browse-Ergebnisse eines Geräts (z.B. nach einem Reconnect).
+func invalidateBrowseCache(deviceID string) {
+	browseCache.Lock()
+	defer browseCache.Unlock()
+	delete(browseCache.devices, deviceID)
+}
+
+// InvalidateBrowseCache verwirft den Browse-Cache eines Geräts.
+func InvalidateBrowseCache(deviceID string) {
+	invalidateBrowseCache(deviceID)
+}
+
+// BrowseChildren liefert die Kind-Knoten eines Knotens über die Session des Treibers.
+// Ohne nodeID wird der Objects-Ordner verwendet. Mit continuationPoint wird die nächste
+// Seite eines vorherigen Aufrufs geladen.
+func BrowseChildren(deviceID, nodeID, continuationPoint string, maxReferences uint32) (*BrowsePage, error) {
+	ch, ok := GetClient(deviceID)
+	if !ok {
+		return nil, ErrNoSession
+	}
+	if nodeID == "" {
+		nodeID = fmt.Sprint(awcullenua.ObjectIDObjectsFolder)
+	}
+	if maxReferences == 0 {
+		maxReferences = defaultMaxReferences
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), browseTimeout)
+	defer cancel()
+
+	// Folgeseite über BrowseNext laden
+	if continuationPoint != "" {
+		cp, err := base64.StdEncoding.DecodeString(continuationPoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid continuation point: %v", err)
+		}
+		resp, err := ch.BrowseNext(ctx, &awcullenua.BrowseNextRequest{
+			ContinuationPoints: []awcullenua.ByteString{awcullenua.ByteString(cp)},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("browse next failed: %v", err)
+		}
+		if len(resp.Results) == 0 {
+			return nil, errors.New("browse next returned no results")
+		}
+		return toBrowsePage(nodeID, resp.Results[0])
+	}
+
+	cacheKey := fmt.Sprintf("page|%s|%d", nodeID, maxReferences)
+	if children, ok := getCachedChildren(deviceID, cacheKey); ok {
+		return &BrowsePage{NodeID: nodeID, Children: children, Cached: true}, nil
+	}
+
+	result, err := browseOnce(ctx, ch, nodeID, maxReferences)
+	if err != nil {
+		return nil, err
+	}
+	page, err := toBrowsePage(nodeID, result)
+	if err != nil {
+		return nil, err
+	}
+
+	// Nur vollständige Ergebnisse cachen - Continuation Points sind serverseitig nur einmal gültig
+	if page.ContinuationPoint == "" {
+		setCachedChildren(deviceID, cacheKey, page.Children)
+	}
+	return page, nil
+}
+
+// browseOnce führt einen einzelnen Browse-Aufruf für hierarchische Vorwärtsreferenzen aus.
+func browseOnce(ctx context.Context, ch *client.Client, nodeID string, maxReferences uint32) (awcullenua.BrowseResult, error) {
+	resp, err := ch.Browse(ctx, &awcullenua.BrowseRequest{
+		RequestedMaxReferencesPerNode: maxReferences,
+		NodesToBrowse: []awcullenua.BrowseDescription{
+			{
+				NodeID:          awcullenua.ParseNodeID(nodeID),
+				BrowseDirection: awcullenua.BrowseDirectionForward,
+				ReferenceTypeID: awcullenua.ReferenceTypeIDHierarchicalReferences,
+				IncludeSubtypes: true,
+				ResultMask:      uint32(awcullenua.BrowseResultMaskAll),
+			},
+		},
+	})
+	if err != nil {
+		return awcullenua.BrowseResult{}, fmt.Errorf("browse failed: %v", err)
+	}
+	if len(resp.Results) == 0 {
+		return awcullenua.BrowseResult{}, errors.New("browse returned no results")
+	}
+	return resp.Results[0], nil
+}
+
+// browseAllChildren lädt alle Kind-Knoten und folgt dabei den Continuation Points selbst.
+func browseAllChildren(ctx context.Context, ch *client.Client, deviceID, nodeID string) ([]BrowseNode, error) {
+	cacheKey := "all|" + nodeID
+	if children, ok := getCachedChildren(deviceID, cacheKey); ok {
+		return children, nil
+	}
+
+	result, err := browseOnce(ctx, ch, nodeID, defaultMaxReferences)
+	if err != nil {
+		return nil, err
+	}
+
+	var children []BrowseNode
+	for {
+		if !result.StatusCode.IsGood() {
+			return nil, fmt.Errorf("browse of node %s failed with status: %v", nodeID, result.StatusCode)
+		}
+		for _, ref := range result.References {
+			children = append(children, toBrowseNode(ref))
+		}
+		if result.ContinuationPoint == "" {
+			break
+		}
+		resp, err := ch.BrowseNext(ctx, &awcullenua.BrowseNextRequest{
+			ContinuationPoints: []awcullenua.ByteString{result.ContinuationPoint},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("browse next failed: %v", err)
+		}
+		if len(resp.Results) == 0 {
+			break
+		}
+		result = resp.Results[0]
+	}
+
+	setCachedChildren(deviceID, cacheKey, children)
+	return children, nil
+}
+
+// toBrowsePage wandelt ein BrowseResult in eine BrowsePage um.
+func toBrowsePage(nodeID string, result awcullenua.BrowseResult) (*BrowsePage, error) {
+	if !result.StatusCode.IsGood() {
+		return nil, fmt.Errorf("browse of node %s failed with status: %v", nodeID, result.StatusCode)
+	}
+
+	page := &BrowsePage{
+		NodeID:   nodeID,
+		Children: make([]BrowseNode, 0, len(result.References)),
+	}
+	for _, ref := range result.References {
+		page.Children = append(page.Children, toBrowseNode(ref))
+	}
+	if result.ContinuationPoint != "" {
+		page.ContinuationPoint = result.ContinuationPoint.String()
+	}
+	return page, nil
+}
+
+// toBrowseNode wandelt eine ReferenceDescription in einen BrowseNode um.
+func toBrowseNode(ref awcullenua.ReferenceDescription) BrowseNode {
+	node := BrowseNode{
+		NodeID:      ref.NodeID.String(),
+		BrowseName:  ref.BrowseName.Name,
+		DisplayName: ref.DisplayName.Text,
+		NodeClass:   ref.NodeClass.String(),
+	}
+	if ref.TypeDefinition.NodeID != nil {
+		node.TypeDefinition = ref.TypeDefinition.String()
+	}
+	return node
+}
+
+// SearchNodes sucht ab startNodeID per Breitensuche nach Knoten, deren BrowseName oder
+// DisplayName den Suchbegriff enthält (Groß-/Kleinschreibung wird ignoriert).
+func SearchNodes(deviceID, startNodeID, query string, maxDepth, maxResults int) ([]BrowseNode, error) {
+	ch, ok := GetClient(deviceID)
+	if !ok {
+		return nil, ErrNoSession
+	}
+	if startNodeID == "" {
+		startNodeID = fmt.Sprint(awcullenua.ObjectIDObjectsFolder)
+	}
+	if maxDepth <= 0 {
+		maxDepth = defaultSearchMaxDepth
+	}
+	if maxResults <= 0 {
+		maxResults = defaultSearchMaxResult
+	}
+	query = strings.ToLower(query)
+
+	ctx, cancel := context.WithTimeout(context.Background(), browseTimeout)
+	defer cancel()
+
+	type queueItem struct {
+		nodeID string
+		path   string
+		depth  int
+	}
+
+	results := []BrowseNode{}
+	visited := map[string]bool{startNodeID: true}
+	queue := []queueItem{{nodeID: startNodeID}}
+
+	for len(queue) > 0 && len(results) < maxResults {
+		item := queue[0]
+		queue = queue[1:]
+
+		children, err := browseAllChildren(ctx, ch, deviceID, item.nodeID)
+		if err != nil {
+			if ctx.Err() != nil {
+				logrus.Warnf("OPC-UA: Search on device %s stopped after timeout, returning partial results", deviceID)
+				break
+			}
+			logrus.Debugf("OPC-UA: Skipping node %s during search: %v", item.nodeID, err)
+			continue
+		}
+
+		for _, child := range children {
+			if visited[child.NodeID] {
+				continue
+			}
+			visited[child.NodeID] = true
+
+			child.Path = join(item.path, child.BrowseName)
+			if strings.Contains(strings.ToLower(child.BrowseName), query) ||
+				strings.Contains(strings.ToLower(child.DisplayName), query) {
+				results = append(results, child)
+				if len(results) >= maxResults {
+					break
+				}
+			}
+			if item.depth+1 < maxDepth && len(visited) < searchMaxVisitedNodes {
+				queue = append(queue, queueItem{nodeID: child.NodeID, path: child.Path, depth: item.depth + 1})
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// ReadNodeAttributes liest die Detail-Attribute eines Knotens inklusive EngineeringUnits.
+func ReadNodeAttributes(deviceID, nodeID string) (*NodeAttributes, error) {
+	ch, ok := GetClient(deviceID)
+	if !ok {
+		return nil, ErrNoSession
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), browseTimeout)
+	defer cancel()
+
+	parsedNodeID := awcullenua.ParseNodeID(nodeID)
+	attributeIDs := []uint32{
+		awcullenua.AttributeIDBrowseName,
+		awcullenua.AttributeIDDisplayName,
+		awcullenua.AttributeIDDescription,
+		awcullenua.AttributeIDNodeClass,
+		awcullenua.AttributeIDDataType,
+		awcullenua.AttributeIDAccessLevel,
+		awcullenua.AttributeIDHistorizing,
+		awcullenua.AttributeIDValue,
+	}
+
+	req := &awcullenua.ReadRequest{
+		NodesToRead:        make([]awcullenua.ReadValueID, len(attributeIDs)),
+		TimestampsToReturn: awcullenua.TimestampsToReturnBoth,
+	}
+	for i, attributeID := range attributeIDs {
+		req.NodesToRead[i] = awcullenua.ReadValueID{NodeID: parsedNodeID, AttributeID: attributeID}
+	}
+
+	resp, err := ch.Read(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("reading attributes failed: %v", err)
+	}
+	if len(resp.Results) != len(attributeIDs) {
+		return nil, errors.New("unexpected number of attribute results")
+	}
+	if !resp.Results[3].StatusCode.IsGood() {
+		return nil, fmt.Errorf("node %s not found: %v", nodeID, resp.Results[3].StatusCode)
+	}
+
+	attrs := &NodeAttributes{NodeID: nodeID}
+	if v, ok := resp.Results[0].Value.(awcullenua.QualifiedName); ok {
+		attrs.BrowseName = v.Name
+	}
+	if v, ok := resp.Results[1].Value.(awcullenua.LocalizedText); ok {
+		attrs.DisplayName = v.Text
+	}
+	if v, ok := resp.Results[2].Value.(awcullenua.LocalizedText); ok {
+		attrs.Description = v.Text
+	}
+	if v, ok := resp.Results[3].Value.(int32); ok {
+		attrs.NodeClass = awcullenua.NodeClass(v).String()
+	}
+
+	// Die folgenden Attribute existieren nur bei Variablen
+	if resp.Results[4].StatusCode.IsGood() {
+		if dt, ok := resp.Results[4].Value.(awcullenua.NodeID); ok && dt != nil {
+			attrs.DataType = fmt.Sprint(dt)
+			attrs.DataTypeName = dataTypeName(ctx, ch, dt)
+		}
+	}
+	if resp.Results[5].StatusCode.IsGood() {
+		if v, ok := resp.Results[5].Value.(byte); ok {
+			attrs.AccessLevel = v
+			attrs.Readable = v&awcullenua.AccessLevelsCurrentRead != 0
+			attrs.Writable = v&awcullenua.AccessLevelsCurrentWrite != 0
+		}
+	}
+	if v, ok := resp.Results[6].Value.(bool); ok {
+		attrs.Historizing = v
+	}
+	if attrs.NodeClass == awcullenua.NodeClassVariable.String() {
+		value := resp.Results[7]
+		attrs.StatusCode = statusText(value.StatusCode)
+		if value.StatusCode.IsGood() {
+			attrs.Value = value.Value
+		}
+		if !value.SourceTimestamp.IsZero() {
+			ts := value.SourceTimestamp
+			attrs.SourceTimestamp = &ts
+		}
+		attrs.EngineeringUnits = readEngineeringUnits(ctx, ch, deviceID, nodeID)
+	}
+
+	return attrs, nil
+}
+
+// dataTypeName ermittelt einen lesbaren Namen für einen Datentyp.
+func dataTypeName(ctx context.Context, ch *client.Client, dataType awcullenua.NodeID) string {
+	if numeric, ok := dataType.(awcullenua.NodeIDNumeric); ok && numeric.NamespaceIndex == 0 {
+		if name, exists := builtinDataTypes[numeric.ID]; exists {
+			return name
+		}
+	}
+
+	// Benutzerdefinierte Datentypen: DisplayName des Datentyp-Knotens lesen
+	resp, err := ch.Read(ctx, &awcullenua.ReadRequest{
+		NodesToRead: []awcullenua.ReadValueID{{NodeID: dataType, AttributeID: awcullenua.AttributeIDDisplayName}},
+	})
+	if err != nil || len(resp.Results) == 0 || !resp.Results[0].StatusCode.IsGood() {
+		return ""
+	}
+	if v, ok := resp.Results[0].Value.(awcullenua.LocalizedText); ok {
+		return v.Text
+	}
+	return ""
+}
+
+// readEngineeringUnits liest die EngineeringUnits-Property (EUInformation) einer Variable.
+func readEngineeringUnits(ctx context.Context, ch *client.Client, deviceID, nodeID string) string {
+	children, err := browseAllChildren(ctx, ch, deviceID, nodeID)
+	if err != nil {
+		return ""
+	}
+
+	for _, child := range children {
+		if child.BrowseName != "EngineeringUnits" {
+			continue
+		}
+		resp, err := ch.Read(ctx, &awcullenua.ReadRequest{
+			NodesToRead: []awcullenua.ReadValueID{{NodeID: awcullenua.ParseNodeID(child.NodeID), AttributeID: awcullenua.AttributeIDValue}},
+		})
+		if err != nil || len(resp.Results) == 0 || !resp.Results[0].StatusCode.IsGood() {
+			return ""
+		}
+		switch eu := resp.Results[0].Value.(type) {
+		case awcullenua.EUInformation:
+			return eu.DisplayName.Text
+		case *awcullenua.EUInformation:
+			return eu.DisplayName.Text
+		}
+		return ""
+	}
+	return ""
+}
+
+// join fügt den aktuellen Pfad mit dem neuen Knoten zusammen
+func join(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "." + b
+}
@@ -0,0 +1,345 @@
+package opcua
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/awcullen/opcua/client"
+	awcullenua "github.com/awcullen/opcua/ua"
+	MQTT "github.com/mochi-mqtt/server/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultEventFields sind die Felder, die ohne eigene Konfiguration abonniert werden.
+const DefaultEventFields = "Severity,Message,SourceName,ActiveState,AckedState"
+
+const (
+	eventClientHandle       = 1
+	eventPublishingInterval = 1000.0
+	eventQueueSize          = 1000
+	eventRetention          = 90 * 24 * time.Hour // Aufbewahrung gespeicherter Ereignisse
+	eventPruneInterval      = time.Hour           // Mindestabstand zwischen zwei Bereinigungen
+)
+
+// lastEventPrune verhindert, dass die events-Tabelle bei jedem Ereignis bereinigt wird
+var lastEventPrune struct {
+	sync.Mutex
+	at time.Time
+}
+
+// eventFieldOperands ordnet bekannten Feldnamen den Ereignistyp und BrowsePath zu.
+// Unbekannte Namen werden als BrowsePath auf BaseEventType interpretiert.
+var eventFieldOperands = map[string]struct {
+	typeDefinition awcullenua.NodeID
+	browsePath     string
+}{
+	"EventType":      {awcullenua.ObjectTypeIDBaseEventType, "EventType"},
+	"SourceNode":     {awcullenua.ObjectTypeIDBaseEventType, "SourceNode"},
+	"SourceName":     {awcullenua.ObjectTypeIDBaseEventType, "SourceName"},
+	"Time":           {awcullenua.ObjectTypeIDBaseEventType, "Time"},
+	"ReceiveTime":    {awcullenua.ObjectTypeIDBaseEventType, "ReceiveTime"},
+	"Message":        {awcullenua.ObjectTypeIDBaseEventType, "Message"},
+	"Severity":       {awcullenua.ObjectTypeIDBaseEventType, "Severity"},
+	"ConditionName":  {awcullenua.ObjectTypeIDConditionType, "ConditionName"},
+	"BranchId":       {awcullenua.ObjectTypeIDConditionType, "BranchId"},
+	"Retain":         {awcullenua.ObjectTypeIDConditionType, "Retain"},
+	"EnabledState":   {awcullenua.ObjectTypeIDConditionType, "EnabledState/Id"},
+	"AckedState":     {awcullenua.ObjectTypeIDAcknowledgeableConditionType, "AckedState/Id"},
+	"ConfirmedState": {awcullenua.ObjectTypeIDAcknowledgeableConditionType, "ConfirmedState/Id"},
+	"ActiveState":    {awcullenua.ObjectTypeIDAlarmConditionType, "ActiveState/Id"},
+}
+
+// Event ist ein empfangenes OPC-UA-Ereignis (Alarms & Conditions).
+type Event struct {
+	DeviceID    string                 `json:"deviceId"`
+	EventID     string                 `json:"eventId"`
+	ConditionID string                 `json:"conditionId,omitempty"`
+	EventType   string                 `json:"eventType,omitempty"`
+	Time        time.Time              `json:"time"`
+	Fields      map[string]interface{} `json:"fields"`
+}
+
+// eventSelectClauses baut die SelectClauses: zuerst die festen Felder, die für
+// Quittierung und Speicherung benötigt werden, danach die konfigurierten Felder.
+func eventSelectClauses(fields []string) []awcullenua.SimpleAttributeOperand {
+	clauses := []awcullenua.SimpleAttributeOperand{
+		{TypeDefinitionID: awcullenua.ObjectTypeIDBaseEventType, BrowsePath: awcullenua.ParseBrowsePath("EventId"), AttributeID: awcullenua.AttributeIDValue},
+		{TypeDefinitionID: awcullenua.ObjectTypeIDBaseEventType, BrowsePath: awcullenua.ParseBrowsePath("EventType"), AttributeID: awcullenua.AttributeIDValue},
+		{TypeDefinitionID: awcullenua.ObjectTypeIDBaseEventType, BrowsePath: awcullenua.ParseBrowsePath("Time"), AttributeID: awcullenua.AttributeIDValue},
+		{TypeDefinitionID: awcullenua.ObjectTypeIDConditionType, BrowsePath: awcullenua.ParseBrowsePath(""), AttributeID: awcullenua.AttributeIDNodeID},
+	}
+	for _, field := range fields {
+		operand, ok := eventFieldOperands[field]
+		if !ok {
+			operand.typeDefinition = awcullenua.ObjectTypeIDBaseEventType
+			operand.browsePath = field
+		}
+		clauses = append(clauses, awcullenua.SimpleAttributeOperand{
+			TypeDefinitionID: operand.typeDefinition,
+			BrowsePath:       awcullenua.ParseBrowsePath(operand.browsePath),
+			AttributeID:      awcullenua.AttributeIDValue,
+		})
+	}
+	return clauses
+}
+
+// ParseEventFields zerlegt die kommaseparierte Feldliste aus der Gerätekonfiguration.
+func ParseEventFields(fields string) []string {
+	if strings.TrimSpace(fields) == "" {
+		fields = DefaultEventFields
+	}
+	var result []string
+	for _, field := range strings.Split(fields, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			result = append(result, field)
+		}
+	}
+	return result
+}
+
+// runEventSubscription abonniert Ereignisse am Server-Objekt und verarbeitet sie, bis ctx
+// beendet wird oder die Verbindung abbricht.
+func runEventSubscription(ctx context.Context, device DeviceConfig, ch *client.Client, server *MQTT.Server, db *sql.DB) {
+	fields := ParseEventFields(device.EventFields)
+
+	sub, err := ch.CreateSubscription(ctx, &awcullenua.CreateSubscriptionRequest{
+		RequestedPublishingInterval: eventPublishingInterval,
+		RequestedMaxKeepAliveCount:  30,
+		RequestedLifetimeCount:      30 * 3,
+		PublishingEnabled:           true,
+	})
+	if err != nil {
+		logrus.Errorf("OPC-UA: Error creating event subscription for device %s: %v", device.Name, err)
+		return
+	}
+	defer ch.DeleteSubscriptions(context.Background(), &awcullenua.DeleteSubscriptionsRequest{
+		SubscriptionIDs: []uint32{sub.SubscriptionID},
+	})
+
+	items, err := ch.CreateMonitoredItems(ctx, &awcullenua.CreateMonitoredItemsRequest{
+		SubscriptionID:     sub.SubscriptionID,
+		TimestampsToReturn: awcullenua.TimestampsToReturnBoth,
+		ItemsToCreate: []awcullenua.MonitoredItemCreateRequest{
+			{
+				ItemToMonitor: awcullenua.ReadValueID{
+					NodeID:      awcullenua.ObjectIDServer,
+					AttributeID: awcullenua.AttributeIDEventNotifier,
+				},
+				MonitoringMode: awcullenua.MonitoringModeReporting,
+				RequestedParameters: awcullenua.MonitoringParameters{
+					ClientHandle:  eventClientHandle,
+					QueueSize:     eventQueueSize,
+					DiscardOldest: true,
+					Filter:        awcullenua.EventFilter{SelectClauses: eventSelectClauses(fields)},
+				},
+			},
+		},
+	})
+	if err != nil {
+		logrus.Errorf("OPC-UA: Error creating event monitored item for device %s: %v", device.Name, err)
+		return
+	}
+	if len(items.Results) == 0 || !items.Results[0].StatusCode.IsGood() {
+		logrus.Errorf("OPC-UA: Server rejected event monitored item for device %s", device.Name)
+		return
+	}
+
+	logrus.Infof("OPC-UA: Event subscription started for device %s (fields: %s)", device.Name, strings.Join(fields, ", "))
+
+	req := &awcullenua.PublishRequest{
+		RequestHeader:                awcullenua.RequestHeader{TimeoutHint: 60000},
+		SubscriptionAcknowledgements: []awcullenua.SubscriptionAcknowledgement{},
+	}
+	for {
+		res, err := ch.Publish(ctx, req)
+		if err != nil {
+			if ctx.Err() == nil {
+				logrus.Warnf("OPC-UA: Event subscription for device %s stopped: %v", device.Name, err)
+			}
+			return
+		}
+
+		for _, data := range res.NotificationMessage.NotificationData {
+			var events []awcullenua.EventFieldList
+			switch body := data.(type) {
+			case awcullenua.EventNotificationList:
+				events = body.Events
+			case *awcullenua.EventNotificationList:
+				events = body.Events
+			}
+			for _, e := range events {
+				if e.ClientHandle != eventClientHandle {
+					continue
+				}
+				handleEvent(device, fields, e.EventFields, server, db)
+			}
+		}
+
+		req = &awcullenua.PublishRequest{
+			RequestHeader: awcullenua.RequestHeader{TimeoutHint: 60000},
+			SubscriptionAcknowledgements: []awcullenua.SubscriptionAcknowledgement{
+				{SequenceNumber: res.NotificationMessage.SequenceNumber, SubscriptionID: res.SubscriptionID},
+			},
+		}
+	}
+}
+
+// handleEvent wandelt die Ereignisfelder um, speichert das Ereignis und veröffentlicht es.
+func handleEvent(device DeviceConfig, fields []string, values []awcullenua.Variant, server *MQTT.Server, db *sql.DB) {
+	if len(values) != len(fields)+4 {
+		logrus.Warnf("OPC-UA: Received event with %d fields from device %s, expected %d", len(values), device.Name, len(fields)+4)
+		return
+	}
+
+	event := Event{
+		DeviceID: device.ID,
+		Time:     time.Now(),
+		Fields:   make(map[string]interface{}, len(fields)),
+	}
+	if id, ok := values[0].(awcullenua.ByteString); ok {
+		event.EventID = base64.StdEncoding.EncodeToString([]byte(id))
+	}
+	if values[1] != nil {
+		event.EventType = fmt.Sprint(values[1])
+	}
+	if t, ok := values[2].(time.Time); ok && !t.IsZero() {
+		event.Time = t
+	}
+	if values[3] != nil {
+		event.ConditionID = fmt.Sprint(values[3])
+	}
+	for i, field := range fields {
+		event.Fields[field] = eventFieldValue(values[i+4])
+	}
+
+	if err := storeEvent(db, event); err != nil {
+		logrus.Errorf("OPC-UA: Error storing event from device %s: %v", device.Name, err)
+	}
+	pruneEvents(db)
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logrus.Errorf("OPC-UA: Failed to marshal event from device %s: %v", device.Name, err)
+		return
+	}
+	topic := fmt.Sprintf("events/opc-ua/%s", device.ID)
+	if err := server.Publish(topic, payload, false, 1); err != nil {
+		logrus.Errorf("OPC-UA: Failed to publish event from device %s: %v", device.Name, err)
+	}
+}
+
+// eventFieldValue bereitet Feldwerte für JSON auf (LocalizedText, NodeIds, ByteStrings)
+func eventFieldValue(value awcullenua.Variant) interface{} {
+	switch v := value.(type) {
+	case awcullenua.LocalizedText:
+		return v.Text
+	case awcullenua.QualifiedName:
+		return v.Name
+	case awcullenua.ByteString:
+		return base64.StdEncoding.EncodeToString([]byte(v))
+	case awcullenua.NodeID:
+		return fmt.Sprint(v)
+	}
+	return value
+}
+
+// storeEvent speichert ein Ereignis in der events-Tabelle
+func storeEvent(db *sql.DB, event Event) error {
+	fieldsJSON, err := json.Marshal(event.Fields)
+	if err != nil {
+		return err
+	}
+
+	var severity sql.NullInt64
+	if s, ok := event.Fields["Severity"].(uint16); ok {
+		severity = sql.NullInt64{Int64: int64(s), Valid: true}
+	}
+	message, _ := event.Fields["Message"].(string)
+	sourceName, _ := event.Fields["SourceName"].(string)
+
+	_, err = db.Exec(`INSERT INTO events (device_id, event_id, condition_id, event_type, source_name, severity, message, active_state, acked_state, confirmed_state, fields, event_time, received_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		event.DeviceID, event.EventID, event.ConditionID, event.EventType, sourceName, severity, message,
+		boolField(event.Fields, "ActiveState"), boolField(event.Fields, "AckedState"), boolField(event.Fields, "ConfirmedState"),
+		string(fieldsJSON), event.Time.UTC().Format(time.RFC3339Nano), time.Now().UTC().Format(time.RFC3339Nano))
+	return err
+}
+
+// pruneEvents löscht Ereignisse, die älter als eventRetention sind (höchstens einmal pro eventPruneInterval)
+func pruneEvents(db *sql.DB) {
+	lastEventPrune.Lock()
+	if time.Since(lastEventPrune.at) < eventPruneInterval {
+		lastEventPrune.Unlock()
+		return
+	}
+	lastEventPrune.at = time.Now()
+	lastEventPrune.Unlock()
+
+	cutoff := time.Now().Add(-eventRetention).UTC().Format(time.RFC3339Nano)
+	if _, err := db.Exec(`DELETE FROM events WHERE received_at < ?`, cutoff); err != nil {
+		logrus.Errorf("OPC-UA: Error pruning events: %v", err)
+	}
+}
+
+// boolField liefert einen Zustand als NullBool (nicht abonniert oder kein bool -> NULL)
+func boolField(fields map[string]interface{}, name string) sql.NullBool {
+	if v, ok := fields[name].(bool); ok {
+		return sql.NullBool{Bool: v, Valid: true}
+	}
+	return sql.NullBool{}
+}
+
+// AcknowledgeCondition quittiert (confirm=false) oder bestätigt (confirm=true) eine Condition
+// über die Session des Treibers. eventID ist die base64-kodierte EventId des Ereignisses.
+func AcknowledgeCondition(deviceID, conditionID, eventID, comment string, confirm bool) error {
+	ch, ok := GetClient(deviceID)
+	if !ok {
+		return ErrNoSession
+	}
+	if conditionID == "" || eventID == "" {
+		return errors.New("event has no condition to acknowledge")
+	}
+
+	rawEventID, err := base64.StdEncoding.DecodeString(eventID)
+	if err != nil {
+		return fmt.Errorf("invalid event id: %v", err)
+	}
+
+	methodID := awcullenua.MethodIDAcknowledgeableConditionTypeAcknowledge
+	if confirm {
+		methodID = awcullenua.MethodIDAcknowledgeableConditionTypeConfirm
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), methodCallTimeout)
+	defer cancel()
+
+	resp, err := ch.Call(ctx, &awcullenua.CallRequest{
+		MethodsToCall: []awcullenua.CallMethodRequest{
+			{
+				ObjectID: awcullenua.ParseNodeID(conditionID),
+				MethodID: methodID,
+				InputArguments: []awcullenua.Variant{
+					awcullenua.ByteString(rawEventID),
+					awcullenua.LocalizedText{Text: comment},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("acknowledge call failed: %v", err)
+	}
+	if len(resp.Results) == 0 {
+		return errors.New("acknowledge call returned no results")
+	}
+	if !resp.Results[0].StatusCode.IsGood() {
+		return fmt.Errorf("server rejected acknowledge: %s", statusText(resp.Results[0].StatusCode))
+	}
+	return nil
+}
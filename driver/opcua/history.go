@@ -0,0 +1,193 @@
+package opcua
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/awcullen/opcua/client"
+	awcullenua "github.com/awcullen/opcua/ua"
+	MQTT "github.com/mochi-mqtt/server/v2"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	backfillMaxWindow       = 24 * time.Hour // Maximal nachzuholender Zeitraum
+	backfillValuesPerNode   = 1000           // Werte pro HistoryRead-Aufruf
+	backfillMaxValuesPerRun = 100000         // Obergrenze pro Knoten und Backfill
+	backfillTimeout         = 5 * time.Minute
+)
+
+// lastReadPersistInterval begrenzt, wie oft der letzte erfolgreiche Lesezyklus in die DB geschrieben wird
+const lastReadPersistInterval = 10 * time.Second
+
+// lastSuccessfulRead speichert pro Gerät den Zeitpunkt des letzten erfolgreichen Lesezyklus.
+// persisted hält fest, wann der Wert zuletzt in devices.last_successful_read geschrieben wurde.
+var lastSuccessfulRead = struct {
+	sync.RWMutex
+	times     map[string]time.Time
+	persisted map[string]time.Time
+}{
+	times:     make(map[string]time.Time),
+	persisted: make(map[string]time.Time),
+}
+
+// markSuccessfulRead merkt sich den Zeitpunkt des letzten erfolgreichen Lesezyklus und
+// schreibt ihn höchstens alle lastReadPersistInterval in die DB, damit ein Ausfall über
+// einen Neustart des Gateways hinweg nachgeholt werden kann.
+func markSuccessfulRead(db *sql.DB, deviceID string, t time.Time) {
+	lastSuccessfulRead.Lock()
+	lastSuccessfulRead.times[deviceID] = t
+	persist := t.Sub(lastSuccessfulRead.persisted[deviceID]) >= lastReadPersistInterval
+	if persist {
+		lastSuccessfulRead.persisted[deviceID] = t
+	}
+	lastSuccessfulRead.Unlock()
+
+	if persist {
+		persistSuccessfulRead(db, deviceID, t)
+	}
+}
+
+// flushSuccessfulRead schreibt den letzten bekannten Lesezeitpunkt beim Stoppen des Treibers in die DB
+func flushSuccessfulRead(db *sql.DB, deviceID string) {
+	lastSuccessfulRead.Lock()
+	t, ok := lastSuccessfulRead.times[deviceID]
+	if ok {
+		lastSuccessfulRead.persisted[deviceID] = t
+	}
+	lastSuccessfulRead.Unlock()
+
+	if ok {
+		persistSuccessfulRead(db, deviceID, t)
+	}
+}
+
+func persistSuccessfulRead(db *sql.DB, deviceID string, t time.Time) {
+	if db == nil {
+		return
+	}
+	if _, err := db.Exec(`UPDATE devices SET last_successful_read = ? WHERE id = ?`, t.UTC().Format(time.RFC3339Nano), deviceID); err != nil {
+		logrus.Warnf("OPC-UA: Error persisting last successful read for device %s: %v", deviceID, err)
+	}
+}
+
+// getLastSuccessfulRead liefert den Zeitpunkt des letzten erfolgreichen Lesezyklus.
+// Ist er nicht im Speicher (z.B. nach einem Neustart), wird der persistierte Wert verwendet.
+func getLastSuccessfulRead(db *sql.DB, deviceID string) (time.Time, bool) {
+	lastSuccessfulRead.RLock()
+	t, ok := lastSuccessfulRead.times[deviceID]
+	lastSuccessfulRead.RUnlock()
+	if ok || db == nil {
+		return t, ok
+	}
+
+	var stored sql.NullString
+	if err := db.QueryRow(`SELECT last_successful_read FROM devices WHERE id = ?`, deviceID).Scan(&stored); err != nil || !stored.Valid || stored.String == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, stored.String)
+	if err != nil {
+		logrus.Warnf("OPC-UA: Invalid last successful read %q for device %s: %v", stored.String, deviceID, err)
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// backfillHistory liest nach einem Reconnect die Historie aller DataNodes seit dem letzten
+// erfolgreichen Lesezyklus und veröffentlicht die fehlenden Werte mit ihren Quellzeitstempeln.
+func backfillHistory(device DeviceConfig, ch *client.Client, server *MQTT.Server, since, until time.Time) {
+	if until.Sub(since) > backfillMaxWindow {
+		logrus.Warnf("OPC-UA: Outage on device %s exceeds %v, backfill is limited to the last %v", device.Name, backfillMaxWindow, backfillMaxWindow)
+		since = until.Add(-backfillMaxWindow)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), backfillTimeout)
+	defer cancel()
+
+	logrus.Infof("OPC-UA: Starting history backfill for device %s from %v to %v", device.Name, since.Format(time.RFC3339), until.Format(time.RFC3339))
+
+	total := 0
+	for _, node := range device.DataNode {
+		values, err := readRawHistory(ctx, ch, node.Node, since, until)
+		if err != nil {
+			logrus.Warnf("OPC-UA: History backfill for node %s on device %s failed: %v", node.Node, device.Name, err)
+			continue
+		}
+
+		key := "[" + node.ID + "] " + node.Name
+		for _, value := range values {
+			// Werte mit dem Zeitstempel des letzten Zyklus wurden bereits live veröffentlicht
+			if !value.StatusCode.IsGood() || !value.SourceTimestamp.After(since) {
+				continue
+			}
+			if err := pubBackfillData(key, node.Publish.Scale(value.Value), value.SourceTimestamp, device.ID, server); err != nil {
+				logrus.Warnf("OPC-UA: Error publishing backfilled value for %s: %v", key, err)
+				continue
+			}
+			total++
+		}
+	}
+
+	logrus.Infof("OPC-UA: History backfill for device %s finished, %d values published", device.Name, total)
+}
+
+// readRawHistory liest die Rohwerte eines Knotens im Zeitraum über HistoryReadRawModified
+// und folgt dabei den Continuation Points.
+func readRawHistory(ctx context.Context, ch *client.Client, nodeID string, start, end time.Time) ([]awcullenua.DataValue, error) {
+	var values []awcullenua.DataValue
+	var continuationPoint awcullenua.ByteString
+
+	for {
+		resp, err := ch.HistoryRead(ctx, &awcullenua.HistoryReadRequest{
+			HistoryReadDetails: awcullenua.ReadRawModifiedDetails{
+				IsReadModified:   false,
+				StartTime:        start,
+				EndTime:          end,
+				NumValuesPerNode: backfillValuesPerNode,
+				ReturnBounds:     false,
+			},
+			TimestampsToReturn: awcullenua.TimestampsToReturnSource,
+			NodesToRead: []awcullenua.HistoryReadValueID{
+				{NodeID: awcullenua.ParseNodeID(nodeID), ContinuationPoint: continuationPoint},
+			},
+		})
+		if err != nil {
+			return values, err
+		}
+		if len(resp.Results) == 0 {
+			return values, nil
+		}
+
+		result := resp.Results[0]
+		if !result.StatusCode.IsGood() {
+			return values, fmt.Errorf("history read failed with status: %v", result.StatusCode)
+		}
+
+		switch data := result.HistoryData.(type) {
+		case awcullenua.HistoryData:
+			values = append(values, data.DataValues...)
+		case *awcullenua.HistoryData:
+			values = append(values, data.DataValues...)
+		}
+
+		if result.ContinuationPoint == "" {
+			return values, nil
+		}
+		if len(values) >= backfillMaxValuesPerRun {
+			// Continuation Point serverseitig freigeben
+			ch.HistoryRead(ctx, &awcullenua.HistoryReadRequest{
+				HistoryReadDetails:        awcullenua.ReadRawModifiedDetails{StartTime: start, EndTime: end},
+				ReleaseContinuationPoints: true,
+				NodesToRead: []awcullenua.HistoryReadValueID{
+					{NodeID: awcullenua.ParseNodeID(nodeID), ContinuationPoint: result.ContinuationPoint},
+				},
+			})
+			logrus.Warnf("OPC-UA: History backfill for node %s truncated after %d values", nodeID, len(values))
+			return values, nil
+		}
+		continuationPoint = result.ContinuationPoint
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"strings"
 	"time"
 
+	"iot-gateway/metrics"
+
 	"github.com/awcullen/opcua/client"
 	"github.com/go-ping/ping"
 	MQTT "github.com/mochi-mqtt/server/v2"
@@ -27,14 +29,30 @@ func Run(device DeviceConfig, db *sql.DB, stopChan chan struct{}, server *MQTT.S
 	var clientOpts []client.Option
 	var ch *client.Client
 	var connectionEstablished bool = false
+	var cancelEvents context.CancelFunc // Beendet die Ereignis-Subscription der aktuellen Verbindung
+	defer func() {
+		if cancelEvents != nil {
+			cancelEvents()
+		}
+		flushSuccessfulRead(db, device.ID) // Für den Backfill nach einem Neustart
+	}()
 
 	// Erstelle Context außerhalb der Schleife
 	ctx := context.Background()
 	lastStatus := ""
+	connectedBefore := false // Für die Zählung von Reconnects
 
 	// Starten mit Initializing
 	updateDeviceStatus(server, "opc-ua", device.ID, "2 (initializing)", db, &lastStatus)
 
+	// Deadband/Publish-on-Change-Zustand für diesen Treiberlauf, Einheiten einmalig veröffentlichen
+	filter := NewPublishFilter()
+	schedule := NewScanSchedule(device)     // Erfassungsgruppen mit eigener Zykluszeit
+	triggers := NewTriggerEvaluator(device) // Ereignisgesteuerte Datensätze
+	for _, node := range device.DataNode {
+		PublishMetadata(server, "opc-ua", device.ID, "["+node.ID+"] "+node.Name, node.Publish)
+	}
+
 	// Client-Optionen einmalig erstellen
 	clientOpts, err = clientOptsFromFlags(device, db)
 	if err != nil {
@@ -48,9 +66,14 @@ func Run(device DeviceConfig, db *sql.DB, stopChan chan struct{}, server *MQTT.S
 	for {
 		select {
 		case <-stopChan:
+			if cancelEvents != nil {
+				cancelEvents()
+			}
 			if ch != nil {
+				removeOpcuaClient(device.ID)
 				ch.Close(ctx)
 			}
+			invalidateBrowseCache(device.ID)
 			updateDeviceStatus(server, "opc-ua", device.ID, "0 (stopped)", db, &lastStatus)
 			return nil
 		default:
@@ -72,20 +95,44 @@ func Run(device DeviceConfig, db *sql.DB, stopChan chan struct{}, server *MQTT.S
 
 				// Verbindung erfolgreich
 				connectionEstablished = true
+				if connectedBefore {
+					metrics.Reconnect("opc-ua", device.ID)
+				}
+				connectedBefore = true
 				addOpcuaClient(device.ID, ch)
+				invalidateBrowseCache(device.ID)
 				logrus.Infof("OPC-UA: Successfully connected to device %v", device.Name)
 				updateDeviceStatus(server, "opc-ua", device.ID, "2 (initializing)", db, &lastStatus)
+
+				// Ausfallzeitraum aus der Server-Historie nachholen (parallel zum Polling)
+				if device.HistoryBackfill {
+					if since, ok := getLastSuccessfulRead(db, device.ID); ok {
+						go backfillHistory(device, ch, server, since, time.Now())
+					}
+				}
+
+				// Alarms & Conditions abonnieren
+				if device.EventSubscription {
+					eventCtx, cancel := context.WithCancel(ctx)
+					cancelEvents = cancel
+					go runEventSubscription(eventCtx, device, ch, server, db)
+				}
 			}
 
 			// Daten sammeln und veröffentlichen mit persistenter Verbindung
-			err = collectAndPublishDataPersistent(device, ch, stopChan, server, db, &lastStatus, &connectionEstablished)
+			err = collectAndPublishDataPersistent(device, ch, stopChan, server, db, filter, schedule, triggers, &lastStatus, &connectionEstablished)
 
 			// Bei Verbindungsverlust: Markiere Verbindung als getrennt und versuche erneut
 			if err != nil {
 				logrus.Warnf("OPC-UA: Connection issue with device %v: %v", device.Name, err)
 				updateDeviceStatus(server, "opc-ua", device.ID, "6 (connection lost)", db, &lastStatus)
 
+				if cancelEvents != nil {
+					cancelEvents()
+					cancelEvents = nil
+				}
 				if ch != nil {
+					removeOpcuaClient(device.ID)
 					ch.Close(ctx)
 					ch = nil
 				}
@@ -125,11 +172,10 @@ func updateDeviceStatus(server *MQTT.Server, deviceType, deviceID, newStatus str
 	}
 }
 
-// collectAndPublishDataPersistent sammelt und veröffentlicht Daten mit persistenter Verbindung
-func collectAndPublishDataPersistent(device DeviceConfig, ch *client.Client, stopChan chan struct{}, server *MQTT.Server, db *sql.DB, lastStatus *string, connectionEstablished *bool) error {
-	dataNodes := device.DataNode
-	sleeptime := time.Duration(device.AcquisitionTime) * time.Millisecond
-
+// collectAndPublishDataPersistent sammelt und veröffentlicht Daten mit persistenter Verbindung.
+// Die Erfassungsgruppen werden gemäß schedule nacheinander über dieselbe Verbindung gelesen,
+// anschließend werden die Trigger-Regeln ausgewertet.
+func collectAndPublishDataPersistent(device DeviceConfig, ch *client.Client, stopChan chan struct{}, server *MQTT.Server, db *sql.DB, filter *PublishFilter, schedule *ScanSchedule, triggers *TriggerEvaluator, lastStatus *string, connectionEstablished *bool) error {
 	// Mehrere Zyklen mit derselben Verbindung ausführen
 	maxCyclesPerConnection := 100 // Nach 100 Zyklen kurz prüfen
 	cycleCount := 0
@@ -139,41 +185,65 @@ func collectAndPublishDataPersistent(device DeviceConfig, ch *client.Client, sto
 		case <-stopChan:
 			return nil
 		default:
-			cycleStart := time.Now()
-
-			// Lese Daten mit Fehlerbehandlung
-			data, err := readDataWithRetry(ch, dataNodes, 3) // 3 Retry-Versuche
-			if err != nil {
-				logrus.Errorf("OPC-UA: Persistent connection failed for device %v: %v", device.Name, err)
-				*connectionEstablished = false
-				return err
+			// Auf die nächste fällige Erfassungsgruppe warten
+			due := schedule.Due(time.Now())
+			if len(due) == 0 {
+				if schedule.Empty() {
+					updateDeviceStatus(server, "opc-ua", device.ID, "4 (no datapoints)", db, lastStatus)
+				}
+				select {
+				case <-stopChan:
+					return nil
+				case <-time.After(schedule.Wait(time.Now())):
+				}
+				continue
 			}
 
-			convData, err := convData(data, dataNodes)
-			if err != nil {
-				logrus.Errorf("OPC-UA: Error converting data from %v: %s", device.Name, err)
-				updateDeviceStatus(server, "opc-ua", device.ID, "3 (error)", db, lastStatus)
-				return err
-			}
+			for _, group := range due {
+				cycleStart := time.Now()
+				dataNodes := schedule.Device(group).DataNode
 
-			if err = pubData(convData, device.Name, device.ID, server); err != nil {
-				logrus.Errorf("OPC-UA: Error publishing data from %v: %s", device.Name, err)
-				updateDeviceStatus(server, "opc-ua", device.ID, "3 (error)", db, lastStatus)
-				return err
-			}
+				// Lese Daten mit Fehlerbehandlung
+				data, err := readDataWithRetry(ch, dataNodes, 3) // 3 Retry-Versuche
+				if err != nil {
+					logrus.Errorf("OPC-UA: Persistent connection failed for device %v: %v", device.Name, err)
+					metrics.ReadError("opc-ua", device.ID)
+					*connectionEstablished = false
+					schedule.Retry()
+					return err
+				}
 
-			// Status aktualisieren
-			if len(convData) > 0 {
-				updateDeviceStatus(server, "opc-ua", device.ID, "1 (running)", db, lastStatus)
-			} else {
-				updateDeviceStatus(server, "opc-ua", device.ID, "4 (no datapoints)", db, lastStatus)
-			}
+				convData, err := convData(data, dataNodes)
+				if err != nil {
+					logrus.Errorf("OPC-UA: Error converting data from %v: %s", device.Name, err)
+					updateDeviceStatus(server, "opc-ua", device.ID, "3 (error)", db, lastStatus)
+					schedule.Retry()
+					return err
+				}
 
-			// Cycle-Timing
-			cycleDuration := time.Since(cycleStart)
-			remainingTime := sleeptime - cycleDuration
-			if remainingTime > 0 {
-				time.Sleep(remainingTime)
+				if err = pubData(convData, device, server, filter); err != nil {
+					logrus.Errorf("OPC-UA: Error publishing data from %v: %s", device.Name, err)
+					updateDeviceStatus(server, "opc-ua", device.ID, "3 (error)", db, lastStatus)
+					schedule.Retry()
+					return err
+				}
+				markSuccessfulRead(db, device.ID, cycleStart)
+
+				// Trigger-Regeln auswerten und Datensätze lesen
+				if !triggers.Empty() {
+					runTriggers(device, ch, server, triggers, convData, dataNodes)
+				}
+
+				// Status aktualisieren
+				if len(convData) > 0 {
+					updateDeviceStatus(server, "opc-ua", device.ID, "1 (running)", db, lastStatus)
+				} else {
+					updateDeviceStatus(server, "opc-ua", device.ID, "4 (no datapoints)", db, lastStatus)
+				}
+
+				// Zyklusdauer und Overruns der Gruppe erfassen, nächsten Zyklus planen
+				metrics.ObserveCycle("opc-ua", device.ID, time.Since(cycleStart))
+				schedule.Done(group, cycleStart)
 			}
 
 			cycleCount++
@@ -185,7 +255,7 @@ func collectAndPublishDataPersistent(device DeviceConfig, ch *client.Client, sto
 }
 
 // collectAndPublishData collects and publishes data from an OPC-UA client to an MQTT broker.
-func collectAndPublishData(device DeviceConfig, ch *client.Client, stopChan chan struct{}, server *MQTT.Server, db *sql.DB, lastStatus *string) error {
+func collectAndPublishData(device DeviceConfig, ch *client.Client, stopChan chan struct{}, server *MQTT.Server, db *sql.DB, filter *PublishFilter, lastStatus *string) error {
 	dataNodes := device.DataNode
 
 	sleeptime := time.Duration(device.AcquisitionTime) * time.Millisecond
@@ -212,7 +282,7 @@ func collectAndPublishData(device DeviceConfig, ch *client.Client, stopChan chan
 				return err
 			}
 
-			if err = pubData(convData, device.Name, device.ID, server); err != nil {
+			if err = pubData(convData, device, server, filter); err != nil {
 				logrus.Errorf("OPC-UA: Error publishing data from %v: %s", device.Name, err)
 				updateDeviceStatus(server, "opc-ua", device.ID, "3 (error)", db, lastStatus)
 				return err
@@ -0,0 +1,565 @@
+package opcua
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/awcullen/opcua/client"
+	awcullenua "github.com/awcullen/opcua/ua"
+)
+
+const methodCallTimeout = 30 * time.Second
+
+// MethodArgument beschreibt ein Ein- oder Ausgabeargument einer OPC-UA-Methode.
+type MethodArgument struct {
+	Name         string `json:"name"`
+	DataType     string `json:"dataType"`
+	DataTypeName string `json:"dataTypeName,omitempty"`
+	BuiltinType  string `json:"builtinType,omitempty"` // Übertragener Standard-Datentyp (z.B. Int32 bei Enumerationen)
+	ValueRank    int32  `json:"valueRank"`
+	Description  string `json:"description,omitempty"`
+}
+
+// MethodSignature enthält die Argumente einer Methode aus den Properties InputArguments/OutputArguments.
+type MethodSignature struct {
+	MethodID        string           `json:"methodId"`
+	InputArguments  []MethodArgument `json:"inputArguments"`
+	OutputArguments []MethodArgument `json:"outputArguments"`
+}
+
+// MethodInput ist ein Eingabewert für einen Methodenaufruf. Ist Name gesetzt, wird das Argument
+// über den Namen zugeordnet, sonst über die Position. DataType (Standard-Datentyp, z.B. Int32)
+// ist erforderlich, wenn die Methode keine InputArguments-Property besitzt, und überschreibt sonst
+// den Datentyp des Arguments, etwa bei Strukturen ohne passenden Standard-Datentyp.
+type MethodInput struct {
+	Name     string      `json:"name,omitempty"`
+	Value    interface{} `json:"value"`
+	DataType string      `json:"dataType,omitempty"`
+}
+
+// MethodCallRequest beschreibt einen Methodenaufruf.
+type MethodCallRequest struct {
+	ObjectID string        `json:"objectId"`
+	MethodID string        `json:"methodId"`
+	Inputs   []MethodInput `json:"inputs"`
+}
+
+// MethodOutput ist ein typisierter Rückgabewert eines Methodenaufrufs.
+type MethodOutput struct {
+	Name     string      `json:"name,omitempty"`
+	DataType string      `json:"dataType,omitempty"`
+	Value    interface{} `json:"value"`
+}
+
+// MethodCallResult ist das Ergebnis eines Methodenaufrufs.
+type MethodCallResult struct {
+	StatusCode           string         `json:"statusCode"`
+	Good                 bool           `json:"good"`
+	InputArgumentResults []string       `json:"inputArgumentResults,omitempty"`
+	Outputs              []MethodOutput `json:"outputs"`
+}
+
+// ErrInvalidMethodInput kennzeichnet Eingabefehler, die vor dem Aufruf erkannt werden.
+var ErrInvalidMethodInput = errors.New("invalid method input")
+
+// builtinDataTypeIDs ist die Umkehrung von builtinDataTypes (Name -> ID).
+var builtinDataTypeIDs = func() map[string]uint32 {
+	ids := make(map[string]uint32, len(builtinDataTypes))
+	for id, name := range builtinDataTypes {
+		ids[name] = id
+	}
+	return ids
+}()
+
+// GetMethodSignature liest die Ein- und Ausgabeargumente einer Methode über die Session des Treibers.
+func GetMethodSignature(deviceID, methodID string) (*MethodSignature, error) {
+	ch, ok := GetClient(deviceID)
+	if !ok {
+		return nil, ErrNoSession
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), methodCallTimeout)
+	defer cancel()
+
+	return readMethodSignature(ctx, ch, deviceID, methodID)
+}
+
+// CallMethod ruft eine beliebige Methode über die Session des Treibers auf. Die Eingaben werden
+// anhand der InputArguments-Property geprüft und in die erwarteten Datentypen konvertiert.
+func CallMethod(deviceID string, req MethodCallRequest) (*MethodCallResult, error) {
+	ch, ok := GetClient(deviceID)
+	if !ok {
+		return nil, ErrNoSession
+	}
+	if req.ObjectID == "" || req.MethodID == "" {
+		return nil, fmt.Errorf("%w: objectId and methodId are required", ErrInvalidMethodInput)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), methodCallTimeout)
+	defer cancel()
+
+	signature, err := readMethodSignature(ctx, ch, deviceID, req.MethodID)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs, err := buildInputArguments(signature.InputArguments, req.Inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := ch.Call(ctx, &awcullenua.CallRequest{
+		MethodsToCall: []awcullenua.CallMethodRequest{
+			{
+				ObjectID:       awcullenua.ParseNodeID(req.ObjectID),
+				MethodID:       awcullenua.ParseNodeID(req.MethodID),
+				InputArguments: inputs,
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("method call failed: %v", err)
+	}
+	if len(resp.Results) == 0 {
+		return nil, errors.New("method call returned no results")
+	}
+
+	result := resp.Results[0]
+	callResult := &MethodCallResult{
+		StatusCode: statusText(result.StatusCode),
+		Good:       result.StatusCode.IsGood(),
+		Outputs:    make([]MethodOutput, 0, len(result.OutputArguments)),
+	}
+	for _, status := range result.InputArgumentResults {
+		callResult.InputArgumentResults = append(callResult.InputArgumentResults, statusText(status))
+	}
+	for i, value := range result.OutputArguments {
+		output := MethodOutput{Value: value}
+		if i < len(signature.OutputArguments) {
+			output.Name = signature.OutputArguments[i].Name
+			output.DataType = signature.OutputArguments[i].DataTypeName
+		}
+		callResult.Outputs = append(callResult.Outputs, output)
+	}
+
+	return callResult, nil
+}
+
+// readMethodSignature sucht die Properties InputArguments/OutputArguments der Methode und liest sie.
+func readMethodSignature(ctx context.Context, ch *client.Client, deviceID, methodID string) (*MethodSignature, error) {
+	signature := &MethodSignature{
+		MethodID:        methodID,
+		InputArguments:  []MethodArgument{},
+		OutputArguments: []MethodArgument{},
+	}
+
+	children, err := browseAllChildren(ctx, ch, deviceID, methodID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, child := range children {
+		if child.BrowseName != "InputArguments" && child.BrowseName != "OutputArguments" {
+			continue
+		}
+
+		resp, err := ch.Read(ctx, &awcullenua.ReadRequest{
+			NodesToRead: []awcullenua.ReadValueID{{NodeID: awcullenua.ParseNodeID(child.NodeID), AttributeID: awcullenua.AttributeIDValue}},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("reading %s failed: %v", child.BrowseName, err)
+		}
+		if len(resp.Results) == 0 || !resp.Results[0].StatusCode.IsGood() {
+			continue
+		}
+
+		arguments := decodeArguments(ctx, ch, resp.Results[0].Value)
+		if child.BrowseName == "InputArguments" {
+			signature.InputArguments = arguments
+		} else {
+			signature.OutputArguments = arguments
+		}
+	}
+
+	return signature, nil
+}
+
+// decodeArguments wandelt den Wert einer Arguments-Property in MethodArguments um.
+func decodeArguments(ctx context.Context, ch *client.Client, value interface{}) []MethodArgument {
+	var raw []awcullenua.ExtensionObject
+	switch v := value.(type) {
+	case []awcullenua.ExtensionObject:
+		raw = v
+	case awcullenua.ExtensionObject:
+		raw = []awcullenua.ExtensionObject{v}
+	}
+
+	arguments := make([]MethodArgument, 0, len(raw))
+	for _, obj := range raw {
+		var arg awcullenua.Argument
+		switch a := obj.(type) {
+		case awcullenua.Argument:
+			arg = a
+		case *awcullenua.Argument:
+			arg = *a
+		default:
+			continue
+		}
+
+		argument := MethodArgument{
+			Name:        arg.Name,
+			ValueRank:   arg.ValueRank,
+			Description: arg.Description.Text,
+		}
+		if arg.DataType != nil {
+			argument.DataType = fmt.Sprint(arg.DataType)
+			argument.DataTypeName = dataTypeName(ctx, ch, arg.DataType)
+			argument.BuiltinType = encodingType(ctx, ch, arg.DataType)
+		}
+		arguments = append(arguments, argument)
+	}
+	return arguments
+}
+
+// encodingType liefert den Standard-Datentyp, mit dem Werte eines Datentyps übertragen werden: den Typ
+// selbst, den nächsten Standard-Obertyp (z.B. Duration -> Double) bzw. Int32 bei Enumerationen.
+// Für Strukturen und unbekannte Typen wird "" geliefert.
+func encodingType(ctx context.Context, ch *client.Client, dataType awcullenua.NodeID) string {
+	for depth := 0; depth < 10 && dataType != nil; depth++ {
+		if numeric, ok := dataType.(awcullenua.NodeIDNumeric); ok && numeric.NamespaceIndex == 0 {
+			switch name := builtinDataTypes[numeric.ID]; name {
+			case "Enumeration":
+				return "Int32"
+			case "Structure", "BaseDataType":
+				return ""
+			case "":
+			default:
+				return name
+			}
+		}
+		dataType = superType(ctx, ch, dataType)
+	}
+	return ""
+}
+
+// superType liefert den Obertyp eines Datentyps über die inverse HasSubtype-Referenz.
+func superType(ctx context.Context, ch *client.Client, dataType awcullenua.NodeID) awcullenua.NodeID {
+	resp, err := ch.Browse(ctx, &awcullenua.BrowseRequest{
+		NodesToBrowse: []awcullenua.BrowseDescription{
+			{
+				NodeID:          dataType,
+				BrowseDirection: awcullenua.BrowseDirectionInverse,
+				ReferenceTypeID: awcullenua.ReferenceTypeIDHasSubtype,
+				ResultMask:      uint32(awcullenua.BrowseResultMaskNone),
+			},
+		},
+	})
+	if err != nil || len(resp.Results) == 0 || len(resp.Results[0].References) == 0 {
+		return nil
+	}
+	return resp.Results[0].References[0].NodeID.NodeID
+}
+
+// buildInputArguments ordnet die Eingaben den erwarteten Argumenten zu und konvertiert sie.
+func buildInputArguments(expected []MethodArgument, inputs []MethodInput) ([]awcullenua.Variant, error) {
+	// Methoden ohne InputArguments-Property: Datentypen müssen explizit angegeben werden
+	if len(expected) == 0 {
+		variants := make([]awcullenua.Variant, 0, len(inputs))
+		for i, input := range inputs {
+			if input.DataType == "" {
+				return nil, fmt.Errorf("%w: method has no InputArguments, dataType is required for input %d", ErrInvalidMethodInput, i)
+			}
+			// Ohne ValueRank bestimmt die Form des JSON-Werts, ob ein Array übergeben wird
+			valueRank := int32(valueRankScalar)
+			if _, isList := input.Value.([]interface{}); isList {
+				valueRank = valueRankOneDimension
+			}
+			value, err := convertMethodValue(input.Value, input.DataType, valueRank)
+			if err != nil {
+				return nil, fmt.Errorf("%w: input %d: %v", ErrInvalidMethodInput, i, err)
+			}
+			variants = append(variants, value)
+		}
+		return variants, nil
+	}
+
+	if len(inputs) != len(expected) {
+		return nil, fmt.Errorf("%w: expected %d input arguments, got %d", ErrInvalidMethodInput, len(expected), len(inputs))
+	}
+
+	// Zuordnung über Namen, falls alle Eingaben benannt sind
+	byName := make(map[string]MethodInput, len(inputs))
+	for _, input := range inputs {
+		if input.Name == "" {
+			byName = nil
+			break
+		}
+		byName[input.Name] = input
+	}
+
+	variants := make([]awcullenua.Variant, len(expected))
+	for i, arg := range expected {
+		input := inputs[i]
+		if byName != nil {
+			named, ok := byName[arg.Name]
+			if !ok {
+				return nil, fmt.Errorf("%w: missing input argument %q", ErrInvalidMethodInput, arg.Name)
+			}
+			input = named
+		}
+
+		// Explizit angegebener Datentyp vor dem übertragenen Typ des Arguments
+		typeName := input.DataType
+		if typeName == "" {
+			typeName = arg.BuiltinType
+		}
+		if typeName == "" {
+			typeName = arg.DataTypeName
+		}
+		value, err := convertMethodValue(input.Value, typeName, arg.ValueRank)
+		if err != nil {
+			return nil, fmt.Errorf("%w: argument %q: %v", ErrInvalidMethodInput, arg.Name, err)
+		}
+		variants[i] = value
+	}
+	return variants, nil
+}
+
+// ValueRank-Werte laut OPC UA Part 3
+const (
+	valueRankScalarOrOneDimension = -3
+	valueRankAny                  = -2
+	valueRankScalar               = -1
+	valueRankOneDimension         = 1 // >= 0: Array (0 = eine oder mehrere Dimensionen)
+)
+
+// convertMethodValue konvertiert einen JSON-Wert in den OPC-UA-Datentyp. Bei ValueRank >= 0
+// wird ein Array erwartet, bei Any und ScalarOrOneDimension sind Skalar und Array zulässig.
+func convertMethodValue(value interface{}, typeName string, valueRank int32) (interface{}, error) {
+	if _, ok := builtinDataTypeIDs[typeName]; !ok {
+		return nil, fmt.Errorf("unsupported data type %q, specify a builtin dataType (e.g. Int32) for this input", typeName)
+	}
+
+	list, isList := value.([]interface{})
+	switch {
+	case valueRank >= 0:
+		if !isList {
+			return nil, errors.New("expected array value")
+		}
+	case valueRank == valueRankAny || valueRank == valueRankScalarOrOneDimension:
+		if !isList {
+			return convertScalar(value, typeName)
+		}
+	default:
+		if isList {
+			return nil, errors.New("expected scalar value, got array")
+		}
+		return convertScalar(value, typeName)
+	}
+
+	zero, err := convertScalar(zeroJSONValue(typeName), typeName)
+	if err != nil {
+		return nil, err
+	}
+	slice := reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(zero)), len(list), len(list))
+	for i, item := range list {
+		converted, err := convertScalar(item, typeName)
+		if err != nil {
+			return nil, fmt.Errorf("element %d: %v", i, err)
+		}
+		slice.Index(i).Set(reflect.ValueOf(converted))
+	}
+	return slice.Interface(), nil
+}
+
+// zeroJSONValue liefert einen Platzhalterwert, um den Go-Typ eines Datentyps zu bestimmen.
+func zeroJSONValue(typeName string) interface{} {
+	switch typeName {
+	case "Boolean":
+		return false
+	case "String", "LocalizedText", "QualifiedName", "ByteString", "NodeId":
+		return ""
+	case "DateTime":
+		return time.Time{}.Format(time.RFC3339)
+	default:
+		return float64(0)
+	}
+}
+
+// convertScalar konvertiert einen einzelnen Wert in den passenden Go-Typ für den Encoder.
+func convertScalar(value interface{}, typeName string) (interface{}, error) {
+	switch typeName {
+	case "Boolean":
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			return strconv.ParseBool(v)
+		case float64:
+			return v != 0, nil
+		}
+	case "SByte", "Int16", "Int32", "Int64", "Integer":
+		n, err := toInt64(value)
+		if err != nil {
+			return nil, err
+		}
+		switch typeName {
+		case "SByte":
+			if n < math.MinInt8 || n > math.MaxInt8 {
+				return nil, fmt.Errorf("value %d out of range for SByte", n)
+			}
+			return int8(n), nil
+		case "Int16":
+			if n < math.MinInt16 || n > math.MaxInt16 {
+				return nil, fmt.Errorf("value %d out of range for Int16", n)
+			}
+			return int16(n), nil
+		case "Int32":
+			if n < math.MinInt32 || n > math.MaxInt32 {
+				return nil, fmt.Errorf("value %d out of range for Int32", n)
+			}
+			return int32(n), nil
+		}
+		return n, nil
+	case "Byte", "UInt16", "UInt32", "UInt64", "UInteger":
+		n, err := toUint64(value)
+		if err != nil {
+			return nil, fmt.Errorf("%v for %s", err, typeName)
+		}
+		switch typeName {
+		case "Byte":
+			if n > math.MaxUint8 {
+				return nil, fmt.Errorf("value %d out of range for Byte", n)
+			}
+			return uint8(n), nil
+		case "UInt16":
+			if n > math.MaxUint16 {
+				return nil, fmt.Errorf("value %d out of range for UInt16", n)
+			}
+			return uint16(n), nil
+		case "UInt32":
+			if n > math.MaxUint32 {
+				return nil, fmt.Errorf("value %d out of range for UInt32", n)
+			}
+			return uint32(n), nil
+		}
+		return n, nil
+	case "Float", "Double", "Number":
+		f, err := toFloat64(value)
+		if err != nil {
+			return nil, err
+		}
+		if typeName == "Float" {
+			return float32(f), nil
+		}
+		return f, nil
+	case "String":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		return fmt.Sprint(value), nil
+	case "LocalizedText":
+		if s, ok := value.(string); ok {
+			return awcullenua.LocalizedText{Text: s}, nil
+		}
+	case "QualifiedName":
+		if s, ok := value.(string); ok {
+			return awcullenua.ParseQualifiedName(s), nil
+		}
+	case "NodeId":
+		if s, ok := value.(string); ok {
+			return awcullenua.ParseNodeID(s), nil
+		}
+	case "ByteString":
+		if s, ok := value.(string); ok {
+			return awcullenua.ByteString(s), nil
+		}
+	case "DateTime":
+		if s, ok := value.(string); ok {
+			return time.Parse(time.RFC3339, s)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported data type %q", typeName)
+	}
+	return nil, fmt.Errorf("cannot convert %v (%T) to %s", value, value, typeName)
+}
+
+// toInt64 konvertiert JSON-Zahlen und Strings in int64
+func toInt64(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, fmt.Errorf("value %v is not an integer", v)
+		}
+		// float64(math.MaxInt64) ist 2^63 und damit bereits außerhalb des Bereichs
+		if v < math.MinInt64 || v >= math.MaxInt64 {
+			return 0, fmt.Errorf("value %v out of range", v)
+		}
+		return int64(v), nil
+	case int:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	}
+	return 0, fmt.Errorf("cannot convert %v (%T) to integer", value, value)
+}
+
+// toUint64 konvertiert JSON-Zahlen und Strings in uint64. Werte über 2^53 sind als JSON-Zahl
+// nicht exakt darstellbar und sollten als String übergeben werden.
+func toUint64(value interface{}) (uint64, error) {
+	switch v := value.(type) {
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, fmt.Errorf("value %v is not an integer", v)
+		}
+		if v < 0 || v >= math.MaxUint64 {
+			return 0, fmt.Errorf("value %v out of range", v)
+		}
+		return uint64(v), nil
+	case int:
+		if v < 0 {
+			return 0, fmt.Errorf("value %d must not be negative", v)
+		}
+		return uint64(v), nil
+	case int64:
+		if v < 0 {
+			return 0, fmt.Errorf("value %d must not be negative", v)
+		}
+		return uint64(v), nil
+	case uint64:
+		return v, nil
+	case string:
+		return strconv.ParseUint(v, 10, 64)
+	}
+	return 0, fmt.Errorf("cannot convert %v (%T) to unsigned integer", value, value)
+}
+
+// toFloat64 konvertiert JSON-Zahlen und Strings in float64
+func toFloat64(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	return 0, fmt.Errorf("cannot convert %v (%T) to number", value, value)
+}
+
+// statusText liefert eine lesbare Darstellung eines StatusCodes
+func statusText(code awcullenua.StatusCode) string {
+	if code.IsGood() {
+		return "Good"
+	}
+	return code.Error()
+}
@@ -3,14 +3,29 @@ package opcua
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"iot-gateway/topics"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	MQTT "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
 	"github.com/sirupsen/logrus"
 )
 
 var mqttClient mqtt.Client
 
+// Methodenaufrufe über MQTT: Anfrage auf commands/opc-ua/<deviceId>/methods,
+// Antwort auf commands/opc-ua/<deviceId>/methods/response
+const methodCommandTopic = "commands/opc-ua/+/methods"
+
+var (
+	methodCommandServer *MQTT.Server
+	methodCommandMu     sync.Mutex
+)
+
 // StartMqttDataUpdateListener starts the MQTT listener for data point updates.
 //
 // Args:
@@ -48,7 +63,7 @@ func handleMqttDataUpdate(client mqtt.Client, message mqtt.Message) {
 		return
 	}
 
-	opcuaClient, exists := opcuaClients[update.DeviceName]
+	opcuaClient, exists := GetClient(update.DeviceName)
 	if !exists {
 		logrus.Errorf("OPC-UA: Client for device '%s' not found", update.DeviceName)
 		return
@@ -64,7 +79,8 @@ func handleMqttDataUpdate(client mqtt.Client, message mqtt.Message) {
 // Args:
 //
 //   - data (map[string]interface{}): The data to publish.
-//   - deviceName (string): The name of the device.
+//   - device (DeviceConfig): The device, its DataNodes carry the publish settings.
+//   - filter (*PublishFilter): Deadband/publish-on-change state of the current driver run.
 //
 // Returns:
 //
@@ -76,24 +92,99 @@ func handleMqttDataUpdate(client mqtt.Client, message mqtt.Message) {
 //	    "temperature": 25.0,
 //	    "humidity":    50.0,
 //	}
-//	err := pubData(data, "device1")
+//	err := pubData(data, device, server, filter)
 //	if err != nil {
 //	    fmt.Println(err)
 //	}
-func pubData(data map[string]interface{}, deviceName string, deviceId string, server *MQTT.Server) error {
-	for id, value := range data {
-		payload, err := json.Marshal(value)
-		if err != nil {
-			return fmt.Errorf("OPC-UA: Failed to marshal data for node-name %s: %v", id, err)
-		}
+func pubData(data map[string]interface{}, device DeviceConfig, server *MQTT.Server, filter *PublishFilter) error {
+	settings := make(map[string]PublishSettings, len(device.DataNode))
+	for _, node := range device.DataNode {
+		settings["["+node.ID+"] "+node.Name] = node.Publish
+	}
 
-		topic := fmt.Sprintf("data/opc-ua/%s/%s", deviceId, id)
-		err = server.Publish(topic, []byte(payload), true, 2)
-		if err != nil {
+	for id, value := range data {
+		topic := fmt.Sprintf("data/opc-ua/%s/%s", device.ID, id)
+		if _, err := PublishValue(server, filter, topic, value, settings[id]); err != nil {
 			return fmt.Errorf("OPC-UA: Failed to publish data for node-name %s: %v", id, err)
 		}
+	}
 
+	return nil
+}
+
+// pubBackfillData veröffentlicht einen nachgeholten Wert aus der Server-Historie.
+// Backfill-Werte gehen nicht auf data/..., damit retained Live-Werte nicht überschrieben werden.
+func pubBackfillData(id string, value interface{}, sourceTimestamp time.Time, deviceId string, server *MQTT.Server) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"value":     value,
+		"timestamp": sourceTimestamp.UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return fmt.Errorf("OPC-UA: Failed to marshal backfill data for node-name %s: %v", id, err)
 	}
 
+	topic := fmt.Sprintf("backfill/opc-ua/%s/%s", deviceId, id)
+	if err := server.Publish(topic, payload, false, 1); err != nil {
+		return fmt.Errorf("OPC-UA: Failed to publish backfill data for node-name %s: %v", id, err)
+	}
 	return nil
 }
+
+// StartMethodCommandListener abonniert das Command-Topic für Methodenaufrufe über den Inline-Client.
+// Mehrfache Aufrufe mit demselben Server sind unkritisch.
+func StartMethodCommandListener(server *MQTT.Server) {
+	methodCommandMu.Lock()
+	defer methodCommandMu.Unlock()
+
+	if server == nil || server == methodCommandServer {
+		return
+	}
+
+	if err := server.Subscribe(methodCommandTopic, topics.MethodCommandSubscriptionID, func(cl *MQTT.Client, sub packets.Subscription, pk packets.Packet) {
+		// Methodenaufrufe können dauern - den Broker nicht blockieren
+		go handleMethodCommand(server, pk.TopicName, pk.Payload)
+	}); err != nil {
+		logrus.Errorf("OPC-UA: Failed to subscribe to topic %v: %v", methodCommandTopic, err)
+		return
+	}
+
+	methodCommandServer = server
+	logrus.Infof("OPC-UA: Method command listener subscribed to %v", methodCommandTopic)
+}
+
+// handleMethodCommand führt einen Methodenaufruf aus einer MQTT-Nachricht aus und publiziert das Ergebnis.
+func handleMethodCommand(server *MQTT.Server, topic string, payload []byte) {
+	parts := strings.Split(topic, "/")
+	if len(parts) != 4 {
+		return
+	}
+	deviceID := parts[2]
+
+	var command struct {
+		MethodCallRequest
+		RequestID string `json:"requestId,omitempty"`
+	}
+
+	response := map[string]interface{}{}
+	if err := json.Unmarshal(payload, &command); err != nil {
+		response["error"] = fmt.Sprintf("invalid payload: %v", err)
+	} else {
+		response["requestId"] = command.RequestID
+		result, err := CallMethod(deviceID, command.MethodCallRequest)
+		if err != nil {
+			logrus.Warnf("OPC-UA: Method call via MQTT on device %s failed: %v", deviceID, err)
+			response["error"] = err.Error()
+		} else {
+			response["result"] = result
+		}
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		logrus.Errorf("OPC-UA: Failed to marshal method call response: %v", err)
+		return
+	}
+	if err := server.Publish(topic+"/response", data, false, 1); err != nil {
+		logrus.Errorf("OPC-UA: Failed to publish method call response: %v", err)
+	}
+}
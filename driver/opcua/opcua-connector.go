@@ -41,7 +41,7 @@ func readData(ch *client.Client, nodes []DataNode) ([]*awcullenua.DataValue, err
 
 	for i, result := range readResponse.Results {
 		if !result.StatusCode.IsGood() {
-			logrus.Errorf("OPC-UA: reading node '%s' failed with status: %v", nodes[i], result.StatusCode)
+			logrus.Errorf("OPC-UA: reading node '%s' failed with status: %v", nodes[i].Node, result.StatusCode)
 			continue
 		}
 		successfulResults = append(successfulResults, &result)
@@ -0,0 +1,237 @@
+package opcua
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+	"time"
+
+	MQTT "github.com/mochi-mqtt/server/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// Deadband-Arten
+const (
+	DeadbandAbsolute = "absolute"
+	DeadbandPercent  = "percent" // Prozent des Messbereichs (engMin..engMax), ohne Bereich des letzten Werts
+)
+
+// PublishSettings sind die Veröffentlichungs-Einstellungen eines Datenpunkts (S7) bzw. DataNodes (OPC UA).
+// Sie werden als JSON in der Spalte publish_settings gespeichert.
+type PublishSettings struct {
+	// Lineare Skalierung: value*scaleFactor + scaleOffset. Sind rawMin/rawMax gesetzt, wird stattdessen
+	// der Rohbereich auf engMin..engMax abgebildet.
+	ScaleFactor float64 `json:"scaleFactor,omitempty"`
+	ScaleOffset float64 `json:"scaleOffset,omitempty"`
+	RawMin      float64 `json:"rawMin,omitempty"`
+	RawMax      float64 `json:"rawMax,omitempty"`
+	EngMin      float64 `json:"engMin,omitempty"`
+	EngMax      float64 `json:"engMax,omitempty"`
+	Unit        string  `json:"unit,omitempty"`
+
+	Deadband         float64 `json:"deadband,omitempty"`
+	DeadbandType     string  `json:"deadbandType,omitempty"`     // absolute (Standard) oder percent
+	PublishOnChange  bool    `json:"publishOnChange,omitempty"`  // Nur bei Änderung veröffentlichen
+	HeartbeatSeconds int     `json:"heartbeatSeconds,omitempty"` // Spätestens nach dieser Zeit erneut veröffentlichen
+
+	QoS    *byte `json:"qos,omitempty"`    // Standard 2
+	Retain *bool `json:"retain,omitempty"` // Standard true
+}
+
+// ParsePublishSettings liest die Einstellungen aus der Datenbank (leer = Standardverhalten)
+func ParsePublishSettings(raw string) PublishSettings {
+	var settings PublishSettings
+	if raw == "" {
+		return settings
+	}
+	if err := json.Unmarshal([]byte(raw), &settings); err != nil {
+		logrus.Warnf("Invalid publish settings %q: %v", raw, err)
+	}
+	return settings
+}
+
+// Validate prüft die Einstellungen
+func (s PublishSettings) Validate() error {
+	if s.RawMin != s.RawMax && s.EngMin == s.EngMax {
+		return fmt.Errorf("engMin and engMax are required for range scaling")
+	}
+	if s.Deadband < 0 || s.HeartbeatSeconds < 0 {
+		return fmt.Errorf("deadband and heartbeatSeconds must not be negative")
+	}
+	if s.DeadbandType != "" && s.DeadbandType != DeadbandAbsolute && s.DeadbandType != DeadbandPercent {
+		return fmt.Errorf("unknown deadbandType %q", s.DeadbandType)
+	}
+	if s.QoS != nil && *s.QoS > 2 {
+		return fmt.Errorf("qos must be 0, 1 or 2")
+	}
+	return nil
+}
+
+// Scale wendet die Skalierung auf numerische Werte an. Andere Werte bleiben unverändert.
+func (s PublishSettings) Scale(value interface{}) interface{} {
+	rangeScaling := s.RawMax != s.RawMin
+	if !rangeScaling && s.ScaleFactor == 0 && s.ScaleOffset == 0 {
+		return value
+	}
+	v, ok := numericValue(value)
+	if !ok {
+		return value
+	}
+	if rangeScaling {
+		return s.EngMin + (v-s.RawMin)*(s.EngMax-s.EngMin)/(s.RawMax-s.RawMin)
+	}
+	factor := s.ScaleFactor
+	if factor == 0 {
+		factor = 1
+	}
+	return v*factor + s.ScaleOffset
+}
+
+// qos liefert die QoS für die Veröffentlichung
+func (s PublishSettings) qos() byte {
+	if s.QoS != nil {
+		return *s.QoS
+	}
+	return 2
+}
+
+// retain liefert das Retain-Flag für die Veröffentlichung
+func (s PublishSettings) retain() bool {
+	if s.Retain != nil {
+		return *s.Retain
+	}
+	return true
+}
+
+// PublishFilter entscheidet anhand von Deadband, Publish-on-Change und Heartbeat, ob ein Wert veröffentlicht wird.
+// Ein Filter gehört zu einem Treiberlauf; nach einem Neustart wird jeder Wert einmal veröffentlicht.
+type PublishFilter struct {
+	mu   sync.Mutex
+	last map[string]publishedValue
+}
+
+type publishedValue struct {
+	value interface{}
+	at    time.Time
+}
+
+// NewPublishFilter erstellt einen leeren Filter
+func NewPublishFilter() *PublishFilter {
+	return &PublishFilter{last: make(map[string]publishedValue)}
+}
+
+// allow prüft, ob ein Wert für das Topic veröffentlicht werden soll
+func (f *PublishFilter) allow(topic string, value interface{}, s PublishSettings, now time.Time) bool {
+	if !s.PublishOnChange && s.Deadband == 0 {
+		return true
+	}
+
+	f.mu.Lock()
+	last, ok := f.last[topic]
+	f.mu.Unlock()
+	if !ok {
+		return true
+	}
+	if s.HeartbeatSeconds > 0 && now.Sub(last.at) >= time.Duration(s.HeartbeatSeconds)*time.Second {
+		return true
+	}
+
+	v, okNew := numericValue(value)
+	prev, okPrev := numericValue(last.value)
+	if !okNew || !okPrev {
+		return !reflect.DeepEqual(value, last.value)
+	}
+
+	diff := math.Abs(v - prev)
+	if s.Deadband == 0 {
+		return diff != 0
+	}
+	threshold := s.Deadband
+	if s.DeadbandType == DeadbandPercent {
+		span := math.Abs(s.EngMax - s.EngMin)
+		if span == 0 {
+			span = math.Abs(prev)
+		}
+		threshold = s.Deadband / 100 * span
+	}
+	return diff > threshold
+}
+
+// record merkt einen veröffentlichten Wert
+func (f *PublishFilter) record(topic string, value interface{}, now time.Time) {
+	f.mu.Lock()
+	f.last[topic] = publishedValue{value, now}
+	f.mu.Unlock()
+}
+
+// PublishValue skaliert einen Wert, prüft ihn gegen den Filter und veröffentlicht ihn mit QoS/Retain des Datenpunkts.
+// Der Rückgabewert gibt an, ob veröffentlicht wurde.
+func PublishValue(server *MQTT.Server, filter *PublishFilter, topic string, value interface{}, s PublishSettings) (bool, error) {
+	value = s.Scale(value)
+	now := time.Now()
+	if filter != nil && !filter.allow(topic, value, s, now) {
+		return false, nil
+	}
+
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	if err := server.Publish(topic, payload, s.retain(), s.qos()); err != nil {
+		return false, err
+	}
+	if filter != nil {
+		filter.record(topic, value, now)
+	}
+	return true, nil
+}
+
+// PublishMetadata veröffentlicht Einheit und Messbereich eines Datenpunkts retained auf meta/<type>/<deviceId>/<name>
+func PublishMetadata(server *MQTT.Server, deviceType, deviceID, name string, s PublishSettings) {
+	if s.Unit == "" && s.EngMin == s.EngMax {
+		return
+	}
+	meta := map[string]interface{}{"unit": s.Unit}
+	if s.EngMin != s.EngMax {
+		meta["engMin"] = s.EngMin
+		meta["engMax"] = s.EngMax
+	}
+	payload, _ := json.Marshal(meta)
+	topic := fmt.Sprintf("meta/%s/%s/%s", deviceType, deviceID, name)
+	if err := server.Publish(topic, payload, true, 1); err != nil {
+		logrus.Warnf("Failed to publish metadata on %s: %v", topic, err)
+	}
+}
+
+// numericValue wandelt numerische Werte in float64 um
+func numericValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	}
+	return 0, false
+}
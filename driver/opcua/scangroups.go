@@ -0,0 +1,219 @@
+package opcua
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ScanGroup ist eine Erfassungsgruppe eines Geräts mit eigener Zykluszeit.
+// Datenpunkte ohne (gültige) Gruppe gehören zur Standardgruppe 0 mit der Zykluszeit des Geräts.
+type ScanGroup struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Interval int    `json:"interval"` // Zykluszeit in ms
+}
+
+// ScanGroupStats sind die Zyklusstatistiken einer Erfassungsgruppe seit dem Start des Treibers
+type ScanGroupStats struct {
+	GroupID      int64     `json:"groupId"`
+	Name         string    `json:"name"`
+	Interval     int       `json:"interval"`
+	Datapoints   int       `json:"datapoints"`
+	Cycles       int64     `json:"cycles"`
+	Overruns     int64     `json:"overruns"`     // Zyklen, die länger als die Zykluszeit dauerten oder zu spät starteten
+	LastDuration float64   `json:"lastDuration"` // ms
+	MaxDuration  float64   `json:"maxDuration"`  // ms
+	AvgDuration  float64   `json:"avgDuration"`  // ms
+	MaxLateness  float64   `json:"maxLateness"`  // ms, Verspätung gegenüber dem geplanten Start
+	LastRun      time.Time `json:"lastRun,omitempty"`
+}
+
+var (
+	scanStats   = make(map[string]map[int64]*ScanGroupStats) // Geräte-ID -> Gruppen-ID -> Statistik
+	scanStatsMu sync.RWMutex
+)
+
+// GetScanGroupStats liefert die Statistiken aller Erfassungsgruppen eines Geräts
+func GetScanGroupStats(deviceID string) []ScanGroupStats {
+	scanStatsMu.RLock()
+	defer scanStatsMu.RUnlock()
+
+	stats := []ScanGroupStats{}
+	for _, s := range scanStats[deviceID] {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].GroupID < stats[j].GroupID })
+	return stats
+}
+
+// ScanSchedule plant die Erfassungsgruppen eines Geräts über eine gemeinsame Verbindung.
+// Fällige Gruppen werden nacheinander gelesen; eine Verspätung durch andere Gruppen zählt als Overrun.
+type ScanSchedule struct {
+	device DeviceConfig
+	groups []ScanGroup
+	known  map[int64]bool
+	skip   map[string]bool // Datenpunkte, die nur von Trigger-Regeln gelesen werden
+	next   map[int64]time.Time
+}
+
+// NewScanSchedule erstellt den Plan für ein Gerät. Gruppen ohne Datenpunkte werden nicht gelesen.
+func NewScanSchedule(device DeviceConfig) *ScanSchedule {
+	s := &ScanSchedule{
+		device: device,
+		known:  make(map[int64]bool),
+		skip:   recordOnly(device),
+		next:   make(map[int64]time.Time),
+	}
+	for _, g := range device.ScanGroups {
+		if g.ID != 0 && g.Interval > 0 {
+			s.known[g.ID] = true
+		}
+	}
+
+	counts := make(map[int64]int)
+	for _, dp := range device.Datapoint {
+		if !s.skip[dp.ID] {
+			counts[s.groupOf(dp.ScanGroup)]++
+		}
+	}
+	for _, node := range device.DataNode {
+		if !s.skip[node.ID] {
+			counts[s.groupOf(node.ScanGroup)]++
+		}
+	}
+
+	if counts[0] > 0 {
+		s.groups = append(s.groups, ScanGroup{ID: 0, Name: "default", Interval: device.AcquisitionTime})
+	}
+	for _, g := range device.ScanGroups {
+		if s.known[g.ID] && counts[g.ID] > 0 {
+			s.groups = append(s.groups, g)
+		}
+	}
+
+	// Statistiken für diesen Treiberlauf zurücksetzen
+	stats := make(map[int64]*ScanGroupStats, len(s.groups))
+	for _, g := range s.groups {
+		stats[g.ID] = &ScanGroupStats{GroupID: g.ID, Name: g.Name, Interval: g.Interval, Datapoints: counts[g.ID]}
+	}
+	scanStatsMu.Lock()
+	scanStats[device.ID] = stats
+	scanStatsMu.Unlock()
+
+	return s
+}
+
+// groupOf bildet unbekannte Gruppen auf die Standardgruppe ab
+func (s *ScanSchedule) groupOf(id int64) int64 {
+	if s.known[id] {
+		return id
+	}
+	return 0
+}
+
+// Due liefert die zum Zeitpunkt now fälligen Gruppen
+func (s *ScanSchedule) Due(now time.Time) []ScanGroup {
+	var due []ScanGroup
+	for _, g := range s.groups {
+		if !now.Before(s.next[g.ID]) {
+			due = append(due, g)
+		}
+	}
+	return due
+}
+
+// Empty meldet, dass keine Gruppe zyklisch zu lesen ist (keine Datenpunkte oder nur Trigger-Datensätze)
+func (s *ScanSchedule) Empty() bool {
+	return len(s.groups) == 0
+}
+
+// Wait liefert die Zeit bis zur nächsten fälligen Gruppe. Ohne Gruppen wird die Zykluszeit des
+// Geräts geliefert, damit der Treiber nicht ohne Pause erneut prüft.
+func (s *ScanSchedule) Wait(now time.Time) time.Duration {
+	if s.Empty() {
+		if s.device.AcquisitionTime > 0 {
+			return time.Duration(s.device.AcquisitionTime) * time.Millisecond
+		}
+		return time.Second
+	}
+
+	wait := time.Duration(-1)
+	for _, g := range s.groups {
+		if d := s.next[g.ID].Sub(now); wait < 0 || d < wait {
+			wait = d
+		}
+	}
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// Device liefert eine Kopie der Gerätekonfiguration mit den zyklisch zu lesenden Datenpunkten einer Gruppe
+func (s *ScanSchedule) Device(group ScanGroup) DeviceConfig {
+	device := s.device
+	device.Datapoint = nil
+	device.DataNode = nil
+	for _, dp := range s.device.Datapoint {
+		if !s.skip[dp.ID] && s.groupOf(dp.ScanGroup) == group.ID {
+			device.Datapoint = append(device.Datapoint, dp)
+		}
+	}
+	for _, node := range s.device.DataNode {
+		if !s.skip[node.ID] && s.groupOf(node.ScanGroup) == group.ID {
+			device.DataNode = append(device.DataNode, node)
+		}
+	}
+	return device
+}
+
+// Done plant den nächsten Zyklus einer Gruppe und erfasst die Statistik des abgeschlossenen Zyklus
+func (s *ScanSchedule) Done(group ScanGroup, start time.Time) {
+	now := time.Now()
+	interval := time.Duration(group.Interval) * time.Millisecond
+	duration := now.Sub(start)
+
+	var lateness time.Duration
+	if scheduled := s.next[group.ID]; !scheduled.IsZero() {
+		lateness = start.Sub(scheduled)
+	}
+
+	// Nächster Start im festen Raster; bei Überlauf ab jetzt neu einplanen
+	next := s.next[group.ID].Add(interval)
+	if s.next[group.ID].IsZero() || next.Before(now) {
+		next = start.Add(interval)
+		if next.Before(now) {
+			next = now
+		}
+	}
+	s.next[group.ID] = next
+
+	scanStatsMu.Lock()
+	defer scanStatsMu.Unlock()
+	stats := scanStats[s.device.ID][group.ID]
+	if stats == nil {
+		return
+	}
+	ms := float64(duration) / float64(time.Millisecond)
+	stats.Cycles++
+	stats.LastDuration = ms
+	stats.AvgDuration += (ms - stats.AvgDuration) / float64(stats.Cycles)
+	if ms > stats.MaxDuration {
+		stats.MaxDuration = ms
+	}
+	if late := float64(lateness) / float64(time.Millisecond); late > stats.MaxLateness {
+		stats.MaxLateness = late
+	}
+	if interval > 0 && (duration > interval || lateness > interval) {
+		stats.Overruns++
+	}
+	stats.LastRun = now
+}
+
+// Retry verschiebt alle Gruppen nach einem Verbindungsfehler, damit nach dem Reconnect sofort gelesen wird
+func (s *ScanSchedule) Retry() {
+	for id := range s.next {
+		delete(s.next, id)
+	}
+}
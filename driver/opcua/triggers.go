@@ -0,0 +1,293 @@
+package opcua
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/awcullen/opcua/client"
+	awcullenua "github.com/awcullen/opcua/ua"
+	MQTT "github.com/mochi-mqtt/server/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// triggerIOTimeout begrenzt Lesen des Datensatzes und Schreiben des Quittierbits
+const triggerIOTimeout = 10 * time.Second
+
+// Auslösearten einer Trigger-Regel
+const (
+	TriggerRising = "rising" // Trigger wechselt auf true (bzw. ≠ 0)
+	TriggerChange = "change" // Jede Wertänderung des Triggers
+)
+
+// TriggerRule liest beim Auslösen eines Trigger-Datenpunkts eine Gruppe von Datenpunkten gemeinsam
+// und veröffentlicht sie als ein Datensatz auf records/<type>/<deviceId>/<name>.
+// Datenpunkte, die nur als Record-Datenpunkt einer aktiven Regel verwendet werden, werden nicht zyklisch gelesen.
+type TriggerRule struct {
+	ID         int64    `json:"id"`
+	DeviceID   int64    `json:"deviceId"`
+	Name       string   `json:"name"`
+	Trigger    string   `json:"trigger"`              // Datapoint-ID des Trigger-Datenpunkts
+	Mode       string   `json:"mode"`                 // rising (Standard) oder change
+	Datapoints []string `json:"datapoints"`           // Datapoint-IDs des Datensatzes
+	AckAddress string   `json:"ackAddress,omitempty"` // Optional: Quittierbit (S7-Adresse bzw. OPC-UA-NodeID)
+	Enabled    bool     `json:"enabled"`
+}
+
+// Validate prüft eine Regel
+func (r *TriggerRule) Validate() error {
+	if r.Name == "" || r.Trigger == "" {
+		return fmt.Errorf("name and trigger are required")
+	}
+	if r.Mode == "" {
+		r.Mode = TriggerRising
+	}
+	if r.Mode != TriggerRising && r.Mode != TriggerChange {
+		return fmt.Errorf("unknown trigger mode %q", r.Mode)
+	}
+	if len(r.Datapoints) == 0 {
+		return fmt.Errorf("at least one record datapoint is required")
+	}
+	return nil
+}
+
+// Topic liefert das Topic der Datensätze einer Regel
+func (r TriggerRule) Topic(deviceType, deviceID string) string {
+	return fmt.Sprintf("records/%s/%s/%s", deviceType, deviceID, r.Name)
+}
+
+// TriggerFire ist eine ausgelöste Regel mit dem auslösenden Wert
+type TriggerFire struct {
+	Rule  TriggerRule
+	Value interface{}
+}
+
+// TriggerEvaluator erkennt Flanken und Änderungen der Trigger-Datenpunkte eines Treiberlaufs
+type TriggerEvaluator struct {
+	rules   []TriggerRule
+	last    map[int64]interface{}
+	seen    map[int64]bool
+	pending map[int64]bool
+}
+
+// NewTriggerEvaluator erstellt den Auswerter für die aktiven Regeln eines Geräts
+func NewTriggerEvaluator(device DeviceConfig) *TriggerEvaluator {
+	e := &TriggerEvaluator{
+		last:    make(map[int64]interface{}),
+		seen:    make(map[int64]bool),
+		pending: make(map[int64]bool),
+	}
+	for _, r := range device.Triggers {
+		if r.Enabled {
+			e.rules = append(e.rules, r)
+		}
+	}
+	return e
+}
+
+// Empty gibt an, ob keine Regeln aktiv sind
+func (e *TriggerEvaluator) Empty() bool {
+	return len(e.rules) == 0
+}
+
+// Check wertet die gelesenen Werte (Datapoint-ID -> Wert) aus und liefert die ausgelösten Regeln sowie
+// die Regeln, deren Trigger zurückgefallen ist (Quittierbit zurücksetzen).
+// Steht ein Trigger beim Start bereits an, wird nur ausgelöst, wenn ein Quittierbit konfiguriert ist;
+// ohne Handshake könnte der Datensatz sonst nach jedem Neustart doppelt gesendet werden.
+func (e *TriggerEvaluator) Check(values map[string]interface{}) (fired, released []TriggerFire) {
+	for _, r := range e.rules {
+		value, ok := values[r.Trigger]
+		if !ok {
+			continue
+		}
+		prev, seen := e.last[r.ID], e.seen[r.ID]
+		e.last[r.ID], e.seen[r.ID] = value, true
+
+		if e.pending[r.ID] {
+			delete(e.pending, r.ID)
+			fired = append(fired, TriggerFire{r, value})
+			continue
+		}
+
+		switch r.Mode {
+		case TriggerChange:
+			if seen && fmt.Sprint(prev) != fmt.Sprint(value) {
+				fired = append(fired, TriggerFire{r, value})
+			}
+		default:
+			now := truthy(value)
+			switch {
+			case now && seen && !truthy(prev), now && !seen && r.AckAddress != "":
+				fired = append(fired, TriggerFire{r, value})
+			case !now && seen && truthy(prev) && r.AckAddress != "":
+				released = append(released, TriggerFire{r, value})
+			}
+		}
+	}
+	return fired, released
+}
+
+// Retry löst eine Regel beim nächsten Lesen des Triggers erneut aus (z.B. nach einem Lesefehler)
+func (e *TriggerEvaluator) Retry(rule TriggerRule) {
+	e.pending[rule.ID] = true
+}
+
+// recordOnly liefert die Datenpunkte, die nur von Trigger-Regeln gelesen werden
+func recordOnly(device DeviceConfig) map[string]bool {
+	triggers := make(map[string]bool)
+	records := make(map[string]bool)
+	for _, r := range device.Triggers {
+		if !r.Enabled {
+			continue
+		}
+		triggers[r.Trigger] = true
+		for _, id := range r.Datapoints {
+			records[id] = true
+		}
+	}
+	for id := range triggers {
+		delete(records, id)
+	}
+	return records
+}
+
+// AckValue liefert den zu schreibenden Wert des Quittierbits
+func (f TriggerFire) AckValue(fired bool) bool {
+	if f.Rule.Mode == TriggerChange {
+		return truthy(f.Value)
+	}
+	return fired
+}
+
+// PublishRecord veröffentlicht einen Datensatz (nicht retained, QoS 2)
+func PublishRecord(server *MQTT.Server, deviceType, deviceID string, fire TriggerFire, values map[string]interface{}) error {
+	record := map[string]interface{}{
+		"rule":      fire.Rule.Name,
+		"ruleId":    fire.Rule.ID,
+		"trigger":   fire.Value,
+		"timestamp": time.Now().UTC().Format(time.RFC3339Nano),
+		"values":    values,
+	}
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return server.Publish(fire.Rule.Topic(deviceType, deviceID), payload, false, 2)
+}
+
+// truthy interpretiert Booleans und Zahlen (≠ 0) als true
+func truthy(value interface{}) bool {
+	if b, ok := value.(bool); ok {
+		return b
+	}
+	if v, ok := numericValue(value); ok {
+		return v != 0
+	}
+	return false
+}
+
+// valuesByID ordnet die gelesenen Werte ("[id] name") den Datapoint-IDs zu
+func valuesByID(data map[string]interface{}, nodes []DataNode) map[string]interface{} {
+	values := make(map[string]interface{}, len(nodes))
+	for _, node := range nodes {
+		if v, ok := data["["+node.ID+"] "+node.Name]; ok {
+			values[node.ID] = v
+		}
+	}
+	return values
+}
+
+// runTriggers wertet die Trigger-Regeln nach einem Zyklus aus, liest die Datensätze mit einem Read-Request
+// und schreibt optional das Quittierbit
+func runTriggers(device DeviceConfig, ch *client.Client, server *MQTT.Server, evaluator *TriggerEvaluator, data map[string]interface{}, nodes []DataNode) {
+	fired, released := evaluator.Check(valuesByID(data, nodes))
+
+	for _, fire := range fired {
+		values, err := readRecord(ch, device, fire.Rule)
+		if err == nil {
+			err = PublishRecord(server, "opc-ua", device.ID, fire, values)
+		}
+		if err != nil {
+			logrus.Errorf("OPC-UA: Trigger %s on device %s failed: %v", fire.Rule.Name, device.Name, err)
+			evaluator.Retry(fire.Rule)
+			continue
+		}
+		logrus.Debugf("OPC-UA: Trigger %s on device %s published %d values", fire.Rule.Name, device.Name, len(values))
+		if fire.Rule.AckAddress != "" {
+			if err := writeAck(ch, fire.Rule.AckAddress, fire.AckValue(true)); err != nil {
+				logrus.Errorf("OPC-UA: Failed to acknowledge trigger %s: %v", fire.Rule.Name, err)
+			}
+		}
+	}
+	for _, fire := range released {
+		if err := writeAck(ch, fire.Rule.AckAddress, false); err != nil {
+			logrus.Errorf("OPC-UA: Failed to reset acknowledge of trigger %s: %v", fire.Rule.Name, err)
+		}
+	}
+}
+
+// readRecord liest die Datenpunkte einer Regel in einem gemeinsamen Read-Request
+func readRecord(ch *client.Client, device DeviceConfig, rule TriggerRule) (map[string]interface{}, error) {
+	nodes := make(map[string]DataNode, len(device.DataNode))
+	for _, node := range device.DataNode {
+		nodes[node.ID] = node
+	}
+
+	var record []DataNode
+	req := &awcullenua.ReadRequest{TimestampsToReturn: awcullenua.TimestampsToReturnBoth}
+	for _, id := range rule.Datapoints {
+		node, ok := nodes[id]
+		if !ok {
+			logrus.Warnf("OPC-UA: Trigger %s references unknown datapoint %s", rule.Name, id)
+			continue
+		}
+		record = append(record, node)
+		req.NodesToRead = append(req.NodesToRead, awcullenua.ReadValueID{
+			NodeID:      awcullenua.ParseNodeID(node.Node),
+			AttributeID: awcullenua.AttributeIDValue,
+		})
+	}
+	if len(record) == 0 {
+		return nil, fmt.Errorf("no valid record datapoints")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), triggerIOTimeout)
+	defer cancel()
+	resp, err := ch.Read(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]interface{}, len(record))
+	for i, result := range resp.Results {
+		if i >= len(record) {
+			break
+		}
+		if !result.StatusCode.IsGood() {
+			return nil, fmt.Errorf("reading node %s failed with status: %v", record[i].Node, result.StatusCode)
+		}
+		values["["+record[i].ID+"] "+record[i].Name] = record[i].Publish.Scale(result.Value)
+	}
+	return values, nil
+}
+
+// writeAck schreibt das Quittierbit einer Regel
+func writeAck(ch *client.Client, nodeID string, value bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), triggerIOTimeout)
+	defer cancel()
+	resp, err := ch.Write(ctx, &awcullenua.WriteRequest{
+		NodesToWrite: []awcullenua.WriteValue{{
+			NodeID:      awcullenua.ParseNodeID(nodeID),
+			AttributeID: awcullenua.AttributeIDValue,
+			Value:       awcullenua.DataValue{Value: value},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	if len(resp.Results) > 0 && !resp.Results[0].IsGood() {
+		return fmt.Errorf("write failed with status: %v", resp.Results[0])
+	}
+	return nil
+}
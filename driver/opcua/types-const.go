@@ -1,10 +1,15 @@
 package opcua
 
-import "github.com/awcullen/opcua/client"
+import (
+	"sync"
+
+	"github.com/awcullen/opcua/client"
+)
 
 // opcua-connector.go types
 
 var opcuaClients = make(map[string]*client.Client) // Map to store OPC-UA clients by device name
+var opcuaClientsMu sync.RWMutex                    // Schützt opcuaClients vor parallelem Zugriff (Treiber und Web-UI)
 
 // logic.go types
 
@@ -26,32 +31,42 @@ type MqttConfig struct {
 
 // Gerätekonfigurationsstruktur
 type DeviceConfig struct {
-	ID              string      `json:"id"`
-	Type            string      `json:"type"`
-	Name            string      `json:"name"`
-	Address         string      `json:"address"`
-	SecurityMode    string      `json:"securityMode,omitempty"`   // Only for OPC UA
-	SecurityPolicy  string      `json:"securityPolicy,omitempty"` // Only for OPC UA
-	Datapoint       []Datapoint `json:"datapoints,omitempty"`     // Only for S7
-	DataNode        []DataNode  `json:"dataNodes,omitempty"`      // Only for OPC UA
-	AcquisitionTime int         `json:"acquisitionTime"`
-	CertFile        string      `json:"certificate,omitempty"` // Only for OPC UA
-	KeyFile         string      `json:"key,omitempty"`         // Only for OPC UA
-	Username        string      `json:"username,omitempty"`    // Only for OPC UA
-	Password        string      `json:"password,omitempty"`    // Only for OPC UA
-	Rack            int         `json:"rack,omitempty"`        // Only for S7
-	Slot            int         `json:"slot,omitempty"`        // Only for S7
+	ID                string        `json:"id"`
+	Type              string        `json:"type"`
+	Name              string        `json:"name"`
+	Address           string        `json:"address"`
+	SecurityMode      string        `json:"securityMode,omitempty"`   // Only for OPC UA
+	SecurityPolicy    string        `json:"securityPolicy,omitempty"` // Only for OPC UA
+	Datapoint         []Datapoint   `json:"datapoints,omitempty"`     // Only for S7 and virtual devices (Address = expression)
+	DataNode          []DataNode    `json:"dataNodes,omitempty"`      // Only for OPC UA
+	AcquisitionTime   int           `json:"acquisitionTime"`
+	CertFile          string        `json:"certificate,omitempty"`       // Only for OPC UA
+	KeyFile           string        `json:"key,omitempty"`               // Only for OPC UA
+	Username          string        `json:"username,omitempty"`          // Only for OPC UA
+	Password          string        `json:"password,omitempty"`          // Only for OPC UA
+	Rack              int           `json:"rack,omitempty"`              // Only for S7
+	Slot              int           `json:"slot,omitempty"`              // Only for S7
+	HistoryBackfill   bool          `json:"historyBackfill,omitempty"`   // Only for OPC UA: HistoryRead-Backfill nach Reconnect
+	EventSubscription bool          `json:"eventSubscription,omitempty"` // Only for OPC UA: Alarms & Conditions abonnieren
+	EventFields       string        `json:"eventFields,omitempty"`       // Only for OPC UA: kommaseparierte Ereignisfelder
+	ScanGroups        []ScanGroup   `json:"scanGroups,omitempty"`        // Only for S7 and OPC UA: Erfassungsgruppen mit eigener Zykluszeit
+	Triggers          []TriggerRule `json:"triggers,omitempty"`          // Only for S7 and OPC UA: ereignisgesteuerte Datensätze
 }
 
 type Datapoint struct {
-	ID       string `json:"id"`
-	Name     string `json:"name"`
-	Datatype string `json:"datatype"`
-	Address  string `json:"address"`
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Datatype  string          `json:"datatype"`
+	Address   string          `json:"address"`
+	Publish   PublishSettings `json:"publish,omitempty"`
+	ScanGroup int64           `json:"scanGroup,omitempty"` // 0 = Zykluszeit des Geräts
+	Topic     string          `json:"topic,omitempty"`     // Only for MQTT: Topic-Filter relativ zu data/mqtt/<name>/ (Address = Feldausdruck)
 }
 
 type DataNode struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
-	Node string `json:"node"`
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Node      string          `json:"node"`
+	Publish   PublishSettings `json:"publish,omitempty"`
+	ScanGroup int64           `json:"scanGroup,omitempty"` // 0 = Zykluszeit des Geräts
 }
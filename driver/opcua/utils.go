@@ -142,9 +142,27 @@ func getSecurityPolicy(policy string) string {
 
 // AddOpcuaClient adds an OPC-UA client to the map of clients.
 func addOpcuaClient(deviceID string, ch *client.Client) {
+	opcuaClientsMu.Lock()
+	defer opcuaClientsMu.Unlock()
 	opcuaClients[deviceID] = ch
 }
 
+// removeOpcuaClient entfernt den Client eines Geräts, sobald die Session geschlossen wurde.
+func removeOpcuaClient(deviceID string) {
+	opcuaClientsMu.Lock()
+	defer opcuaClientsMu.Unlock()
+	delete(opcuaClients, deviceID)
+}
+
+// GetClient gibt die aktive Session des Treibers für ein Gerät zurück.
+// Die Session gehört dem Treiber und darf vom Aufrufer nicht geschlossen werden.
+func GetClient(deviceID string) (*client.Client, bool) {
+	opcuaClientsMu.RLock()
+	defer opcuaClientsMu.RUnlock()
+	ch, exists := opcuaClients[deviceID]
+	return ch, exists && ch != nil
+}
+
 // DebugIdentityToken gibt detaillierte Informationen über die Authentifizierungskonfiguration aus
 func DebugIdentityToken(device DeviceConfig) {
 	// logrus.Infof("=== OPC-UA Identity Debug für Gerät: %s ===", device.Name)
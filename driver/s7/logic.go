@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"fmt"
 	"iot-gateway/driver/opcua"
+	"iot-gateway/metrics"
 	"strings"
 	"time"
 
@@ -23,12 +24,22 @@ func Run(device opcua.DeviceConfig, db *sql.DB, stopChan chan struct{}, server *
 
 	retryInterval := 5 * time.Second
 	lastStatus := ""
-	sleeptime := time.Duration(device.AcquisitionTime) * time.Millisecond
+	connectedBefore := false // Für die Zählung von Reconnects
+
+	// Erfassungsgruppen mit eigener Zykluszeit über eine gemeinsame Verbindung
+	schedule := opcua.NewScanSchedule(device)
+	triggers := opcua.NewTriggerEvaluator(device) // Ereignisgesteuerte Datensätze
 
 	// Starten mit Initializing
 	// updateDeviceStatus(server, "s7", device.ID, "2 (initializing)", db, &lastStatus)
 	publishDeviceState(server, "s7", device.ID, "2 (initializing)", db)
 
+	// Deadband/Publish-on-Change-Zustand für diesen Treiberlauf, Einheiten einmalig veröffentlichen
+	filter := opcua.NewPublishFilter()
+	for _, dp := range device.Datapoint {
+		opcua.PublishMetadata(server, "s7", device.ID, fmt.Sprintf("[%s] %s", dp.ID, dp.Name), dp.Publish)
+	}
+
 	for {
 		select {
 		case <-stopChan:
@@ -39,9 +50,6 @@ func Run(device opcua.DeviceConfig, db *sql.DB, stopChan chan struct{}, server *
 			updateDeviceStatus(server, "s7", device.ID, "0 (stopped)", db, &lastStatus)
 			return nil
 		default:
-			// Startzeit ermitteln
-			cycleStart := time.Now()
-
 			// Wenn kein Client existiert, erstelle einen neuen
 			if client == nil || handler == nil {
 				client, handler, err = createS7Client(device)
@@ -57,70 +65,97 @@ func Run(device opcua.DeviceConfig, db *sql.DB, stopChan chan struct{}, server *
 						continue
 					}
 				}
+				if connectedBefore {
+					metrics.Reconnect("s7", device.ID)
+				}
+				connectedBefore = true
 			}
 
-			// Versuche, die Verbindung herzustellen
-			data, err := fetchS7Data(client, device)
-			if err != nil {
-				logrus.Errorf("S7: Error initializing client for device %s: %v", device.Name, err)
-				updateDeviceStatus(server, "s7", device.ID, "5 (no connection)", db, &lastStatus)
-
-				// Schließe den alten Handler sicher
-				if handler != nil {
-					handler.Close()
-					handler = nil
+			// Auf die nächste fällige Erfassungsgruppe warten
+			due := schedule.Due(time.Now())
+			if len(due) == 0 {
+				if schedule.Empty() {
+					updateDeviceStatus(server, "s7", device.ID, "4 (no datapoints)", db, &lastStatus)
 				}
-				client = nil
-
-				// Prüfe, ob ein Stop-Request empfangen wurde
 				select {
 				case <-stopChan:
-					return fmt.Errorf("connection aborted for device %v", device.Name)
-				case <-time.After(retryInterval):
-					continue
+				case <-time.After(schedule.Wait(time.Now())):
 				}
+				continue
 			}
 
-			// Wenn die Verbindung erfolgreich war, verarbeite die Daten
-			mqttData, err := convData(data, device.Name)
-			if err != nil {
-				logrus.Errorf("S7: Error converting data: %v", err)
-				updateDeviceStatus(server, "s7", device.ID, "3 (error)", db, &lastStatus)
+			for _, group := range due {
+				// Startzeit ermitteln
+				cycleStart := time.Now()
+				groupDevice := schedule.Device(group)
 
-				select {
-				case <-stopChan:
-					return fmt.Errorf("processing aborted for device %v", device.Name)
-				case <-time.After(retryInterval):
-					continue
+				// Versuche, die Verbindung herzustellen
+				data, err := fetchS7Data(client, groupDevice)
+				if err != nil {
+					logrus.Errorf("S7: Error initializing client for device %s: %v", device.Name, err)
+					metrics.ReadError("s7", device.ID)
+					updateDeviceStatus(server, "s7", device.ID, "5 (no connection)", db, &lastStatus)
+
+					// Schließe den alten Handler sicher
+					if handler != nil {
+						handler.Close()
+						handler = nil
+					}
+					client = nil
+					schedule.Retry()
+
+					// Prüfe, ob ein Stop-Request empfangen wurde
+					select {
+					case <-stopChan:
+						return fmt.Errorf("connection aborted for device %v", device.Name)
+					case <-time.After(retryInterval):
+					}
+					break
 				}
-			}
 
-			if err := pubData(mqttData, device.ID, server, db); err != nil {
-				logrus.Errorf("S7: Error publishing data: %v", err)
-				updateDeviceStatus(server, "s7", device.ID, "3 (error)", db, &lastStatus)
+				// Wenn die Verbindung erfolgreich war, verarbeite die Daten
+				mqttData, err := convData(data, device.Name)
+				if err != nil {
+					logrus.Errorf("S7: Error converting data: %v", err)
+					updateDeviceStatus(server, "s7", device.ID, "3 (error)", db, &lastStatus)
+					schedule.Retry()
 
-				select {
-				case <-stopChan:
-					return fmt.Errorf("publishing aborted for device %v", device.Name)
-				case <-time.After(retryInterval):
-					continue
+					select {
+					case <-stopChan:
+						return fmt.Errorf("processing aborted for device %v", device.Name)
+					case <-time.After(retryInterval):
+					}
+					break
 				}
-			}
 
-			// Wenn alles erfolgreich war
-			if len(mqttData) > 0 {
-				updateDeviceStatus(server, "s7", device.ID, "1 (running)", db, &lastStatus)
-			} else {
-				updateDeviceStatus(server, "s7", device.ID, "4 (no datapoints)", db, &lastStatus)
-			}
+				if err := pubData(mqttData, groupDevice, server, db, filter); err != nil {
+					logrus.Errorf("S7: Error publishing data: %v", err)
+					updateDeviceStatus(server, "s7", device.ID, "3 (error)", db, &lastStatus)
+					schedule.Retry()
 
-			// Berechnung der Dauer der Cycle
-			cycleDuration := time.Since(cycleStart)
+					select {
+					case <-stopChan:
+						return fmt.Errorf("publishing aborted for device %v", device.Name)
+					case <-time.After(retryInterval):
+					}
+					break
+				}
+
+				// Trigger-Regeln auswerten und Datensätze lesen
+				if !triggers.Empty() {
+					runTriggers(device, client, server, triggers, mqttData)
+				}
+
+				// Wenn alles erfolgreich war
+				if len(mqttData) > 0 {
+					updateDeviceStatus(server, "s7", device.ID, "1 (running)", db, &lastStatus)
+				} else {
+					updateDeviceStatus(server, "s7", device.ID, "4 (no datapoints)", db, &lastStatus)
+				}
 
-			// Cycle Time von sleeptime abziehen
-			remainingTime := sleeptime - cycleDuration
-			if remainingTime > 0 {
-				time.Sleep(remainingTime)
+				// Zyklusdauer und Overruns der Gruppe erfassen, nächsten Zyklus planen
+				metrics.ObserveCycle("s7", device.ID, time.Since(cycleStart))
+				schedule.Done(group, cycleStart)
 			}
 		}
 	}
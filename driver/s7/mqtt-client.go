@@ -2,15 +2,21 @@ package s7
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
+	"iot-gateway/driver/opcua"
 
 	MQTT "github.com/mochi-mqtt/server/v2"
 	"github.com/sirupsen/logrus"
 )
 
-// PubData veröffentlicht die Daten auf dem MQTT-Broker
-func pubData(data []map[string]interface{}, deviceID string, server *MQTT.Server, db *sql.DB) error {
+// PubData veröffentlicht die Daten auf dem MQTT-Broker. Skalierung, Deadband, Publish-on-Change
+// und QoS/Retain werden aus den Einstellungen des jeweiligen Datenpunkts übernommen.
+func pubData(data []map[string]interface{}, device opcua.DeviceConfig, server *MQTT.Server, db *sql.DB, filter *opcua.PublishFilter) error {
+	settings := make(map[string]opcua.PublishSettings, len(device.Datapoint))
+	for _, dp := range device.Datapoint {
+		settings[dp.ID] = dp.Publish
+	}
+
 	for _, dp := range data {
 		// name muss aus [DatapointId]_[DatapointName] bestehen
 		name, ok := dp["name"].(string)
@@ -24,21 +30,14 @@ func pubData(data []map[string]interface{}, deviceID string, server *MQTT.Server
 			return nil
 		}
 
-		payload, err := json.Marshal(value)
-		if err != nil {
-			logrus.Errorf("S7: Failed to marshal data for datapoint %s: %v", name, err)
-			return nil
-		}
-
 		// name muss aus "[DatapointId] DatapointName" bestehen
 		id := dp["id"].(string)
 		name = fmt.Sprintf("[%s] %s", id, name)
 
-		topic := fmt.Sprintf("data/s7/%s/%s", deviceID, name)
-		err = server.Publish(topic, []byte(payload), true, 2)
-		if err != nil {
+		topic := fmt.Sprintf("data/s7/%s/%s", device.ID, name)
+		if _, err := opcua.PublishValue(server, filter, topic, value, settings[id]); err != nil {
 			logrus.Errorf("S7: Failed to publish data for datapoint %s: %v", name, err)
-			publishDeviceState(server, "s7", deviceID, "6 (connection lost)", db)
+			publishDeviceState(server, "s7", device.ID, "6 (connection lost)", db)
 			return nil
 		}
 	}
@@ -117,7 +117,11 @@ func parseAddress(address string, datatype string) (ParsedAddress, error) {
 					return parsed, fmt.Errorf("invalid DB address format")
 				}
 
-				// Bit Offset extrahieren
+				// Byte- und Bit-Offset extrahieren
+				parsed.ByteAddr, err = strconv.Atoi(addrParts[0])
+				if err != nil {
+					return parsed, fmt.Errorf("invalid byte address: %v", err)
+				}
 				parsed.BitAddr, err = strconv.Atoi(addrParts[1])
 				if err != nil {
 					return parsed, fmt.Errorf("invalid bit address: %v", err)
@@ -0,0 +1,209 @@
+package s7
+
+import (
+	"fmt"
+	"iot-gateway/driver/opcua"
+
+	MQTT "github.com/mochi-mqtt/server/v2"
+	s7 "github.com/robinson/gos7"
+	"github.com/sirupsen/logrus"
+)
+
+// runTriggers wertet die Trigger-Regeln nach einem Zyklus aus, liest die Datensätze direkt nacheinander
+// über dieselbe Verbindung und schreibt optional das Quittierbit
+func runTriggers(device opcua.DeviceConfig, client s7.Client, server *MQTT.Server, evaluator *opcua.TriggerEvaluator, data []map[string]interface{}) {
+	values := make(map[string]interface{}, len(data))
+	for _, dp := range data {
+		if id, ok := dp["id"].(string); ok {
+			values[id] = dp["value"]
+		}
+	}
+	fired, released := evaluator.Check(values)
+
+	for _, fire := range fired {
+		record, err := readRecord(client, device, fire.Rule)
+		if err == nil {
+			err = opcua.PublishRecord(server, "s7", device.ID, fire, record)
+		}
+		if err != nil {
+			logrus.Errorf("S7: Trigger %s on device %s failed: %v", fire.Rule.Name, device.Name, err)
+			evaluator.Retry(fire.Rule)
+			continue
+		}
+		logrus.Debugf("S7: Trigger %s on device %s published %d values", fire.Rule.Name, device.Name, len(record))
+		if fire.Rule.AckAddress != "" {
+			if err := writeBit(client, fire.Rule.AckAddress, fire.AckValue(true)); err != nil {
+				logrus.Errorf("S7: Failed to acknowledge trigger %s: %v", fire.Rule.Name, err)
+			}
+		}
+	}
+	for _, fire := range released {
+		if err := writeBit(client, fire.Rule.AckAddress, false); err != nil {
+			logrus.Errorf("S7: Failed to reset acknowledge of trigger %s: %v", fire.Rule.Name, err)
+		}
+	}
+}
+
+// Grenzen für AGReadMulti: höchstens 20 Variablen je Anfrage, und die Antwort muss in die
+// minimale PDU-Größe von 240 Byte passen (4 Byte Kopf je Variable)
+const (
+	multiReadMaxItems = 20
+	multiReadMaxBytes = 200
+	wordLenByte       = 0x02 // Wortlänge Byte im S7-Protokoll
+)
+
+// multiReadArea ordnet den Adressbereichen die Bereichs-Codes des S7-Protokolls zu
+var multiReadArea = map[VariableType]int{
+	Input:     0x81,
+	Output:    0x82,
+	Merker:    0x83,
+	DataBlock: 0x84,
+}
+
+// readRecord liest die Datenpunkte einer Regel gebündelt, damit der Datensatz möglichst konsistent ist
+func readRecord(client s7.Client, device opcua.DeviceConfig, rule opcua.TriggerRule) (map[string]interface{}, error) {
+	datapoints := make(map[string]opcua.Datapoint, len(device.Datapoint))
+	for _, dp := range device.Datapoint {
+		datapoints[dp.ID] = dp
+	}
+
+	var recordDatapoints []opcua.Datapoint
+	for _, id := range rule.Datapoints {
+		dp, ok := datapoints[id]
+		if !ok {
+			logrus.Warnf("S7: Trigger %s references unknown datapoint %s", rule.Name, id)
+			continue
+		}
+		recordDatapoints = append(recordDatapoints, dp)
+	}
+	if len(recordDatapoints) == 0 {
+		return nil, fmt.Errorf("no valid record datapoints")
+	}
+
+	values, err := readMulti(client, recordDatapoints)
+	if err != nil {
+		return nil, err
+	}
+	record := make(map[string]interface{}, len(values))
+	for i, dp := range recordDatapoints {
+		record[fmt.Sprintf("[%s] %s", dp.ID, dp.Name)] = dp.Publish.Scale(values[i])
+	}
+	return record, nil
+}
+
+// readMulti liest Datenpunkte per AGReadMulti in so wenigen Anfragen wie möglich (ein Datensatz mit bis zu
+// 20 kleinen Werten in einer Anfrage). Werte, die nicht in eine PDU passen (STRING), werden einzeln gelesen.
+func readMulti(client s7.Client, datapoints []opcua.Datapoint) ([]interface{}, error) {
+	values := make([]interface{}, len(datapoints))
+
+	var items []s7.S7DataItem
+	var pending []int // Index des Datenpunkts je Item
+	var bitAddrs []int
+	size := 0
+	flush := func() error {
+		if len(items) == 0 {
+			return nil
+		}
+		if err := client.AGReadMulti(items, len(items)); err != nil {
+			return err
+		}
+		for i, item := range items {
+			dp := datapoints[pending[i]]
+			if item.Error != "" {
+				return fmt.Errorf("failed to read data from address %s: %s", dp.Address, item.Error)
+			}
+			value, err := convertBufferToType(item.Data, dp.Datatype, bitAddrs[i])
+			if err != nil {
+				return err
+			}
+			values[pending[i]] = value
+		}
+		items, pending, bitAddrs, size = nil, nil, nil, 0
+		return nil
+	}
+
+	for i, dp := range datapoints {
+		addr, err := parseAddress(dp.Address, dp.Datatype)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse address %s: %v", dp.Address, err)
+		}
+		area, ok := multiReadArea[addr.Type]
+		if !ok {
+			return nil, fmt.Errorf("unsupported variable type %v", addr.Type)
+		}
+		itemSize, err := getDataTypeSize(addr.DataType)
+		if err != nil {
+			return nil, err
+		}
+
+		if itemSize+4 > multiReadMaxBytes {
+			results, err := readData(client, opcua.DeviceConfig{Datapoint: []opcua.Datapoint{dp}})
+			if err != nil {
+				return nil, err
+			}
+			values[i] = results[0]["value"]
+			continue
+		}
+		if len(items) == multiReadMaxItems || size+itemSize+4 > multiReadMaxBytes {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+		items = append(items, s7.S7DataItem{
+			Area:     area,
+			WordLen:  wordLenByte,
+			DBNumber: addr.DBNum,
+			Start:    addr.ByteAddr,
+			Amount:   itemSize,
+			Data:     make([]byte, itemSize),
+		})
+		pending = append(pending, i)
+		bitAddrs = append(bitAddrs, addr.BitAddr)
+		size += itemSize + 4
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// writeBit setzt oder löscht ein einzelnes Bit (z.B. DB5.0.1, M10.3, Q0.0) per Read-Modify-Write
+func writeBit(client s7.Client, address string, value bool) error {
+	addr, err := parseAddress(address, "BOOL")
+	if err != nil {
+		return fmt.Errorf("failed to parse address %s: %v", address, err)
+	}
+	if addr.BitAddr < 0 || addr.BitAddr > 7 {
+		return fmt.Errorf("address %s is not a bit address", address)
+	}
+
+	buffer := make([]byte, 1)
+	switch addr.Type {
+	case Output:
+		err = client.AGReadAB(addr.ByteAddr, 1, buffer)
+	case Merker:
+		err = client.AGReadMB(addr.ByteAddr, 1, buffer)
+	case DataBlock:
+		err = client.AGReadDB(addr.DBNum, addr.ByteAddr, 1, buffer)
+	default:
+		return fmt.Errorf("address %s is not writable", address)
+	}
+	if err != nil {
+		return err
+	}
+
+	if value {
+		buffer[0] |= 1 << addr.BitAddr
+	} else {
+		buffer[0] &^= 1 << addr.BitAddr
+	}
+
+	switch addr.Type {
+	case Output:
+		return client.AGWriteAB(addr.ByteAddr, 1, buffer)
+	case Merker:
+		return client.AGWriteMB(addr.ByteAddr, 1, buffer)
+	default:
+		return client.AGWriteDB(addr.DBNum, addr.ByteAddr, 1, buffer)
+	}
+}
@@ -0,0 +1,649 @@
+// Package virtual berechnet virtuelle Datenpunkte aus Ausdrücken über andere Datenpunkte.
+//
+// Ausdrücke unterstützen:
+//   - Zahlen, true/false, Klammern
+//   - Arithmetik: + - * / % ^ (Potenz)
+//   - Vergleiche und Logik: == != < <= > >= && || ! sowie cond ? a : b
+//   - Datenpunkte: dp("s7/3/10030001") liefert den letzten Wert der Quelle <type>/<deviceId>/<datapointId>
+//   - Zeitfenster: avg_over("s7/3/10030001", 60), min_over(...), max_over(...) über die letzten N Sekunden
+//   - Funktionen: abs, sqrt, exp, log, log10, sin, cos, tan, floor, ceil, round(x[, digits]),
+//     pow(x, y), min(a, b, ...), max(a, b, ...), clamp(x, lo, hi), bit(x, n)
+package virtual
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	// ErrNoValue wird geliefert, solange für eine Quelle noch kein Wert vorliegt
+	ErrNoValue = errors.New("no value for source")
+)
+
+// Inputs liefert die Eingangswerte für die Auswertung
+type Inputs interface {
+	Value(source string) (float64, bool)
+	Window(source string, d time.Duration) []float64
+}
+
+// Expression ist ein übersetzter Ausdruck
+type Expression struct {
+	text    string
+	root    node
+	sources map[string]bool
+	windows map[string]time.Duration // Quelle -> größtes benötigtes Zeitfenster
+}
+
+// Compile übersetzt einen Ausdruck
+func Compile(text string) (*Expression, error) {
+	p := &parser{expr: &Expression{text: text, sources: map[string]bool{}, windows: map[string]time.Duration{}}}
+	if err := p.tokenize(text); err != nil {
+		return nil, err
+	}
+	root, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos)
+	}
+	p.expr.root = root
+	return p.expr, nil
+}
+
+// Sources liefert alle im Ausdruck verwendeten Quellen
+func (e *Expression) Sources() []string {
+	list := make([]string, 0, len(e.sources))
+	for source := range e.sources {
+		list = append(list, source)
+	}
+	return list
+}
+
+// Uses prüft, ob der Ausdruck eine Quelle verwendet
+func (e *Expression) Uses(source string) bool {
+	return e.sources[source]
+}
+
+// Windows liefert die benötigten Zeitfenster je Quelle
+func (e *Expression) Windows() map[string]time.Duration {
+	return e.windows
+}
+
+// IsBoolean prüft, ob der Ausdruck einen Wahrheitswert liefert (Vergleich oder Logik)
+func (e *Expression) IsBoolean() bool {
+	return e.root.boolean()
+}
+
+// Eval wertet den Ausdruck aus. Wahrheitswerte werden als 1/0 geliefert.
+func (e *Expression) Eval(in Inputs) (float64, error) {
+	v, err := e.root.eval(in)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("result is not a finite number")
+	}
+	return v, nil
+}
+
+func (e *Expression) String() string {
+	return e.text
+}
+
+// %%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%% AST %%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%
+
+type node interface {
+	eval(in Inputs) (float64, error)
+	boolean() bool
+}
+
+type numberNode float64
+
+func (n numberNode) eval(Inputs) (float64, error) { return float64(n), nil }
+func (n numberNode) boolean() bool                { return false }
+
+type boolNode bool
+
+func (n boolNode) eval(Inputs) (float64, error) { return toNumber(bool(n)), nil }
+func (n boolNode) boolean() bool                { return true }
+
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+func (n *unaryNode) eval(in Inputs) (float64, error) {
+	v, err := n.operand.eval(in)
+	if err != nil {
+		return 0, err
+	}
+	if n.op == "!" {
+		return toNumber(v == 0), nil
+	}
+	return -v, nil
+}
+
+func (n *unaryNode) boolean() bool { return n.op == "!" }
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+func (n *binaryNode) eval(in Inputs) (float64, error) {
+	l, err := n.left.eval(in)
+	if err != nil {
+		return 0, err
+	}
+	// Kurzschlussauswertung
+	switch n.op {
+	case "&&":
+		if l == 0 {
+			return 0, nil
+		}
+	case "||":
+		if l != 0 {
+			return 1, nil
+		}
+	}
+	r, err := n.right.eval(in)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return l / r, nil
+	case "%":
+		if r == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return math.Mod(l, r), nil
+	case "^":
+		return math.Pow(l, r), nil
+	case "==":
+		return toNumber(l == r), nil
+	case "!=":
+		return toNumber(l != r), nil
+	case "<":
+		return toNumber(l < r), nil
+	case "<=":
+		return toNumber(l <= r), nil
+	case ">":
+		return toNumber(l > r), nil
+	case ">=":
+		return toNumber(l >= r), nil
+	case "&&", "||":
+		return toNumber(r != 0), nil
+	}
+	return 0, fmt.Errorf("unknown operator %s", n.op)
+}
+
+func (n *binaryNode) boolean() bool {
+	switch n.op {
+	case "==", "!=", "<", "<=", ">", ">=", "&&", "||":
+		return true
+	}
+	return false
+}
+
+type condNode struct {
+	cond, then, otherwise node
+}
+
+func (n *condNode) eval(in Inputs) (float64, error) {
+	c, err := n.cond.eval(in)
+	if err != nil {
+		return 0, err
+	}
+	if c != 0 {
+		return n.then.eval(in)
+	}
+	return n.otherwise.eval(in)
+}
+
+func (n *condNode) boolean() bool { return n.then.boolean() && n.otherwise.boolean() }
+
+type sourceNode struct {
+	source string
+}
+
+func (n *sourceNode) eval(in Inputs) (float64, error) {
+	v, ok := in.Value(n.source)
+	if !ok {
+		return 0, fmt.Errorf("%w %s", ErrNoValue, n.source)
+	}
+	return v, nil
+}
+
+func (n *sourceNode) boolean() bool { return false }
+
+type windowNode struct {
+	fn     string
+	source string
+	window time.Duration
+}
+
+func (n *windowNode) eval(in Inputs) (float64, error) {
+	values := in.Window(n.source, n.window)
+	if len(values) == 0 {
+		return 0, fmt.Errorf("%w %s", ErrNoValue, n.source)
+	}
+	result := values[0]
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+		switch n.fn {
+		case "min_over":
+			result = math.Min(result, v)
+		case "max_over":
+			result = math.Max(result, v)
+		}
+	}
+	if n.fn == "avg_over" {
+		return sum / float64(len(values)), nil
+	}
+	return result, nil
+}
+
+func (n *windowNode) boolean() bool { return false }
+
+type callNode struct {
+	fn   string
+	args []node
+}
+
+// functions enthält die mathematischen Funktionen mit ihrer Stelligkeit (-1 = beliebig, mindestens eins)
+var functions = map[string]int{
+	"abs": 1, "sqrt": 1, "exp": 1, "log": 1, "log10": 1, "sin": 1, "cos": 1, "tan": 1,
+	"floor": 1, "ceil": 1, "round": -1, "pow": 2, "min": -1, "max": -1, "clamp": 3, "bit": 2,
+}
+
+func (n *callNode) eval(in Inputs) (float64, error) {
+	args := make([]float64, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(in)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = v
+	}
+
+	switch n.fn {
+	case "abs":
+		return math.Abs(args[0]), nil
+	case "sqrt":
+		return math.Sqrt(args[0]), nil
+	case "exp":
+		return math.Exp(args[0]), nil
+	case "log":
+		return math.Log(args[0]), nil
+	case "log10":
+		return math.Log10(args[0]), nil
+	case "sin":
+		return math.Sin(args[0]), nil
+	case "cos":
+		return math.Cos(args[0]), nil
+	case "tan":
+		return math.Tan(args[0]), nil
+	case "floor":
+		return math.Floor(args[0]), nil
+	case "ceil":
+		return math.Ceil(args[0]), nil
+	case "round":
+		if len(args) > 2 {
+			return 0, errors.New("round expects 1 or 2 arguments")
+		}
+		if len(args) == 2 {
+			factor := math.Pow(10, math.Floor(args[1]))
+			return math.Round(args[0]*factor) / factor, nil
+		}
+		return math.Round(args[0]), nil
+	case "pow":
+		return math.Pow(args[0], args[1]), nil
+	case "min", "max":
+		result := args[0]
+		for _, v := range args[1:] {
+			if n.fn == "min" {
+				result = math.Min(result, v)
+			} else {
+				result = math.Max(result, v)
+			}
+		}
+		return result, nil
+	case "clamp":
+		return math.Max(args[1], math.Min(args[2], args[0])), nil
+	case "bit":
+		if args[1] < 0 || args[1] > 63 {
+			return 0, errors.New("bit index must be between 0 and 63")
+		}
+		return float64((int64(args[0]) >> uint(args[1])) & 1), nil
+	}
+	return 0, fmt.Errorf("unknown function %s", n.fn)
+}
+
+func (n *callNode) boolean() bool { return false }
+
+func toNumber(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// %%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%% Parser %%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%
+
+const (
+	tokEOF = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind int
+	text string
+	pos  int
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	expr   *Expression
+}
+
+// tokenize zerlegt den Ausdruck in Tokens
+func (p *parser) tokenize(text string) error {
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.' || runes[i] == 'e' || runes[i] == 'E' ||
+				((runes[i] == '+' || runes[i] == '-') && (runes[i-1] == 'e' || runes[i-1] == 'E'))) {
+				i++
+			}
+			p.tokens = append(p.tokens, token{tokNumber, string(runes[start:i]), start})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			p.tokens = append(p.tokens, token{tokIdent, string(runes[start:i]), start})
+		case r == '"' || r == '\'':
+			start := i
+			i++
+			for i < len(runes) && runes[i] != r {
+				i++
+			}
+			if i >= len(runes) {
+				return fmt.Errorf("unterminated string at position %d", start)
+			}
+			p.tokens = append(p.tokens, token{tokString, string(runes[start+1 : i]), start})
+			i++
+		default:
+			op := string(r)
+			if i+1 < len(runes) {
+				switch two := string(runes[i : i+2]); two {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					op = two
+				}
+			}
+			if !strings.Contains("+-*/%^()<>!?:,", op) && len(op) == 1 {
+				return fmt.Errorf("unexpected character %q at position %d", r, i)
+			}
+			p.tokens = append(p.tokens, token{tokOp, op, i})
+			i += len(op)
+		}
+	}
+	p.tokens = append(p.tokens, token{tokEOF, "end of expression", len(runes)})
+	return nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) acceptOp(ops ...string) (string, bool) {
+	tok := p.peek()
+	if tok.kind != tokOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if tok.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) expectOp(op string) error {
+	if _, ok := p.acceptOp(op); !ok {
+		tok := p.peek()
+		return fmt.Errorf("expected %q at position %d, got %q", op, tok.pos, tok.text)
+	}
+	return nil
+}
+
+// parseExpr: ternary := or ('?' expr ':' expr)?
+func (p *parser) parseExpr() (node, error) {
+	cond, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.acceptOp("?"); !ok {
+		return cond, nil
+	}
+	then, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectOp(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	return &condNode{cond, then, otherwise}, nil
+}
+
+// precedence enthält die binären Operatoren nach aufsteigender Bindung
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) parseBinary(level int) (node, error) {
+	if level == len(precedence) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.acceptOp(precedence[level]...)
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op, left, right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if op, ok := p.acceptOp("-", "!"); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op, operand}, nil
+	}
+	return p.parsePower()
+}
+
+// parsePower: primary ('^' unary)?, rechtsassoziativ
+func (p *parser) parsePower() (node, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.acceptOp("^"); ok {
+		exponent, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &binaryNode{"^", base, exponent}, nil
+	}
+	return base, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNumber:
+		v, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", tok.text, tok.pos)
+		}
+		return numberNode(v), nil
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			return boolNode(true), nil
+		case "false":
+			return boolNode(false), nil
+		}
+		return p.parseCall(tok)
+	case tokOp:
+		if tok.text == "(" {
+			inner, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			return inner, nil
+		}
+	case tokString:
+		return nil, fmt.Errorf("unexpected string %q at position %d, use dp(%q)", tok.text, tok.pos, tok.text)
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos)
+}
+
+// parseCall übersetzt einen Funktionsaufruf
+func (p *parser) parseCall(name token) (node, error) {
+	if err := p.expectOp("("); err != nil {
+		return nil, fmt.Errorf("unknown identifier %q at position %d", name.text, name.pos)
+	}
+
+	switch name.text {
+	case "dp":
+		source, err := p.parseSourceArg()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		p.expr.sources[source] = true
+		return &sourceNode{source}, nil
+
+	case "avg_over", "min_over", "max_over":
+		source, err := p.parseSourceArg()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(","); err != nil {
+			return nil, err
+		}
+		tok := p.next()
+		seconds, err := strconv.ParseFloat(tok.text, 64)
+		if tok.kind != tokNumber || err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("%s expects a positive number of seconds at position %d", name.text, tok.pos)
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		window := time.Duration(seconds * float64(time.Second))
+		p.expr.sources[source] = true
+		if window > p.expr.windows[source] {
+			p.expr.windows[source] = window
+		}
+		return &windowNode{name.text, source, window}, nil
+	}
+
+	arity, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at position %d", name.text, name.pos)
+	}
+	var args []node
+	if _, ok := p.acceptOp(")"); !ok {
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if _, ok := p.acceptOp(","); ok {
+				continue
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	if (arity >= 0 && len(args) != arity) || len(args) == 0 {
+		return nil, fmt.Errorf("wrong number of arguments for %s at position %d", name.text, name.pos)
+	}
+	return &callNode{name.text, args}, nil
+}
+
+// parseSourceArg liest eine Quellangabe der Form "<type>/<deviceId>/<datapointId>"
+func (p *parser) parseSourceArg() (string, error) {
+	tok := p.next()
+	if tok.kind != tokString {
+		return "", fmt.Errorf("expected source string at position %d, e.g. dp(\"s7/3/10030001\")", tok.pos)
+	}
+	if len(strings.Split(tok.text, "/")) != 3 {
+		return "", fmt.Errorf("invalid source %q at position %d, expected <type>/<deviceId>/<datapointId>", tok.text, tok.pos)
+	}
+	return tok.text, nil
+}
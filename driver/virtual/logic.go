@@ -0,0 +1,276 @@
+package virtual
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iot-gateway/driver/opcua"
+	"iot-gateway/topics"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	MQTT "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	windowTick = time.Second // Neuberechnung von Zeitfenstern ohne Zykluszeit
+)
+
+// sample ist ein Eingangswert mit Zeitstempel
+type sample struct {
+	value float64
+	time  time.Time
+}
+
+// datapoint ist ein übersetzter virtueller Datenpunkt
+type datapoint struct {
+	id        string
+	name      string
+	expr      *Expression
+	lastValue interface{}
+	published bool
+}
+
+// inputStore hält die Eingangswerte eines virtuellen Geräts
+type inputStore struct {
+	mu      sync.Mutex
+	latest  map[string]sample
+	history map[string][]sample
+	windows map[string]time.Duration
+	dirty   map[string]bool
+	notify  chan struct{}
+}
+
+// Value liefert den letzten Wert einer Quelle
+func (s *inputStore) Value(source string) (float64, bool) {
+	v, ok := s.latest[source]
+	return v.value, ok
+}
+
+// Window liefert die Werte einer Quelle aus dem angegebenen Zeitfenster
+func (s *inputStore) Window(source string, d time.Duration) []float64 {
+	since := time.Now().Add(-d)
+	var values []float64
+	for _, smp := range s.history[source] {
+		if !smp.time.Before(since) {
+			values = append(values, smp.value)
+		}
+	}
+	return values
+}
+
+// add speichert einen Eingangswert und merkt die Quelle zur Neuberechnung vor
+func (s *inputStore) add(source string, value float64, now time.Time) {
+	s.mu.Lock()
+	s.latest[source] = sample{value, now}
+	if window, ok := s.windows[source]; ok {
+		history := append(s.history[source], sample{value, now})
+		cut := 0
+		for cut < len(history) && now.Sub(history[cut].time) > window {
+			cut++
+		}
+		s.history[source] = history[cut:]
+	}
+	s.dirty[source] = true
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Run berechnet die virtuellen Datenpunkte eines Geräts, sobald sich ein Eingang auf data/# ändert.
+// Ergebnisse werden unter data/virtual/<deviceId>/[<datapointId>] <name> veröffentlicht.
+func Run(device opcua.DeviceConfig, db *sql.DB, stopChan chan struct{}, server *MQTT.Server) error {
+	datapoints, err := compileDatapoints(device)
+	if err != nil {
+		return err
+	}
+
+	store := &inputStore{
+		latest:  make(map[string]sample),
+		history: make(map[string][]sample),
+		windows: make(map[string]time.Duration),
+		dirty:   make(map[string]bool),
+		notify:  make(chan struct{}, 1),
+	}
+	sources := make(map[string]bool)
+	for _, dp := range datapoints {
+		for _, source := range dp.expr.Sources() {
+			sources[source] = true
+		}
+		for source, window := range dp.expr.Windows() {
+			if window > store.windows[source] {
+				store.windows[source] = window
+			}
+		}
+	}
+
+	subscriptionID := topics.NextSubscriptionID()
+	err = server.Subscribe("data/#", subscriptionID, func(cl *MQTT.Client, sub packets.Subscription, pk packets.Packet) {
+		source := topics.DataSource(pk.TopicName)
+		if !sources[source] {
+			return
+		}
+		if value, ok := parseValue(pk.Payload); ok {
+			store.add(source, value, time.Now())
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("error subscribing to data/#: %v", err)
+	}
+	defer server.Unsubscribe("data/#", subscriptionID)
+
+	publishDeviceState(server, device.ID, "1 (running)", db)
+	logrus.Infof("VIRTUAL: Device %s started with %d datapoints.", device.Name, len(datapoints))
+
+	// Zyklische Neuberechnung: Zykluszeit des Geräts, sonst nur bei Zeitfenstern
+	interval := time.Duration(device.AcquisitionTime) * time.Millisecond
+	if interval <= 0 && len(store.windows) > 0 {
+		interval = windowTick
+	}
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-stopChan:
+			logrus.Infof("VIRTUAL: Stopping device %s.", device.Name)
+			publishDeviceState(server, device.ID, "0 (stopped)", db)
+			return nil
+		case <-store.notify:
+			store.mu.Lock()
+			dirty := store.dirty
+			store.dirty = make(map[string]bool)
+			var results []result
+			for _, dp := range datapoints {
+				for source := range dirty {
+					if dp.expr.Uses(source) {
+						results = appendResult(results, dp, store)
+						break
+					}
+				}
+			}
+			store.mu.Unlock()
+			publishResults(device.ID, results, server)
+		case <-tick:
+			store.mu.Lock()
+			var results []result
+			for _, dp := range datapoints {
+				results = appendResult(results, dp, store)
+			}
+			store.mu.Unlock()
+			publishResults(device.ID, results, server)
+		}
+	}
+}
+
+// compileDatapoints übersetzt die Ausdrücke eines Geräts. Ungültige Datenpunkte werden übersprungen.
+func compileDatapoints(device opcua.DeviceConfig) ([]*datapoint, error) {
+	ownPrefix := "virtual/" + device.ID + "/"
+
+	var datapoints []*datapoint
+	for _, dp := range device.Datapoint {
+		expr, err := Compile(dp.Address)
+		if err == nil {
+			for _, source := range expr.Sources() {
+				if strings.HasPrefix(source, ownPrefix) {
+					err = fmt.Errorf("datapoints of the same virtual device cannot be referenced (%s)", source)
+					break
+				}
+			}
+		}
+		if err != nil {
+			logrus.Errorf("VIRTUAL: Invalid expression for datapoint %s of device %s: %v", dp.Name, device.Name, err)
+			continue
+		}
+		datapoints = append(datapoints, &datapoint{id: dp.ID, name: dp.Name, expr: expr})
+	}
+	if len(datapoints) == 0 {
+		return nil, fmt.Errorf("no valid virtual datapoints for device %s", device.Name)
+	}
+	return datapoints, nil
+}
+
+// result ist ein geänderter Wert, der veröffentlicht werden soll
+type result struct {
+	dp    *datapoint
+	value interface{}
+}
+
+// appendResult berechnet einen Datenpunkt und merkt ihn bei Änderung zur Veröffentlichung vor
+func appendResult(results []result, dp *datapoint, in Inputs) []result {
+	v, err := dp.expr.Eval(in)
+	if err != nil {
+		if !errors.Is(err, ErrNoValue) {
+			logrus.Debugf("VIRTUAL: Error evaluating datapoint %s: %v", dp.name, err)
+		}
+		return results
+	}
+
+	var value interface{} = v
+	if dp.expr.IsBoolean() {
+		value = v != 0
+	}
+	if dp.published && dp.lastValue == value {
+		return results
+	}
+	return append(results, result{dp, value})
+}
+
+// publishResults veröffentlicht berechnete Werte. Der Aufruf erfolgt außerhalb der Sperre des
+// Eingangsspeichers, da Publish Inline-Subscriber synchron aufruft.
+func publishResults(deviceID string, results []result, server *MQTT.Server) {
+	for _, r := range results {
+		// Gleicher Veröffentlichungsweg und damit gleiche QoS/Retain-Vorgaben wie bei S7, OPC-UA und MQTT
+		topic := fmt.Sprintf("data/virtual/%s/[%s] %s", deviceID, r.dp.id, r.dp.name)
+		if _, err := opcua.PublishValue(server, nil, topic, r.value, opcua.PublishSettings{}); err != nil {
+			logrus.Errorf("VIRTUAL: Failed to publish datapoint %s: %v", r.dp.name, err)
+			continue
+		}
+		r.dp.lastValue = r.value
+		r.dp.published = true
+	}
+}
+
+// publishDeviceState veröffentlicht den Gerätestatus und speichert ihn in der Datenbank
+func publishDeviceState(server *MQTT.Server, deviceID string, status string, db *sql.DB) {
+	topic := "driver/states/virtual/" + deviceID
+	server.Publish(topic, []byte(status), true, 2)
+
+	if _, err := db.Exec("UPDATE devices SET status = ? WHERE id = ?", status, deviceID); err != nil {
+		logrus.Errorf("Error updating device state in the database: %v", err)
+	}
+}
+
+// parseValue interpretiert einen Payload als Zahl (Booleans als 1/0)
+func parseValue(payload []byte) (float64, bool) {
+	var decoded interface{}
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		decoded = strings.TrimSpace(string(payload))
+	}
+	switch v := decoded.(type) {
+	case float64:
+		return v, true
+	case bool:
+		return toNumber(v), true
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f, true
+		}
+		if b, err := strconv.ParseBool(v); err == nil {
+			return toNumber(b), true
+		}
+	}
+	return 0, false
+}
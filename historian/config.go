@@ -0,0 +1,91 @@
+package historian
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// Backends der historischen Daten (HISTORY_BACKEND)
+const (
+	BackendAuto     = "auto"     // Historian, wenn INFLUXDB_URL nicht gesetzt ist
+	BackendInfluxDB = "influxdb" // Nur InfluxDB
+	BackendLocal    = "local"    // Nur eingebetteter Historian
+)
+
+// Config enthält die Konfiguration des eingebetteten Historians
+type Config struct {
+	Backend         string
+	DBPath          string        // SQLite-Datei der Zeitreihen, getrennt von der Gateway-Datenbank
+	FlushInterval   time.Duration // Intervall, in dem gepufferte Werte als Segment gespeichert werden
+	MaxPending      int           // Gepufferte Werte, danach werden neue Werte verworfen
+	RawRetention    time.Duration // Aufbewahrung der Rohwerte
+	MinuteRetention time.Duration // Aufbewahrung der 1-Minuten-Stufe
+	HourRetention   time.Duration // Aufbewahrung der 1-Stunden-Stufe
+}
+
+// LoadConfig lädt die Konfiguration aus Umgebungsvariablen
+func LoadConfig() Config {
+	config := Config{
+		Backend:         BackendAuto,
+		DBPath:          "./historian.db",
+		FlushInterval:   10 * time.Second,
+		MaxPending:      100000,
+		RawRetention:    7 * 24 * time.Hour,
+		MinuteRetention: 90 * 24 * time.Hour,
+		HourRetention:   5 * 365 * 24 * time.Hour,
+	}
+
+	switch val := os.Getenv("HISTORY_BACKEND"); val {
+	case BackendInfluxDB, BackendLocal:
+		config.Backend = val
+	}
+
+	if val := os.Getenv("HISTORIAN_DB_PATH"); val != "" {
+		config.DBPath = val
+	}
+
+	if val := os.Getenv("HISTORIAN_FLUSH_SECONDS"); val != "" {
+		if intVal, err := strconv.Atoi(val); err == nil && intVal > 0 {
+			config.FlushInterval = time.Duration(intVal) * time.Second
+		}
+	}
+
+	if val := os.Getenv("HISTORIAN_MAX_PENDING"); val != "" {
+		if intVal, err := strconv.Atoi(val); err == nil && intVal > 0 {
+			config.MaxPending = intVal
+		}
+	}
+
+	if val := os.Getenv("HISTORIAN_RAW_RETENTION_DAYS"); val != "" {
+		if intVal, err := strconv.Atoi(val); err == nil && intVal > 0 {
+			config.RawRetention = time.Duration(intVal) * 24 * time.Hour
+		}
+	}
+
+	if val := os.Getenv("HISTORIAN_MINUTE_RETENTION_DAYS"); val != "" {
+		if intVal, err := strconv.Atoi(val); err == nil && intVal > 0 {
+			config.MinuteRetention = time.Duration(intVal) * 24 * time.Hour
+		}
+	}
+
+	if val := os.Getenv("HISTORIAN_HOUR_RETENTION_DAYS"); val != "" {
+		if intVal, err := strconv.Atoi(val); err == nil && intVal > 0 {
+			config.HourRetention = time.Duration(intVal) * 24 * time.Hour
+		}
+	}
+
+	return config
+}
+
+// Enabled meldet, ob der eingebettete Historian statt der InfluxDB verwendet wird: bei
+// HISTORY_BACKEND=local oder, im Standard auto, wenn keine InfluxDB konfiguriert ist.
+func Enabled() bool {
+	switch LoadConfig().Backend {
+	case BackendLocal:
+		return true
+	case BackendInfluxDB:
+		return false
+	}
+	return os.Getenv("INFLUXDB_URL") == ""
+}
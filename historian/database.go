@@ -0,0 +1,83 @@
+package historian
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+
+	_ "github.com/glebarez/go-sqlite" // Import für SQLite
+)
+
+// Zeitreihen, komprimierte Rohwert-Segmente und Aggregationsstufen. Die Tabellen liegen in einer
+// eigenen Datenbank (HISTORIAN_DB_PATH), damit die Schreiblast und Größe der Zeitreihen die
+// Konfigurationsdatenbank des Gateways nicht belasten.
+const schema = `
+	CREATE TABLE IF NOT EXISTS historian_series (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		measurement TEXT NOT NULL,          -- "[<datapointId>] <name>" wie in der InfluxDB
+		device_id TEXT NOT NULL,
+		datapoint_id TEXT NOT NULL DEFAULT '',
+		last_time INTEGER NOT NULL DEFAULT 0, -- Unix-Zeit (ns) des letzten Werts
+		UNIQUE (device_id, measurement)
+	);
+
+	CREATE TABLE IF NOT EXISTS historian_segments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		series_id INTEGER NOT NULL,
+		start_time INTEGER NOT NULL,        -- Unix-Zeit (ns) des ersten Werts
+		end_time INTEGER NOT NULL,          -- Unix-Zeit (ns) des letzten Werts
+		count INTEGER NOT NULL,
+		data BLOB NOT NULL                  -- Segmentformat siehe segment.go
+	);
+	CREATE INDEX IF NOT EXISTS idx_historian_segments_series ON historian_segments(series_id, start_time);
+	CREATE INDEX IF NOT EXISTS idx_historian_segments_end ON historian_segments(end_time);
+
+	CREATE TABLE IF NOT EXISTS historian_rollups (
+		series_id INTEGER NOT NULL,
+		tier INTEGER NOT NULL,              -- Intervall in Sekunden (60, 3600)
+		bucket INTEGER NOT NULL,            -- Unix-Zeit (s) des Intervallbeginns
+		count INTEGER NOT NULL,             -- Alle Werte
+		num_count INTEGER NOT NULL,         -- Zahlenwerte (sum, min, max)
+		sum REAL NOT NULL DEFAULT 0,
+		min REAL,
+		max REAL,
+		last_time INTEGER NOT NULL,         -- Unix-Zeit (ns) des letzten Werts
+		last_num REAL,
+		last_text TEXT,
+		PRIMARY KEY (series_id, tier, bucket)
+	);
+	CREATE INDEX IF NOT EXISTS idx_historian_rollups_bucket ON historian_rollups(tier, bucket);
+`
+
+// openDatabase öffnet die Datenbank des Historians und legt fehlende Tabellen an
+func openDatabase(path string) (*sql.DB, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	hdb, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Wie die Gateway-Datenbank im WAL-Mode; Segmente werden nur gebündelt im Flush geschrieben,
+	// daher genügt synchronous=NORMAL (im WAL-Mode bei einem Absturz höchstens der letzte Flush verloren)
+	for _, pragma := range []string{
+		"PRAGMA journal_mode=WAL",
+		"PRAGMA busy_timeout=5000",
+		"PRAGMA synchronous=NORMAL",
+	} {
+		if _, err := hdb.Exec(pragma); err != nil {
+			hdb.Close()
+			return nil, err
+		}
+	}
+
+	if _, err := hdb.Exec(schema); err != nil {
+		hdb.Close()
+		return nil, err
+	}
+	return hdb, nil
+}
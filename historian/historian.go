@@ -0,0 +1,467 @@
+package historian
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dataforwarding "iot-gateway/data-forwarding"
+	"iot-gateway/topics"
+
+	MQTT "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	maxSegmentSamples = 10000     // Werte je Segment, größere Puffer werden aufgeteilt
+	retentionInterval = time.Hour // Intervall für das Löschen abgelaufener Daten
+)
+
+// Aggregationsstufen in Sekunden
+const (
+	TierMinute = 60
+	TierHour   = 3600
+)
+
+// seriesKey identifiziert eine Zeitreihe wie die Tags einer InfluxDB-Serie
+type seriesKey struct {
+	deviceID    string
+	measurement string
+}
+
+// pendingSeries sind die noch nicht gespeicherten Werte einer Zeitreihe
+type pendingSeries struct {
+	datapointID string
+	samples     []Sample
+}
+
+// Stats enthält Kennzahlen des Historians
+type Stats struct {
+	Running        bool
+	Pending        int
+	StoredSamples  int64
+	DroppedSamples int64
+	Flushes        int64
+	LastFlush      time.Time
+	LastError      string
+}
+
+var (
+	mu           sync.Mutex
+	db           *sql.DB
+	server       *MQTT.Server
+	config       Config
+	pending      = make(map[seriesKey]*pendingSeries)
+	pendingCount int
+	seriesIDs    = make(map[seriesKey]int64)
+	stats        Stats
+	stopChan     chan struct{}
+	loopDone     chan struct{}
+	flushMu      sync.Mutex // Nur ein Flush gleichzeitig
+)
+
+// Start öffnet die Datenbank des Historians (HISTORIAN_DB_PATH) und speichert ab jetzt alle Werte
+// auf data/# und backfill/#, sofern er als Backend aktiv ist (siehe Enabled)
+func Start(serverF *MQTT.Server) error {
+	if !Enabled() {
+		logrus.Info("HISTORIAN: Embedded historian disabled, historical data is stored in InfluxDB.")
+		return nil
+	}
+
+	cfg := LoadConfig()
+	hdb, err := openDatabase(cfg.DBPath)
+	if err != nil {
+		return fmt.Errorf("failed to open historian database %s: %w", cfg.DBPath, err)
+	}
+
+	mu.Lock()
+	db = hdb
+	server = serverF
+	config = cfg
+	seriesIDs = make(map[seriesKey]int64)
+	stats = Stats{Running: true}
+	mu.Unlock()
+
+	if err := server.Subscribe("data/#", topics.HistorianDataSubscriptionID, func(cl *MQTT.Client, sub packets.Subscription, pk packets.Packet) {
+		handleValue(pk.TopicName, pk.Payload, false)
+	}); err != nil {
+		mu.Lock()
+		db, server = nil, nil
+		stats.Running = false
+		mu.Unlock()
+		hdb.Close()
+		return fmt.Errorf("error subscribing to topic data/#: %w", err)
+	}
+	if err := server.Subscribe("backfill/#", topics.HistorianBackfillSubscriptionID, func(cl *MQTT.Client, sub packets.Subscription, pk packets.Packet) {
+		handleValue(pk.TopicName, pk.Payload, true)
+	}); err != nil {
+		logrus.Errorf("HISTORIAN: Error subscribing to topic backfill/#: %v", err)
+	}
+
+	stopChan = make(chan struct{})
+	loopDone = make(chan struct{})
+	go loop(stopChan, loopDone)
+
+	logrus.Infof("HISTORIAN: Embedded historian started in %s (raw %v, 1 min %v, 1 h %v retention).",
+		config.DBPath, config.RawRetention, config.MinuteRetention, config.HourRetention)
+	return nil
+}
+
+// Stop beendet die Subscriptions, speichert die gepufferten Werte und schließt die Datenbank
+func Stop(ctx context.Context) error {
+	mu.Lock()
+	if server != nil {
+		server.Unsubscribe("data/#", topics.HistorianDataSubscriptionID)
+		server.Unsubscribe("backfill/#", topics.HistorianBackfillSubscriptionID)
+		server = nil
+	}
+	stop, done := stopChan, loopDone
+	stopChan, loopDone = nil, nil
+	mu.Unlock()
+	if stop == nil {
+		return nil
+	}
+
+	close(stop)
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	err := flush()
+	mu.Lock()
+	stats.Running = false
+	hdb := db
+	db = nil
+	mu.Unlock()
+	if closeErr := hdb.Close(); err == nil {
+		err = closeErr
+	}
+	logrus.Info("HISTORIAN: Embedded historian stopped.")
+	return err
+}
+
+// GetStats liefert die Kennzahlen des Historians
+func GetStats() Stats {
+	mu.Lock()
+	defer mu.Unlock()
+	s := stats
+	s.Pending = pendingCount
+	return s
+}
+
+func loop(stop, done chan struct{}) {
+	defer close(done)
+	flushTicker := time.NewTicker(config.FlushInterval)
+	defer flushTicker.Stop()
+	retentionTicker := time.NewTicker(retentionInterval)
+	defer retentionTicker.Stop()
+
+	applyRetention(time.Now())
+	for {
+		select {
+		case <-stop:
+			return
+		case <-flushTicker.C:
+			if err := flush(); err != nil {
+				logrus.Errorf("HISTORIAN: Error storing values: %v", err)
+			}
+		case now := <-retentionTicker.C:
+			applyRetention(now)
+		}
+	}
+}
+
+// handleValue puffert einen Wert von data/... bzw. einen nachgeholten Wert von backfill/...
+func handleValue(topic string, payload []byte, backfill bool) {
+	parsed := dataforwarding.ParseTopic(topic)
+	if !parsed.IsValid {
+		return
+	}
+
+	sample := Sample{Time: time.Now()}
+	if backfill {
+		var v struct {
+			Value     json.RawMessage `json:"value"`
+			Timestamp time.Time       `json:"timestamp"`
+		}
+		if err := json.Unmarshal(payload, &v); err != nil || v.Timestamp.IsZero() {
+			logrus.Warnf("HISTORIAN: Invalid backfill payload on %s", topic)
+			return
+		}
+		sample = Sample{Time: v.Timestamp, Value: dataforwarding.ParsePayload(v.Value)}
+	} else {
+		sample.Value = dataforwarding.ParsePayload(payload)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if pendingCount >= config.MaxPending {
+		stats.DroppedSamples++
+		if stats.DroppedSamples == 1 || stats.DroppedSamples%10000 == 0 {
+			logrus.Warnf("HISTORIAN: Buffer full (%d values), %d values dropped so far", config.MaxPending, stats.DroppedSamples)
+		}
+		return
+	}
+	key := seriesKey{deviceID: parsed.DeviceID, measurement: parsed.Measurement}
+	p, ok := pending[key]
+	if !ok {
+		p = &pendingSeries{datapointID: parsed.DatapointID}
+		pending[key] = p
+	}
+	p.samples = append(p.samples, sample)
+	pendingCount++
+}
+
+// flush speichert die gepufferten Werte als Segmente und aktualisiert die Aggregationsstufen.
+// Schlägt das fehl, werden die Werte erneut gepuffert.
+func flush() error {
+	flushMu.Lock()
+	defer flushMu.Unlock()
+
+	mu.Lock()
+	batch, count := pending, pendingCount
+	pending, pendingCount = make(map[seriesKey]*pendingSeries), 0
+	mu.Unlock()
+	if count == 0 {
+		return nil
+	}
+
+	err := store(batch)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if err != nil {
+		stats.LastError = err.Error()
+		requeue(batch)
+		return err
+	}
+	stats.StoredSamples += int64(count)
+	stats.Flushes++
+	stats.LastFlush = time.Now()
+	stats.LastError = ""
+	return nil
+}
+
+// requeue übernimmt nicht gespeicherte Werte wieder in den Puffer, mu muss gesperrt sein
+func requeue(batch map[seriesKey]*pendingSeries) {
+	for key, b := range batch {
+		p, ok := pending[key]
+		if !ok {
+			p = &pendingSeries{datapointID: b.datapointID}
+			pending[key] = p
+		}
+		room := config.MaxPending - pendingCount
+		samples := b.samples
+		if len(samples) > room {
+			stats.DroppedSamples += int64(len(samples) - room)
+			samples = samples[:room]
+		}
+		p.samples = append(samples, p.samples...)
+		pendingCount += len(samples)
+	}
+}
+
+func store(batch map[seriesKey]*pendingSeries) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	newIDs := make(map[seriesKey]int64)
+	for key, p := range batch {
+		samples := p.samples
+		sort.SliceStable(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+
+		id, ok := lookupSeriesID(key)
+		if !ok {
+			if id, err = createSeries(tx, key, p.datapointID); err != nil {
+				return err
+			}
+			newIDs[key] = id
+		}
+		last := samples[len(samples)-1].Time.UnixNano()
+		if _, err := tx.Exec(`UPDATE historian_series SET last_time = MAX(last_time, ?) WHERE id = ?`, last, id); err != nil {
+			return err
+		}
+
+		for start := 0; start < len(samples); start += maxSegmentSamples {
+			end := start + maxSegmentSamples
+			if end > len(samples) {
+				end = len(samples)
+			}
+			if err := insertSegment(tx, id, samples[start:end]); err != nil {
+				return err
+			}
+		}
+		for _, tier := range []int64{TierMinute, TierHour} {
+			if err := upsertRollups(tx, id, tier, samples); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	for key, id := range newIDs {
+		seriesIDs[key] = id
+	}
+	mu.Unlock()
+	return nil
+}
+
+func lookupSeriesID(key seriesKey) (int64, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	id, ok := seriesIDs[key]
+	return id, ok
+}
+
+// createSeries legt eine Zeitreihe an bzw. liefert die ID einer bestehenden
+func createSeries(tx *sql.Tx, key seriesKey, datapointID string) (int64, error) {
+	if _, err := tx.Exec(`INSERT OR IGNORE INTO historian_series (measurement, device_id, datapoint_id) VALUES (?, ?, ?)`,
+		key.measurement, key.deviceID, datapointID); err != nil {
+		return 0, err
+	}
+	var id int64
+	err := tx.QueryRow(`SELECT id FROM historian_series WHERE device_id = ? AND measurement = ?`, key.deviceID, key.measurement).Scan(&id)
+	return id, err
+}
+
+func insertSegment(tx *sql.Tx, seriesID int64, samples []Sample) error {
+	data, err := encodeSegment(samples)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO historian_segments (series_id, start_time, end_time, count, data) VALUES (?, ?, ?, ?, ?)`,
+		seriesID, samples[0].Time.UnixNano(), samples[len(samples)-1].Time.UnixNano(), len(samples), data)
+	return err
+}
+
+// rollup ist ein Intervall einer Aggregationsstufe
+type rollup struct {
+	bucket   int64
+	count    int64
+	numCount int64
+	sum      float64
+	min, max sql.NullFloat64
+	lastTime int64
+	lastNum  sql.NullFloat64
+	lastText sql.NullString
+}
+
+// add übernimmt einen Wert. Texte zählen nur für count und last.
+func (r *rollup) add(s Sample) {
+	r.count++
+	t := s.Time.UnixNano()
+	last := t >= r.lastTime
+	if last {
+		r.lastTime = t
+	}
+	switch v := s.Value.(type) {
+	case float64:
+		r.numCount++
+		r.sum += v
+		if !r.min.Valid || v < r.min.Float64 {
+			r.min = sql.NullFloat64{Float64: v, Valid: true}
+		}
+		if !r.max.Valid || v > r.max.Float64 {
+			r.max = sql.NullFloat64{Float64: v, Valid: true}
+		}
+		if last {
+			r.lastNum, r.lastText = sql.NullFloat64{Float64: v, Valid: true}, sql.NullString{}
+		}
+	case string:
+		if last {
+			r.lastNum, r.lastText = sql.NullFloat64{}, sql.NullString{String: v, Valid: true}
+		}
+	}
+}
+
+// upsertRollups fasst nach Zeit sortierte Werte zu Intervallen einer Stufe zusammen und ergänzt
+// die gespeicherten Intervalle
+func upsertRollups(tx *sql.Tx, seriesID, tier int64, samples []Sample) error {
+	stmt, err := tx.Prepare(`INSERT INTO historian_rollups (series_id, tier, bucket, count, num_count, sum, min, max, last_time, last_num, last_text)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (series_id, tier, bucket) DO UPDATE SET
+			count = count + excluded.count,
+			num_count = num_count + excluded.num_count,
+			sum = sum + excluded.sum,
+			min = CASE WHEN min IS NULL OR excluded.min < min THEN excluded.min ELSE min END,
+			max = CASE WHEN max IS NULL OR excluded.max > max THEN excluded.max ELSE max END,
+			last_num = CASE WHEN excluded.last_time >= last_time THEN excluded.last_num ELSE last_num END,
+			last_text = CASE WHEN excluded.last_time >= last_time THEN excluded.last_text ELSE last_text END,
+			last_time = MAX(last_time, excluded.last_time)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var r *rollup
+	write := func() error {
+		_, err := stmt.Exec(seriesID, tier, r.bucket, r.count, r.numCount, r.sum, r.min, r.max, r.lastTime, r.lastNum, r.lastText)
+		return err
+	}
+	for _, s := range samples {
+		bucket := floorDiv(s.Time.Unix(), tier) * tier
+		if r != nil && r.bucket != bucket {
+			if err := write(); err != nil {
+				return err
+			}
+			r = nil
+		}
+		if r == nil {
+			r = &rollup{bucket: bucket, lastTime: s.Time.UnixNano()}
+		}
+		r.add(s)
+	}
+	if r != nil {
+		return write()
+	}
+	return nil
+}
+
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && a < 0 {
+		q--
+	}
+	return q
+}
+
+// applyRetention löscht Rohwerte und Intervalle, deren Aufbewahrungszeit abgelaufen ist
+func applyRetention(now time.Time) {
+	deletes := []struct {
+		query string
+		arg   int64
+	}{
+		{`DELETE FROM historian_segments WHERE end_time < ?`, now.Add(-config.RawRetention).UnixNano()},
+		{fmt.Sprintf(`DELETE FROM historian_rollups WHERE tier = %d AND bucket < ?`, TierMinute), now.Add(-config.MinuteRetention).Unix()},
+		{fmt.Sprintf(`DELETE FROM historian_rollups WHERE tier = %d AND bucket < ?`, TierHour), now.Add(-config.HourRetention).Unix()},
+	}
+	var removed []string
+	for _, d := range deletes {
+		res, err := db.Exec(d.query, d.arg)
+		if err != nil {
+			logrus.Errorf("HISTORIAN: Error applying retention: %v", err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			table := strings.Fields(d.query)[2]
+			removed = append(removed, fmt.Sprintf("%d rows from %s", n, table))
+		}
+	}
+	if len(removed) > 0 {
+		logrus.Infof("HISTORIAN: Retention removed %s", strings.Join(removed, ", "))
+	}
+}
@@ -0,0 +1,387 @@
+package historian
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SeriesFilter wählt Zeitreihen aus: über DeviceID und DatapointID oder über das Measurement
+type SeriesFilter struct {
+	DeviceID    string
+	DatapointID string
+	Measurement string
+}
+
+// Series ist eine gespeicherte Zeitreihe
+type Series struct {
+	ID          int64
+	DeviceID    string
+	DatapointID string
+	Measurement string
+}
+
+// Point ist ein Wert bzw. ein aggregiertes Fenster einer Zeitreihe
+type Point struct {
+	Series
+	Time  time.Time
+	Value interface{} // float64 oder string
+}
+
+// Query ist eine Abfrage mit denselben Aggregationen wie die Flux-Abfragen der InfluxDB:
+// mean, min, max (nur Zahlen), last, count oder none (Rohwerte)
+type Query struct {
+	Series    []SeriesFilter
+	Start     time.Time
+	Stop      time.Time
+	Aggregate string
+	Window    time.Duration  // Bei Aggregationen erforderlich
+	Location  *time.Location // Ausrichtung der Fenster (z.B. Tage ab Mitternacht)
+	Limit     int            // Bei none: Rohwerte je Zeitreihe, 0 = alle
+}
+
+func database() (*sql.DB, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if db == nil {
+		return nil, fmt.Errorf("embedded historian is not running")
+	}
+	return db, nil
+}
+
+// Devices liefert die Geräte-IDs mit Werten seit since
+func Devices(ctx context.Context, since time.Time) ([]string, error) {
+	db, err := database()
+	if err != nil {
+		return nil, err
+	}
+	return queryStrings(ctx, db, `SELECT DISTINCT device_id FROM historian_series WHERE last_time >= ? ORDER BY device_id`, since.UnixNano())
+}
+
+// Measurements liefert die Measurements eines Geräts mit Werten seit since
+func Measurements(ctx context.Context, deviceID string, since time.Time) ([]string, error) {
+	db, err := database()
+	if err != nil {
+		return nil, err
+	}
+	return queryStrings(ctx, db, `SELECT measurement FROM historian_series WHERE device_id = ? AND last_time >= ? ORDER BY measurement`,
+		deviceID, since.UnixNano())
+}
+
+func queryStrings(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// Run führt eine Abfrage aus und übergibt die Punkte je Zeitreihe nach Zeit sortiert an fn
+func Run(ctx context.Context, q Query, fn func(Point) error) error {
+	db, err := database()
+	if err != nil {
+		return err
+	}
+	if q.Location == nil {
+		q.Location = time.UTC
+	}
+	if q.Aggregate != "none" && q.Window <= 0 {
+		return fmt.Errorf("window is required for aggregate %q", q.Aggregate)
+	}
+
+	series, err := findSeries(ctx, db, q.Series)
+	if err != nil {
+		return err
+	}
+	for _, s := range series {
+		if q.Aggregate == "none" {
+			err = runRaw(ctx, db, q, s, fn)
+		} else {
+			err = runAggregate(ctx, db, q, s, fn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findSeries liefert die Zeitreihen, auf die mindestens ein Filter passt
+func findSeries(ctx context.Context, db *sql.DB, filters []SeriesFilter) ([]Series, error) {
+	var conds []string
+	var args []interface{}
+	for _, f := range filters {
+		var parts []string
+		if f.DeviceID != "" {
+			parts = append(parts, "device_id = ?")
+			args = append(args, f.DeviceID)
+		}
+		if f.DatapointID != "" {
+			parts = append(parts, "datapoint_id = ?")
+			args = append(args, f.DatapointID)
+		} else {
+			parts = append(parts, "measurement = ?")
+			args = append(args, f.Measurement)
+		}
+		conds = append(conds, "("+strings.Join(parts, " AND ")+")")
+	}
+	if len(conds) == 0 {
+		return nil, nil
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT id, device_id, datapoint_id, measurement FROM historian_series WHERE `+
+		strings.Join(conds, " OR ")+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var series []Series
+	for rows.Next() {
+		var s Series
+		if err := rows.Scan(&s.ID, &s.DeviceID, &s.DatapointID, &s.Measurement); err != nil {
+			return nil, err
+		}
+		series = append(series, s)
+	}
+	return series, rows.Err()
+}
+
+// scanSamples liest die Rohwerte einer Zeitreihe im Bereich [start, stop) nach Zeit sortiert.
+// Segmente können sich durch nachgeholte Werte überlappen; Werte werden erst weitergegeben,
+// wenn kein später beginnendes Segment mehr davor liegen kann.
+func scanSamples(ctx context.Context, db *sql.DB, seriesID int64, start, stop time.Time, fn func(Sample) (bool, error)) error {
+	rows, err := db.QueryContext(ctx, `SELECT start_time, count, data FROM historian_segments
+		WHERE series_id = ? AND start_time < ? AND end_time >= ? ORDER BY start_time`,
+		seriesID, stop.UnixNano(), start.UnixNano())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var buf []Sample
+	// emit gibt alle gepufferten Werte vor until weiter (until = 0: alle)
+	emit := func(until int64) (bool, error) {
+		sort.SliceStable(buf, func(i, j int) bool { return buf[i].Time.Before(buf[j].Time) })
+		n := 0
+		for ; n < len(buf); n++ {
+			s := buf[n]
+			if until != 0 && s.Time.UnixNano() >= until {
+				break
+			}
+			if s.Time.Before(start) || !s.Time.Before(stop) {
+				continue
+			}
+			if more, err := fn(s); err != nil || !more {
+				return false, err
+			}
+		}
+		buf = append(buf[:0], buf[n:]...)
+		return true, nil
+	}
+
+	for rows.Next() {
+		var segStart int64
+		var count int
+		var data []byte
+		if err := rows.Scan(&segStart, &count, &data); err != nil {
+			return err
+		}
+		if more, err := emit(segStart); err != nil || !more {
+			return err
+		}
+		samples, err := decodeSegment(data, segStart, count)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, samples...)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = emit(0)
+	return err
+}
+
+func runRaw(ctx context.Context, db *sql.DB, q Query, s Series, fn func(Point) error) error {
+	n := 0
+	return scanSamples(ctx, db, s.ID, q.Start, q.Stop, func(sample Sample) (bool, error) {
+		if err := fn(Point{Series: s, Time: sample.Time, Value: sample.Value}); err != nil {
+			return false, err
+		}
+		n++
+		return q.Limit == 0 || n < q.Limit, nil
+	})
+}
+
+// tierFor wählt die Datenquelle einer Aggregation: die feinste Stufe, in deren Intervalle das Fenster
+// aufgeht und die den Beginn der Abfrage noch enthält. Sonst die feinste Stufe, die den Beginn enthält
+// (gröbere Punkte), und ist der Beginn überall abgelaufen, die gröbste Stufe. 0 = Rohwerte.
+func tierFor(q Query, now time.Time) int64 {
+	candidates := []struct {
+		tier      int64
+		retention time.Duration
+	}{
+		{0, config.RawRetention},
+		{TierMinute, config.MinuteRetention},
+		{TierHour, config.HourRetention},
+	}
+	for _, aligned := range []bool{true, false} {
+		for _, c := range candidates {
+			if aligned && c.tier != 0 && q.Window%(time.Duration(c.tier)*time.Second) != 0 {
+				continue
+			}
+			if !q.Start.Before(now.Add(-c.retention)) {
+				return c.tier
+			}
+		}
+	}
+	return TierHour
+}
+
+// windowStart liefert den Beginn des Fensters, in das t fällt. Fenster sind wie bei Flux an der
+// Ortszeit ausgerichtet, Tagesfenster beginnen also um Mitternacht in q.Location.
+func windowStart(t time.Time, window time.Duration, loc *time.Location) time.Time {
+	local := t.In(loc)
+	wall := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), local.Nanosecond(), time.UTC)
+	ws := wall.Truncate(window)
+	return time.Date(ws.Year(), ws.Month(), ws.Day(), ws.Hour(), ws.Minute(), ws.Second(), ws.Nanosecond(), loc)
+}
+
+// aggregateWindow sammelt Werte bzw. Intervalle eines Fensters
+type aggregateWindow struct {
+	start time.Time
+	rollup
+}
+
+func (w *aggregateWindow) merge(r rollup) {
+	w.count += r.count
+	w.numCount += r.numCount
+	w.sum += r.sum
+	if r.min.Valid && (!w.min.Valid || r.min.Float64 < w.min.Float64) {
+		w.min = r.min
+	}
+	if r.max.Valid && (!w.max.Valid || r.max.Float64 > w.max.Float64) {
+		w.max = r.max
+	}
+	if r.lastTime >= w.lastTime {
+		w.lastTime, w.lastNum, w.lastText = r.lastTime, r.lastNum, r.lastText
+	}
+}
+
+// value liefert das Ergebnis einer Aggregation. Fenster ohne passende Werte entfallen.
+func (w *aggregateWindow) value(aggregate string) (interface{}, bool) {
+	switch aggregate {
+	case "mean":
+		if w.numCount > 0 {
+			return w.sum / float64(w.numCount), true
+		}
+	case "min":
+		if w.min.Valid {
+			return w.min.Float64, true
+		}
+	case "max":
+		if w.max.Valid {
+			return w.max.Float64, true
+		}
+	case "count":
+		if w.count > 0 {
+			return w.count, true
+		}
+	case "last":
+		if w.lastNum.Valid {
+			return w.lastNum.Float64, true
+		}
+		if w.lastText.Valid {
+			return w.lastText.String, true
+		}
+	}
+	return nil, false
+}
+
+func runAggregate(ctx context.Context, db *sql.DB, q Query, s Series, fn func(Point) error) error {
+	switch q.Aggregate {
+	case "mean", "min", "max", "last", "count":
+	default:
+		return fmt.Errorf("unknown aggregate %q", q.Aggregate)
+	}
+
+	var w *aggregateWindow
+	emit := func() error {
+		if w == nil {
+			return nil
+		}
+		value, ok := w.value(q.Aggregate)
+		if !ok {
+			return nil
+		}
+		// Wie bei Flux beginnt das erste Fenster frühestens mit der Abfrage
+		t := w.start
+		if t.Before(q.Start) {
+			t = q.Start
+		}
+		return fn(Point{Series: s, Time: t, Value: value})
+	}
+	add := func(t time.Time, r rollup) error {
+		start := windowStart(t, q.Window, q.Location)
+		if w != nil && !w.start.Equal(start) {
+			if err := emit(); err != nil {
+				return err
+			}
+			w = nil
+		}
+		if w == nil {
+			w = &aggregateWindow{start: start}
+		}
+		w.merge(r)
+		return nil
+	}
+
+	tier := tierFor(q, time.Now())
+	if tier == 0 {
+		err := scanSamples(ctx, db, s.ID, q.Start, q.Stop, func(sample Sample) (bool, error) {
+			r := rollup{lastTime: sample.Time.UnixNano()}
+			r.add(sample)
+			return true, add(sample.Time, r)
+		})
+		if err != nil {
+			return err
+		}
+		return emit()
+	}
+
+	// Intervalle, die vor dem Beginn anfangen, werden mitgezählt
+	rows, err := db.QueryContext(ctx, `SELECT bucket, count, num_count, sum, min, max, last_time, last_num, last_text
+		FROM historian_rollups WHERE series_id = ? AND tier = ? AND bucket > ? AND bucket < ? ORDER BY bucket`,
+		s.ID, tier, q.Start.Unix()-tier, q.Stop.Unix())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r rollup
+		if err := rows.Scan(&r.bucket, &r.count, &r.numCount, &r.sum, &r.min, &r.max, &r.lastTime, &r.lastNum, &r.lastText); err != nil {
+			return err
+		}
+		if err := add(time.Unix(r.bucket, 0), r); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return emit()
+}
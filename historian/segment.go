@@ -0,0 +1,126 @@
+package historian
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Segmentformat (Version 1), mit DEFLATE komprimiert:
+//
+//	Version (1 Byte)
+//	je Wert: Zeitabstand zum vorherigen Wert bzw. zu start_time in ns (uvarint), Art (1 Byte),
+//	         Zahl: Bits XOR Bits der vorherigen Zahl (uvarint) | Text: Länge (uvarint) + Bytes
+//
+// Die Werte eines Segments sind nach Zeit sortiert.
+const segmentVersion = 1
+
+const (
+	kindFloat  = 0
+	kindString = 1
+)
+
+// Sample ist ein gespeicherter Wert einer Zeitreihe
+type Sample struct {
+	Time  time.Time
+	Value interface{} // float64 oder string
+}
+
+// encodeSegment kodiert nach Zeit sortierte Werte, start_time des Segments ist die Zeit des ersten Werts
+func encodeSegment(samples []Sample) ([]byte, error) {
+	var raw bytes.Buffer
+	raw.WriteByte(segmentVersion)
+	buf := make([]byte, binary.MaxVarintLen64)
+	putUvarint := func(v uint64) {
+		raw.Write(buf[:binary.PutUvarint(buf, v)])
+	}
+
+	prevTime := samples[0].Time.UnixNano()
+	var prevBits uint64
+	for _, s := range samples {
+		t := s.Time.UnixNano()
+		putUvarint(uint64(t - prevTime))
+		prevTime = t
+		switch v := s.Value.(type) {
+		case float64:
+			raw.WriteByte(kindFloat)
+			bits := math.Float64bits(v)
+			putUvarint(bits ^ prevBits)
+			prevBits = bits
+		case string:
+			raw.WriteByte(kindString)
+			putUvarint(uint64(len(v)))
+			raw.WriteString(v)
+		default:
+			return nil, fmt.Errorf("unsupported value type %T", s.Value)
+		}
+	}
+
+	var out bytes.Buffer
+	w, err := flate.NewWriter(&out, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(raw.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// decodeSegment dekodiert ein Segment mit count Werten, das bei start beginnt
+func decodeSegment(data []byte, start int64, count int) ([]Sample, error) {
+	raw, err := io.ReadAll(flate.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, fmt.Errorf("corrupt segment: %v", err)
+	}
+	r := bytes.NewReader(raw)
+	if version, err := r.ReadByte(); err != nil || version != segmentVersion {
+		return nil, fmt.Errorf("unsupported segment version")
+	}
+
+	samples := make([]Sample, 0, count)
+	t := start
+	var prevBits uint64
+	for i := 0; i < count; i++ {
+		delta, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("corrupt segment: %v", err)
+		}
+		t += int64(delta)
+		kind, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("corrupt segment: %v", err)
+		}
+		var value interface{}
+		switch kind {
+		case kindFloat:
+			x, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, fmt.Errorf("corrupt segment: %v", err)
+			}
+			prevBits ^= x
+			value = math.Float64frombits(prevBits)
+		case kindString:
+			n, err := binary.ReadUvarint(r)
+			if err != nil || n > uint64(r.Len()) {
+				return nil, fmt.Errorf("corrupt segment: invalid string length")
+			}
+			b := make([]byte, n)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return nil, fmt.Errorf("corrupt segment: %v", err)
+			}
+			value = string(b)
+		default:
+			return nil, fmt.Errorf("corrupt segment: unknown value kind %d", kind)
+		}
+		samples = append(samples, Sample{Time: time.Unix(0, t), Value: value})
+	}
+	return samples, nil
+}
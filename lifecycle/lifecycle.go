@@ -0,0 +1,206 @@
+// Package lifecycle startet und stoppt die Komponenten des Gateways in fester Reihenfolge.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultStopTimeout gilt für Komponenten ohne eigenes StopTimeout
+const DefaultStopTimeout = 10 * time.Second
+
+// Component ist ein Teil des Gateways mit Start- und Stop-Funktion.
+// Stop bekommt einen Kontext mit dem StopTimeout der Komponente und soll nach dessen Ablauf
+// zügig zurückkehren. Bis dahin werden keine weiteren Komponenten gestoppt.
+type Component struct {
+	Name        string
+	Start       func(ctx context.Context) error
+	Stop        func(ctx context.Context) error
+	StopTimeout time.Duration
+	Restartable bool // Wird bei einem Neustart des Gateways gestoppt und wieder gestartet
+}
+
+// Manager verwaltet die Komponenten. Gestartet wird in Registrierungsreihenfolge,
+// gestoppt in umgekehrter Reihenfolge.
+type Manager struct {
+	mu         sync.Mutex // Serialisiert Start, Stop und Restart
+	components []Component
+	started    int // Anzahl der laufenden Komponenten (von vorne)
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+// New erzeugt einen Manager
+func New() *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{ctx: ctx, cancel: cancel}
+}
+
+// Add registriert eine Komponente
+func (m *Manager) Add(c Component) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.components = append(m.components, c)
+}
+
+// Context wird beim Herunterfahren abgebrochen
+func (m *Manager) Context() context.Context {
+	return m.ctx
+}
+
+// Start startet alle Komponenten. Schlägt eine fehl, werden die bereits gestarteten wieder gestoppt.
+func (m *Manager) Start() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := m.started; i < len(m.components); i++ {
+		c := m.components[i]
+		if err := m.startComponent(c); err != nil {
+			m.stopFrom(0)
+			return err
+		}
+		m.started = i + 1
+	}
+	return nil
+}
+
+// Shutdown stoppt alle Komponenten in umgekehrter Reihenfolge
+func (m *Manager) Shutdown() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	logrus.Info("LIFECYCLE: Shutting down gateway...")
+	m.cancel()
+	m.stopFrom(0)
+	logrus.Info("LIFECYCLE: Gateway stopped.")
+}
+
+// Restart stoppt die neustartbaren Komponenten in umgekehrter Reihenfolge und startet sie wieder.
+// Fehler beim Stoppen oder Starten einer Komponente werden gesammelt gemeldet; die übrigen
+// Komponenten werden trotzdem gestartet, damit ein Fehler nicht das ganze Gateway stilllegt.
+func (m *Manager) Restart() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.ctx.Err() != nil {
+		return fmt.Errorf("gateway is shutting down")
+	}
+
+	logrus.Info("LIFECYCLE: Restarting gateway...")
+	var errs []error
+	for i := m.started - 1; i >= 0; i-- {
+		if c := m.components[i]; c.Restartable {
+			if err := m.stopComponent(c); err != nil {
+				logrus.Errorf("LIFECYCLE: %v", err)
+				errs = append(errs, err)
+			}
+		}
+	}
+	for i := 0; i < m.started; i++ {
+		if c := m.components[i]; c.Restartable {
+			if err := m.startComponent(c); err != nil {
+				logrus.Errorf("LIFECYCLE: %v", err)
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	logrus.Info("LIFECYCLE: Gateway restarted successfully.")
+	return nil
+}
+
+// Run startet alle Komponenten und blockiert bis SIGINT/SIGTERM, danach wird geordnet heruntergefahren
+func (m *Manager) Run() error {
+	if err := m.Start(); err != nil {
+		return err
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	select {
+	case sig := <-sigs:
+		logrus.Infof("LIFECYCLE: Received signal %v", sig)
+	case <-m.ctx.Done():
+	}
+	m.Shutdown()
+	return nil
+}
+
+// stopFrom stoppt alle laufenden Komponenten ab Index from (Aufruf nur unter mu)
+func (m *Manager) stopFrom(from int) {
+	for i := m.started - 1; i >= from; i-- {
+		if err := m.stopComponent(m.components[i]); err != nil {
+			logrus.Errorf("LIFECYCLE: %v", err)
+		}
+	}
+	m.started = from
+}
+
+func (m *Manager) startComponent(c Component) error {
+	if c.Start == nil {
+		return nil
+	}
+	logrus.Infof("LIFECYCLE: Starting %s...", c.Name)
+	if err := c.Start(m.ctx); err != nil {
+		return fmt.Errorf("failed to start %s: %v", c.Name, err)
+	}
+	return nil
+}
+
+func (m *Manager) stopComponent(c Component) error {
+	if c.Stop == nil {
+		return nil
+	}
+	timeout := c.StopTimeout
+	if timeout <= 0 {
+		timeout = DefaultStopTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	logrus.Infof("LIFECYCLE: Stopping %s...", c.Name)
+	start := time.Now()
+
+	// Nach Ablauf des Timeouts wird weiter gewartet: Die nachfolgenden Komponenten (z.B. die Datenbank)
+	// dürfen erst gestoppt werden, wenn diese Komponente nicht mehr läuft.
+	done := make(chan error, 1)
+	go func() { done <- c.Stop(ctx) }()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		logrus.Warnf("LIFECYCLE: %s did not stop within %v, waiting for it to finish...", c.Name, timeout)
+		ticker := time.NewTicker(timeout)
+		defer ticker.Stop()
+	wait:
+		for {
+			select {
+			case err = <-done:
+				break wait
+			case <-ticker.C:
+				logrus.Warnf("LIFECYCLE: Still waiting for %s to stop (%v)", c.Name, time.Since(start).Round(time.Second))
+			}
+		}
+		if err == nil {
+			err = fmt.Errorf("timeout after %v, stopped after %v", timeout, time.Since(start).Round(time.Millisecond))
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stop %s: %v", c.Name, err)
+	}
+	logrus.Infof("LIFECYCLE: %s stopped in %v", c.Name, time.Since(start).Round(time.Millisecond))
+	return nil
+}
@@ -2,6 +2,7 @@ package logic
 
 import (
 	"database/sql"
+	"fmt"
 	"os"
 	"time"
 
@@ -56,7 +57,13 @@ const (
 			certificate TEXT,            -- Optional für Zertifikat-basierte Authentifizierung
 			key TEXT,                    -- Optional für Zertifikat-basierte Authentifizierung
 			username TEXT,               -- Optional für Username-basierte Authentifizierung
-			password TEXT                -- Optional für Passwort-basierte Authentifizierung
+			password TEXT,               -- Optional für Passwort-basierte Authentifizierung
+			history_backfill BOOLEAN DEFAULT 0, -- Optional: HistoryRead-Backfill nach Reconnect (nur OPC-UA)
+			event_subscription BOOLEAN DEFAULT 0, -- Optional: Alarms & Conditions abonnieren (nur OPC-UA)
+			event_fields TEXT,                  -- Optional: Ereignisfelder, kommasepariert (nur OPC-UA)
+			status_message TEXT,                -- Optional: Fehlermeldung zum Status (z.B. Zuordnungsfehler bei MQTT)
+			last_seen TEXT,                     -- Optional: Letzte Aktivität (nur MQTT, RFC3339)
+			last_successful_read TEXT           -- Optional: Letzter erfolgreicher Lesezyklus für den Backfill (nur OPC-UA, RFC3339)
 		);
 	`
 
@@ -67,7 +74,9 @@ const (
 			datapointId VARCHAR(10) NOT NULL,
 			name VARCHAR(100) NOT NULL,
 			datatype VARCHAR(100) NOT NULL,
-			address VARCHAR(20) NOT NULL
+			address VARCHAR(20) NOT NULL,
+			publish_settings TEXT,       -- Optional: JSON mit Skalierung, Einheit, Deadband, QoS/Retain
+			scan_group_id INTEGER DEFAULT 0 -- Erfassungsgruppe (scan_groups.group_id), 0 = Zykluszeit des Geräts
 		);
 	`
 
@@ -77,7 +86,56 @@ const (
 			device_id INT NOT NULL,
 			datapointId VARCHAR(10) NOT NULL,
 			name VARCHAR(100) NOT NULL,
-			node_identifier VARCHAR(100) NOT NULL
+			node_identifier VARCHAR(100) NOT NULL,
+			publish_settings TEXT,       -- Optional: JSON mit Skalierung, Einheit, Deadband, QoS/Retain
+			scan_group_id INTEGER DEFAULT 0 -- Erfassungsgruppe (scan_groups.group_id), 0 = Zykluszeit des Geräts
+		);
+	`
+
+	createScanGroupsTable = `
+		CREATE TABLE IF NOT EXISTS scan_groups (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			device_id INT NOT NULL,
+			group_id INT NOT NULL,        -- Gruppennummer innerhalb des Geräts (ab 1)
+			name VARCHAR(100) NOT NULL,
+			interval_ms INT NOT NULL,     -- Zykluszeit der Gruppe in ms
+			UNIQUE (device_id, group_id)
+		);
+	`
+
+	createTriggerRulesTable = `
+		CREATE TABLE IF NOT EXISTS trigger_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			device_id INT NOT NULL,
+			name VARCHAR(100) NOT NULL,
+			trigger_datapoint VARCHAR(10) NOT NULL, -- Datapoint-ID des Triggers
+			mode VARCHAR(10) NOT NULL DEFAULT 'rising', -- rising | change
+			datapoints TEXT NOT NULL,     -- Datapoint-IDs des Datensatzes, kommasepariert
+			ack_address TEXT,             -- Optional: Quittierbit (S7-Adresse bzw. OPC-UA-NodeID)
+			enabled BOOLEAN DEFAULT 1
+		);
+	`
+
+	createVirtualDatapointsTable = `
+		CREATE TABLE IF NOT EXISTS virtual_datapoints (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			device_id INT NOT NULL,
+			datapointId VARCHAR(10) NOT NULL,
+			name VARCHAR(100) NOT NULL,
+			expression TEXT NOT NULL      -- z.B. dp("s7/3/10030001") * 0.1
+		);
+	`
+
+	createMqttMappingsTable = `
+		CREATE TABLE IF NOT EXISTS mqtt_mappings (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			device_id INT NOT NULL,
+			datapointId VARCHAR(10) NOT NULL,
+			name VARCHAR(100) NOT NULL,
+			topic TEXT NOT NULL DEFAULT '',  -- Topic-Filter relativ zu data/mqtt/<name>/, leer = alle
+			expression TEXT NOT NULL,        -- Feldausdruck, z.B. $.sensors.t
+			datatype VARCHAR(20) NOT NULL DEFAULT '', -- float, int, bool, string, leer = JSON-Typ
+			publish_settings TEXT            -- Optional: JSON mit Skalierung, Einheit, Deadband, QoS/Retain
 		);
 	`
 
@@ -123,6 +181,111 @@ const (
 		);
 	`
 
+	createEventsTable = `
+		CREATE TABLE IF NOT EXISTS events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			device_id INTEGER NOT NULL,
+			event_id TEXT NOT NULL,
+			condition_id TEXT,
+			event_type TEXT,
+			source_name TEXT,
+			severity INTEGER,
+			message TEXT,
+			active_state BOOLEAN,
+			acked_state BOOLEAN,
+			confirmed_state BOOLEAN,
+			fields TEXT,
+			event_time TEXT NOT NULL,
+			received_at TEXT NOT NULL,
+			acknowledged_at TEXT,
+			acknowledged_by TEXT,
+			ack_comment TEXT,
+			confirmed_at TEXT,
+			FOREIGN KEY (device_id) REFERENCES devices(id)
+		);
+	`
+
+	createAlarmRulesTable = `
+		CREATE TABLE IF NOT EXISTS alarm_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name VARCHAR(100) NOT NULL,
+			source TEXT NOT NULL,            -- <type>/<deviceId>/<datapointId>, z.B. s7/3/10030001
+			rule_type VARCHAR(20) NOT NULL,  -- high, low, rate_of_change, stale, boolean
+			setpoint REAL,                   -- Grenzwert (high/low), max. Änderung pro Sekunde (rate_of_change)
+			hysteresis REAL DEFAULT 0,
+			delay_seconds INTEGER DEFAULT 0,
+			stale_seconds INTEGER DEFAULT 0,
+			expected_state BOOLEAN DEFAULT 1, -- boolean: Alarm, wenn der Wert diesem Zustand entspricht
+			severity INTEGER DEFAULT 500,
+			message TEXT,
+			enabled BOOLEAN DEFAULT 1,
+			shelved_until TEXT,
+			created_at TEXT NOT NULL,
+			updated_at TEXT NOT NULL
+		);
+	`
+
+	createAlarmsTable = `
+		CREATE TABLE IF NOT EXISTS alarms (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			rule_id INTEGER NOT NULL,
+			source TEXT NOT NULL,
+			state VARCHAR(20) NOT NULL,      -- active, acknowledged, shelved, cleared
+			severity INTEGER,
+			message TEXT,
+			value TEXT,
+			activated_at TEXT NOT NULL,
+			acknowledged_at TEXT,
+			acknowledged_by TEXT,
+			ack_comment TEXT,
+			shelved_until TEXT,
+			cleared_at TEXT,
+			FOREIGN KEY (rule_id) REFERENCES alarm_rules(id)
+		);
+	`
+
+	createNotificationChannelsTable = `
+		CREATE TABLE IF NOT EXISTS notification_channels (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name VARCHAR(100) NOT NULL,
+			channel_type VARCHAR(20) NOT NULL, -- smtp, webhook, teams, slack
+			config TEXT NOT NULL,              -- JSON, abhängig vom Typ
+			rate_limit_per_hour INTEGER DEFAULT 0,
+			dedup_seconds INTEGER DEFAULT 300,
+			enabled BOOLEAN DEFAULT 1,
+			created_at TEXT NOT NULL,
+			updated_at TEXT NOT NULL
+		);
+	`
+
+	createNotificationRulesTable = `
+		CREATE TABLE IF NOT EXISTS notification_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			channel_id INTEGER NOT NULL,
+			events TEXT,                       -- Kommagetrennt: device_offline, device_online, alarm_active, alarm_cleared (leer = alle)
+			device_id INTEGER,                 -- NULL = alle Geräte
+			min_severity INTEGER DEFAULT 0,
+			time_from VARCHAR(5),              -- HH:MM, NULL = ganztägig
+			time_to VARCHAR(5),
+			weekdays VARCHAR(20),              -- Kommagetrennt 0 (So) bis 6 (Sa), leer = alle
+			enabled BOOLEAN DEFAULT 1,
+			FOREIGN KEY (channel_id) REFERENCES notification_channels(id) ON DELETE CASCADE
+		);
+	`
+
+	createNotificationLogTable = `
+		CREATE TABLE IF NOT EXISTS notification_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			channel_id INTEGER,
+			channel_name VARCHAR(100),
+			event VARCHAR(30) NOT NULL,
+			title TEXT,
+			status VARCHAR(20) NOT NULL,       -- sent, failed, rate_limited
+			error TEXT,
+			created_at TEXT NOT NULL
+		);
+	`
+
 	createSystemSettingsTable = `
 		CREATE TABLE IF NOT EXISTS system_settings (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -136,8 +299,84 @@ const (
 			updated_at TEXT NOT NULL
 		);
 	`
+
+	createTLSCertificatesTable = `
+		CREATE TABLE IF NOT EXISTS tls_certificates (
+			name VARCHAR(50) PRIMARY KEY,      -- mqtt_server, mqtt_client_ca
+			certificate TEXT NOT NULL,         -- PEM
+			private_key TEXT,                  -- PEM (nur Serverzertifikat)
+			source VARCHAR(20) NOT NULL,       -- generated, uploaded
+			updated_at TEXT NOT NULL
+		);
+	`
+
+	createBrokerStorageTable = `
+		CREATE TABLE IF NOT EXISTS broker_storage (
+			key TEXT PRIMARY KEY,              -- z.B. RET_<topic>, CL_<client>
+			kind VARCHAR(5) NOT NULL,          -- CL, SUB, IFM, RET, SYS
+			client TEXT NOT NULL DEFAULT '',   -- Client-ID (leer bei RET/SYS)
+			value BLOB NOT NULL,               -- JSON (mochi storage-Format)
+			updated_at INTEGER NOT NULL        -- Unix-Zeit, bei Clients zuletzt gesehen
+		);
+		CREATE INDEX IF NOT EXISTS idx_broker_storage_kind ON broker_storage(kind, client);
+	`
+
+	createBrokerListenersTable = `
+		CREATE TABLE IF NOT EXISTS broker_listeners (
+			id VARCHAR(50) PRIMARY KEY,
+			type VARCHAR(20) NOT NULL,                     -- tcp, websocket, http-stats, unix
+			address TEXT NOT NULL,                         -- ":5000" bzw. Pfad des Unix-Sockets
+			tls_profile VARCHAR(20) NOT NULL DEFAULT 'none', -- none, tls, mtls-optional, mtls
+			enabled BOOLEAN NOT NULL DEFAULT 1
+		);
+	`
 )
 
+// columnMigrations enthält Spalten, die nach der ersten Version hinzugekommen sind.
+// CREATE TABLE IF NOT EXISTS legt sie bei bestehenden Datenbanken nicht an.
+var columnMigrations = []struct {
+	table, column, definition string
+}{
+	{"devices", "history_backfill", "BOOLEAN DEFAULT 0"},
+	{"devices", "event_subscription", "BOOLEAN DEFAULT 0"},
+	{"devices", "event_fields", "TEXT"},
+	{"s7_datapoints", "publish_settings", "TEXT"},
+	{"opcua_datanodes", "publish_settings", "TEXT"},
+	{"s7_datapoints", "scan_group_id", "INTEGER DEFAULT 0"},
+	{"opcua_datanodes", "scan_group_id", "INTEGER DEFAULT 0"},
+	{"devices", "status_message", "TEXT"},
+	{"devices", "last_seen", "TEXT"},
+	{"devices", "last_successful_read", "TEXT"},
+}
+
+// ensureColumn fügt eine Spalte hinzu, falls sie in der Tabelle noch fehlt
+func ensureColumn(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
 // InitDB initialisiert die SQLite-Datenbank mit einem übergebenen Pfad
 func InitDB(dbPath string) (*sql.DB, error) {
 	// Überprüfen, ob die Datenbankdatei existiert
@@ -188,9 +427,22 @@ func InitDB(dbPath string) (*sql.DB, error) {
 		createDevicesTable,
 		createS7DatapointsTable,
 		createOPCUADatanodesTable,
+		createVirtualDatapointsTable,
+		createMqttMappingsTable,
+		createScanGroupsTable,
+		createTriggerRulesTable,
 		createImagesTable,
 		createImageCaptureProcessesTable,
 		createSystemSettingsTable,
+		createEventsTable,
+		createAlarmRulesTable,
+		createAlarmsTable,
+		createNotificationChannelsTable,
+		createNotificationRulesTable,
+		createNotificationLogTable,
+		createTLSCertificatesTable,
+		createBrokerStorageTable,
+		createBrokerListenersTable,
 	}
 
 	// Tabellen erstellen
@@ -200,6 +452,13 @@ func InitDB(dbPath string) (*sql.DB, error) {
 		}
 	}
 
+	// Neue Spalten in bestehenden Datenbanken nachziehen
+	for _, m := range columnMigrations {
+		if err := ensureColumn(db, m.table, m.column, m.definition); err != nil {
+			return nil, err
+		}
+	}
+
 	// Check if there are any users in the database
 	var countUsers int
 	db.QueryRow("SELECT COUNT(*) FROM users").Scan(&countUsers)
@@ -212,27 +471,26 @@ func InitDB(dbPath string) (*sql.DB, error) {
         `, "admin", "password", "Admin", "Admin Street", "Admin Corp.", "admin@admin.com")
 	}
 
-	// Check if there are any system settings in the database
-	var countSettings int
-	db.QueryRow("SELECT COUNT(*) FROM system_settings").Scan(&countSettings)
+	// Create missing default settings (also settings added in later versions)
+	now := time.Now().Format("2006-01-02 15:04:05")
 
-	// If no settings are found, create default settings
-	if countSettings == 0 {
-		now := time.Now().Format("2006-01-02 15:04:05")
-
-		defaultSettings := []struct {
-			key, value, settingType, description, category string
-		}{
-			{"node_red_url", "http://node-red:1880", "string", "Node-RED Web-Interface URL", "integration"},
-			{"influxdb_url", "http://influxdb:8086", "string", "InfluxDB Server URL", "integration"},
-		}
+	defaultSettings := []struct {
+		key, value, settingType, description, category string
+	}{
+		{"node_red_url", "http://node-red:1880", "string", "Node-RED Web-Interface URL", "integration"},
+		{"influxdb_url", "http://influxdb:8086", "string", "InfluxDB Server URL", "integration"},
+		{"influxdb_manage_buckets", "false", "boolean", "InfluxDB-Buckets und Downsampling-Tasks vom Gateway verwalten", "influxdb"},
+		{"influxdb_retention_days", "", "integer", "Aufbewahrung der Rohdaten in Tagen (0 = unbegrenzt, leer = bestehenden Bucket nicht ändern, neue mit 7 Tagen)", "influxdb"},
+		{"influxdb_downsampling", "false", "boolean", "Werte zusätzlich in 1-Minuten- und 1-Stunden-Buckets zusammenfassen", "influxdb"},
+		{"influxdb_downsampling_1m_days", "90", "integer", "Aufbewahrung der 1-Minuten-Werte in Tagen (0 = unbegrenzt)", "influxdb"},
+		{"influxdb_downsampling_1h_days", "1825", "integer", "Aufbewahrung der 1-Stunden-Werte in Tagen (0 = unbegrenzt)", "influxdb"},
+	}
 
-		for _, setting := range defaultSettings {
-			db.Exec(`
-				INSERT INTO system_settings (setting_key, setting_value, setting_type, description, category, created_at, updated_at)
-				VALUES (?, ?, ?, ?, ?, ?, ?)
-			`, setting.key, setting.value, setting.settingType, setting.description, setting.category, now, now)
-		}
+	for _, setting := range defaultSettings {
+		db.Exec(`
+			INSERT OR IGNORE INTO system_settings (setting_key, setting_value, setting_type, description, category, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, setting.key, setting.value, setting.settingType, setting.description, setting.category, now, now)
 	}
 
 	return db, nil
@@ -3,10 +3,13 @@ package logic
 import (
 	"context"
 	"database/sql"
+	"sync"
 	"time"
 
+	mqtt_driver "iot-gateway/driver/mqtt"
 	opcua "iot-gateway/driver/opcua"
 	s7 "iot-gateway/driver/s7"
+	virtual "iot-gateway/driver/virtual"
 
 	MQTT "github.com/mochi-mqtt/server/v2"
 	"github.com/sirupsen/logrus"
@@ -21,16 +24,22 @@ const (
 	No_Datapoints   = "4 (no datapoints)"
 	No_Connection   = "5 (no connection)"
 	Connection_Lost = "6 (connection lost)"
+	Stale           = "7 (stale)" // Verbunden, aber keine Nachrichten mehr (nur MQTT-Geräte)
 	deleted         = "9 (deleted)"
 )
 
 var (
-	opcuaStopChans    = make(map[string]chan struct{})
-	s7StopChans       = make(map[string]chan struct{})
-	opcuaDeviceStates = make(map[string]*DeviceState)
-	s7DeviceStates    = make(map[string]*DeviceState)
-	server            *MQTT.Server
-	db                *sql.DB
+	opcuaStopChans      = make(map[string]chan struct{})
+	s7StopChans         = make(map[string]chan struct{})
+	opcuaDeviceStates   = make(map[string]*DeviceState)
+	s7DeviceStates      = make(map[string]*DeviceState)
+	virtualStopChans    = make(map[string]chan struct{})
+	virtualDeviceStates = make(map[string]*DeviceState)
+	mqttStopChans       = make(map[string]chan struct{})
+	mqttDeviceStates    = make(map[string]*DeviceState)
+	server              *MQTT.Server
+	db                  *sql.DB
+	driverWg            sync.WaitGroup // Laufende Treiber-Goroutinen (für das geordnete Herunterfahren)
 )
 
 // %%%%%%%%%%%%%%%%%%%%%%%%%%%%%%% Handling-All-Driver %%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%
@@ -44,6 +53,9 @@ func StartAllDrivers(dbF *sql.DB, serverF *MQTT.Server) {
 
 	logrus.Info("DM: Starting all drivers...")
 
+	// Methodenaufrufe über MQTT (commands/opc-ua/<deviceId>/methods)
+	opcua.StartMethodCommandListener(server)
+
 	// Setze für alle Geräte initialen Status in der DB
 	if _, err := db.Exec("UPDATE devices SET status = ?", Initializing); err != nil {
 		logrus.Errorf("DM: Error updating devices to initializing: %v", err)
@@ -83,16 +95,19 @@ func StartAllDrivers(dbF *sql.DB, serverF *MQTT.Server) {
 				go StartOPCUADriver(db, deviceID)
 			case "s7":
 				go StartS7Driver(db, deviceID)
+			case "virtual":
+				go StartVirtualDriver(db, deviceID)
 			case "mqtt":
-				// MQTT-Treiber starten, falls erforderlich
+				go StartMqttDriver(db, deviceID)
 			default:
 				logrus.Warnf("DM: Unknown device type %s for device %s", deviceType, deviceName)
 			}
 		}
 	}()
 
-	// Aktualisiere in der DB den Status auf Running, sofern noch Initializing gesetzt ist
-	if _, err := db.Exec("UPDATE devices SET status = ? WHERE status = ?", Running, Initializing); err != nil {
+	// Aktualisiere in der DB den Status auf Running, sofern noch Initializing gesetzt ist.
+	// MQTT-Geräte erhalten ihren Status aus dem Verbindungszustand.
+	if _, err := db.Exec("UPDATE devices SET status = ? WHERE status = ? AND type != 'mqtt'", Running, Initializing); err != nil {
 		logrus.Errorf("DM: Error updating devices to running: %v", err)
 	}
 
@@ -110,9 +125,33 @@ func StopAllDrivers() {
 		stopS7Driver(deviceID)
 	}
 
+	for deviceID := range virtualDeviceStates {
+		stopVirtualDriver(deviceID)
+	}
+
+	for deviceID := range mqttDeviceStates {
+		stopMqttDriver(deviceID)
+	}
+
 	logrus.Info("DM: All drivers have been stopped.")
 }
 
+// WaitForDrivers wartet, bis alle gestoppten Treiber ihren letzten Zyklus beendet haben
+func WaitForDrivers(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		driverWg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func RestartAllDrivers(db *sql.DB, serverNew *MQTT.Server) {
 	logrus.Info("DM: Restarting all drivers...")
 	server = serverNew // Update the server reference
@@ -139,6 +178,10 @@ func RestartDevice(db *sql.DB, deviceID string) {
 		restartOPCUADriver(db, deviceID)
 	case "s7":
 		restartS7Driver(db, deviceID)
+	case "virtual":
+		restartVirtualDriver(db, deviceID)
+	case "mqtt":
+		restartMqttDriver(db, deviceID)
 	}
 }
 
@@ -158,6 +201,10 @@ func StopDriver(deviceID string) {
 		stopOPCUADriver(deviceID)
 	case "s7":
 		stopS7Driver(deviceID)
+	case "virtual":
+		stopVirtualDriver(deviceID)
+	case "mqtt":
+		stopMqttDriver(deviceID)
 	}
 }
 
@@ -207,6 +254,14 @@ func StartOPCUADriver(db *sql.DB, deviceID string) {
 	}
 	opcuaConfig.DataNode = nodes
 
+	// Erfassungsgruppen und Trigger-Regeln laden (ohne Gruppen gilt die Zykluszeit des Geräts)
+	if opcuaConfig.ScanGroups, err = readScanGroups(db, deviceID); err != nil {
+		logrus.Warnf("%v", err)
+	}
+	if opcuaConfig.Triggers, err = readTriggerRules(db, deviceID); err != nil {
+		logrus.Warnf("%v", err)
+	}
+
 	// Verbindungstest vor dem Start des Treibers (nur wenn der Treiber nicht bereits läuft), solange bis die Verbindung hergestellt ist
 	if connected := opcua.TestConnection(opcuaConfig.Address); connected {
 		state.status = No_Connection
@@ -222,7 +277,9 @@ func StartOPCUADriver(db *sql.DB, deviceID string) {
 	opcuaStopChans[deviceID] = stopChan
 
 	// Starte den OPC-UA-Treiber in einer separaten Goroutine mit genauer Fehlerbehandlung
+	driverWg.Add(1)
 	go func() {
+		defer driverWg.Done()
 		if err := opcua.Run(opcuaConfig, db, stopChan, server); err != nil {
 			st := getOrCreateDeviceState(deviceID, opcuaDeviceStates)
 			st.mu.Lock()
@@ -327,12 +384,22 @@ func StartS7Driver(db *sql.DB, deviceID string) {
 	}
 	s7Config.Datapoint = datapoints
 
+	// Erfassungsgruppen und Trigger-Regeln laden (ohne Gruppen gilt die Zykluszeit des Geräts)
+	if s7Config.ScanGroups, err = readScanGroups(db, deviceID); err != nil {
+		logrus.Warnf("%v", err)
+	}
+	if s7Config.Triggers, err = readTriggerRules(db, deviceID); err != nil {
+		logrus.Warnf("%v", err)
+	}
+
 	// 3. Starte den S7-Treiber
 	stopChan := make(chan struct{})
 	s7StopChans[deviceID] = stopChan
 
 	// Starte den S7-Treiber in einer separaten Goroutine
+	driverWg.Add(1)
 	go func(config opcua.DeviceConfig) {
+		defer driverWg.Done()
 		if err := s7.Run(config, db, stopChan, server); err != nil {
 			state := getOrCreateDeviceState(deviceID, s7DeviceStates)
 			state.mu.Lock()
@@ -375,3 +442,234 @@ func restartS7Driver(db *sql.DB, deviceID string) {
 	time.Sleep(2000 * time.Millisecond)
 	go StartS7Driver(db, deviceID)
 }
+
+// %%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%% Virtual-Part %%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%
+
+// StartVirtualDriver startet die Berechnung der virtuellen Datenpunkte eines Geräts
+func StartVirtualDriver(db *sql.DB, deviceID string) {
+	state := getOrCreateDeviceState(deviceID, virtualDeviceStates)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	state.status = Initializing
+	publishDeviceState(server, "virtual", deviceID, state.status)
+
+	config, err := readVirtualDeviceConfig(db, deviceID)
+	if err != nil {
+		state.status = Error
+		logrus.Errorf("%v", err)
+		publishDeviceState(server, "virtual", deviceID, state.status)
+		return
+	}
+
+	datapoints, err := readVirtualDatapoints(db, deviceID)
+	if err != nil {
+		state.status = Error
+		logrus.Errorf("%v", err)
+		publishDeviceState(server, "virtual", deviceID, state.status)
+		return
+	}
+	if len(datapoints) == 0 {
+		state.status = No_Datapoints
+		logrus.Errorf("DM: No virtual datapoints found for device %s", config.Name)
+		publishDeviceState(server, "virtual", deviceID, state.status)
+		return
+	}
+	config.Datapoint = datapoints
+
+	stopChan := make(chan struct{})
+	virtualStopChans[deviceID] = stopChan
+
+	driverWg.Add(1)
+	go func(config opcua.DeviceConfig) {
+		defer driverWg.Done()
+		if err := virtual.Run(config, db, stopChan, server); err != nil {
+			state := getOrCreateDeviceState(deviceID, virtualDeviceStates)
+			state.mu.Lock()
+			defer state.mu.Unlock()
+			state.running = false
+			state.status = Error
+			publishDeviceState(server, "virtual", deviceID, state.status)
+			logrus.Errorf("DM: Error running virtual driver for device %s: %v", config.Name, err)
+		}
+	}(config)
+
+	state.running = true
+	state.status = Running
+	logrus.Infof("DM: Virtual driver started for device %s.", config.Name)
+}
+
+// stopVirtualDriver beendet die Berechnung eines virtuellen Geräts
+func stopVirtualDriver(deviceID string) {
+	state := getOrCreateDeviceState(deviceID, virtualDeviceStates)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if !state.running {
+		logrus.Warnf("DM: Virtual driver for device %s is not running.", deviceID)
+		return
+	}
+
+	if stopChan, ok := virtualStopChans[deviceID]; ok && stopChan != nil {
+		close(stopChan)
+		delete(virtualStopChans, deviceID)
+	}
+	state.running = false
+	state.status = Stopped
+	publishDeviceState(server, "virtual", deviceID, state.status)
+	logrus.Infof("DM: Stopped virtual driver for device %s.", deviceID)
+}
+
+// restartVirtualDriver startet ein virtuelles Gerät neu, z.B. nach Änderung der Ausdrücke
+func restartVirtualDriver(db *sql.DB, deviceID string) {
+	logrus.Infof("DM: Restarting virtual driver for device %s...", deviceID)
+	stopVirtualDriver(deviceID)
+	time.Sleep(500 * time.Millisecond)
+	go StartVirtualDriver(db, deviceID)
+}
+
+// %%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%% MQTT-Part %%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%
+
+// mqttStatus ist der Zustand eines MQTT-Geräts aus Verbindung (vom Broker gemeldet) und Zuordnungsfehlern (Treiber)
+type mqttStatus struct {
+	presence     string // No_Connection, Running, Connection_Lost oder Stale
+	presenceInfo string // z.B. "no messages for 5m0s"
+	mappingError string
+}
+
+var (
+	mqttStatusMu sync.Mutex
+	mqttStatuses = make(map[string]*mqttStatus)
+)
+
+// mqttStatusOf liefert den Zustand eines MQTT-Geräts. Aufruf nur unter mqttStatusMu.
+func mqttStatusOf(deviceID string) *mqttStatus {
+	st, ok := mqttStatuses[deviceID]
+	if !ok {
+		st = &mqttStatus{presence: No_Connection}
+		mqttStatuses[deviceID] = st
+	}
+	return st
+}
+
+// SetMqttDevicePresence übernimmt den vom Broker gemeldeten Verbindungszustand eines MQTT-Geräts
+// (No_Connection, Running, Connection_Lost oder Stale) und veröffentlicht den Gerätestatus.
+func SetMqttDevicePresence(deviceID, presence, info string) {
+	mqttStatusMu.Lock()
+	defer mqttStatusMu.Unlock()
+	st := mqttStatusOf(deviceID)
+	st.presence, st.presenceInfo = presence, info
+	publishMqttDeviceState(deviceID, st)
+}
+
+// setMqttMappingError übernimmt die Zuordnungsfehler eines MQTT-Geräts (leer = keine Fehler)
+func setMqttMappingError(deviceID, mappingError string) {
+	mqttStatusMu.Lock()
+	defer mqttStatusMu.Unlock()
+	st := mqttStatusOf(deviceID)
+	st.mappingError = mappingError
+	publishMqttDeviceState(deviceID, st)
+}
+
+// publishMqttDeviceState veröffentlicht den Status eines MQTT-Geräts. Zuordnungsfehler werden nur bei
+// verbundenem Gerät gemeldet, sonst hat der Verbindungszustand Vorrang. Aufruf nur unter mqttStatusMu.
+func publishMqttDeviceState(deviceID string, st *mqttStatus) {
+	if server == nil || db == nil {
+		// Treiber noch nicht gestartet, StartMqttDriver veröffentlicht den Zustand
+		return
+	}
+	status, message := st.presence, st.presenceInfo
+	if (st.presence == Running || st.presence == Stale) && st.mappingError != "" {
+		status, message = Error, st.mappingError
+	}
+
+	server.Publish("driver/states/mqtt/"+deviceID, []byte(status), true, 2)
+	if _, err := db.Exec("UPDATE devices SET status = ?, status_message = ? WHERE id = ?", status, sql.NullString{String: message, Valid: message != ""}, deviceID); err != nil {
+		logrus.Errorf("Error updating device state in the database: %v", err)
+	}
+}
+
+// StartMqttDriver startet die Zuordnung der Payloads eines MQTT-Geräts zu Datenpunkten.
+// Geräte ohne Zuordnungen veröffentlichen ihre Daten weiterhin nur unter data/mqtt/<name>/.
+// Der Status ergibt sich aus dem Verbindungszustand, den der Broker über SetMqttDevicePresence meldet.
+func StartMqttDriver(db *sql.DB, deviceID string) {
+	state := getOrCreateDeviceState(deviceID, mqttDeviceStates)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	state.status = Initializing
+	publishDeviceState(server, "mqtt", deviceID, state.status)
+
+	config, err := readMqttDeviceConfig(db, deviceID)
+	if err != nil {
+		state.status = Error
+		logrus.Errorf("%v", err)
+		publishDeviceState(server, "mqtt", deviceID, state.status)
+		return
+	}
+
+	mappings, err := readMqttMappings(db, deviceID)
+	if err != nil {
+		state.status = Error
+		logrus.Errorf("%v", err)
+		publishDeviceState(server, "mqtt", deviceID, state.status)
+		return
+	}
+	setMqttMappingError(deviceID, "")
+	state.status = Running
+	if len(mappings) == 0 {
+		logrus.Infof("DM: MQTT device %s has no mappings.", config.Name)
+		return
+	}
+	config.Datapoint = mappings
+
+	stopChan := make(chan struct{})
+	mqttStopChans[deviceID] = stopChan
+
+	driverWg.Add(1)
+	go func(config opcua.DeviceConfig) {
+		defer driverWg.Done()
+		report := func(mappingError string) { setMqttMappingError(deviceID, mappingError) }
+		if err := mqtt_driver.Run(config, stopChan, server, report); err != nil {
+			state := getOrCreateDeviceState(deviceID, mqttDeviceStates)
+			state.mu.Lock()
+			defer state.mu.Unlock()
+			state.running = false
+			state.status = Error
+			setMqttMappingError(deviceID, err.Error())
+			logrus.Errorf("DM: Error running MQTT driver for device %s: %v", config.Name, err)
+		}
+	}(config)
+
+	state.running = true
+	logrus.Infof("DM: MQTT driver started for device %s.", config.Name)
+}
+
+// stopMqttDriver beendet die Zuordnung eines MQTT-Geräts
+func stopMqttDriver(deviceID string) {
+	state := getOrCreateDeviceState(deviceID, mqttDeviceStates)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if !state.running {
+		logrus.Debugf("DM: MQTT driver for device %s is not running.", deviceID)
+		return
+	}
+
+	if stopChan, ok := mqttStopChans[deviceID]; ok && stopChan != nil {
+		close(stopChan)
+		delete(mqttStopChans, deviceID)
+	}
+	state.running = false
+	state.status = Stopped
+	publishDeviceState(server, "mqtt", deviceID, state.status)
+	logrus.Infof("DM: Stopped MQTT driver for device %s.", deviceID)
+}
+
+// restartMqttDriver startet ein MQTT-Gerät neu, z.B. nach Änderung der Zuordnungen
+func restartMqttDriver(db *sql.DB, deviceID string) {
+	logrus.Infof("DM: Restarting MQTT driver for device %s...", deviceID)
+	stopMqttDriver(deviceID)
+	time.Sleep(500 * time.Millisecond)
+	go StartMqttDriver(db, deviceID)
+}
@@ -192,15 +192,20 @@ func readDeviceConfig(db *sql.DB, deviceID string) (opcua.DeviceConfig, error) {
 	var config opcua.DeviceConfig
 	var deviceAddress, deviceName string
 	var acquisitionTime int
-	query := `SELECT name, address, acquisition_time FROM devices WHERE id = ?`
-	if err := db.QueryRow(query, deviceID).Scan(&deviceName, &deviceAddress, &acquisitionTime); err != nil {
+	var historyBackfill, eventSubscription sql.NullBool
+	var eventFields sql.NullString
+	query := `SELECT name, address, acquisition_time, history_backfill, event_subscription, event_fields FROM devices WHERE id = ?`
+	if err := db.QueryRow(query, deviceID).Scan(&deviceName, &deviceAddress, &acquisitionTime, &historyBackfill, &eventSubscription, &eventFields); err != nil {
 		return config, fmt.Errorf("DM: Error querying device config: %v", err)
 	}
 	config = opcua.DeviceConfig{
-		ID:              deviceID,
-		Name:            deviceName,
-		Address:         deviceAddress,
-		AcquisitionTime: acquisitionTime,
+		ID:                deviceID,
+		Name:              deviceName,
+		Address:           deviceAddress,
+		AcquisitionTime:   acquisitionTime,
+		HistoryBackfill:   historyBackfill.Valid && historyBackfill.Bool,
+		EventSubscription: eventSubscription.Valid && eventSubscription.Bool,
+		EventFields:       eventFields.String,
 	}
 	return config, nil
 }
@@ -224,7 +229,7 @@ func readSecurityOptions(db *sql.DB, deviceID string) (security struct {
 
 // Hilfsfunktion: Lese alle OPC-UA-Knoten eines Gerätes
 func readOPCUANodes(db *sql.DB, deviceID string) ([]opcua.DataNode, error) {
-	nodeQuery := `SELECT datapointId, name, node_identifier FROM opcua_datanodes WHERE device_id = ?`
+	nodeQuery := `SELECT datapointId, name, node_identifier, COALESCE(publish_settings, ''), COALESCE(scan_group_id, 0) FROM opcua_datanodes WHERE device_id = ?`
 	rows, err := db.Query(nodeQuery, deviceID)
 	if err != nil {
 		return nil, fmt.Errorf("DM: Error querying OPC-UA nodes: %v", err)
@@ -233,14 +238,17 @@ func readOPCUANodes(db *sql.DB, deviceID string) ([]opcua.DataNode, error) {
 
 	var nodes []opcua.DataNode
 	for rows.Next() {
-		var datapointId, nodeName, nodeIdentifier string
-		if err := rows.Scan(&datapointId, &nodeName, &nodeIdentifier); err != nil {
+		var datapointId, nodeName, nodeIdentifier, publishSettings string
+		var scanGroup int64
+		if err := rows.Scan(&datapointId, &nodeName, &nodeIdentifier, &publishSettings, &scanGroup); err != nil {
 			return nil, fmt.Errorf("DM: Error scanning node data: %v", err)
 		}
 		nodes = append(nodes, opcua.DataNode{
-			ID:   datapointId,
-			Name: nodeName,
-			Node: nodeIdentifier,
+			ID:        datapointId,
+			Name:      nodeName,
+			Node:      nodeIdentifier,
+			Publish:   opcua.ParsePublishSettings(publishSettings),
+			ScanGroup: scanGroup,
 		})
 	}
 	return nodes, nil
@@ -295,7 +303,7 @@ func readS7DeviceConfig(db *sql.DB, deviceID string) (opcua.DeviceConfig, error)
 
 // Liest die S7-Datenpunkte eines Gerätes aus der s7_datapoints-Tabelle
 func readS7Datapoints(db *sql.DB, deviceID string) ([]opcua.Datapoint, error) {
-	query := `SELECT datapointId, name, datatype, address FROM s7_datapoints WHERE device_id = ?`
+	query := `SELECT datapointId, name, datatype, address, COALESCE(publish_settings, ''), COALESCE(scan_group_id, 0) FROM s7_datapoints WHERE device_id = ?`
 	rows, err := db.Query(query, deviceID)
 	if err != nil {
 		return nil, fmt.Errorf("DM: Error querying S7 datapoints: %v", err)
@@ -305,9 +313,11 @@ func readS7Datapoints(db *sql.DB, deviceID string) ([]opcua.Datapoint, error) {
 	var datapoints []opcua.Datapoint
 	for rows.Next() {
 		var dp opcua.Datapoint
-		if err := rows.Scan(&dp.ID, &dp.Name, &dp.Datatype, &dp.Address); err != nil {
+		var publishSettings string
+		if err := rows.Scan(&dp.ID, &dp.Name, &dp.Datatype, &dp.Address, &publishSettings, &dp.ScanGroup); err != nil {
 			return nil, fmt.Errorf("DM: Error scanning S7 datapoint: %v", err)
 		}
+		dp.Publish = opcua.ParsePublishSettings(publishSettings)
 		datapoints = append(datapoints, dp)
 	}
 	if err := rows.Err(); err != nil {
@@ -315,3 +325,123 @@ func readS7Datapoints(db *sql.DB, deviceID string) ([]opcua.Datapoint, error) {
 	}
 	return datapoints, nil
 }
+
+// Liest die Erfassungsgruppen eines Gerätes (S7 und OPC-UA)
+func readScanGroups(db *sql.DB, deviceID string) ([]opcua.ScanGroup, error) {
+	rows, err := db.Query(`SELECT group_id, name, interval_ms FROM scan_groups WHERE device_id = ? ORDER BY group_id`, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("DM: Error querying scan groups: %v", err)
+	}
+	defer rows.Close()
+
+	var groups []opcua.ScanGroup
+	for rows.Next() {
+		var g opcua.ScanGroup
+		if err := rows.Scan(&g.ID, &g.Name, &g.Interval); err != nil {
+			return nil, fmt.Errorf("DM: Error scanning scan group: %v", err)
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// Liest die aktiven Trigger-Regeln eines Gerätes (S7 und OPC-UA)
+func readTriggerRules(db *sql.DB, deviceID string) ([]opcua.TriggerRule, error) {
+	rows, err := db.Query(`SELECT id, device_id, name, trigger_datapoint, mode, datapoints, COALESCE(ack_address, ''), enabled
+		FROM trigger_rules WHERE device_id = ? AND enabled = 1 ORDER BY id`, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("DM: Error querying trigger rules: %v", err)
+	}
+	defer rows.Close()
+
+	var rules []opcua.TriggerRule
+	for rows.Next() {
+		var r opcua.TriggerRule
+		var datapoints string
+		if err := rows.Scan(&r.ID, &r.DeviceID, &r.Name, &r.Trigger, &r.Mode, &datapoints, &r.AckAddress, &r.Enabled); err != nil {
+			return nil, fmt.Errorf("DM: Error scanning trigger rule: %v", err)
+		}
+		for _, id := range strings.Split(datapoints, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				r.Datapoints = append(r.Datapoints, id)
+			}
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// %%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%% Virtual-Part %%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%
+
+// Liest die Konfiguration eines virtuellen Gerätes
+func readVirtualDeviceConfig(db *sql.DB, deviceID string) (opcua.DeviceConfig, error) {
+	var config opcua.DeviceConfig
+	query := `SELECT name, acquisition_time FROM devices WHERE id = ?`
+	if err := db.QueryRow(query, deviceID).Scan(&config.Name, &config.AcquisitionTime); err != nil {
+		return config, fmt.Errorf("DM: Error querying virtual device config: %v", err)
+	}
+	config.ID = deviceID
+	config.Type = "virtual"
+	return config, nil
+}
+
+// Liest die virtuellen Datenpunkte eines Gerätes. Der Ausdruck steht im Feld Address.
+func readVirtualDatapoints(db *sql.DB, deviceID string) ([]opcua.Datapoint, error) {
+	query := `SELECT datapointId, name, expression FROM virtual_datapoints WHERE device_id = ?`
+	rows, err := db.Query(query, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("DM: Error querying virtual datapoints: %v", err)
+	}
+	defer rows.Close()
+
+	var datapoints []opcua.Datapoint
+	for rows.Next() {
+		var dp opcua.Datapoint
+		if err := rows.Scan(&dp.ID, &dp.Name, &dp.Address); err != nil {
+			return nil, fmt.Errorf("DM: Error scanning virtual datapoint: %v", err)
+		}
+		datapoints = append(datapoints, dp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DM: Error iterating virtual datapoints: %v", err)
+	}
+	return datapoints, nil
+}
+
+// %%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%% MQTT-Part %%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%%
+
+// Liest die Konfiguration eines MQTT-Gerätes
+func readMqttDeviceConfig(db *sql.DB, deviceID string) (opcua.DeviceConfig, error) {
+	var config opcua.DeviceConfig
+	if err := db.QueryRow(`SELECT name FROM devices WHERE id = ?`, deviceID).Scan(&config.Name); err != nil {
+		return config, fmt.Errorf("DM: Error querying MQTT device config: %v", err)
+	}
+	config.ID = deviceID
+	config.Type = "mqtt"
+	return config, nil
+}
+
+// Liest die Zuordnungen eines MQTT-Gerätes. Der Feldausdruck steht im Feld Address.
+func readMqttMappings(db *sql.DB, deviceID string) ([]opcua.Datapoint, error) {
+	query := `SELECT datapointId, name, topic, expression, datatype, COALESCE(publish_settings, '') FROM mqtt_mappings WHERE device_id = ?`
+	rows, err := db.Query(query, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("DM: Error querying MQTT mappings: %v", err)
+	}
+	defer rows.Close()
+
+	var datapoints []opcua.Datapoint
+	for rows.Next() {
+		var dp opcua.Datapoint
+		var publishSettings string
+		if err := rows.Scan(&dp.ID, &dp.Name, &dp.Topic, &dp.Address, &dp.Datatype, &publishSettings); err != nil {
+			return nil, fmt.Errorf("DM: Error scanning MQTT mapping: %v", err)
+		}
+		dp.Publish = opcua.ParsePublishSettings(publishSettings)
+		datapoints = append(datapoints, dp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DM: Error iterating MQTT mappings: %v", err)
+	}
+	return datapoints, nil
+}
@@ -1,12 +1,21 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"time"
+
+	MQTT "github.com/mochi-mqtt/server/v2"
 	"github.com/sirupsen/logrus"
 
+	alarms "iot-gateway/alarms"
 	dataforwarding "iot-gateway/data-forwarding"
 	opcua_driver "iot-gateway/driver/opcua"
+	historian "iot-gateway/historian"
+	"iot-gateway/lifecycle"
 	logic "iot-gateway/logic"
 	mqtt_broker "iot-gateway/mqtt_broker"
+	notifications "iot-gateway/notifications"
 	webui "iot-gateway/webui"
 )
 
@@ -43,39 +52,140 @@ func main() {
 	// Log-System initialisieren
 	go logic.GatewayLogs()
 
+	var (
+		db     *sql.DB
+		server *MQTT.Server
+	)
+
+	// Komponenten in Startreihenfolge, gestoppt wird in umgekehrter Reihenfolge.
+	// Die Treiber stoppen vor dem InfluxDB-Writer, damit dessen finaler Flush alle Werte enthält.
+	gateway := lifecycle.New()
+
 	// Initialisiere die SQLite-Datenbank mit dem übergebenen Pfad
-	db, _ := logic.InitDB(dbPath)
-	defer db.Close()
+	gateway.Add(lifecycle.Component{
+		Name: "database",
+		Start: func(ctx context.Context) error {
+			var err error
+			db, err = logic.InitDB(dbPath)
+			return err
+		},
+		Stop: func(ctx context.Context) error {
+			return db.Close()
+		},
+		StopTimeout: 5 * time.Second,
+	})
 
 	// Initialisiere OPC-UA Zertifikate (proaktiv erstellen)
 	// initializeOPCUACertificates()
 
-	// Start MQTT-Broker
-	server := mqtt_broker.StartBroker(db)
-	logrus.Info("MAIN: Broker started.")
+	// MQTT-Broker
+	gateway.Add(lifecycle.Component{
+		Name: "MQTT broker",
+		Start: func(ctx context.Context) error {
+			server = mqtt_broker.StartBroker(db)
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			mqtt_broker.StopBroker()
+			return nil
+		},
+		StopTimeout: 5 * time.Second,
+	})
+
+	// InfluxDB-Writer bzw. eingebetteter Historian (HISTORY_BACKEND)
+	gateway.Add(lifecycle.Component{
+		Name: "InfluxDB writer",
+		Start: func(ctx context.Context) error {
+			if historian.Enabled() {
+				return nil
+			}
+			dataforwarding.StartInfluxDBWriter(db, server)
+			return nil
+		},
+		Stop:        dataforwarding.StopInfluxDBWriter,
+		StopTimeout: 30 * time.Second,
+		Restartable: true,
+	})
+
+	gateway.Add(lifecycle.Component{
+		Name: "historian",
+		Start: func(ctx context.Context) error {
+			return historian.Start(server)
+		},
+		Stop:        historian.Stop,
+		StopTimeout: 10 * time.Second,
+		Restartable: true,
+	})
+
+	// Alarm-Engine
+	gateway.Add(lifecycle.Component{
+		Name: "alarm engine",
+		Start: func(ctx context.Context) error {
+			alarms.StartAlarmEngine(db, server)
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			alarms.StopAlarmEngine()
+			return nil
+		},
+	})
+
+	// Benachrichtigungen
+	gateway.Add(lifecycle.Component{
+		Name: "notifier",
+		Start: func(ctx context.Context) error {
+			notifications.StartNotifier(db, server)
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			notifications.StopNotifier()
+			return nil
+		},
+	})
+
+	// Treiber
+	gateway.Add(lifecycle.Component{
+		Name: "drivers",
+		Start: func(ctx context.Context) error {
+			logic.StartAllDrivers(db, server)
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			logic.StopAllDrivers()
+			return logic.WaitForDrivers(ctx)
+		},
+		StopTimeout: 15 * time.Second,
+		Restartable: true,
+	})
+
+	// Image Capture Prozesse
+	gateway.Add(lifecycle.Component{
+		Name: "image capture",
+		Start: func(ctx context.Context) error {
+			webui.InitImageCaptureProcesses(db)
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			webui.StopAllImageCaptureProcesses(db)
+			return nil
+		},
+		Restartable: true,
+	})
 
 	// Web-UI
-	go webui.Main(db, server)
-	defer webui.StopWebUI()
-	logrus.Info("MAIN: Web-UI-server started.")
-
-	// InfluxDB-Writer
-	go dataforwarding.StartInfluxDBWriter(db, server)
-	defer dataforwarding.StopInfluxDBWriter()
-
-	// Start Driver
-	go logic.StartAllDrivers(db, server)
-	defer logic.StopAllDrivers()
-
-	// Image Capture Prozesse initialisieren
-	webui.InitImageCaptureProcesses(db)
-	logrus.Info("MAIN: Image Capture Prozesse initialisiert.")
-
-	// Cleanup-Funktion für graceful shutdown
-	defer func() {
-		webui.StopAllImageCaptureProcesses(db)
-		logrus.Info("MAIN: Image Capture Prozesse gestoppt.")
-	}()
-
-	select {}
+	gateway.Add(lifecycle.Component{
+		Name: "web UI",
+		Start: func(ctx context.Context) error {
+			go webui.Main(db, server)
+			return nil
+		},
+		Stop:        webui.StopWebUI,
+		StopTimeout: 5 * time.Second,
+	})
+	webui.SetLifecycle(gateway)
+
+	// Läuft bis SIGINT/SIGTERM
+	if err := gateway.Run(); err != nil {
+		logrus.Fatalf("MAIN: %v", err)
+	}
 }
@@ -0,0 +1,180 @@
+// Package metrics sammelt Laufzeitkennzahlen der Treiber und gibt sie im Prometheus-Textformat aus.
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DeviceMetrics sind die Kennzahlen eines Geräts seit dem Start des Gateways
+type DeviceMetrics struct {
+	Type       string
+	ID         string
+	Cycles     int64
+	CycleTime  time.Duration // Dauer des letzten Zyklus
+	ReadErrors int64
+	Reconnects int64
+	LastRead   time.Time // Letzter erfolgreicher Lesezyklus
+}
+
+var (
+	devices   = make(map[string]*DeviceMetrics) // Schlüssel: <type>/<id>
+	devicesMu sync.Mutex
+)
+
+// device liefert den Eintrag eines Geräts (Aufruf nur unter devicesMu)
+func device(deviceType, deviceID string) *DeviceMetrics {
+	key := deviceType + "/" + deviceID
+	d, ok := devices[key]
+	if !ok {
+		d = &DeviceMetrics{Type: deviceType, ID: deviceID}
+		devices[key] = d
+	}
+	return d
+}
+
+// ObserveCycle erfasst einen erfolgreichen Lesezyklus
+func ObserveCycle(deviceType, deviceID string, duration time.Duration) {
+	devicesMu.Lock()
+	d := device(deviceType, deviceID)
+	d.Cycles++
+	d.CycleTime = duration
+	d.LastRead = time.Now()
+	devicesMu.Unlock()
+}
+
+// ReadError zählt einen fehlgeschlagenen Lesezyklus
+func ReadError(deviceType, deviceID string) {
+	devicesMu.Lock()
+	device(deviceType, deviceID).ReadErrors++
+	devicesMu.Unlock()
+}
+
+// Reconnect zählt einen Verbindungsaufbau nach einem Verbindungsverlust
+func Reconnect(deviceType, deviceID string) {
+	devicesMu.Lock()
+	device(deviceType, deviceID).Reconnects++
+	devicesMu.Unlock()
+}
+
+// Devices liefert eine Kopie der Gerätekennzahlen, sortiert nach Typ und ID
+func Devices() []DeviceMetrics {
+	devicesMu.Lock()
+	list := make([]DeviceMetrics, 0, len(devices))
+	for _, d := range devices {
+		list = append(list, *d)
+	}
+	devicesMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Type != list[j].Type {
+			return list[i].Type < list[j].Type
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// Exposition baut eine Ausgabe im Prometheus-Textformat (Version 0.0.4) auf.
+// Alle Samples einer Metrik müssen direkt nacheinander geschrieben werden.
+type Exposition struct {
+	b    strings.Builder
+	last string
+}
+
+// ContentType ist der Content-Type der Ausgabe
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Gauge schreibt einen Messwert. labels sind Name/Wert-Paare.
+func (e *Exposition) Gauge(name, help string, value float64, labels ...string) {
+	e.sample(name, help, "gauge", value, labels)
+}
+
+// Counter schreibt einen Zählerstand. labels sind Name/Wert-Paare.
+func (e *Exposition) Counter(name, help string, value float64, labels ...string) {
+	e.sample(name, help, "counter", value, labels)
+}
+
+func (e *Exposition) sample(name, help, kind string, value float64, labels []string) {
+	if name != e.last {
+		fmt.Fprintf(&e.b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		e.last = name
+	}
+	e.b.WriteString(name)
+	if len(labels) > 1 {
+		e.b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				e.b.WriteByte(',')
+			}
+			fmt.Fprintf(&e.b, "%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		e.b.WriteByte('}')
+	}
+	e.b.WriteByte(' ')
+	e.b.WriteString(formatValue(value))
+	e.b.WriteByte('\n')
+}
+
+// String liefert die Ausgabe
+func (e *Exposition) String() string {
+	return e.b.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// WriteDevices schreibt die Gerätekennzahlen
+func WriteDevices(e *Exposition) {
+	list := Devices()
+	for _, d := range list {
+		e.Gauge("gateway_device_cycle_seconds", "Duration of the last acquisition cycle.", d.CycleTime.Seconds(), "type", d.Type, "device", d.ID)
+	}
+	for _, d := range list {
+		e.Counter("gateway_device_cycles_total", "Successful acquisition cycles.", float64(d.Cycles), "type", d.Type, "device", d.ID)
+	}
+	for _, d := range list {
+		e.Counter("gateway_device_read_errors_total", "Failed acquisition cycles.", float64(d.ReadErrors), "type", d.Type, "device", d.ID)
+	}
+	for _, d := range list {
+		e.Counter("gateway_device_reconnects_total", "Reconnects after a lost connection.", float64(d.Reconnects), "type", d.Type, "device", d.ID)
+	}
+	for _, d := range list {
+		var last float64
+		if !d.LastRead.IsZero() {
+			last = float64(d.LastRead.UnixNano()) / 1e9
+		}
+		e.Gauge("gateway_device_last_read_timestamp_seconds", "Unix time of the last successful read.", last, "type", d.Type, "device", d.ID)
+	}
+}
+
+// WriteRuntime schreibt Kennzahlen der Go-Laufzeit
+func WriteRuntime(e *Exposition) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	e.Gauge("go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+	e.Gauge("go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", float64(m.Alloc))
+	e.Gauge("go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", float64(m.HeapInuse))
+	e.Gauge("go_memstats_sys_bytes", "Number of bytes obtained from system.", float64(m.Sys))
+	e.Counter("go_memstats_mallocs_total", "Total number of mallocs.", float64(m.Mallocs))
+	e.Counter("go_gc_cycles_total", "Number of completed GC cycles.", float64(m.NumGC))
+	e.Counter("go_gc_pause_seconds_total", "Total GC pause time.", float64(m.PauseTotalNs)/1e9)
+}
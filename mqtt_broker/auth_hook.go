@@ -0,0 +1,254 @@
+package mqtt_broker
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+
+	MQTT "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/sirupsen/logrus"
+)
+
+// authCacheTTL begrenzt das Alter des Caches, falls Änderungen an auth/acl ohne InvalidateAuth erfolgen
+const authCacheTTL = 60 * time.Second
+
+// brokerUser ist ein Eintrag aus auth mit den zugehörigen ACL-Filtern aus acl
+type brokerUser struct {
+	password string
+	allow    bool
+	acl      map[string]auth.Access // Filter (mit %u/%c) -> Berechtigung
+}
+
+// AuthRepository liest Benutzer und ACLs aus der Datenbank und hält sie im Speicher
+type AuthRepository struct {
+	db     *sql.DB
+	mu     sync.RWMutex
+	users  map[string]*brokerUser
+	loaded time.Time
+}
+
+// NewAuthRepository erzeugt ein Repository, geladen wird beim ersten Zugriff
+func NewAuthRepository(db *sql.DB) *AuthRepository {
+	return &AuthRepository{db: db}
+}
+
+// Invalidate verwirft den Cache, der nächste Zugriff liest neu aus der Datenbank
+func (r *AuthRepository) Invalidate() {
+	r.mu.Lock()
+	r.users = nil
+	r.mu.Unlock()
+}
+
+// user liefert einen Benutzer aus dem Cache und lädt ihn bei Bedarf neu
+func (r *AuthRepository) user(username string) (*brokerUser, bool) {
+	r.mu.RLock()
+	if r.users != nil && time.Since(r.loaded) < authCacheTTL {
+		u, ok := r.users[username]
+		r.mu.RUnlock()
+		return u, ok
+	}
+	r.mu.RUnlock()
+
+	users, err := r.load()
+	if err != nil {
+		logrus.Errorf("MQTT-Broker: Failed to load auth data: %v", err)
+		return nil, false
+	}
+	u, ok := users[username]
+	return u, ok
+}
+
+// load liest auth und acl neu ein und ersetzt den Cache
+func (r *AuthRepository) load() (map[string]*brokerUser, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Ein anderer Aufrufer hat inzwischen geladen
+	if r.users != nil && time.Since(r.loaded) < authCacheTTL {
+		return r.users, nil
+	}
+
+	rows, err := r.db.Query("SELECT username, password, allow FROM auth")
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[string]*brokerUser)
+	for rows.Next() {
+		var username string
+		u := &brokerUser{acl: make(map[string]auth.Access)}
+		if err := rows.Scan(&username, &u.password, &u.allow); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		users[username] = u
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	aclRows, err := r.db.Query("SELECT username, topic, permission FROM acl")
+	if err != nil {
+		return nil, err
+	}
+	defer aclRows.Close()
+	for aclRows.Next() {
+		var username, topic string
+		var permission int
+		if err := aclRows.Scan(&username, &topic, &permission); err != nil {
+			return nil, err
+		}
+		if u, ok := users[username]; ok {
+			u.acl[topic] = auth.Access(permission)
+		}
+	}
+	if err := aclRows.Err(); err != nil {
+		return nil, err
+	}
+
+	r.users = users
+	r.loaded = time.Now()
+	return users, nil
+}
+
+// Exists meldet, ob ein Benutzer existiert und zugelassen ist
+func (r *AuthRepository) Exists(username string) bool {
+	u, ok := r.user(username)
+	return ok && u.allow
+}
+
+// Authenticate prüft Benutzername und Passwort
+func (r *AuthRepository) Authenticate(username, password string) bool {
+	u, ok := r.user(username)
+	if !ok || !u.allow {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(u.password), []byte(password)) == 1
+}
+
+// ACLOk prüft den Zugriff auf ein Topic wie das Ledger von mochi: Erlaubt, wenn ein passender Filter
+// die Berechtigung gewährt, verboten, wenn nur Filter ohne Berechtigung passen. Passt kein Filter
+// (oder hat der Benutzer keine ACL-Einträge), ist der Zugriff erlaubt.
+// In Filtern werden %u durch den Benutzernamen und %c durch die Client-ID ersetzt.
+func (r *AuthRepository) ACLOk(username, clientID, topic string, write bool) bool {
+	u, ok := r.user(username)
+	if !ok {
+		return false
+	}
+
+	matched := false
+	for filter, access := range u.acl {
+		filter = expandFilter(filter, username, clientID)
+		if !auth.RString(filter).FilterMatches(topic) {
+			continue
+		}
+		matched = true
+		if write && (access == auth.WriteOnly || access == auth.ReadWrite) {
+			return true
+		}
+		if !write && (access == auth.ReadOnly || access == auth.ReadWrite) {
+			return true
+		}
+	}
+	return !matched
+}
+
+// expandFilter ersetzt %u und %c in einem ACL-Filter. Enthält der Wert selbst Wildcards oder
+// Trennzeichen, bleibt der Platzhalter stehen und der Filter passt auf kein Topic.
+func expandFilter(filter, username, clientID string) string {
+	if !strings.Contains(filter, "%") {
+		return filter
+	}
+	if !strings.ContainsAny(username, "+#/") {
+		filter = strings.ReplaceAll(filter, "%u", username)
+	}
+	if !strings.ContainsAny(clientID, "+#/") {
+		filter = strings.ReplaceAll(filter, "%c", clientID)
+	}
+	return filter
+}
+
+// AuthHook prüft Verbindungen und Topic-Zugriffe gegen das AuthRepository.
+// Änderungen an Benutzern greifen nach InvalidateAuth ohne Neustart des Brokers.
+type AuthHook struct {
+	MQTT.HookBase
+	repo *AuthRepository
+}
+
+// ID liefert die ID des Hooks
+func (h *AuthHook) ID() string {
+	return "gateway-auth"
+}
+
+// Provides meldet die Hook-Methoden
+func (h *AuthHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		MQTT.OnConnectAuthenticate,
+		MQTT.OnACLCheck,
+	}, []byte{b})
+}
+
+// Init übernimmt das Repository
+func (h *AuthHook) Init(config any) error {
+	repo, ok := config.(*AuthRepository)
+	if !ok || repo == nil {
+		return MQTT.ErrInvalidConfigType
+	}
+	h.repo = repo
+	return nil
+}
+
+// OnConnectAuthenticate prüft Benutzername und Passwort des verbindenden Clients. Clients mit
+// geprüftem Zertifikat (mTLS) melden sich als der Benutzer aus dem CN an, ein Passwort entfällt.
+func (h *AuthHook) OnConnectAuthenticate(cl *MQTT.Client, pk packets.Packet) bool {
+	if cn, ok := peerCommonName(cl.Net.Conn); ok {
+		if !h.repo.Exists(cn) {
+			logrus.Infof("MQTT-Broker: Client %s presented certificate for unknown user %q", cl.ID, cn)
+			return false
+		}
+		cl.Properties.Username = []byte(cn)
+		return true
+	}
+
+	if h.repo.Authenticate(string(pk.Connect.Username), string(pk.Connect.Password)) {
+		return true
+	}
+	logrus.Infof("MQTT-Broker: Client %s (user %q, %s) failed authentication", cl.ID, pk.Connect.Username, cl.Net.Remote)
+	return false
+}
+
+// OnACLCheck prüft den Lese- bzw. Schreibzugriff auf ein Topic
+func (h *AuthHook) OnACLCheck(cl *MQTT.Client, topic string, write bool) bool {
+	if h.repo.ACLOk(string(cl.Properties.Username), cl.ID, topic, write) {
+		return true
+	}
+	logrus.Debugf("MQTT-Broker: Client %s (user %q) denied %s access to %s", cl.ID, cl.Properties.Username, accessName(write), topic)
+	return false
+}
+
+func accessName(write bool) string {
+	if write {
+		return "write"
+	}
+	return "read"
+}
+
+// disconnectRemovedUsers trennt Clients, deren Benutzer gelöscht oder gesperrt wurde
+func disconnectRemovedUsers(s *MQTT.Server, repo *AuthRepository) {
+	for _, cl := range s.Clients.GetAll() {
+		if cl.Net.Inline || cl.Closed() {
+			continue
+		}
+		username := string(cl.Properties.Username)
+		if repo.Exists(username) {
+			continue
+		}
+		logrus.Infof("MQTT-Broker: Disconnecting client %s, user %q was removed", cl.ID, username)
+		s.DisconnectClient(cl, packets.ErrNotAuthorized)
+	}
+}
@@ -0,0 +1,307 @@
+package mqtt_broker
+
+import (
+	"database/sql"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"iot-gateway/logic"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	MQTT "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// openTestDB legt eine leere Gateway-Datenbank im Temp-Verzeichnis an
+func openTestDB(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	db, err := logic.InitDB(path)
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	return openTestDB(t, filepath.Join(t.TempDir(), "broker.db"))
+}
+
+// addUser legt einen Broker-Benutzer mit ACL-Einträgen an
+func addUser(t *testing.T, db *sql.DB, username, password string, acl map[string]auth.Access) {
+	t.Helper()
+	if _, err := db.Exec("INSERT INTO auth (username, password, allow) VALUES (?, ?, ?)", username, password, true); err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+	for topic, access := range acl {
+		if _, err := db.Exec("INSERT INTO acl (username, topic, permission) VALUES (?, ?, ?)", username, topic, int(access)); err != nil {
+			t.Fatalf("insert acl: %v", err)
+		}
+	}
+}
+
+// testBroker startet einen Broker mit Auth-Hook und Inline-Client auf einem freien lokalen Port
+func testBroker(t *testing.T, db *sql.DB, setup func(s *MQTT.Server)) (*MQTT.Server, *AuthRepository, string) {
+	t.Helper()
+	repo := NewAuthRepository(db)
+	s := MQTT.New(&MQTT.Options{InlineClient: true})
+	if err := s.AddHook(new(AuthHook), repo); err != nil {
+		t.Fatalf("add auth hook: %v", err)
+	}
+	if setup != nil {
+		setup(s)
+	}
+	addr, _ := serveTestBroker(t, s)
+	return s, repo, addr
+}
+
+// serveTestBroker startet den Broker auf einem freien lokalen Port. Der Broker wird am Ende des
+// Tests gestoppt, stop erlaubt das vorzeitige Stoppen (z.B. für einen Neustart).
+func serveTestBroker(t *testing.T, s *MQTT.Server) (addr string, stop func()) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	if err := s.AddListener(listeners.NewNet("test", l)); err != nil {
+		t.Fatalf("add listener: %v", err)
+	}
+	if err := s.Serve(); err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+	stop = sync.OnceFunc(func() { s.Close() })
+	t.Cleanup(stop)
+	return l.Addr().String(), stop
+}
+
+// connectClient verbindet einen MQTT-Client; lost wird beim Verbindungsabbruch geschlossen
+func connectClient(t *testing.T, addr, clientID, username, password string, cleanSession bool) (paho.Client, chan struct{}, error) {
+	t.Helper()
+	lost := make(chan struct{})
+	opts := paho.NewClientOptions().
+		AddBroker("tcp://" + addr).
+		SetClientID(clientID).
+		SetUsername(username).
+		SetPassword(password).
+		SetCleanSession(cleanSession).
+		SetAutoReconnect(false).
+		SetConnectTimeout(5 * time.Second).
+		SetConnectionLostHandler(func(paho.Client, error) { close(lost) })
+	client := paho.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(5 * time.Second) {
+		t.Fatalf("connect %s: timeout", clientID)
+	}
+	if err := token.Error(); err != nil {
+		return nil, nil, err
+	}
+	t.Cleanup(func() { client.Disconnect(0) })
+	return client, lost, nil
+}
+
+func publish(t *testing.T, client paho.Client, topic string, qos byte, retained bool, payload string) {
+	t.Helper()
+	token := client.Publish(topic, qos, retained, payload)
+	if !token.WaitTimeout(5 * time.Second) {
+		t.Fatalf("publish %s: timeout", topic)
+	}
+	if err := token.Error(); err != nil {
+		t.Fatalf("publish %s: %v", topic, err)
+	}
+}
+
+func TestInvalidateAuthReloadsCache(t *testing.T) {
+	db := newTestDB(t)
+	addUser(t, db, "sensor", "old", nil)
+	s, repo, _ := testBroker(t, db, nil)
+
+	prevRepo, prevServer := authRepo, server
+	authRepo, server = repo, s
+	t.Cleanup(func() { authRepo, server = prevRepo, prevServer })
+
+	if !repo.Authenticate("sensor", "old") {
+		t.Fatal("initial password rejected")
+	}
+	if _, err := db.Exec("UPDATE auth SET password = 'new' WHERE username = 'sensor'"); err != nil {
+		t.Fatal(err)
+	}
+	addUser(t, db, "added", "secret", nil)
+
+	// Bis zur Invalidierung gilt der Cache
+	if !repo.Authenticate("sensor", "old") || repo.Authenticate("sensor", "new") {
+		t.Fatal("password change visible before InvalidateAuth")
+	}
+	if repo.Exists("added") {
+		t.Fatal("new user visible before InvalidateAuth")
+	}
+
+	InvalidateAuth()
+
+	if repo.Authenticate("sensor", "old") {
+		t.Error("old password still accepted after InvalidateAuth")
+	}
+	if !repo.Authenticate("sensor", "new") {
+		t.Error("new password rejected after InvalidateAuth")
+	}
+	if !repo.Authenticate("added", "secret") {
+		t.Error("new user rejected after InvalidateAuth")
+	}
+}
+
+func TestExpandFilter(t *testing.T) {
+	tests := []struct {
+		filter, username, clientID, want string
+	}{
+		{"devices/%u/#", "dev1", "c1", "devices/dev1/#"},
+		{"clients/%c/status", "dev1", "c1", "clients/c1/status"},
+		{"%u/%c/+", "dev1", "c1", "dev1/c1/+"},
+		{"plain/topic", "dev1", "c1", "plain/topic"},
+		// Wildcards oder Trennzeichen im Wert dürfen den Filter nicht erweitern
+		{"devices/%u/#", "#", "c1", "devices/%u/#"},
+		{"devices/%u/#", "a/b", "c1", "devices/%u/#"},
+		{"clients/%c", "dev1", "+", "clients/%c"},
+	}
+	for _, tt := range tests {
+		if got := expandFilter(tt.filter, tt.username, tt.clientID); got != tt.want {
+			t.Errorf("expandFilter(%q, %q, %q) = %q, want %q", tt.filter, tt.username, tt.clientID, got, tt.want)
+		}
+	}
+}
+
+func TestACLOkExpandsPlaceholders(t *testing.T) {
+	db := newTestDB(t)
+	addUser(t, db, "dev1", "pw", map[string]auth.Access{
+		"#":               auth.Deny,
+		"devices/%u/#":    auth.ReadWrite,
+		"clients/%c/cmd":  auth.ReadOnly,
+		"clients/%c/data": auth.WriteOnly,
+	})
+	addUser(t, db, "open", "pw", nil)
+	repo := NewAuthRepository(db)
+
+	tests := []struct {
+		username, clientID, topic string
+		write, want               bool
+	}{
+		{"dev1", "c1", "devices/dev1/temp", true, true},
+		{"dev1", "c1", "devices/dev1/temp", false, true},
+		{"dev1", "c1", "devices/dev2/temp", true, false},
+		{"dev1", "c1", "clients/c1/cmd", false, true},
+		{"dev1", "c1", "clients/c1/cmd", true, false},
+		{"dev1", "c1", "clients/c1/data", true, true},
+		{"dev1", "c2", "clients/c1/data", true, false},
+		{"dev1", "c1", "other", true, false},
+		{"open", "c1", "anything/at/all", true, true},
+		{"unknown", "c1", "devices/unknown/temp", true, false},
+	}
+	for _, tt := range tests {
+		if got := repo.ACLOk(tt.username, tt.clientID, tt.topic, tt.write); got != tt.want {
+			t.Errorf("ACLOk(%q, %q, %q, write=%v) = %v, want %v", tt.username, tt.clientID, tt.topic, tt.write, got, tt.want)
+		}
+	}
+}
+
+func TestAuthHookFiltersPublishes(t *testing.T) {
+	db := newTestDB(t)
+	addUser(t, db, "dev1", "pw", map[string]auth.Access{
+		"#":            auth.Deny,
+		"devices/%u/#": auth.ReadWrite,
+		"clients/%c":   auth.WriteOnly,
+	})
+	s, _, addr := testBroker(t, db, nil)
+
+	received := make(chan string, 10)
+	if err := s.Subscribe("#", 1, func(cl *MQTT.Client, sub packets.Subscription, pk packets.Packet) {
+		received <- pk.TopicName
+	}); err != nil {
+		t.Fatalf("inline subscribe: %v", err)
+	}
+
+	if _, _, err := connectClient(t, addr, "c1", "dev1", "wrong", true); err == nil {
+		t.Fatal("connect with wrong password succeeded")
+	}
+	client, _, err := connectClient(t, addr, "c1", "dev1", "pw", true)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+
+	// QoS 0 wird bei fehlender Berechtigung still verworfen, die Verbindung bleibt bestehen
+	publish(t, client, "devices/dev2/temp", 0, false, "1")
+	publish(t, client, "clients/c2", 0, false, "1")
+	publish(t, client, "devices/dev1/temp", 0, false, "1")
+	publish(t, client, "clients/c1", 1, false, "1")
+
+	var got []string
+	timeout := time.After(5 * time.Second)
+	for len(got) < 2 {
+		select {
+		case topic := <-received:
+			got = append(got, topic)
+		case <-timeout:
+			t.Fatalf("received %v, want [devices/dev1/temp clients/c1]", got)
+		}
+	}
+	if got[0] != "devices/dev1/temp" || got[1] != "clients/c1" {
+		t.Errorf("received %v, want [devices/dev1/temp clients/c1]", got)
+	}
+}
+
+func TestDisconnectRemovedUsers(t *testing.T) {
+	db := newTestDB(t)
+	addUser(t, db, "keep", "pw", nil)
+	addUser(t, db, "remove", "pw", nil)
+	s, repo, addr := testBroker(t, db, nil)
+
+	prevRepo, prevServer := authRepo, server
+	authRepo, server = repo, s
+	t.Cleanup(func() { authRepo, server = prevRepo, prevServer })
+
+	kept, keptLost, err := connectClient(t, addr, "kept-client", "keep", "pw", true)
+	if err != nil {
+		t.Fatalf("connect keep: %v", err)
+	}
+	_, removedLost, err := connectClient(t, addr, "removed-client", "remove", "pw", true)
+	if err != nil {
+		t.Fatalf("connect remove: %v", err)
+	}
+
+	// Inline-Client und Benutzer ohne Änderung bleiben verbunden
+	InvalidateAuth()
+	select {
+	case <-removedLost:
+		t.Fatal("client disconnected although its user still exists")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if _, err := db.Exec("DELETE FROM auth WHERE username = 'remove'"); err != nil {
+		t.Fatal(err)
+	}
+	InvalidateAuth()
+
+	select {
+	case <-removedLost:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client of removed user still connected")
+	}
+	if cl, ok := s.Clients.Get("removed-client"); ok && !cl.Closed() {
+		t.Error("broker still holds an open connection for the removed user")
+	}
+
+	select {
+	case <-keptLost:
+		t.Fatal("client of remaining user was disconnected")
+	default:
+	}
+	if !kept.IsConnectionOpen() {
+		t.Error("client of remaining user lost its connection")
+	}
+	if cl, ok := s.Clients.Get(MQTT.InlineClientId); !ok || cl.Closed() {
+		t.Error("inline client was disconnected")
+	}
+}
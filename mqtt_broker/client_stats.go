@@ -0,0 +1,317 @@
+package mqtt_broker
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	MQTT "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// clientRateInterval ist das Intervall, über das die Nachrichtenrate je Client berechnet wird
+const clientRateInterval = 5 * time.Second
+
+var ErrClientNotFound = errors.New("client not found")
+
+// clientCounters sind die Zähler eines Clients seit dem Verbindungsaufbau
+type clientCounters struct {
+	connectedAt      time.Time
+	messagesReceived atomic.Int64
+	messagesSent     atomic.Int64
+	packetsReceived  atomic.Int64
+	packetsSent      atomic.Int64
+	bytesReceived    atomic.Int64
+	bytesSent        atomic.Int64
+	lastActivity     atomic.Int64 // Unix-Zeit des letzten empfangenen Pakets
+
+	// Nur im Ticker von ClientStatsHook verwendet (unter mu)
+	lastMessages int64
+	receiveRate  float64
+}
+
+// ClientStats sind die Zähler eines Clients seit dem Verbindungsaufbau
+type ClientStats struct {
+	ConnectedAt      time.Time `json:"connectedAt"`
+	LastActivity     time.Time `json:"lastActivity"`
+	MessagesReceived int64     `json:"messagesReceived"`
+	MessagesSent     int64     `json:"messagesSent"`
+	PacketsReceived  int64     `json:"packetsReceived"`
+	PacketsSent      int64     `json:"packetsSent"`
+	BytesReceived    int64     `json:"bytesReceived"`
+	BytesSent        int64     `json:"bytesSent"`
+	ReceiveRate      float64   `json:"receiveRate"` // Empfangene PUBLISH pro Sekunde
+}
+
+// ClientStatsHook zählt Nachrichten und Bytes je Client, um z.B. Clients zu finden, die den Broker fluten
+type ClientStatsHook struct {
+	MQTT.HookBase
+	mu      sync.RWMutex
+	clients map[string]*clientCounters
+	stop    chan struct{}
+}
+
+// ID liefert die ID des Hooks
+func (h *ClientStatsHook) ID() string {
+	return "gateway-client-stats"
+}
+
+// Provides meldet die Hook-Methoden
+func (h *ClientStatsHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		MQTT.OnSessionEstablished,
+		MQTT.OnPacketRead,
+		MQTT.OnPacketSent,
+		MQTT.OnDisconnect,
+		MQTT.OnClientExpired,
+	}, []byte{b})
+}
+
+// Init startet die Berechnung der Nachrichtenraten
+func (h *ClientStatsHook) Init(config any) error {
+	h.clients = make(map[string]*clientCounters)
+	h.stop = make(chan struct{})
+	go h.run()
+	return nil
+}
+
+// Stop beendet die Berechnung der Nachrichtenraten
+func (h *ClientStatsHook) Stop() error {
+	if h.stop != nil {
+		close(h.stop)
+		h.stop = nil
+	}
+	return nil
+}
+
+func (h *ClientStatsHook) run() {
+	ticker := time.NewTicker(clientRateInterval)
+	defer ticker.Stop()
+	stop := h.stop
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			h.mu.Lock()
+			for _, c := range h.clients {
+				received := c.messagesReceived.Load()
+				c.receiveRate = float64(received-c.lastMessages) / clientRateInterval.Seconds()
+				c.lastMessages = received
+			}
+			h.mu.Unlock()
+		}
+	}
+}
+
+// counters liefert die Zähler eines Clients, nil für unbekannte und den Inline-Client
+func (h *ClientStatsHook) counters(cl *MQTT.Client) *clientCounters {
+	if cl.Net.Inline {
+		return nil
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.clients[cl.ID]
+}
+
+// OnSessionEstablished setzt die Zähler bei jedem Verbindungsaufbau zurück
+func (h *ClientStatsHook) OnSessionEstablished(cl *MQTT.Client, pk packets.Packet) {
+	if cl.Net.Inline {
+		return
+	}
+	c := &clientCounters{connectedAt: time.Now()}
+	c.lastActivity.Store(c.connectedAt.Unix())
+	h.mu.Lock()
+	h.clients[cl.ID] = c
+	h.mu.Unlock()
+}
+
+// OnPacketRead zählt empfangene Pakete. Die Größe wird aus dem Fixed Header berechnet.
+func (h *ClientStatsHook) OnPacketRead(cl *MQTT.Client, pk packets.Packet) (packets.Packet, error) {
+	if c := h.counters(cl); c != nil {
+		c.packetsReceived.Add(1)
+		c.bytesReceived.Add(int64(packetSize(pk.FixedHeader.Remaining)))
+		c.lastActivity.Store(time.Now().Unix())
+		if pk.FixedHeader.Type == packets.Publish {
+			c.messagesReceived.Add(1)
+		}
+	}
+	return pk, nil
+}
+
+// OnPacketSent zählt gesendete Pakete
+func (h *ClientStatsHook) OnPacketSent(cl *MQTT.Client, pk packets.Packet, b []byte) {
+	if c := h.counters(cl); c != nil {
+		c.packetsSent.Add(1)
+		c.bytesSent.Add(int64(len(b)))
+		if pk.FixedHeader.Type == packets.Publish {
+			c.messagesSent.Add(1)
+		}
+	}
+}
+
+// OnDisconnect verwirft die Zähler von Clients ohne persistente Session
+func (h *ClientStatsHook) OnDisconnect(cl *MQTT.Client, err error, expire bool) {
+	if expire && cl.StopCause() != packets.ErrSessionTakenOver {
+		h.OnClientExpired(cl)
+	}
+}
+
+// OnClientExpired verwirft die Zähler einer abgelaufenen Session
+func (h *ClientStatsHook) OnClientExpired(cl *MQTT.Client) {
+	h.mu.Lock()
+	delete(h.clients, cl.ID)
+	h.mu.Unlock()
+}
+
+// Stats liefert die Zähler eines Clients
+func (h *ClientStatsHook) Stats(clientID string) (ClientStats, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	c, ok := h.clients[clientID]
+	if !ok {
+		return ClientStats{}, false
+	}
+	return ClientStats{
+		ConnectedAt:      c.connectedAt,
+		LastActivity:     time.Unix(c.lastActivity.Load(), 0),
+		MessagesReceived: c.messagesReceived.Load(),
+		MessagesSent:     c.messagesSent.Load(),
+		PacketsReceived:  c.packetsReceived.Load(),
+		PacketsSent:      c.packetsSent.Load(),
+		BytesReceived:    c.bytesReceived.Load(),
+		BytesSent:        c.bytesSent.Load(),
+		ReceiveRate:      c.receiveRate,
+	}, true
+}
+
+// packetSize liefert die Größe eines Pakets aus der Restlänge (Fixed Header: 1 Byte + Längenfeld)
+func packetSize(remaining int) int {
+	size := 1 + remaining
+	for {
+		size++
+		remaining /= 128
+		if remaining == 0 {
+			return size
+		}
+	}
+}
+
+// ClientSubscription ist eine Subscription eines Clients
+type ClientSubscription struct {
+	Filter string `json:"filter"`
+	Qos    byte   `json:"qos"`
+}
+
+// ClientInfo beschreibt einen Client des Brokers
+type ClientInfo struct {
+	ID              string               `json:"id"`
+	Username        string               `json:"username"`
+	Remote          string               `json:"remote"`
+	Listener        string               `json:"listener"`
+	ProtocolVersion byte                 `json:"protocolVersion"`
+	CleanSession    bool                 `json:"cleanSession"`
+	Keepalive       uint16               `json:"keepalive"`
+	Connected       bool                 `json:"connected"`
+	DisconnectedAt  *time.Time           `json:"disconnectedAt,omitempty"`
+	Subscriptions   int                  `json:"subscriptions"`
+	Inflight        int                  `json:"inflight"`
+	Stats           *ClientStats         `json:"stats,omitempty"`
+	Filters         []ClientSubscription `json:"filters,omitempty"` // Nur in GetClient
+}
+
+// clientInfo fasst Zustand und Zähler eines Clients zusammen
+func clientInfo(cl *MQTT.Client) ClientInfo {
+	info := ClientInfo{
+		ID:              cl.ID,
+		Username:        string(cl.Properties.Username),
+		Remote:          cl.Net.Remote,
+		Listener:        cl.Net.Listener,
+		ProtocolVersion: cl.Properties.ProtocolVersion,
+		CleanSession:    cl.Properties.Clean,
+		Keepalive:       cl.State.Keepalive,
+		Connected:       !cl.Closed(),
+		Subscriptions:   cl.State.Subscriptions.Len(),
+		Inflight:        cl.State.Inflight.Len(),
+	}
+	if !info.Connected {
+		if stopped := cl.StopTime(); stopped > 0 {
+			t := time.Unix(stopped, 0)
+			info.DisconnectedAt = &t
+		}
+	}
+	if clientStats != nil {
+		if stats, ok := clientStats.Stats(cl.ID); ok {
+			info.Stats = &stats
+		}
+	}
+	return info
+}
+
+// ListClients liefert alle Clients des Brokers (verbunden und persistente Sessions) ohne den Inline-Client.
+// sortBy sortiert absteigend nach messagesReceived, bytesReceived oder receiveRate, sonst nach ID.
+func ListClients(s *MQTT.Server, sortBy string) []ClientInfo {
+	clients := []ClientInfo{}
+	for _, cl := range s.Clients.GetAll() {
+		if cl.Net.Inline {
+			continue
+		}
+		clients = append(clients, clientInfo(cl))
+	}
+
+	value := func(c ClientInfo) float64 {
+		if c.Stats == nil {
+			return -1
+		}
+		switch sortBy {
+		case "messagesReceived":
+			return float64(c.Stats.MessagesReceived)
+		case "bytesReceived":
+			return float64(c.Stats.BytesReceived)
+		case "receiveRate":
+			return c.Stats.ReceiveRate
+		}
+		return 0
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		if vi, vj := value(clients[i]), value(clients[j]); vi != vj {
+			return vi > vj
+		}
+		return clients[i].ID < clients[j].ID
+	})
+	return clients
+}
+
+// GetClient liefert einen Client inklusive seiner Subscriptions
+func GetClient(s *MQTT.Server, clientID string) (ClientInfo, error) {
+	cl, ok := s.Clients.Get(clientID)
+	if !ok || cl.Net.Inline {
+		return ClientInfo{}, ErrClientNotFound
+	}
+	info := clientInfo(cl)
+	info.Filters = []ClientSubscription{}
+	for filter, sub := range cl.State.Subscriptions.GetAll() {
+		info.Filters = append(info.Filters, ClientSubscription{Filter: filter, Qos: sub.Qos})
+	}
+	sort.Slice(info.Filters, func(i, j int) bool { return info.Filters[i].Filter < info.Filters[j].Filter })
+	return info, nil
+}
+
+// KickClient trennt einen verbundenen Client. Eine persistente Session bleibt erhalten.
+func KickClient(s *MQTT.Server, clientID string) error {
+	cl, ok := s.Clients.Get(clientID)
+	if !ok || cl.Net.Inline {
+		return ErrClientNotFound
+	}
+	if cl.Closed() {
+		return nil
+	}
+	// DisconnectClient liefert den Reason Code als Fehler zurück
+	if err := s.DisconnectClient(cl, packets.ErrAdministrativeAction); err != nil && !errors.Is(err, packets.ErrAdministrativeAction) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package mqtt_broker
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"iot-gateway/logic"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	MQTT "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/sirupsen/logrus"
+)
+
+// presenceCheckInterval ist das Intervall für die Prüfung auf verstummte Geräte und das Speichern von last_seen
+const presenceCheckInterval = 10 * time.Second
+
+// DevicePresenceConfig enthält die Konfiguration der Verbindungsüberwachung von MQTT-Geräten
+type DevicePresenceConfig struct {
+	StaleTimeout time.Duration // Verbundene Geräte ohne Nachricht gelten danach als verstummt, 0 = aus
+}
+
+// loadDevicePresenceConfigFromEnv lädt die Konfiguration aus Umgebungsvariablen
+func loadDevicePresenceConfigFromEnv() DevicePresenceConfig {
+	config := DevicePresenceConfig{
+		StaleTimeout: 5 * time.Minute,
+	}
+
+	if val := os.Getenv("MQTT_DEVICE_STALE_SECONDS"); val != "" {
+		if intVal, err := strconv.Atoi(val); err == nil {
+			config.StaleTimeout = time.Duration(intVal) * time.Second
+		}
+	}
+
+	return config
+}
+
+// DevicePresenceHookOptions enthält die Optionen des DevicePresenceHook
+type DevicePresenceHookOptions struct {
+	DB     *sql.DB
+	Config DevicePresenceConfig
+}
+
+// presenceClient ist eine Verbindung eines MQTT-Geräts
+type presenceClient struct {
+	deviceID string
+	willSent bool
+}
+
+// presenceDevice ist der Verbindungszustand eines MQTT-Geräts
+type presenceDevice struct {
+	clients  int          // Anzahl der Verbindungen mit dem Benutzer des Geräts
+	lastSeen atomic.Int64 // Unix-Zeit der letzten Nachricht bzw. des Verbindungsaufbaus
+	stored   int64        // Zuletzt in devices.last_seen gespeicherte Zeit
+	stale    atomic.Bool
+}
+
+// DevicePresenceHook ordnet Clients über ihren Benutzernamen den MQTT-Geräten zu und meldet deren
+// Verbindungszustand (verbunden, Verbindung verloren, verstummt) an den Driver-Manager.
+type DevicePresenceHook struct {
+	MQTT.HookBase
+	db      *sql.DB
+	config  DevicePresenceConfig
+	mu      sync.RWMutex
+	clients map[*MQTT.Client]*presenceClient
+	devices map[string]*presenceDevice
+	stop    chan struct{}
+}
+
+// ID liefert die ID des Hooks
+func (h *DevicePresenceHook) ID() string {
+	return "gateway-device-presence"
+}
+
+// Provides meldet die Hook-Methoden
+func (h *DevicePresenceHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		MQTT.OnSessionEstablished,
+		MQTT.OnPublished,
+		MQTT.OnWillSent,
+		MQTT.OnDisconnect,
+	}, []byte{b})
+}
+
+// Init startet die Prüfung auf verstummte Geräte
+func (h *DevicePresenceHook) Init(config any) error {
+	opts, ok := config.(*DevicePresenceHookOptions)
+	if !ok || opts == nil || opts.DB == nil {
+		return MQTT.ErrInvalidConfigType
+	}
+	h.db = opts.DB
+	h.config = opts.Config
+	h.clients = make(map[*MQTT.Client]*presenceClient)
+	h.devices = make(map[string]*presenceDevice)
+	h.stop = make(chan struct{})
+	go h.run()
+	return nil
+}
+
+// Stop beendet die Prüfung auf verstummte Geräte
+func (h *DevicePresenceHook) Stop() error {
+	if h.stop != nil {
+		close(h.stop)
+		h.stop = nil
+	}
+	return nil
+}
+
+// deviceID liefert die ID des MQTT-Geräts zu einem Benutzernamen
+func (h *DevicePresenceHook) deviceID(username string) (string, bool) {
+	if username == "" {
+		return "", false
+	}
+	var id string
+	err := h.db.QueryRow(`SELECT id FROM devices WHERE type = 'mqtt' AND name = ?`, username).Scan(&id)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logrus.Errorf("MQTT-Broker: Error looking up MQTT device %s: %v", username, err)
+		}
+		return "", false
+	}
+	return id, true
+}
+
+// OnSessionEstablished meldet ein MQTT-Gerät als verbunden. OnConnect wird nicht verwendet,
+// da es vor der Authentifizierung aufgerufen wird.
+func (h *DevicePresenceHook) OnSessionEstablished(cl *MQTT.Client, pk packets.Packet) {
+	if cl.Net.Inline {
+		return
+	}
+	deviceID, ok := h.deviceID(string(cl.Properties.Username))
+	if !ok {
+		return
+	}
+
+	now := time.Now().Unix()
+	h.mu.Lock()
+	h.clients[cl] = &presenceClient{deviceID: deviceID}
+	dev, ok := h.devices[deviceID]
+	if !ok {
+		dev = &presenceDevice{}
+		h.devices[deviceID] = dev
+	}
+	dev.clients++
+	dev.lastSeen.Store(now)
+	dev.stale.Store(false)
+	h.mu.Unlock()
+
+	h.storeLastSeen(deviceID, now)
+	logic.SetMqttDevicePresence(deviceID, logic.Running, "")
+}
+
+// OnPublished merkt die Zeit der letzten Nachricht eines MQTT-Geräts
+func (h *DevicePresenceHook) OnPublished(cl *MQTT.Client, pk packets.Packet) {
+	h.mu.RLock()
+	c, ok := h.clients[cl]
+	var dev *presenceDevice
+	if ok {
+		dev = h.devices[c.deviceID]
+	}
+	h.mu.RUnlock()
+	if dev == nil {
+		return
+	}
+
+	dev.lastSeen.Store(time.Now().Unix())
+	if dev.stale.CompareAndSwap(true, false) {
+		logrus.Infof("MQTT-Broker: MQTT device %s is publishing again", c.deviceID)
+		logic.SetMqttDevicePresence(c.deviceID, logic.Running, "")
+	}
+}
+
+// OnWillSent merkt, dass für die Verbindung eines MQTT-Geräts die Will Message gesendet wurde
+func (h *DevicePresenceHook) OnWillSent(cl *MQTT.Client, pk packets.Packet) {
+	h.mu.Lock()
+	if c, ok := h.clients[cl]; ok {
+		c.willSent = true
+	}
+	h.mu.Unlock()
+}
+
+// OnDisconnect meldet den Verbindungsverlust, sobald die letzte Verbindung eines MQTT-Geräts getrennt ist
+func (h *DevicePresenceHook) OnDisconnect(cl *MQTT.Client, err error, expire bool) {
+	h.mu.Lock()
+	c, ok := h.clients[cl]
+	if !ok {
+		h.mu.Unlock()
+		return
+	}
+	delete(h.clients, cl)
+	dev := h.devices[c.deviceID]
+	dev.clients--
+	remaining := dev.clients
+	lastSeen := dev.lastSeen.Load()
+	h.mu.Unlock()
+
+	h.storeLastSeen(c.deviceID, lastSeen)
+	// Beim Herunterfahren des Brokers bleibt der Status unverändert, übernommene Sessions sind weiter verbunden
+	if remaining > 0 || errors.Is(err, packets.ErrServerShuttingDown) {
+		return
+	}
+
+	info := "disconnected"
+	if err != nil {
+		info = err.Error()
+	}
+	if c.willSent {
+		info += ", will message sent"
+	}
+	logrus.Infof("MQTT-Broker: MQTT device %s disconnected (%s)", c.deviceID, info)
+	logic.SetMqttDevicePresence(c.deviceID, logic.Connection_Lost, info)
+}
+
+func (h *DevicePresenceHook) run() {
+	ticker := time.NewTicker(presenceCheckInterval)
+	defer ticker.Stop()
+	stop := h.stop
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			h.check(time.Now())
+		}
+	}
+}
+
+// check speichert last_seen verbundener Geräte und meldet Geräte, die länger als StaleTimeout
+// keine Nachricht gesendet haben
+func (h *DevicePresenceHook) check(now time.Time) {
+	lastSeen := make(map[string]int64)
+	var stale []string
+
+	h.mu.Lock()
+	for deviceID, dev := range h.devices {
+		if dev.clients == 0 {
+			continue
+		}
+		last := dev.lastSeen.Load()
+		if last != dev.stored {
+			lastSeen[deviceID] = last
+		}
+		if h.config.StaleTimeout > 0 && now.Sub(time.Unix(last, 0)) > h.config.StaleTimeout && dev.stale.CompareAndSwap(false, true) {
+			stale = append(stale, deviceID)
+		}
+	}
+	h.mu.Unlock()
+
+	for deviceID, last := range lastSeen {
+		h.storeLastSeen(deviceID, last)
+	}
+	for _, deviceID := range stale {
+		logrus.Warnf("MQTT-Broker: MQTT device %s is connected but has not published for %v", deviceID, h.config.StaleTimeout)
+		logic.SetMqttDevicePresence(deviceID, logic.Stale, fmt.Sprintf("no messages for %v", h.config.StaleTimeout))
+	}
+}
+
+// storeLastSeen speichert die Zeit der letzten Aktivität eines MQTT-Geräts in devices.last_seen
+func (h *DevicePresenceHook) storeLastSeen(deviceID string, lastSeen int64) {
+	h.mu.Lock()
+	if dev, ok := h.devices[deviceID]; ok {
+		if dev.stored == lastSeen {
+			h.mu.Unlock()
+			return
+		}
+		dev.stored = lastSeen
+	}
+	h.mu.Unlock()
+
+	if _, err := h.db.Exec(`UPDATE devices SET last_seen = ? WHERE id = ?`, time.Unix(lastSeen, 0).Format(time.RFC3339), deviceID); err != nil {
+		logrus.Errorf("MQTT-Broker: Error storing last seen time of MQTT device %s: %v", deviceID, err)
+	}
+}
@@ -0,0 +1,449 @@
+package mqtt_broker
+
+import (
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	MQTT "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/sirupsen/logrus"
+)
+
+// Listener-Typen
+const (
+	ListenerTCP       = "tcp"
+	ListenerWebsocket = "websocket"
+	ListenerHTTPStats = "http-stats" // $SYS-Werte als JSON über HTTP
+	ListenerUnix      = "unix"
+)
+
+// TLS-Profile der Listener
+const (
+	TLSProfileNone         = "none"          // Unverschlüsselt
+	TLSProfileServer       = "tls"           // Serverzertifikat, Client-Zertifikate nach MQTT_TLS_CLIENT_AUTH
+	TLSProfileMTLSOptional = "mtls-optional" // Client-Zertifikat wird geprüft, falls vorhanden
+	TLSProfileMTLS         = "mtls"          // Client-Zertifikat erforderlich
+)
+
+var (
+	ErrListenerNotFound    = errors.New("listener not found")
+	ErrListenerExists      = errors.New("listener already exists")
+	ErrInvalidListener     = errors.New("invalid listener")
+	ErrListenerUnavailable = errors.New("listener could not be started")
+)
+
+// listenerMu serialisiert Änderungen an den Listenern (Datenbank und laufender Broker)
+var listenerMu sync.Mutex
+
+// ListenerConfig ist ein Eintrag aus broker_listeners
+type ListenerConfig struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Address    string `json:"address"`
+	TLSProfile string `json:"tlsProfile"`
+	Enabled    bool   `json:"enabled"`
+}
+
+// ListenerStatus beschreibt den Zustand eines konfigurierten Listeners
+type ListenerStatus struct {
+	ListenerConfig
+	Listening bool   `json:"listening"`
+	Error     string `json:"error,omitempty"`
+}
+
+// defaultListeners liefert die vier Standard-Listener aus den Umgebungsvariablen.
+// Sie werden beim ersten Start in broker_listeners übernommen.
+func defaultListeners() []ListenerConfig {
+
+	// Get all environment variables for Listener
+	listenerLocalTCPAddress := os.Getenv("MQTT_LISTENER_LOCAL_TCP_ADDRESS")
+	if listenerLocalTCPAddress == "" {
+		listenerLocalTCPAddress = "5000"
+	}
+	listenerLocalWSAddress := os.Getenv("MQTT_LISTENER_LOCAL_WS_ADDRESS")
+	if listenerLocalWSAddress == "" {
+		listenerLocalWSAddress = "5001"
+	}
+	listenerPublicTCPAddress := os.Getenv("MQTT_LISTENER_PUBLIC_TCP_ADDRESS")
+	if listenerPublicTCPAddress == "" {
+		listenerPublicTCPAddress = "5100"
+	}
+	listenerPublicWSAddress := os.Getenv("MQTT_LISTENER_PUBLIC_WS_ADDRESS")
+	if listenerPublicWSAddress == "" {
+		listenerPublicWSAddress = "5101"
+	}
+
+	return []ListenerConfig{
+		{ID: "localTCP", Type: ListenerTCP, Address: ":" + listenerLocalTCPAddress, TLSProfile: TLSProfileNone, Enabled: true},
+		{ID: "localWS", Type: ListenerWebsocket, Address: ":" + listenerLocalWSAddress, TLSProfile: TLSProfileNone, Enabled: true},
+		{ID: "publicTCP", Type: ListenerTCP, Address: ":" + listenerPublicTCPAddress, TLSProfile: TLSProfileServer, Enabled: true},
+		{ID: "publicWS", Type: ListenerWebsocket, Address: ":" + listenerPublicWSAddress, TLSProfile: TLSProfileServer, Enabled: true},
+	}
+}
+
+// seedListeners übernimmt die Standard-Listener, solange broker_listeners leer ist
+func seedListeners(db *sql.DB) error {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM broker_listeners").Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	for _, l := range defaultListeners() {
+		if err := insertListener(db, l); err != nil {
+			return err
+		}
+	}
+	logrus.Info("MQTT-Broker: Default listeners stored in broker_listeners")
+	return nil
+}
+
+// createListeners registriert alle aktiven Listener aus broker_listeners beim (noch nicht laufenden) Server
+func createListeners(s *MQTT.Server, db *sql.DB) error {
+	if err := seedListeners(db); err != nil {
+		return err
+	}
+	configs, err := ListListeners(db)
+	if err != nil {
+		return err
+	}
+
+	for _, cfg := range configs {
+		if !cfg.Enabled {
+			continue
+		}
+		l, err := newListener(s, cfg)
+		if err != nil {
+			// Ein fehlerhafter Listener soll die übrigen nicht verhindern, er taucht in CheckListeners auf
+			logrus.Errorf("MQTT-Broker: Skipping listener %s: %v", cfg.ID, err)
+			continue
+		}
+		if err := s.AddListener(l); err != nil {
+			logrus.Errorf("MQTT-Broker: Error adding listener %s: %v", cfg.ID, err)
+		}
+	}
+	return nil
+}
+
+// newListener erzeugt einen mochi-Listener aus der Konfiguration
+func newListener(s *MQTT.Server, cfg ListenerConfig) (listeners.Listener, error) {
+	tlsConfig, err := listenerTLSConfig(cfg.TLSProfile)
+	if err != nil {
+		return nil, err
+	}
+	config := listeners.Config{
+		ID:        cfg.ID,
+		Address:   cfg.Address,
+		TLSConfig: tlsConfig,
+	}
+
+	switch cfg.Type {
+	case ListenerTCP:
+		return listeners.NewTCP(config), nil
+	case ListenerWebsocket:
+		return listeners.NewWebsocket(config), nil
+	case ListenerHTTPStats:
+		return listeners.NewHTTPStats(config, s.Info), nil
+	case ListenerUnix:
+		return listeners.NewUnixSock(config), nil
+	default:
+		return nil, fmt.Errorf("unknown listener type %q", cfg.Type)
+	}
+}
+
+// listenerTLSConfig liefert die TLS-Konfiguration eines Profils (nil = unverschlüsselt)
+func listenerTLSConfig(profile string) (*tls.Config, error) {
+	if profile == TLSProfileNone || profile == "" {
+		return nil, nil
+	}
+	if certs == nil {
+		return nil, fmt.Errorf("TLS certificates not loaded")
+	}
+	switch profile {
+	case TLSProfileServer:
+		return certs.TLSConfig(), nil
+	case TLSProfileMTLSOptional:
+		return certs.TLSConfigWithClientAuth(ClientAuthOptional), nil
+	case TLSProfileMTLS:
+		return certs.TLSConfigWithClientAuth(ClientAuthRequired), nil
+	default:
+		return nil, fmt.Errorf("unknown TLS profile %q", profile)
+	}
+}
+
+// normalize setzt Standardwerte und prüft die Konfiguration
+func (cfg *ListenerConfig) normalize() error {
+	cfg.ID = strings.TrimSpace(cfg.ID)
+	cfg.Type = strings.ToLower(strings.TrimSpace(cfg.Type))
+	cfg.Address = strings.TrimSpace(cfg.Address)
+	cfg.TLSProfile = strings.ToLower(strings.TrimSpace(cfg.TLSProfile))
+	if cfg.TLSProfile == "" {
+		cfg.TLSProfile = TLSProfileNone
+	}
+
+	if cfg.ID == "" || len(cfg.ID) > 50 || strings.ContainsAny(cfg.ID, " \t/") {
+		return fmt.Errorf("%w: id must be 1-50 characters without spaces or slashes", ErrInvalidListener)
+	}
+	switch cfg.TLSProfile {
+	case TLSProfileNone, TLSProfileServer, TLSProfileMTLSOptional, TLSProfileMTLS:
+	default:
+		return fmt.Errorf("%w: unknown TLS profile %q (none, tls, mtls-optional, mtls)", ErrInvalidListener, cfg.TLSProfile)
+	}
+	if cfg.Address == "" {
+		return fmt.Errorf("%w: address is required", ErrInvalidListener)
+	}
+
+	switch cfg.Type {
+	case ListenerTCP, ListenerWebsocket, ListenerHTTPStats:
+		_, port, err := net.SplitHostPort(cfg.Address)
+		if err != nil {
+			return fmt.Errorf("%w: address must be host:port or :port", ErrInvalidListener)
+		}
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return fmt.Errorf("%w: invalid port %q", ErrInvalidListener, port)
+		}
+	case ListenerUnix:
+		if cfg.TLSProfile != TLSProfileNone {
+			return fmt.Errorf("%w: unix sockets do not support TLS", ErrInvalidListener)
+		}
+	default:
+		return fmt.Errorf("%w: unknown type %q (tcp, websocket, http-stats, unix)", ErrInvalidListener, cfg.Type)
+	}
+	return nil
+}
+
+// ListListeners liefert alle Listener aus broker_listeners
+func ListListeners(db *sql.DB) ([]ListenerConfig, error) {
+	rows, err := db.Query("SELECT id, type, address, tls_profile, enabled FROM broker_listeners ORDER BY rowid")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	configs := []ListenerConfig{}
+	for rows.Next() {
+		var cfg ListenerConfig
+		if err := rows.Scan(&cfg.ID, &cfg.Type, &cfg.Address, &cfg.TLSProfile, &cfg.Enabled); err != nil {
+			return nil, err
+		}
+		configs = append(configs, cfg)
+	}
+	return configs, rows.Err()
+}
+
+// GetListener liefert einen Listener aus broker_listeners
+func GetListener(db *sql.DB, id string) (*ListenerConfig, error) {
+	var cfg ListenerConfig
+	err := db.QueryRow("SELECT id, type, address, tls_profile, enabled FROM broker_listeners WHERE id = ?", id).
+		Scan(&cfg.ID, &cfg.Type, &cfg.Address, &cfg.TLSProfile, &cfg.Enabled)
+	if err == sql.ErrNoRows {
+		return nil, ErrListenerNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func insertListener(db *sql.DB, cfg ListenerConfig) error {
+	_, err := db.Exec("INSERT INTO broker_listeners (id, type, address, tls_profile, enabled) VALUES (?, ?, ?, ?, ?)",
+		cfg.ID, cfg.Type, cfg.Address, cfg.TLSProfile, cfg.Enabled)
+	return err
+}
+
+// CreateListener speichert einen Listener und startet ihn sofort, wenn er aktiv ist und der Broker läuft
+func CreateListener(db *sql.DB, cfg ListenerConfig) (*ListenerConfig, error) {
+	if err := cfg.normalize(); err != nil {
+		return nil, err
+	}
+
+	listenerMu.Lock()
+	defer listenerMu.Unlock()
+
+	if _, err := GetListener(db, cfg.ID); err == nil {
+		return nil, ErrListenerExists
+	} else if !errors.Is(err, ErrListenerNotFound) {
+		return nil, err
+	}
+
+	if cfg.Enabled && server != nil {
+		if err := startListener(server, cfg); err != nil {
+			return nil, err
+		}
+	}
+	if err := insertListener(db, cfg); err != nil {
+		if server != nil {
+			stopListener(server, cfg.ID)
+		}
+		return nil, err
+	}
+	logrus.Infof("MQTT-Broker: Listener %s (%s %s) created", cfg.ID, cfg.Type, cfg.Address)
+	return &cfg, nil
+}
+
+// UpdateListener ändert einen Listener. Im laufenden Broker wird er geschlossen (verbundene Clients
+// werden getrennt) und mit der neuen Konfiguration wieder gestartet. Schlägt der Start fehl,
+// bleibt die alte Konfiguration aktiv.
+func UpdateListener(db *sql.DB, id string, cfg ListenerConfig) (*ListenerConfig, error) {
+	cfg.ID = id
+	if err := cfg.normalize(); err != nil {
+		return nil, err
+	}
+
+	listenerMu.Lock()
+	defer listenerMu.Unlock()
+
+	old, err := GetListener(db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if server != nil && *old != cfg {
+		stopListener(server, id)
+		if cfg.Enabled {
+			if err := startListener(server, cfg); err != nil {
+				if old.Enabled {
+					if restoreErr := startListener(server, *old); restoreErr != nil {
+						logrus.Errorf("MQTT-Broker: Failed to restore listener %s: %v", id, restoreErr)
+					}
+				}
+				return nil, err
+			}
+		}
+	}
+
+	_, err = db.Exec("UPDATE broker_listeners SET type = ?, address = ?, tls_profile = ?, enabled = ? WHERE id = ?",
+		cfg.Type, cfg.Address, cfg.TLSProfile, cfg.Enabled, id)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Infof("MQTT-Broker: Listener %s updated (%s %s, enabled=%t)", cfg.ID, cfg.Type, cfg.Address, cfg.Enabled)
+	return &cfg, nil
+}
+
+// DeleteListener schließt einen Listener im laufenden Broker und löscht ihn aus broker_listeners
+func DeleteListener(db *sql.DB, id string) error {
+	listenerMu.Lock()
+	defer listenerMu.Unlock()
+
+	if _, err := GetListener(db, id); err != nil {
+		return err
+	}
+	if server != nil {
+		stopListener(server, id)
+	}
+	if _, err := db.Exec("DELETE FROM broker_listeners WHERE id = ?", id); err != nil {
+		return err
+	}
+	logrus.Infof("MQTT-Broker: Listener %s deleted", id)
+	return nil
+}
+
+// startListener fügt einen Listener zum laufenden Server hinzu und startet ihn
+func startListener(s *MQTT.Server, cfg ListenerConfig) error {
+	// Websocket- und HTTP-Listener binden erst in Serve, Fehler würden dort nur geloggt
+	if cfg.Type == ListenerWebsocket || cfg.Type == ListenerHTTPStats {
+		ln, err := net.Listen("tcp", cfg.Address)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrListenerUnavailable, err)
+		}
+		ln.Close()
+	}
+
+	l, err := newListener(s, cfg)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrListenerUnavailable, err)
+	}
+	if err := s.AddListener(l); err != nil {
+		return fmt.Errorf("%w: %v", ErrListenerUnavailable, err)
+	}
+	s.Listeners.Serve(cfg.ID, s.EstablishConnection)
+	logrus.Infof("MQTT-Broker: Listener %s started on %s", cfg.ID, cfg.Address)
+	return nil
+}
+
+// stopListener schließt einen Listener des laufenden Servers und trennt dessen Clients
+func stopListener(s *MQTT.Server, id string) {
+	if _, ok := s.Listeners.Get(id); !ok {
+		return
+	}
+	s.Listeners.Close(id, func(listenerID string) {
+		for _, cl := range s.Clients.GetByListener(listenerID) {
+			s.DisconnectClient(cl, packets.ErrServerShuttingDown)
+		}
+	})
+	s.Listeners.Delete(id)
+	logrus.Infof("MQTT-Broker: Listener %s stopped", id)
+}
+
+// ListenerStatuses liefert alle Listener aus broker_listeners mit ihrem Zustand im Broker
+func ListenerStatuses(s *MQTT.Server, db *sql.DB) ([]ListenerStatus, error) {
+	configs, err := ListListeners(db)
+	if err != nil {
+		return nil, err
+	}
+	status := make([]ListenerStatus, 0, len(configs))
+	for _, cfg := range configs {
+		st := ListenerStatus{ListenerConfig: cfg}
+		if cfg.Enabled {
+			st.Listening, st.Error = probeListener(s, cfg)
+		}
+		status = append(status, st)
+	}
+	return status, nil
+}
+
+// CheckListeners prüft, ob die aktiven Listener beim Broker registriert sind und Verbindungen annehmen
+func CheckListeners(s *MQTT.Server) []ListenerStatus {
+	if brokerDB == nil {
+		return []ListenerStatus{}
+	}
+	all, err := ListenerStatuses(s, brokerDB)
+	if err != nil {
+		logrus.Errorf("MQTT-Broker: Failed to load listeners: %v", err)
+		return []ListenerStatus{}
+	}
+	status := make([]ListenerStatus, 0, len(all))
+	for _, st := range all {
+		if st.Enabled {
+			status = append(status, st)
+		}
+	}
+	return status
+}
+
+// probeListener prüft per Verbindungsaufbau, ob ein Listener erreichbar ist
+func probeListener(s *MQTT.Server, cfg ListenerConfig) (bool, string) {
+	if _, ok := s.Listeners.Get(cfg.ID); !ok {
+		return false, "listener not registered"
+	}
+	network, address := "tcp", dialAddress(cfg.Address)
+	if cfg.Type == ListenerUnix {
+		network, address = "unix", cfg.Address
+	}
+	conn, err := net.DialTimeout(network, address, time.Second)
+	if err != nil {
+		return false, err.Error()
+	}
+	conn.Close()
+	return true, ""
+}
+
+// dialAddress ergänzt eine Listener-Adresse ohne Host (":5000") um localhost
+func dialAddress(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || host == "" || host == "0.0.0.0" || host == "::" {
+		return net.JoinHostPort("127.0.0.1", port)
+	}
+	return address
+}
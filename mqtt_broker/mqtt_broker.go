@@ -1,36 +1,24 @@
 package mqtt_broker
 
 import (
-	"crypto/tls"
 	"database/sql"
 	"iot-gateway/logic"
-	"os"
-	"os/signal"
 	"sync"
-	"syscall"
 	"time"
 
 	_ "github.com/glebarez/go-sqlite" // Import für SQLite
 	MQTT "github.com/mochi-mqtt/server/v2"
-	"github.com/mochi-mqtt/server/v2/hooks/auth"
-	"github.com/mochi-mqtt/server/v2/listeners"
 	"github.com/sirupsen/logrus"
-	yaml "gopkg.in/yaml.v2"
 )
 
 var server *MQTT.Server
 var once sync.Once
-
-type ListenerConfig struct {
-	ID      string `json:"id"`
-	Address string `json:"address"`
-	Type    string `json:"type"`
-	TLS     bool   `json:"tls"`
-}
-
-type Config struct {
-	Listeners []ListenerConfig `json:"listeners"`
-}
+var authRepo *AuthRepository
+var certs *CertManager
+var certWatchStop chan struct{}
+var brokerDB *sql.DB
+var clientStats *ClientStatsHook
+var topicTracker *TopicTrackerHook
 
 // StartBroker initialisiert den Broker synchron und startet den blockierenden Serve-Loop asynchron.
 func StartBroker(db *sql.DB) *MQTT.Server {
@@ -67,227 +55,96 @@ func startBrokerInstance(db *sql.DB) *MQTT.Server {
 		logrus.Fatal("Failed to manage External Driver access: ", err)
 	}
 
-	// Authentifizierungsdaten aus der Datenbank laden.
-	var authData []byte
+	// Authentifizierungsdaten aus der Datenbank laden (Cache, wird über InvalidateAuth aktualisiert).
+	authRepo = NewAuthRepository(db)
 	err := logic.RetryableDBOperation(func() error {
-		var loadErr error
-		authData, loadErr = loadAuthDataFromDB(db)
+		_, loadErr := authRepo.load()
 		return loadErr
 	}, "MQTT Auth Data Loading")
 	if err != nil {
 		logrus.Fatal("Failed to load auth data from the database: ", err)
 	}
 
-	// Generierung eines selbstsignierten Zertifikats für TLS.
-	cert, err := logic.GenerateSelfSignedCert()
+	// Zertifikate für TLS (Dateien, Datenbank oder persistiertes selbstsigniertes Zertifikat).
+	certs, err = NewCertManager(db)
 	if err != nil {
-		logrus.Fatalf("MQTT-Broker: Failed to generate self-signed certificate: %v", err)
-	}
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{cert},
+		logrus.Fatalf("MQTT-Broker: Failed to load TLS certificates: %v", err)
 	}
+	certWatchStop = make(chan struct{})
+	go certs.Watch(certWatchStop)
 
 	// Erzeugen des neuen MQTT-Servers.
 	s := MQTT.New(&MQTT.Options{
 		InlineClient: true,
 	})
 
-	// Hinzufügen des Authentifizierungs-Hooks mit den geladenen Daten.
-	if err := s.AddHook(new(auth.Hook), &auth.Options{Data: authData}); err != nil {
+	// Hinzufügen des Authentifizierungs-Hooks mit dem Repository.
+	if err := s.AddHook(new(AuthHook), authRepo); err != nil {
 		logrus.Fatal("MQTT-Broker: Failed to add auth hook: ", err)
 	}
 
-	// Listener anhand der Konfiguration hinzufügen.
-	if err := createListeners(s, tlsConfig); err != nil {
-		logrus.Fatal("MQTT-Broker: Error adding listeners: ", err)
+	// Zähler je Client für die Client-Verwaltung.
+	clientStats = new(ClientStatsHook)
+	if err := s.AddHook(clientStats, nil); err != nil {
+		logrus.Fatal("MQTT-Broker: Failed to add client stats hook: ", err)
 	}
 
-	// Signal-Handler in einer separaten Goroutine, um bei SIGINT/SIGTERM den Broker (und die DB) sauber zu schließen.
-	go func() {
-		sigs := make(chan os.Signal, 1)
-		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-		<-sigs
-		s.Close()
-		db.Close()
-		logrus.Info("MQTT Broker shutdown triggered by signal")
-	}()
-
-	return s
-}
-
-func loadConfigFromEnv() Config {
-
-	// Get all environment variables for Listener
-	listenerLocalTCPAddress := os.Getenv("MQTT_LISTENER_LOCAL_TCP_ADDRESS")
-	if listenerLocalTCPAddress == "" {
-		listenerLocalTCPAddress = "5000"
-	}
-	listenerLocalWSAddress := os.Getenv("MQTT_LISTENER_LOCAL_WS_ADDRESS")
-	if listenerLocalWSAddress == "" {
-		listenerLocalWSAddress = "5001"
-	}
-	listenerPublicTCPAddress := os.Getenv("MQTT_LISTENER_PUBLIC_TCP_ADDRESS")
-	if listenerPublicTCPAddress == "" {
-		listenerPublicTCPAddress = "5100"
-	}
-	listenerPublicWSAddress := os.Getenv("MQTT_LISTENER_PUBLIC_WS_ADDRESS")
-	if listenerPublicWSAddress == "" {
-		listenerPublicWSAddress = "5101"
+	// Letzte Nachricht je Topic für den Topic-Explorer.
+	topicTracker = new(TopicTrackerHook)
+	if err := s.AddHook(topicTracker, nil); err != nil {
+		logrus.Fatal("MQTT-Broker: Failed to add topic tracker hook: ", err)
 	}
 
-	return Config{
-		Listeners: []ListenerConfig{
-			{
-				ID:      "localTCP",
-				Address: ":" + listenerLocalTCPAddress,
-				Type:    "tcp",
-				TLS:     false,
-			},
-			{
-				ID:      "localWS",
-				Address: ":" + listenerLocalWSAddress,
-				Type:    "websocket",
-				TLS:     false,
-			},
-			{
-				ID:      "publicTCP",
-				Address: ":" + listenerPublicTCPAddress,
-				Type:    "tcp",
-				TLS:     true,
-			},
-			{
-				ID:      "publicWS",
-				Address: ":" + listenerPublicWSAddress,
-				Type:    "websocket",
-				TLS:     true,
-			},
-		},
+	// Verbindungszustand der MQTT-Geräte anhand ihres Benutzernamens.
+	presence := loadDevicePresenceConfigFromEnv()
+	if err := s.AddHook(new(DevicePresenceHook), &DevicePresenceHookOptions{DB: db, Config: presence}); err != nil {
+		logrus.Fatal("MQTT-Broker: Failed to add device presence hook: ", err)
 	}
-}
-
-func createListeners(server *MQTT.Server, tlsConfig *tls.Config) error {
-	config := loadConfigFromEnv()
-
-	for _, listener := range config.Listeners {
-		var l listeners.Listener
-
-		switch listener.Type {
-		case "tcp":
-			l = listeners.NewTCP(listeners.Config{
-				ID:        listener.ID,
-				Address:   listener.Address,
-				TLSConfig: getTLSConfig(listener.TLS, tlsConfig),
-			})
-		case "websocket":
-			l = listeners.NewWebsocket(listeners.Config{
-				ID:        listener.ID,
-				Address:   listener.Address,
-				TLSConfig: getTLSConfig(listener.TLS, tlsConfig),
-			})
-		case "http":
-			l = listeners.NewHTTPStats(
-				listeners.Config{
-					ID:        listener.ID,
-					Address:   listener.Address,
-					TLSConfig: getTLSConfig(listener.TLS, tlsConfig),
-				}, server.Info,
-			)
-		default:
-			logrus.Warn("Unknown listener type: ", listener.Type)
-			continue
-		}
 
-		err := server.AddListener(l)
-		if err != nil {
-			logrus.Fatal("Error adding listener: ", err)
+	// Retained Messages, Sessions und Inflight Messages in der Datenbank persistieren.
+	// Der Hook muss vor Serve registriert sein, damit der gespeicherte Zustand geladen wird.
+	persistence := loadPersistenceConfigFromEnv()
+	if persistence.Enabled {
+		persistence.applyExpiry(s.Options.Capabilities)
+		if err := s.AddHook(new(StorageHook), &StorageHookOptions{DB: db, Config: persistence}); err != nil {
+			logrus.Fatal("MQTT-Broker: Failed to add storage hook: ", err)
 		}
+	} else {
+		logrus.Warn("MQTT-Broker: Persistence disabled, retained messages and sessions are lost on restart")
 	}
 
-	return nil
-}
-
-func getTLSConfig(tlsRequired bool, tlsConfig *tls.Config) *tls.Config {
-	if tlsRequired {
-		return tlsConfig
-	}
-	return nil
-}
-
-// loadAuthDataFromDB lädt Authentifizierungsdaten aus der SQLite-Datenbank
-func loadAuthDataFromDB(db *sql.DB) ([]byte, error) {
-	// Schritt 1: Hole alle Benutzerinformationen (geschlossene Query)
-	authRows, err := logic.SafeDBQuery(db, "SELECT username, password, allow FROM auth")
-	if err != nil {
-		return nil, err
-	}
-	defer authRows.Close()
-
-	var auth []map[string]interface{}
-	var usernames []string
-
-	for authRows.Next() {
-		var username, password string
-		var allow bool
-		if err := authRows.Scan(&username, &password, &allow); err != nil {
-			return nil, err
-		}
-
-		// Auth-Daten sammeln
-		auth = append(auth, map[string]interface{}{
-			"username": username,
-			"password": password,
-			"allow":    allow,
-		})
-
-		usernames = append(usernames, username)
+	// Listener anhand der Konfiguration in broker_listeners hinzufügen.
+	brokerDB = db
+	if err := createListeners(s, db); err != nil {
+		logrus.Fatal("MQTT-Broker: Error adding listeners: ", err)
 	}
-	authRows.Close() // Explizit schließen vor nächster Query
-
-	// Schritt 2: Hole alle ACL-Daten in einer separaten Query
-	var acl []map[string]interface{}
-
-	for _, username := range usernames {
-		aclRows, err := logic.SafeDBQuery(db, "SELECT topic, permission FROM acl WHERE username = ?", username)
-		if err != nil {
-			return nil, err
-		}
 
-		filters := make(map[string]int)
-		for aclRows.Next() {
-			var topic string
-			var permission int
-			if err := aclRows.Scan(&topic, &permission); err != nil {
-				aclRows.Close()
-				return nil, err
-			}
-			filters[topic] = permission
-		}
-		aclRows.Close() // Explizit schließen
-
-		// ACL-Daten sammeln
-		acl = append(acl, map[string]interface{}{
-			"username": username,
-			"filters":  filters,
-		})
-	}
+	return s
+}
 
-	// Erstelle die komplette Datenstruktur
-	data := map[string]interface{}{
-		"auth": auth,
-		"acl":  acl,
+// InvalidateAuth übernimmt geänderte Benutzer und ACLs ohne Neustart des Brokers
+// und trennt Clients, deren Benutzer gelöscht wurde
+func InvalidateAuth() {
+	if authRepo == nil {
+		return
 	}
-
-	// Konvertiere die Daten in YAML
-	yamlData, err := yaml.Marshal(data)
-	if err != nil {
-		return nil, err
+	authRepo.Invalidate()
+	if server != nil {
+		disconnectRemovedUsers(server, authRepo)
 	}
+}
 
-	return yamlData, nil
+// Certificates liefert die Zertifikatsverwaltung des Brokers
+func Certificates() *CertManager {
+	return certs
 }
 
 // StopBroker stoppt den MQTT Broker
 func StopBroker() {
+	if certWatchStop != nil {
+		close(certWatchStop)
+		certWatchStop = nil
+	}
 	if server != nil {
 		server.Close()
 		logrus.Info("MQTT Broker stopped successfully.")
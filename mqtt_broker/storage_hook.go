@@ -0,0 +1,617 @@
+package mqtt_broker
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	MQTT "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/storage"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/mochi-mqtt/server/v2/system"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	storageFlushInterval       = time.Second
+	storageMaintenanceInterval = 5 * time.Minute
+)
+
+// PersistenceConfig steuert, was der Broker über Neustarts hinweg in broker_storage behält
+type PersistenceConfig struct {
+	Enabled       bool
+	MaxRetained   int           // Maximale Anzahl gespeicherter Retained Messages (älteste fliegen raus)
+	MaxInflight   int           // Maximale Anzahl gespeicherter QoS-1/2-Nachrichten pro Client
+	MessageExpiry time.Duration // Retained und Inflight Messages werden danach verworfen
+	SessionExpiry time.Duration // Persistente Sessions getrennter Clients werden danach verworfen
+}
+
+// loadPersistenceConfigFromEnv liest die Konfiguration aus den Umgebungsvariablen MQTT_PERSISTENCE*
+func loadPersistenceConfigFromEnv() PersistenceConfig {
+	config := PersistenceConfig{
+		Enabled:       true,
+		MaxRetained:   10000,
+		MaxInflight:   1000,
+		MessageExpiry: 7 * 24 * time.Hour,
+		SessionExpiry: 7 * 24 * time.Hour,
+	}
+
+	if val := os.Getenv("MQTT_PERSISTENCE"); val != "" {
+		if boolVal, err := strconv.ParseBool(val); err == nil {
+			config.Enabled = boolVal
+		}
+	}
+
+	if val := os.Getenv("MQTT_PERSISTENCE_MAX_RETAINED"); val != "" {
+		if intVal, err := strconv.Atoi(val); err == nil {
+			config.MaxRetained = intVal
+		}
+	}
+
+	if val := os.Getenv("MQTT_PERSISTENCE_MAX_INFLIGHT"); val != "" {
+		if intVal, err := strconv.Atoi(val); err == nil {
+			config.MaxInflight = intVal
+		}
+	}
+
+	if val := os.Getenv("MQTT_MESSAGE_EXPIRY_HOURS"); val != "" {
+		if intVal, err := strconv.Atoi(val); err == nil {
+			config.MessageExpiry = time.Duration(intVal) * time.Hour
+		}
+	}
+
+	if val := os.Getenv("MQTT_SESSION_EXPIRY_HOURS"); val != "" {
+		if intVal, err := strconv.Atoi(val); err == nil {
+			config.SessionExpiry = time.Duration(intVal) * time.Hour
+		}
+	}
+
+	return config
+}
+
+// applyExpiry überträgt die Ablaufzeiten auf den Broker, der sie auch zur Laufzeit durchsetzt
+func (c PersistenceConfig) applyExpiry(caps *MQTT.Capabilities) {
+	if c.MessageExpiry > 0 {
+		caps.MaximumMessageExpiryInterval = int64(c.MessageExpiry / time.Second)
+	}
+	if c.SessionExpiry > 0 {
+		caps.MaximumSessionExpiryInterval = uint32(c.SessionExpiry / time.Second)
+	}
+}
+
+// StorageHookOptions ist die Konfiguration des StorageHook
+type StorageHookOptions struct {
+	DB     *sql.DB
+	Config PersistenceConfig
+}
+
+// storageOp ist eine noch nicht geschriebene Änderung an broker_storage
+type storageOp struct {
+	kind    string
+	client  string
+	value   []byte // nil = löschen
+	updated int64
+}
+
+// StorageHook speichert Retained Messages, Sessions, Subscriptions und Inflight Messages in SQLite.
+// Schreibzugriffe werden gesammelt und einmal pro Sekunde in einer Transaktion geschrieben,
+// sodass z.B. häufig aktualisierte Retained-Topics nur einmal pro Intervall geschrieben werden.
+type StorageHook struct {
+	MQTT.HookBase
+	db     *sql.DB
+	config PersistenceConfig
+
+	mu      sync.Mutex
+	pending map[string]storageOp
+	purged  []string // Clients, deren Subscriptions und Inflight Messages gelöscht werden
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// ID liefert die ID des Hooks
+func (h *StorageHook) ID() string {
+	return "gateway-storage"
+}
+
+// Provides meldet die Hook-Methoden
+func (h *StorageHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		MQTT.OnSessionEstablished,
+		MQTT.OnDisconnect,
+		MQTT.OnSubscribed,
+		MQTT.OnUnsubscribed,
+		MQTT.OnRetainMessage,
+		MQTT.OnWillSent,
+		MQTT.OnQosPublish,
+		MQTT.OnQosComplete,
+		MQTT.OnQosDropped,
+		MQTT.OnSysInfoTick,
+		MQTT.OnClientExpired,
+		MQTT.OnRetainedExpired,
+		MQTT.StoredClients,
+		MQTT.StoredInflightMessages,
+		MQTT.StoredRetainedMessages,
+		MQTT.StoredSubscriptions,
+		MQTT.StoredSysInfo,
+	}, []byte{b})
+}
+
+// Init übernimmt die Datenbank, bereinigt abgelaufene Einträge und startet das Schreiben im Hintergrund
+func (h *StorageHook) Init(config any) error {
+	opts, ok := config.(*StorageHookOptions)
+	if !ok || opts == nil || opts.DB == nil {
+		return MQTT.ErrInvalidConfigType
+	}
+	h.db = opts.DB
+	h.config = opts.Config
+	h.pending = make(map[string]storageOp)
+
+	if err := h.maintain(); err != nil {
+		return err
+	}
+
+	h.stop = make(chan struct{})
+	h.done = make(chan struct{})
+	go h.run()
+	return nil
+}
+
+// Stop schreibt ausstehende Änderungen und beendet das Schreiben im Hintergrund
+func (h *StorageHook) Stop() error {
+	if h.stop == nil {
+		return nil
+	}
+	close(h.stop)
+	<-h.done
+	h.stop = nil
+	return h.flush()
+}
+
+func (h *StorageHook) run() {
+	defer close(h.done)
+	flushTicker := time.NewTicker(storageFlushInterval)
+	defer flushTicker.Stop()
+	maintenanceTicker := time.NewTicker(storageMaintenanceInterval)
+	defer maintenanceTicker.Stop()
+
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-flushTicker.C:
+			if err := h.flush(); err != nil {
+				logrus.Errorf("MQTT-Broker: Failed to write broker storage: %v", err)
+			}
+		case <-maintenanceTicker.C:
+			if err := h.maintain(); err != nil {
+				logrus.Errorf("MQTT-Broker: Failed to clean up broker storage: %v", err)
+			}
+		}
+	}
+}
+
+// set merkt einen Eintrag zum Schreiben vor
+func (h *StorageHook) set(key, kind, client string, v storage.Serializable) {
+	data, err := v.MarshalBinary()
+	if err != nil {
+		logrus.Errorf("MQTT-Broker: Failed to encode %s for broker storage: %v", key, err)
+		return
+	}
+	h.mu.Lock()
+	h.pending[key] = storageOp{kind: kind, client: client, value: data, updated: time.Now().Unix()}
+	h.mu.Unlock()
+}
+
+// del merkt das Löschen eines Eintrags vor
+func (h *StorageHook) del(key string) {
+	h.mu.Lock()
+	h.pending[key] = storageOp{}
+	h.mu.Unlock()
+}
+
+// purgeClient löscht eine Session samt Subscriptions und Inflight Messages
+func (h *StorageHook) purgeClient(clientID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// Bereits vorgemerkte Einträge des Clients sind damit hinfällig, spätere bleiben erhalten,
+	// da purged vor den übrigen Änderungen geschrieben wird.
+	for key, op := range h.pending {
+		if op.client == clientID {
+			delete(h.pending, key)
+		}
+	}
+	h.pending[clientKey(clientID)] = storageOp{}
+	h.purged = append(h.purged, clientID)
+}
+
+// flush schreibt alle vorgemerkten Änderungen in einer Transaktion
+func (h *StorageHook) flush() error {
+	h.mu.Lock()
+	pending, purged := h.pending, h.purged
+	if len(pending) == 0 && len(purged) == 0 {
+		h.mu.Unlock()
+		return nil
+	}
+	h.pending = make(map[string]storageOp)
+	h.purged = nil
+	h.mu.Unlock()
+
+	err := h.write(pending, purged)
+	if err != nil {
+		// Nicht geschriebene Änderungen erneut vormerken, neuere Änderungen haben Vorrang
+		h.mu.Lock()
+		for key, op := range pending {
+			if _, ok := h.pending[key]; !ok {
+				h.pending[key] = op
+			}
+		}
+		h.purged = append(purged, h.purged...)
+		h.mu.Unlock()
+	}
+	return err
+}
+
+func (h *StorageHook) write(pending map[string]storageOp, purged []string) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, clientID := range purged {
+		if _, err := tx.Exec(`DELETE FROM broker_storage WHERE client = ? AND kind IN (?, ?)`,
+			clientID, storage.SubscriptionKey, storage.InflightKey); err != nil {
+			return err
+		}
+	}
+
+	upsert, err := tx.Prepare(`
+		INSERT INTO broker_storage (key, kind, client, value, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`)
+	if err != nil {
+		return err
+	}
+	defer upsert.Close()
+	remove, err := tx.Prepare(`DELETE FROM broker_storage WHERE key = ?`)
+	if err != nil {
+		return err
+	}
+	defer remove.Close()
+
+	for key, op := range pending {
+		if op.value == nil {
+			_, err = remove.Exec(key)
+		} else {
+			_, err = upsert.Exec(key, op.kind, op.client, op.value, op.updated)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// storageStatement ist eine Abfrage der Bereinigung von broker_storage
+type storageStatement struct {
+	query string
+	args  []any
+}
+
+// maintain löscht abgelaufene Sessions und Nachrichten und setzt die Größenlimits durch
+func (h *StorageHook) maintain() error {
+	now := time.Now()
+	var statements []storageStatement
+
+	if h.config.SessionExpiry > 0 {
+		cutoff := now.Add(-h.config.SessionExpiry).Unix()
+		statements = append(statements,
+			storageStatement{`DELETE FROM broker_storage WHERE kind IN (?, ?) AND client IN
+				(SELECT client FROM broker_storage WHERE kind = ? AND updated_at < ?)`,
+				[]any{storage.SubscriptionKey, storage.InflightKey, storage.ClientKey, cutoff}},
+			storageStatement{`DELETE FROM broker_storage WHERE kind = ? AND updated_at < ?`,
+				[]any{storage.ClientKey, cutoff}},
+		)
+	}
+	if h.config.MessageExpiry > 0 {
+		cutoff := now.Add(-h.config.MessageExpiry).Unix()
+		statements = append(statements,
+			storageStatement{`DELETE FROM broker_storage WHERE kind IN (?, ?) AND updated_at < ?`,
+				[]any{storage.RetainedKey, storage.InflightKey, cutoff}})
+	}
+	if h.config.MaxRetained > 0 {
+		statements = append(statements,
+			storageStatement{`DELETE FROM broker_storage WHERE kind = ? AND key NOT IN
+				(SELECT key FROM broker_storage WHERE kind = ? ORDER BY updated_at DESC LIMIT ?)`,
+				[]any{storage.RetainedKey, storage.RetainedKey, h.config.MaxRetained}})
+	}
+	if h.config.MaxInflight > 0 {
+		statements = append(statements,
+			storageStatement{`DELETE FROM broker_storage WHERE key IN (SELECT key FROM
+				(SELECT key, ROW_NUMBER() OVER (PARTITION BY client ORDER BY updated_at DESC) AS n
+				 FROM broker_storage WHERE kind = ?) WHERE n > ?)`,
+				[]any{storage.InflightKey, h.config.MaxInflight}})
+	}
+
+	var removed int64
+	for _, stmt := range statements {
+		res, err := h.db.Exec(stmt.query, stmt.args...)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil {
+			removed += n
+		}
+	}
+	if removed > 0 {
+		logrus.Infof("MQTT-Broker: Removed %d expired or surplus entries from broker storage", removed)
+	}
+	return nil
+}
+
+// Schlüssel wie beim bolt-Hook von mochi
+func clientKey(clientID string) string {
+	return storage.ClientKey + "_" + clientID
+}
+
+func subscriptionKey(clientID, filter string) string {
+	return storage.SubscriptionKey + "_" + clientID + ":" + filter
+}
+
+func retainedKey(topic string) string {
+	return storage.RetainedKey + "_" + topic
+}
+
+func inflightKey(clientID string, pk packets.Packet) string {
+	return storage.InflightKey + "_" + clientID + ":" + pk.FormatID()
+}
+
+// OnSessionEstablished speichert die Session eines verbundenen Clients
+func (h *StorageHook) OnSessionEstablished(cl *MQTT.Client, pk packets.Packet) {
+	h.updateClient(cl)
+}
+
+// OnWillSent aktualisiert die Session, nachdem die Will Message versendet wurde
+func (h *StorageHook) OnWillSent(cl *MQTT.Client, pk packets.Packet) {
+	h.updateClient(cl)
+}
+
+func (h *StorageHook) updateClient(cl *MQTT.Client) {
+	props := cl.Properties.Props.Copy(false)
+	h.set(clientKey(cl.ID), storage.ClientKey, cl.ID, &storage.Client{
+		ID:              cl.ID,
+		T:               storage.ClientKey,
+		Remote:          cl.Net.Remote,
+		Listener:        cl.Net.Listener,
+		Username:        cl.Properties.Username,
+		Clean:           cl.Properties.Clean,
+		ProtocolVersion: cl.Properties.ProtocolVersion,
+		Properties: storage.ClientProperties{
+			SessionExpiryInterval:     props.SessionExpiryInterval,
+			SessionExpiryIntervalFlag: props.SessionExpiryIntervalFlag,
+			AuthenticationMethod:      props.AuthenticationMethod,
+			AuthenticationData:        props.AuthenticationData,
+			RequestProblemInfoFlag:    props.RequestProblemInfoFlag,
+			RequestProblemInfo:        props.RequestProblemInfo,
+			RequestResponseInfo:       props.RequestResponseInfo,
+			ReceiveMaximum:            props.ReceiveMaximum,
+			TopicAliasMaximum:         props.TopicAliasMaximum,
+			User:                      props.User,
+			MaximumPacketSize:         props.MaximumPacketSize,
+		},
+		Will: storage.ClientWill(cl.Properties.Will),
+	})
+}
+
+// OnDisconnect löscht Sessions ohne Persistenz, bei persistenten Sessions wird der Zeitpunkt
+// der Trennung für SessionExpiry festgehalten
+func (h *StorageHook) OnDisconnect(cl *MQTT.Client, err error, expire bool) {
+	if cl.StopCause() == packets.ErrSessionTakenOver {
+		return
+	}
+	if expire {
+		h.purgeClient(cl.ID)
+		return
+	}
+	// Aus dem Speicher geladene Clients haben keine Verbindung, ihr Zeitpunkt bleibt erhalten
+	if cl.Net.Conn != nil {
+		h.updateClient(cl)
+	}
+}
+
+// OnClientExpired löscht eine abgelaufene Session
+func (h *StorageHook) OnClientExpired(cl *MQTT.Client) {
+	h.purgeClient(cl.ID)
+}
+
+// OnSubscribed speichert die Subscriptions eines Clients.
+// Subscriptions des Inline-Clients (Web-UI, Writer) werden bei jedem Start neu angelegt und nicht gespeichert.
+func (h *StorageHook) OnSubscribed(cl *MQTT.Client, pk packets.Packet, reasonCodes []byte) {
+	if cl.Net.Inline {
+		return
+	}
+	for i, filter := range pk.Filters {
+		if i >= len(reasonCodes) || reasonCodes[i] >= packets.ErrUnspecifiedError.Code {
+			continue
+		}
+		key := subscriptionKey(cl.ID, filter.Filter)
+		h.set(key, storage.SubscriptionKey, cl.ID, &storage.Subscription{
+			ID:                key,
+			T:                 storage.SubscriptionKey,
+			Client:            cl.ID,
+			Qos:               reasonCodes[i],
+			Filter:            filter.Filter,
+			Identifier:        filter.Identifier,
+			NoLocal:           filter.NoLocal,
+			RetainHandling:    filter.RetainHandling,
+			RetainAsPublished: filter.RetainAsPublished,
+		})
+	}
+}
+
+// OnUnsubscribed löscht Subscriptions eines Clients
+func (h *StorageHook) OnUnsubscribed(cl *MQTT.Client, pk packets.Packet) {
+	if cl.Net.Inline {
+		return
+	}
+	for _, filter := range pk.Filters {
+		h.del(subscriptionKey(cl.ID, filter.Filter))
+	}
+}
+
+// OnRetainMessage speichert oder löscht (leerer Payload) eine Retained Message
+func (h *StorageHook) OnRetainMessage(cl *MQTT.Client, pk packets.Packet, r int64) {
+	key := retainedKey(pk.TopicName)
+	if r == -1 {
+		h.del(key)
+		return
+	}
+	msg := storageMessage(key, storage.RetainedKey, pk)
+	msg.Client = cl.ID
+	h.set(key, storage.RetainedKey, "", msg)
+}
+
+// OnRetainedExpired löscht eine abgelaufene Retained Message
+func (h *StorageHook) OnRetainedExpired(filter string) {
+	h.del(retainedKey(filter))
+}
+
+// OnQosPublish speichert eine QoS-1/2-Nachricht, bis sie bestätigt wurde
+func (h *StorageHook) OnQosPublish(cl *MQTT.Client, pk packets.Packet, sent int64, resends int) {
+	key := inflightKey(cl.ID, pk)
+	msg := storageMessage(key, storage.InflightKey, pk)
+	msg.Client = cl.ID
+	msg.Sent = sent
+	h.set(key, storage.InflightKey, cl.ID, msg)
+}
+
+// OnQosComplete löscht eine bestätigte Inflight Message
+func (h *StorageHook) OnQosComplete(cl *MQTT.Client, pk packets.Packet) {
+	h.del(inflightKey(cl.ID, pk))
+}
+
+// OnQosDropped löscht eine verworfene Inflight Message
+func (h *StorageHook) OnQosDropped(cl *MQTT.Client, pk packets.Packet) {
+	h.del(inflightKey(cl.ID, pk))
+}
+
+// OnSysInfoTick speichert die $SYS-Zähler
+func (h *StorageHook) OnSysInfoTick(sys *system.Info) {
+	h.set(storage.SysInfoKey, storage.SysInfoKey, "", &storage.SystemInfo{
+		ID:   storage.SysInfoKey,
+		T:    storage.SysInfoKey,
+		Info: *sys.Clone(),
+	})
+}
+
+func storageMessage(key, kind string, pk packets.Packet) *storage.Message {
+	props := pk.Properties.Copy(false)
+	return &storage.Message{
+		ID:          key,
+		T:           kind,
+		Origin:      pk.Origin,
+		FixedHeader: pk.FixedHeader,
+		TopicName:   pk.TopicName,
+		Payload:     pk.Payload,
+		Created:     pk.Created,
+		PacketID:    pk.PacketID,
+		Properties: storage.MessageProperties{
+			PayloadFormat:          props.PayloadFormat,
+			PayloadFormatFlag:      props.PayloadFormatFlag,
+			MessageExpiryInterval:  props.MessageExpiryInterval,
+			ContentType:            props.ContentType,
+			ResponseTopic:          props.ResponseTopic,
+			CorrelationData:        props.CorrelationData,
+			SubscriptionIdentifier: props.SubscriptionIdentifier,
+			TopicAlias:             props.TopicAlias,
+			User:                   props.User,
+		},
+	}
+}
+
+// stored liest alle Einträge einer Art und dekodiert sie mit decode
+func (h *StorageHook) stored(kind string, decode func(value []byte) error) error {
+	rows, err := h.db.Query(`SELECT key, value FROM broker_storage WHERE kind = ?`, kind)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var value []byte
+		if err := rows.Scan(&key, &value); err != nil {
+			return err
+		}
+		if err := decode(value); err != nil {
+			// Ein defekter Eintrag soll den Start des Brokers nicht verhindern
+			logrus.Warnf("MQTT-Broker: Skipping invalid broker storage entry %s: %v", key, err)
+		}
+	}
+	return rows.Err()
+}
+
+// StoredClients liefert die gespeicherten Sessions
+func (h *StorageHook) StoredClients() (v []storage.Client, err error) {
+	err = h.stored(storage.ClientKey, func(value []byte) error {
+		obj := storage.Client{}
+		if err := obj.UnmarshalBinary(value); err != nil {
+			return err
+		}
+		v = append(v, obj)
+		return nil
+	})
+	return v, err
+}
+
+// StoredSubscriptions liefert die gespeicherten Subscriptions
+func (h *StorageHook) StoredSubscriptions() (v []storage.Subscription, err error) {
+	err = h.stored(storage.SubscriptionKey, func(value []byte) error {
+		obj := storage.Subscription{}
+		if err := obj.UnmarshalBinary(value); err != nil {
+			return err
+		}
+		v = append(v, obj)
+		return nil
+	})
+	return v, err
+}
+
+// StoredRetainedMessages liefert die gespeicherten Retained Messages
+func (h *StorageHook) StoredRetainedMessages() (v []storage.Message, err error) {
+	err = h.stored(storage.RetainedKey, func(value []byte) error {
+		obj := storage.Message{}
+		if err := obj.UnmarshalBinary(value); err != nil {
+			return err
+		}
+		v = append(v, obj)
+		return nil
+	})
+	if err == nil {
+		logrus.Infof("MQTT-Broker: Restored %d retained messages from broker storage", len(v))
+	}
+	return v, err
+}
+
+// StoredInflightMessages liefert die gespeicherten Inflight Messages
+func (h *StorageHook) StoredInflightMessages() (v []storage.Message, err error) {
+	err = h.stored(storage.InflightKey, func(value []byte) error {
+		obj := storage.Message{}
+		if err := obj.UnmarshalBinary(value); err != nil {
+			return err
+		}
+		v = append(v, obj)
+		return nil
+	})
+	return v, err
+}
+
+// StoredSysInfo liefert die gespeicherten $SYS-Zähler
+func (h *StorageHook) StoredSysInfo() (v storage.SystemInfo, err error) {
+	err = h.stored(storage.SysInfoKey, func(value []byte) error {
+		return v.UnmarshalBinary(value)
+	})
+	return v, err
+}
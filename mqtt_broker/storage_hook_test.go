@@ -0,0 +1,198 @@
+package mqtt_broker
+
+import (
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	MQTT "github.com/mochi-mqtt/server/v2"
+)
+
+var testPersistence = PersistenceConfig{
+	Enabled:       true,
+	MaxRetained:   100,
+	MaxInflight:   100,
+	MessageExpiry: time.Hour,
+	SessionExpiry: time.Hour,
+}
+
+// persistentBroker startet einen Broker mit Auth- und Storage-Hook auf der Datenbank
+func persistentBroker(t *testing.T, db *sql.DB) (*MQTT.Server, string, func()) {
+	t.Helper()
+	s := MQTT.New(&MQTT.Options{InlineClient: true})
+	testPersistence.applyExpiry(s.Options.Capabilities)
+	if err := s.AddHook(new(AuthHook), NewAuthRepository(db)); err != nil {
+		t.Fatalf("add auth hook: %v", err)
+	}
+	if err := s.AddHook(new(StorageHook), &StorageHookOptions{DB: db, Config: testPersistence}); err != nil {
+		t.Fatalf("add storage hook: %v", err)
+	}
+	addr, stop := serveTestBroker(t, s)
+	return s, addr, stop
+}
+
+// messageRecorder sammelt empfangene Nachrichten je Topic
+type messageRecorder struct {
+	mu       sync.Mutex
+	messages map[string]paho.Message
+	arrived  chan struct{}
+}
+
+func newMessageRecorder() *messageRecorder {
+	return &messageRecorder{messages: make(map[string]paho.Message), arrived: make(chan struct{}, 100)}
+}
+
+func (r *messageRecorder) handle(_ paho.Client, msg paho.Message) {
+	r.mu.Lock()
+	r.messages[msg.Topic()] = msg
+	r.mu.Unlock()
+	r.arrived <- struct{}{}
+}
+
+// wait wartet, bis Nachrichten zu allen Topics eingetroffen sind
+func (r *messageRecorder) wait(t *testing.T, topics ...string) map[string]paho.Message {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		r.mu.Lock()
+		complete := true
+		for _, topic := range topics {
+			if _, ok := r.messages[topic]; !ok {
+				complete = false
+			}
+		}
+		if complete {
+			messages := make(map[string]paho.Message, len(r.messages))
+			for topic, msg := range r.messages {
+				messages[topic] = msg
+			}
+			r.mu.Unlock()
+			return messages
+		}
+		r.mu.Unlock()
+
+		select {
+		case <-r.arrived:
+		case <-timeout:
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			t.Fatalf("timeout waiting for %v, received %d messages", topics, len(r.messages))
+		}
+	}
+}
+
+func TestStorageHookRestoresRetainedStates(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "broker.db"))
+	addUser(t, db, "driver", "pw", nil)
+	_, addr, stop := persistentBroker(t, db)
+
+	driver, _, err := connectClient(t, addr, "driver", "driver", "pw", true)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	publish(t, driver, "driver/states/1", 1, true, "1 (running)")
+	publish(t, driver, "driver/states/2", 1, true, "2 (stopped)")
+	publish(t, driver, "driver/states/3", 1, true, "3 (error)")
+	// Leerer Payload löscht die Retained Message, sie darf nach dem Neustart nicht wieder auftauchen
+	publish(t, driver, "driver/states/3", 1, true, "")
+	driver.Disconnect(250)
+	stop()
+
+	s, addr, _ := persistentBroker(t, db)
+	if got := len(s.Topics.Messages("driver/states/#")); got != 2 {
+		t.Errorf("restored %d retained driver states, want 2", got)
+	}
+
+	recorder := newMessageRecorder()
+	reader, _, err := connectClient(t, addr, "reader", "driver", "pw", true)
+	if err != nil {
+		t.Fatalf("connect after restart: %v", err)
+	}
+	if token := reader.Subscribe("driver/states/#", 1, recorder.handle); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("subscribe: %v", token.Error())
+	}
+
+	messages := recorder.wait(t, "driver/states/1", "driver/states/2")
+	for topic, want := range map[string]string{"driver/states/1": "1 (running)", "driver/states/2": "2 (stopped)"} {
+		msg := messages[topic]
+		if string(msg.Payload()) != want {
+			t.Errorf("%s: payload %q, want %q", topic, msg.Payload(), want)
+		}
+		if !msg.Retained() {
+			t.Errorf("%s: not flagged as retained", topic)
+		}
+	}
+	if _, ok := messages["driver/states/3"]; ok {
+		t.Error("cleared retained message driver/states/3 was restored")
+	}
+}
+
+func TestStorageHookDeliversQueuedMessagesAfterRestart(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "broker.db"))
+	addUser(t, db, "app", "pw", nil)
+	addUser(t, db, "driver", "pw", nil)
+	_, addr, stop := persistentBroker(t, db)
+
+	// Persistente Session mit QoS-1-Subscription anlegen und trennen
+	subscriber, _, err := connectClient(t, addr, "app-1", "app", "pw", false)
+	if err != nil {
+		t.Fatalf("connect subscriber: %v", err)
+	}
+	if token := subscriber.Subscribe("alerts/#", 1, nil); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("subscribe: %v", token.Error())
+	}
+	subscriber.Disconnect(250)
+
+	driver, _, err := connectClient(t, addr, "driver", "driver", "pw", true)
+	if err != nil {
+		t.Fatalf("connect publisher: %v", err)
+	}
+	publish(t, driver, "alerts/boiler", 1, false, "overheat")
+	publish(t, driver, "alerts/pump", 0, false, "qos0 is not queued")
+	driver.Disconnect(250)
+	stop()
+
+	_, addr, _ = persistentBroker(t, db)
+
+	// Die Session mit gleicher Client-ID und ohne Clean Session fortsetzen
+	recorder := newMessageRecorder()
+	opts := paho.NewClientOptions().
+		AddBroker("tcp://" + addr).
+		SetClientID("app-1").
+		SetUsername("app").
+		SetPassword("pw").
+		SetCleanSession(false).
+		SetAutoReconnect(false).
+		SetDefaultPublishHandler(recorder.handle)
+	resumed := paho.NewClient(opts)
+	token := resumed.Connect()
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("reconnect subscriber: %v", token.Error())
+	}
+	t.Cleanup(func() { resumed.Disconnect(0) })
+	if !token.(*paho.ConnectToken).SessionPresent() {
+		t.Error("persistent session was not restored")
+	}
+
+	messages := recorder.wait(t, "alerts/boiler")
+	if got := string(messages["alerts/boiler"].Payload()); got != "overheat" {
+		t.Errorf("payload %q, want %q", got, "overheat")
+	}
+	if msg := messages["alerts/boiler"]; msg.Qos() != 1 {
+		t.Errorf("qos %d, want 1", msg.Qos())
+	}
+
+	// Die restaurierte Subscription muss auch für neue Nachrichten gelten
+	driver, _, err = connectClient(t, addr, "driver", "driver", "pw", true)
+	if err != nil {
+		t.Fatalf("connect publisher after restart: %v", err)
+	}
+	publish(t, driver, "alerts/valve", 1, false, "stuck")
+	messages = recorder.wait(t, "alerts/valve")
+	if _, ok := messages["alerts/pump"]; ok {
+		t.Error("QoS 0 message was queued for the offline session")
+	}
+}
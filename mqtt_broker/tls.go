@@ -0,0 +1,588 @@
+package mqtt_broker
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Namen der Zertifikate in tls_certificates
+const (
+	certNameServer   = "mqtt_server"
+	certNameClientCA = "mqtt_client_ca"
+)
+
+// Modi der Client-Zertifikatsprüfung auf den TLS-Listenern (MQTT_TLS_CLIENT_AUTH)
+const (
+	ClientAuthOff      = "off"
+	ClientAuthOptional = "optional" // Zertifikat wird geprüft, falls der Client eins schickt
+	ClientAuthRequired = "required" // Ohne gültiges Client-Zertifikat keine Verbindung
+)
+
+// Herkunft eines Zertifikats
+const (
+	certSourceFile      = "file"
+	certSourceGenerated = "generated"
+	certSourceUploaded  = "uploaded"
+)
+
+const (
+	certExpiryWarning = 30 * 24 * time.Hour // Warnung, wenn ein Zertifikat innerhalb dieser Zeit abläuft
+	certWatchInterval = time.Minute
+)
+
+// ErrCertsFromFiles wird zurückgegeben, wenn Zertifikate per Datei konfiguriert sind und nicht per API geändert werden können
+var ErrCertsFromFiles = errors.New("certificates are configured via files (MQTT_TLS_CERT/MQTT_TLS_KEY/MQTT_TLS_CA)")
+
+// CertInfo beschreibt ein Zertifikat für die Web-UI
+type CertInfo struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+	DaysLeft    int       `json:"daysLeft"`
+	Fingerprint string    `json:"fingerprint"` // SHA-256
+	DNSNames    []string  `json:"dnsNames,omitempty"`
+	IPAddresses []string  `json:"ipAddresses,omitempty"`
+	Source      string    `json:"source"`
+}
+
+// TLSStatus ist der Zustand der Broker-Zertifikate
+type TLSStatus struct {
+	Server     *CertInfo  `json:"server"`
+	ClientCAs  []CertInfo `json:"clientCAs"`
+	ClientAuth string     `json:"clientAuth"`
+}
+
+// CertManager verwaltet Serverzertifikat und Client-CA des Brokers. Die TLS-Konfiguration liest
+// bei jedem Handshake den aktuellen Stand, ein Austausch greift daher ohne Neustart.
+type CertManager struct {
+	db         *sql.DB
+	clientAuth string
+	certFile   string
+	keyFile    string
+	caFile     string
+
+	mu           sync.RWMutex
+	server       *tls.Certificate
+	serverSource string
+	caCerts      []*x509.Certificate
+	caPool       *x509.CertPool
+	caSource     string
+	modTimes     map[string]time.Time
+	warned       map[string]time.Time // Letzte Ablaufwarnung je Fingerprint
+}
+
+// NewCertManager liest die Konfiguration aus den Umgebungsvariablen und lädt die Zertifikate
+func NewCertManager(db *sql.DB) (*CertManager, error) {
+	m := &CertManager{
+		db:         db,
+		clientAuth: strings.ToLower(os.Getenv("MQTT_TLS_CLIENT_AUTH")),
+		certFile:   os.Getenv("MQTT_TLS_CERT"),
+		keyFile:    os.Getenv("MQTT_TLS_KEY"),
+		caFile:     os.Getenv("MQTT_TLS_CA"),
+		modTimes:   make(map[string]time.Time),
+		warned:     make(map[string]time.Time),
+	}
+	switch m.clientAuth {
+	case "":
+		m.clientAuth = ClientAuthOff
+	case ClientAuthOff, ClientAuthOptional, ClientAuthRequired:
+	default:
+		return nil, fmt.Errorf("invalid MQTT_TLS_CLIENT_AUTH %q (off, optional, required)", m.clientAuth)
+	}
+	if (m.certFile == "") != (m.keyFile == "") {
+		return nil, fmt.Errorf("MQTT_TLS_CERT and MQTT_TLS_KEY must be set together")
+	}
+
+	if err := m.loadServer(); err != nil {
+		return nil, err
+	}
+	if err := m.loadClientCA(); err != nil {
+		return nil, err
+	}
+	if m.clientAuth != ClientAuthOff && m.caPool == nil {
+		logrus.Warnf("MQTT-Broker: MQTT_TLS_CLIENT_AUTH=%s, but no client CA is configured. Client certificates are rejected until a CA is uploaded.", m.clientAuth)
+	}
+	m.checkExpiry()
+	return m, nil
+}
+
+// TLSConfig liefert die Konfiguration für die TLS-Listener mit dem Client-Zertifikatsmodus aus MQTT_TLS_CLIENT_AUTH
+func (m *CertManager) TLSConfig() *tls.Config {
+	return m.TLSConfigWithClientAuth("")
+}
+
+// TLSConfigWithClientAuth liefert eine TLS-Konfiguration mit festem Client-Zertifikatsmodus
+// (off, optional, required). Ein leerer Modus übernimmt MQTT_TLS_CLIENT_AUTH.
+func (m *CertManager) TLSConfigWithClientAuth(clientAuth string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return m.configForClient(clientAuth)
+		},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			m.mu.RLock()
+			defer m.mu.RUnlock()
+			return m.server, nil
+		},
+	}
+}
+
+func (m *CertManager) configForClient(clientAuth string) (*tls.Config, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if clientAuth == "" {
+		clientAuth = m.clientAuth
+	}
+
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*m.server},
+	}
+	switch clientAuth {
+	case ClientAuthOptional:
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequired:
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if cfg.ClientAuth != tls.NoClientCert {
+		// Ohne CA einen leeren Pool verwenden, damit kein Zertifikat gegen die System-CAs akzeptiert wird
+		cfg.ClientCAs = m.caPool
+		if cfg.ClientCAs == nil {
+			cfg.ClientCAs = x509.NewCertPool()
+		}
+	}
+	return cfg, nil
+}
+
+// Status liefert Serverzertifikat, Client-CAs und Modus
+func (m *CertManager) Status() TLSStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	status := TLSStatus{ClientAuth: m.clientAuth, ClientCAs: []CertInfo{}}
+	if m.server != nil && m.server.Leaf != nil {
+		info := certInfo(m.server.Leaf, m.serverSource)
+		status.Server = &info
+	}
+	for _, ca := range m.caCerts {
+		status.ClientCAs = append(status.ClientCAs, certInfo(ca, m.caSource))
+	}
+	return status
+}
+
+// ServerCertificatePEM liefert das Serverzertifikat zum Pinnen in Clients
+func (m *CertManager) ServerCertificatePEM() []byte {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var out []byte
+	for _, der := range m.server.Certificate {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	return out
+}
+
+// SetServerCertificate tauscht das Serverzertifikat gegen ein hochgeladenes aus
+func (m *CertManager) SetServerCertificate(certPEM, keyPEM []byte) (*CertInfo, error) {
+	if m.certFile != "" {
+		return nil, ErrCertsFromFiles
+	}
+	cert, err := parseKeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(cert.Leaf.NotAfter) {
+		return nil, fmt.Errorf("certificate expired on %s", cert.Leaf.NotAfter.Format(time.RFC3339))
+	}
+	if err := m.store(certNameServer, certPEM, keyPEM, certSourceUploaded); err != nil {
+		return nil, err
+	}
+	return m.swapServer(cert, certSourceUploaded), nil
+}
+
+// RegenerateServerCertificate erzeugt ein neues selbstsigniertes Serverzertifikat
+func (m *CertManager) RegenerateServerCertificate() (*CertInfo, error) {
+	if m.certFile != "" {
+		return nil, ErrCertsFromFiles
+	}
+	cert, err := m.generateServer()
+	if err != nil {
+		return nil, err
+	}
+	return m.swapServer(cert, certSourceGenerated), nil
+}
+
+// SetClientCA ersetzt die CA(s), gegen die Client-Zertifikate geprüft werden
+func (m *CertManager) SetClientCA(caPEM []byte) ([]CertInfo, error) {
+	if m.caFile != "" {
+		return nil, ErrCertsFromFiles
+	}
+	certs, err := parseCertificates(caPEM)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.store(certNameClientCA, caPEM, nil, certSourceUploaded); err != nil {
+		return nil, err
+	}
+	m.swapClientCA(certs, certSourceUploaded)
+	return m.Status().ClientCAs, nil
+}
+
+// RemoveClientCA entfernt die hochgeladene Client-CA
+func (m *CertManager) RemoveClientCA() error {
+	if m.caFile != "" {
+		return ErrCertsFromFiles
+	}
+	if _, err := m.db.Exec(`DELETE FROM tls_certificates WHERE name = ?`, certNameClientCA); err != nil {
+		return err
+	}
+	m.swapClientCA(nil, "")
+	return nil
+}
+
+// Watch lädt per Datei konfigurierte Zertifikate bei Änderungen neu und warnt vor dem Ablauf
+func (m *CertManager) Watch(stop <-chan struct{}) {
+	ticker := time.NewTicker(certWatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if m.certFile != "" && (m.changed(m.certFile) || m.changed(m.keyFile)) {
+				if err := m.loadServer(); err != nil {
+					logrus.Errorf("MQTT-Broker: Failed to reload server certificate: %v", err)
+				} else {
+					logrus.Info("MQTT-Broker: Server certificate reloaded from file")
+				}
+			}
+			if m.caFile != "" && m.changed(m.caFile) {
+				if err := m.loadClientCA(); err != nil {
+					logrus.Errorf("MQTT-Broker: Failed to reload client CA: %v", err)
+				} else {
+					logrus.Info("MQTT-Broker: Client CA reloaded from file")
+				}
+			}
+			m.checkExpiry()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// loadServer lädt das Serverzertifikat aus den Dateien, der Datenbank oder erzeugt ein neues
+func (m *CertManager) loadServer() error {
+	if m.certFile != "" {
+		certPEM, err := os.ReadFile(m.certFile)
+		if err != nil {
+			return err
+		}
+		keyPEM, err := os.ReadFile(m.keyFile)
+		if err != nil {
+			return err
+		}
+		cert, err := parseKeyPair(certPEM, keyPEM)
+		if err != nil {
+			return fmt.Errorf("%s: %v", m.certFile, err)
+		}
+		m.changed(m.certFile)
+		m.changed(m.keyFile)
+		m.swapServer(cert, certSourceFile)
+		return nil
+	}
+
+	var certPEM, keyPEM, source string
+	err := m.db.QueryRow(`SELECT certificate, COALESCE(private_key, ''), source FROM tls_certificates WHERE name = ?`, certNameServer).
+		Scan(&certPEM, &keyPEM, &source)
+	if err == nil {
+		cert, err := parseKeyPair([]byte(certPEM), []byte(keyPEM))
+		if err != nil {
+			return fmt.Errorf("stored server certificate: %v", err)
+		}
+		m.swapServer(cert, source)
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	cert, err := m.generateServer()
+	if err != nil {
+		return err
+	}
+	m.swapServer(cert, certSourceGenerated)
+	logrus.Infof("MQTT-Broker: Generated self-signed server certificate (SHA-256 %s)", fingerprint(cert.Leaf))
+	return nil
+}
+
+// loadClientCA lädt die Client-CA aus der Datei oder der Datenbank
+func (m *CertManager) loadClientCA() error {
+	if m.caFile != "" {
+		caPEM, err := os.ReadFile(m.caFile)
+		if err != nil {
+			return err
+		}
+		certs, err := parseCertificates(caPEM)
+		if err != nil {
+			return fmt.Errorf("%s: %v", m.caFile, err)
+		}
+		m.changed(m.caFile)
+		m.swapClientCA(certs, certSourceFile)
+		return nil
+	}
+
+	var caPEM, source string
+	err := m.db.QueryRow(`SELECT certificate, source FROM tls_certificates WHERE name = ?`, certNameClientCA).Scan(&caPEM, &source)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	certs, err := parseCertificates([]byte(caPEM))
+	if err != nil {
+		return fmt.Errorf("stored client CA: %v", err)
+	}
+	m.swapClientCA(certs, source)
+	return nil
+}
+
+// generateServer erzeugt ein selbstsigniertes Serverzertifikat und speichert es in der Datenbank
+func (m *CertManager) generateServer() (*tls.Certificate, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:         "iot-gateway",
+			Organization:       []string{"HS Ansbach"},
+			OrganizationalUnit: []string{"IDPM"},
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(5 * 365 * 24 * time.Hour), // für 5 Jahre gültig
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+	}
+	if hostname != "" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+	// Zusätzliche Namen/Adressen, unter denen der Broker erreichbar ist
+	for _, host := range strings.Split(os.Getenv("MQTT_TLS_HOSTS"), ",") {
+		if host = strings.TrimSpace(host); host == "" {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	cert, err := parseKeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.store(certNameServer, certPEM, keyPEM, certSourceGenerated); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// store speichert ein Zertifikat in tls_certificates
+func (m *CertManager) store(name string, certPEM, keyPEM []byte, source string) error {
+	var key interface{}
+	if keyPEM != nil {
+		key = string(keyPEM)
+	}
+	_, err := m.db.Exec(`INSERT INTO tls_certificates (name, certificate, private_key, source, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET certificate = excluded.certificate, private_key = excluded.private_key,
+		source = excluded.source, updated_at = excluded.updated_at`,
+		name, string(certPEM), key, source, time.Now().Format(time.RFC3339))
+	return err
+}
+
+func (m *CertManager) swapServer(cert *tls.Certificate, source string) *CertInfo {
+	m.mu.Lock()
+	m.server = cert
+	m.serverSource = source
+	m.mu.Unlock()
+
+	info := certInfo(cert.Leaf, source)
+	return &info
+}
+
+func (m *CertManager) swapClientCA(certs []*x509.Certificate, source string) {
+	var pool *x509.CertPool
+	if len(certs) > 0 {
+		pool = x509.NewCertPool()
+		for _, c := range certs {
+			pool.AddCert(c)
+		}
+	}
+
+	m.mu.Lock()
+	m.caCerts = certs
+	m.caPool = pool
+	m.caSource = source
+	m.mu.Unlock()
+}
+
+// changed meldet, ob sich eine Datei seit dem letzten Aufruf geändert hat
+func (m *CertManager) changed(path string) bool {
+	st, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if st.ModTime().Equal(m.modTimes[path]) {
+		return false
+	}
+	m.modTimes[path] = st.ModTime()
+	return true
+}
+
+// checkExpiry warnt einmal täglich vor ablaufenden oder abgelaufenen Zertifikaten
+func (m *CertManager) checkExpiry() {
+	status := m.Status()
+	certs := status.ClientCAs
+	if status.Server != nil {
+		certs = append([]CertInfo{*status.Server}, certs...)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range certs {
+		left := time.Until(c.NotAfter)
+		if left > certExpiryWarning || time.Since(m.warned[c.Fingerprint]) < 24*time.Hour {
+			continue
+		}
+		m.warned[c.Fingerprint] = time.Now()
+		if left <= 0 {
+			logrus.Errorf("MQTT-Broker: Certificate %q (%s) expired on %s", c.Subject, c.Source, c.NotAfter.Format(time.RFC3339))
+		} else {
+			logrus.Warnf("MQTT-Broker: Certificate %q (%s) expires in %d days (%s)", c.Subject, c.Source, c.DaysLeft, c.NotAfter.Format(time.RFC3339))
+		}
+	}
+}
+
+func parseKeyPair(certPEM, keyPEM []byte) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+	return &cert, nil
+}
+
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM certificate found")
+	}
+	return certs, nil
+}
+
+func certInfo(c *x509.Certificate, source string) CertInfo {
+	info := CertInfo{
+		Subject:     c.Subject.String(),
+		Issuer:      c.Issuer.String(),
+		NotBefore:   c.NotBefore,
+		NotAfter:    c.NotAfter,
+		DaysLeft:    int(time.Until(c.NotAfter).Hours() / 24),
+		Fingerprint: fingerprint(c),
+		DNSNames:    c.DNSNames,
+		Source:      source,
+	}
+	for _, ip := range c.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	return info
+}
+
+func fingerprint(c *x509.Certificate) string {
+	sum := sha256.Sum256(c.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// peerCommonName liefert den CN eines gegen die Client-CA geprüften Client-Zertifikats
+func peerCommonName(conn net.Conn) (string, bool) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		// Der Websocket-Listener verpackt die Verbindung in einen eigenen Typ mit eingebettetem net.Conn
+		v := reflect.ValueOf(conn)
+		if v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Struct {
+			if f := v.Elem().FieldByName("Conn"); f.IsValid() && f.CanInterface() {
+				tlsConn, ok = f.Interface().(*tls.Conn)
+			}
+		}
+		if !ok {
+			return "", false
+		}
+	}
+
+	state := tlsConn.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	cn := state.VerifiedChains[0][0].Subject.CommonName
+	return cn, cn != ""
+}
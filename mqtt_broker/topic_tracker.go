@@ -0,0 +1,194 @@
+package mqtt_broker
+
+import (
+	"bytes"
+	"encoding/base64"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	MQTT "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	maxTrackedTopics  = 20000 // Weitere Topics werden nicht mehr in den Topic-Baum aufgenommen
+	maxTrackedPayload = 1024  // Gespeicherte Bytes der letzten Nachricht je Topic
+)
+
+// trackedMessage ist die letzte beobachtete Nachricht eines Topics
+type trackedMessage struct {
+	payload   []byte // Gekürzt auf maxTrackedPayload
+	size      int
+	qos       byte
+	retain    bool
+	timestamp time.Time
+	count     int64
+}
+
+// TopicMessage ist die letzte Nachricht eines Topics im Topic-Baum
+type TopicMessage struct {
+	Payload         string    `json:"payload"`
+	PayloadEncoding string    `json:"payloadEncoding"` // utf8 oder base64
+	Truncated       bool      `json:"truncated,omitempty"`
+	Size            int       `json:"size"`
+	Qos             byte      `json:"qos"`
+	Retain          bool      `json:"retain"`
+	Timestamp       time.Time `json:"timestamp"`
+	Count           int64     `json:"count"` // Anzahl der beobachteten Nachrichten (0 = nur Retained Message)
+}
+
+// EncodePayload liefert einen Payload als Text, binäre Payloads Base64-kodiert
+func EncodePayload(payload []byte) (string, string) {
+	if utf8.Valid(payload) {
+		return string(payload), "utf8"
+	}
+	return base64.StdEncoding.EncodeToString(payload), "base64"
+}
+
+func newTopicMessage(payload []byte, size int, qos byte, retain bool, timestamp time.Time, count int64) TopicMessage {
+	if len(payload) > maxTrackedPayload {
+		payload = payload[:maxTrackedPayload]
+	}
+	msg := TopicMessage{
+		Truncated: size > len(payload),
+		Size:      size,
+		Qos:       qos,
+		Retain:    retain,
+		Timestamp: timestamp,
+		Count:     count,
+	}
+	msg.Payload, msg.PayloadEncoding = EncodePayload(payload)
+	return msg
+}
+
+// TopicTrackerHook merkt sich die letzte Nachricht aller veröffentlichten Topics für den Topic-Explorer
+type TopicTrackerHook struct {
+	MQTT.HookBase
+	mu     sync.RWMutex
+	topics map[string]*trackedMessage
+	full   bool
+}
+
+// ID liefert die ID des Hooks
+func (h *TopicTrackerHook) ID() string {
+	return "gateway-topic-tracker"
+}
+
+// Provides meldet die Hook-Methoden
+func (h *TopicTrackerHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		MQTT.OnPublished,
+		MQTT.OnRetainMessage,
+	}, []byte{b})
+}
+
+// Init legt die Topic-Liste an
+func (h *TopicTrackerHook) Init(config any) error {
+	h.topics = make(map[string]*trackedMessage)
+	return nil
+}
+
+// OnPublished merkt sich die Nachricht als letzte Nachricht des Topics
+func (h *TopicTrackerHook) OnPublished(cl *MQTT.Client, pk packets.Packet) {
+	size := len(pk.Payload)
+	payload := pk.Payload
+	if size > maxTrackedPayload {
+		payload = payload[:maxTrackedPayload]
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	msg, ok := h.topics[pk.TopicName]
+	if !ok {
+		if len(h.topics) >= maxTrackedTopics {
+			if !h.full {
+				h.full = true
+				logrus.Warnf("MQTT-Broker: Topic explorer tracks at most %d topics, further topics are ignored", maxTrackedTopics)
+			}
+			return
+		}
+		msg = &trackedMessage{}
+		h.topics[pk.TopicName] = msg
+	}
+	msg.payload = append(msg.payload[:0], payload...)
+	msg.size = size
+	msg.qos = pk.FixedHeader.Qos
+	msg.retain = pk.FixedHeader.Retain
+	msg.timestamp = time.Now()
+	msg.count++
+}
+
+// OnRetainMessage entfernt Topics, deren Retained Message gelöscht wurde (leerer Payload)
+func (h *TopicTrackerHook) OnRetainMessage(cl *MQTT.Client, pk packets.Packet, r int64) {
+	if r != -1 {
+		return
+	}
+	h.mu.Lock()
+	delete(h.topics, pk.TopicName)
+	h.mu.Unlock()
+}
+
+// TopicNode ist ein Knoten im Topic-Baum. Message ist nur gesetzt, wenn auf dem Topic selbst
+// eine Nachricht beobachtet wurde oder eine Retained Message existiert.
+type TopicNode struct {
+	Name     string        `json:"name"`
+	Topic    string        `json:"topic"`
+	Message  *TopicMessage `json:"message,omitempty"`
+	Topics   int           `json:"topics"` // Anzahl der Topics mit Nachricht in diesem Teilbaum
+	Children []*TopicNode  `json:"children,omitempty"`
+}
+
+// TopicTree baut den Topic-Baum aus beobachteten Topics und Retained Messages des Brokers.
+// prefix schränkt auf Topics ein, die mit prefix beginnen (z.B. "data/s7").
+func TopicTree(s *MQTT.Server, prefix string) *TopicNode {
+	messages := make(map[string]TopicMessage)
+	for topic, pk := range s.Topics.Retained.GetAll() {
+		if strings.HasPrefix(topic, prefix) {
+			messages[topic] = newTopicMessage(pk.Payload, len(pk.Payload), pk.FixedHeader.Qos, true, time.Unix(pk.Created, 0), 0)
+		}
+	}
+	if topicTracker != nil {
+		topicTracker.mu.RLock()
+		for topic, msg := range topicTracker.topics {
+			if strings.HasPrefix(topic, prefix) {
+				_, retained := messages[topic]
+				messages[topic] = newTopicMessage(msg.payload, msg.size, msg.qos, msg.retain || retained, msg.timestamp, msg.count)
+			}
+		}
+		topicTracker.mu.RUnlock()
+	}
+
+	root := &TopicNode{}
+	nodes := make(map[string]*TopicNode)
+	for topic, msg := range messages {
+		node := root
+		levels := strings.Split(topic, "/")
+		for i, level := range levels {
+			path := strings.Join(levels[:i+1], "/")
+			child, ok := nodes[path]
+			if !ok {
+				child = &TopicNode{Name: level, Topic: path}
+				nodes[path] = child
+				node.Children = append(node.Children, child)
+			}
+			node.Topics++
+			node = child
+		}
+		m := msg
+		node.Message = &m
+		node.Topics++
+	}
+	root.sort()
+	return root
+}
+
+func (n *TopicNode) sort() {
+	sort.Slice(n.Children, func(i, j int) bool { return n.Children[i].Name < n.Children[j].Name })
+	for _, c := range n.Children {
+		c.sort()
+	}
+}
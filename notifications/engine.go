@@ -0,0 +1,352 @@
+package notifications
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"iot-gateway/topics"
+
+	MQTT "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	queueSize      = 100
+	deviceSeverity = 800 // Severity für Verbindungsverlust und -wiederherstellung
+)
+
+var (
+	mu       sync.Mutex
+	db       *sql.DB
+	server   *MQTT.Server
+	queue    chan Notification
+	stopChan chan struct{}
+	seeding  bool // Beim Abonnieren zugestellte Retained-Nachrichten lösen keine Meldungen aus
+
+	offlineDevices = make(map[int64]bool)   // Gerät -> aktuell ohne Verbindung
+	alarmStates    = make(map[int64]string) // Alarm-ID -> zuletzt bekannter Zustand
+	lastSent       = make(map[string]time.Time)
+	sentTimes      = make(map[int64][]time.Time) // Kanal -> Zustellungen der letzten Stunde
+)
+
+// alarmMessage ist der Teil eines alarms/#-Payloads, der für Meldungen benötigt wird
+type alarmMessage struct {
+	ID       int64  `json:"id"`
+	RuleID   int64  `json:"ruleId"`
+	RuleName string `json:"ruleName"`
+	Source   string `json:"source"`
+	State    string `json:"state"`
+	Severity int    `json:"severity"`
+	Message  string `json:"message"`
+	Value    string `json:"value"`
+}
+
+// StartNotifier abonniert Gerätestatus und Alarme und stellt daraus Meldungen über die konfigurierten Kanäle zu
+func StartNotifier(dbF *sql.DB, serverF *MQTT.Server) {
+	mu.Lock()
+	db = dbF
+	server = serverF
+	queue = make(chan Notification, queueSize)
+	stopChan = make(chan struct{})
+	seeding = true
+	mu.Unlock()
+
+	pruneLog()
+	go worker(queue, stopChan)
+
+	if err := server.Subscribe("driver/states/#", topics.NotifyStateSubscriptionID, func(cl *MQTT.Client, sub packets.Subscription, pk packets.Packet) {
+		handleDeviceState(pk.TopicName, string(pk.Payload))
+	}); err != nil {
+		logrus.Errorf("NOTIFY: Error subscribing to topic driver/states/#: %v", err)
+	}
+	if err := server.Subscribe("alarms/#", topics.NotifyAlarmSubscriptionID, func(cl *MQTT.Client, sub packets.Subscription, pk packets.Packet) {
+		handleAlarm(pk.Payload)
+	}); err != nil {
+		logrus.Errorf("NOTIFY: Error subscribing to topic alarms/#: %v", err)
+	}
+
+	mu.Lock()
+	seeding = false
+	mu.Unlock()
+
+	logrus.Info("NOTIFY: Notifier started.")
+}
+
+// StopNotifier beendet die Zustellung
+func StopNotifier() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if server != nil {
+		server.Unsubscribe("driver/states/#", topics.NotifyStateSubscriptionID)
+		server.Unsubscribe("alarms/#", topics.NotifyAlarmSubscriptionID)
+	}
+	if stopChan != nil {
+		close(stopChan)
+		stopChan = nil
+	}
+	logrus.Info("NOTIFY: Notifier stopped.")
+}
+
+// handleDeviceState meldet den Übergang eines Geräts in "5 (no connection)" / "6 (connection lost)" und die Wiederherstellung
+func handleDeviceState(topic, status string) {
+	parts := strings.Split(topic, "/")
+	if len(parts) != 4 {
+		return
+	}
+	deviceID, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return
+	}
+
+	mu.Lock()
+	wasOffline := offlineDevices[deviceID]
+	isOffline := strings.HasPrefix(status, "5") || strings.HasPrefix(status, "6")
+	isOnline := strings.HasPrefix(status, "1")
+	if isOffline {
+		offlineDevices[deviceID] = true
+	} else if isOnline {
+		delete(offlineDevices, deviceID)
+	}
+	skip := seeding
+	mu.Unlock()
+
+	if skip {
+		return
+	}
+
+	var event string
+	switch {
+	case isOffline && !wasOffline:
+		event = EventDeviceOffline
+	case isOnline && wasOffline:
+		event = EventDeviceOnline
+	default:
+		return
+	}
+
+	name, deviceType := deviceInfo(deviceID)
+	if deviceType == "" {
+		deviceType = parts[2]
+	}
+	n := Notification{
+		Event:      event,
+		Severity:   deviceSeverity,
+		DeviceID:   deviceID,
+		DeviceName: name,
+		DeviceType: deviceType,
+		Status:     status,
+		Time:       time.Now(),
+		dedupKey:   fmt.Sprintf("%s:%d", event, deviceID),
+	}
+	if event == EventDeviceOffline {
+		n.Title = fmt.Sprintf("Device %s offline", name)
+		n.Message = fmt.Sprintf("Device %s (ID %d) lost its connection: %s", name, deviceID, status)
+	} else {
+		n.Title = fmt.Sprintf("Device %s online", name)
+		n.Message = fmt.Sprintf("Device %s (ID %d) is connected again.", name, deviceID)
+	}
+	enqueue(n)
+}
+
+// handleAlarm meldet neue und beendete Alarme des Alarmsystems
+func handleAlarm(payload []byte) {
+	var alarm alarmMessage
+	if err := json.Unmarshal(payload, &alarm); err != nil || alarm.ID == 0 {
+		return
+	}
+
+	mu.Lock()
+	previous, known := alarmStates[alarm.ID]
+	if alarm.State == "cleared" {
+		delete(alarmStates, alarm.ID)
+	} else {
+		alarmStates[alarm.ID] = alarm.State
+	}
+	skip := seeding
+	mu.Unlock()
+
+	if skip {
+		return
+	}
+
+	var event string
+	switch {
+	case alarm.State == "active" && !known:
+		event = EventAlarmActive
+	case alarm.State == "cleared" && previous != "cleared":
+		event = EventAlarmCleared
+	default:
+		return
+	}
+
+	n := Notification{
+		Event:    event,
+		Message:  alarm.Message,
+		Severity: alarm.Severity,
+		Source:   alarm.Source,
+		Value:    alarm.Value,
+		Status:   alarm.State,
+		Time:     time.Now(),
+		dedupKey: fmt.Sprintf("%s:%d", event, alarm.ID),
+	}
+	if event == EventAlarmActive {
+		n.Title = fmt.Sprintf("Alarm: %s", alarm.RuleName)
+	} else {
+		n.Title = fmt.Sprintf("Alarm cleared: %s", alarm.RuleName)
+	}
+	// Source hat die Form <type>/<deviceId>/<datapointId>
+	if parts := strings.Split(alarm.Source, "/"); len(parts) >= 2 {
+		if id, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+			n.DeviceID = id
+			n.DeviceName, n.DeviceType = deviceInfo(id)
+		}
+	}
+	enqueue(n)
+}
+
+// enqueue übergibt eine Meldung an den Worker, ohne den MQTT-Callback zu blockieren
+func enqueue(n Notification) {
+	select {
+	case queue <- n:
+	default:
+		logrus.Warnf("NOTIFY: Queue full, dropping notification %q", n.Title)
+	}
+}
+
+// worker stellt Meldungen nacheinander zu
+func worker(queue chan Notification, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case n := <-queue:
+			dispatch(&n)
+		}
+	}
+}
+
+// dispatch stellt eine Meldung über alle Kanäle zu, deren Regeln passen
+func dispatch(n *Notification) {
+	channels, err := queryChannels("WHERE enabled = 1")
+	if err != nil {
+		logrus.Errorf("NOTIFY: Error loading channels: %v", err)
+		return
+	}
+	rules, err := queryRules("WHERE enabled = 1")
+	if err != nil {
+		logrus.Errorf("NOTIFY: Error loading rules: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for i := range channels {
+		ch := &channels[i]
+		matched := false
+		for j := range rules {
+			if rules[j].ChannelID == ch.ID && rules[j].matches(n, now) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		if isDuplicate(ch, n, now) {
+			logrus.Debugf("NOTIFY: Suppressing duplicate %q on channel %s", n.Title, ch.Name)
+			continue
+		}
+		if !allowRate(ch, now) {
+			logrus.Warnf("NOTIFY: Rate limit reached for channel %s, dropping %q", ch.Name, n.Title)
+			writeLog(ch, n, StatusRateLimited, nil)
+			continue
+		}
+
+		// Erst eine erfolgreiche Zustellung unterdrückt Wiederholungen, damit verworfene oder
+		// fehlgeschlagene Meldungen beim nächsten Auftreten erneut zugestellt werden
+		if err := deliver(ch, n); err == nil {
+			recordSent(ch, n, now)
+		}
+	}
+}
+
+// deliver sendet eine Meldung und protokolliert das Ergebnis
+func deliver(ch *Channel, n *Notification) error {
+	err := send(ch, n)
+	if err != nil {
+		logrus.Errorf("NOTIFY: Error sending %q via channel %s: %v", n.Title, ch.Name, err)
+		writeLog(ch, n, StatusFailed, err)
+		return err
+	}
+	logrus.Infof("NOTIFY: Sent %q via channel %s", n.Title, ch.Name)
+	writeLog(ch, n, StatusSent, nil)
+	return nil
+}
+
+// isDuplicate prüft, ob dieselbe Meldung innerhalb des Dedup-Zeitraums bereits über den Kanal ging
+func isDuplicate(ch *Channel, n *Notification, now time.Time) bool {
+	if ch.DedupSeconds == 0 || n.dedupKey == "" {
+		return false
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	last, ok := lastSent[dedupKey(ch, n)]
+	return ok && now.Sub(last) < time.Duration(ch.DedupSeconds)*time.Second
+}
+
+// recordSent merkt sich eine erfolgreich zugestellte Meldung für isDuplicate
+func recordSent(ch *Channel, n *Notification, now time.Time) {
+	if ch.DedupSeconds == 0 || n.dedupKey == "" {
+		return
+	}
+	mu.Lock()
+	lastSent[dedupKey(ch, n)] = now
+	mu.Unlock()
+}
+
+func dedupKey(ch *Channel, n *Notification) string {
+	return fmt.Sprintf("%d:%s", ch.ID, n.dedupKey)
+}
+
+// allowRate prüft das Limit an Zustellungen pro Stunde
+func allowRate(ch *Channel, now time.Time) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	recent := sentTimes[ch.ID][:0]
+	for _, t := range sentTimes[ch.ID] {
+		if now.Sub(t) < time.Hour {
+			recent = append(recent, t)
+		}
+	}
+	if ch.RateLimitPerHour > 0 && len(recent) >= ch.RateLimitPerHour {
+		sentTimes[ch.ID] = recent
+		return false
+	}
+	sentTimes[ch.ID] = append(recent, now)
+	return true
+}
+
+// SendTest sendet eine Testmeldung über einen Kanal, unabhängig von Regeln und Limits
+func SendTest(channelID int64) error {
+	ch, err := getChannel(channelID)
+	if err != nil {
+		return err
+	}
+	n := Notification{
+		Event:    EventTest,
+		Title:    "Test notification",
+		Message:  fmt.Sprintf("Test notification for channel %s.", ch.Name),
+		Severity: 0,
+		Time:     time.Now(),
+	}
+	return deliver(ch, &n)
+}
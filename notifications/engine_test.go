@@ -0,0 +1,320 @@
+package notifications
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"iot-gateway/logic"
+)
+
+// stubChannel ist ein Webhook-Empfänger, der alle zugestellten Meldungen aufzeichnet
+type stubChannel struct {
+	mu       sync.Mutex
+	received []Notification
+	status   int
+	server   *httptest.Server
+}
+
+func newStubChannel(t *testing.T, status int) *stubChannel {
+	t.Helper()
+	stub := &stubChannel{status: status}
+	stub.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n Notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Errorf("stub channel: invalid body: %v", err)
+		}
+		stub.mu.Lock()
+		stub.received = append(stub.received, n)
+		status := stub.status
+		stub.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+func (s *stubChannel) setStatus(status int) {
+	s.mu.Lock()
+	s.status = status
+	s.mu.Unlock()
+}
+
+func (s *stubChannel) titles() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	titles := []string{}
+	for _, n := range s.received {
+		titles = append(titles, n.Title)
+	}
+	return titles
+}
+
+// setupNotifier initialisiert eine leere Datenbank und setzt den Zustand des Notifiers zurück
+func setupNotifier(t *testing.T) {
+	t.Helper()
+	testDB, err := logic.InitDB(filepath.Join(t.TempDir(), "notifications.db"))
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() { testDB.Close() })
+
+	mu.Lock()
+	db = testDB
+	queue = make(chan Notification, queueSize)
+	seeding = false
+	offlineDevices = make(map[int64]bool)
+	alarmStates = make(map[int64]string)
+	lastSent = make(map[string]time.Time)
+	sentTimes = make(map[int64][]time.Time)
+	mu.Unlock()
+}
+
+// addChannel legt einen Webhook-Kanal auf den Stub mit einer Regel an
+func addChannel(t *testing.T, name string, stub *stubChannel, rateLimit int, rule Rule) *Channel {
+	t.Helper()
+	ch := &Channel{
+		Name:             name,
+		Type:             ChannelWebhook,
+		Config:           ChannelConfig{URL: stub.server.URL},
+		RateLimitPerHour: rateLimit,
+		Enabled:          true,
+	}
+	if err := CreateChannel(ch); err != nil {
+		t.Fatalf("CreateChannel: %v", err)
+	}
+	rule.ChannelID = ch.ID
+	rule.Enabled = true
+	if err := CreateRule(&rule); err != nil {
+		t.Fatalf("CreateRule: %v", err)
+	}
+	return ch
+}
+
+func logStatuses(t *testing.T, channelID int64) []string {
+	t.Helper()
+	entries, err := ListLog(channelID, 100)
+	if err != nil {
+		t.Fatalf("ListLog: %v", err)
+	}
+	statuses := []string{}
+	for i := len(entries) - 1; i >= 0; i-- { // ListLog liefert die neuesten zuerst
+		statuses = append(statuses, entries[i].Status)
+	}
+	return statuses
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDispatchRoutesByRule(t *testing.T) {
+	setupNotifier(t)
+
+	device := int64(7)
+	alarmStub := newStubChannel(t, http.StatusOK)
+	deviceStub := newStubChannel(t, http.StatusOK)
+	severeStub := newStubChannel(t, http.StatusOK)
+	alarmCh := addChannel(t, "alarms", alarmStub, 0, Rule{Events: []string{EventAlarmActive}})
+	deviceCh := addChannel(t, "device 7", deviceStub, 0, Rule{Events: []string{EventDeviceOffline}, DeviceID: &device})
+	severeCh := addChannel(t, "severe", severeStub, 0, Rule{MinSeverity: 900})
+
+	dispatch(&Notification{Event: EventAlarmActive, Title: "alarm", Severity: 500, Time: time.Now()})
+	dispatch(&Notification{Event: EventDeviceOffline, Title: "device 7 offline", DeviceID: 7, Severity: 800, Time: time.Now()})
+	dispatch(&Notification{Event: EventDeviceOffline, Title: "device 8 offline", DeviceID: 8, Severity: 950, Time: time.Now()})
+
+	if got := alarmStub.titles(); !equalStrings(got, []string{"alarm"}) {
+		t.Errorf("alarm channel received %v", got)
+	}
+	if got := deviceStub.titles(); !equalStrings(got, []string{"device 7 offline"}) {
+		t.Errorf("device channel received %v", got)
+	}
+	if got := severeStub.titles(); !equalStrings(got, []string{"device 8 offline"}) {
+		t.Errorf("severity channel received %v", got)
+	}
+
+	for _, ch := range []*Channel{alarmCh, deviceCh, severeCh} {
+		if got := logStatuses(t, ch.ID); !equalStrings(got, []string{StatusSent}) {
+			t.Errorf("delivery log of channel %s = %v, want one sent entry", ch.Name, got)
+		}
+	}
+}
+
+func TestDispatchSkipsDisabledRules(t *testing.T) {
+	setupNotifier(t)
+
+	stub := newStubChannel(t, http.StatusOK)
+	ch := addChannel(t, "disabled rule", stub, 0, Rule{})
+	if _, err := db.Exec(`UPDATE notification_rules SET enabled = 0 WHERE channel_id = ?`, ch.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	dispatch(&Notification{Event: EventAlarmActive, Title: "alarm", Time: time.Now()})
+
+	if got := stub.titles(); len(got) != 0 {
+		t.Errorf("channel received %v despite disabled rule", got)
+	}
+	if got := logStatuses(t, ch.ID); len(got) != 0 {
+		t.Errorf("delivery log = %v, want no entries", got)
+	}
+}
+
+func TestDispatchRateLimit(t *testing.T) {
+	setupNotifier(t)
+
+	stub := newStubChannel(t, http.StatusOK)
+	ch := addChannel(t, "limited", stub, 2, Rule{})
+
+	for _, title := range []string{"first", "second", "third"} {
+		dispatch(&Notification{Event: EventAlarmActive, Title: title, Time: time.Now()})
+	}
+
+	if got := stub.titles(); !equalStrings(got, []string{"first", "second"}) {
+		t.Errorf("channel received %v, want the first two notifications", got)
+	}
+	want := []string{StatusSent, StatusSent, StatusRateLimited}
+	if got := logStatuses(t, ch.ID); !equalStrings(got, want) {
+		t.Errorf("delivery log = %v, want %v", got, want)
+	}
+}
+
+func TestAllowRateSlidingWindow(t *testing.T) {
+	setupNotifier(t)
+
+	ch := &Channel{ID: 1, RateLimitPerHour: 1}
+	start := time.Now()
+	if !allowRate(ch, start) {
+		t.Fatal("first delivery was rate limited")
+	}
+	if allowRate(ch, start.Add(30*time.Minute)) {
+		t.Error("second delivery within the hour was allowed")
+	}
+	if !allowRate(ch, start.Add(61*time.Minute)) {
+		t.Error("delivery after the hour was rate limited")
+	}
+}
+
+func TestDispatchDeduplicates(t *testing.T) {
+	setupNotifier(t)
+
+	stub := newStubChannel(t, http.StatusOK)
+	ch := addChannel(t, "dedup", stub, 0, Rule{})
+	if _, err := db.Exec(`UPDATE notification_channels SET dedup_seconds = 300 WHERE id = ?`, ch.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		dispatch(&Notification{Event: EventDeviceOffline, Title: "offline", DeviceID: 3, Time: time.Now(), dedupKey: "device_offline:3"})
+	}
+
+	if got := stub.titles(); len(got) != 1 {
+		t.Errorf("channel received %v, want a single notification", got)
+	}
+}
+
+func TestDispatchRetriesFailedDeliveries(t *testing.T) {
+	setupNotifier(t)
+
+	stub := newStubChannel(t, http.StatusInternalServerError)
+	ch := addChannel(t, "flaky", stub, 0, Rule{})
+	if _, err := db.Exec(`UPDATE notification_channels SET dedup_seconds = 300 WHERE id = ?`, ch.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	n := Notification{Event: EventDeviceOffline, Title: "offline", DeviceID: 3, dedupKey: "device_offline:3"}
+	n.Time = time.Now()
+	dispatch(&n)
+	stub.setStatus(http.StatusOK)
+	dispatch(&n)
+	dispatch(&n) // Jetzt zugestellt und damit unterdrückt
+
+	want := []string{StatusFailed, StatusSent}
+	if got := logStatuses(t, ch.ID); !equalStrings(got, want) {
+		t.Errorf("delivery log = %v, want %v", got, want)
+	}
+}
+
+func TestDispatchRetriesRateLimitedNotifications(t *testing.T) {
+	setupNotifier(t)
+
+	stub := newStubChannel(t, http.StatusOK)
+	ch := addChannel(t, "limited dedup", stub, 1, Rule{})
+	if _, err := db.Exec(`UPDATE notification_channels SET dedup_seconds = 300 WHERE id = ?`, ch.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	dispatch(&Notification{Event: EventDeviceOffline, Title: "device 1 offline", Time: time.Now(), dedupKey: "device_offline:1"})
+	dispatch(&Notification{Event: EventDeviceOffline, Title: "device 2 offline", Time: time.Now(), dedupKey: "device_offline:2"})
+
+	// Das Limit ist wieder frei, die verworfene Meldung darf nicht als Duplikat gelten
+	mu.Lock()
+	sentTimes = make(map[int64][]time.Time)
+	mu.Unlock()
+	dispatch(&Notification{Event: EventDeviceOffline, Title: "device 2 offline", Time: time.Now(), dedupKey: "device_offline:2"})
+
+	if got := stub.titles(); !equalStrings(got, []string{"device 1 offline", "device 2 offline"}) {
+		t.Errorf("channel received %v", got)
+	}
+	want := []string{StatusSent, StatusRateLimited, StatusSent}
+	if got := logStatuses(t, ch.ID); !equalStrings(got, want) {
+		t.Errorf("delivery log = %v, want %v", got, want)
+	}
+}
+
+func TestDeliverLogsFailures(t *testing.T) {
+	setupNotifier(t)
+
+	stub := newStubChannel(t, http.StatusInternalServerError)
+	ch := addChannel(t, "failing", stub, 0, Rule{})
+
+	dispatch(&Notification{Event: EventAlarmActive, Title: "alarm", Time: time.Now()})
+
+	entries, err := ListLog(ch.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("delivery log has %d entries, want 1", len(entries))
+	}
+	e := entries[0]
+	if e.Status != StatusFailed || e.Error == "" {
+		t.Errorf("log entry = %+v, want status failed with an error", e)
+	}
+	if e.ChannelName != "failing" || e.Event != EventAlarmActive || e.Title != "alarm" {
+		t.Errorf("log entry = %+v, want channel, event and title of the notification", e)
+	}
+}
+
+func TestHandleDeviceStateTransitions(t *testing.T) {
+	setupNotifier(t)
+
+	handleDeviceState("driver/states/s7/4", "1 (running)")
+	handleDeviceState("driver/states/s7/4", "6 (connection lost)")
+	handleDeviceState("driver/states/s7/4", "5 (no connection)") // bereits offline, keine zweite Meldung
+	handleDeviceState("driver/states/s7/4", "1 (running)")
+
+	var events []string
+	for len(queue) > 0 {
+		n := <-queue
+		if n.DeviceID != 4 || n.DeviceType != "s7" {
+			t.Errorf("notification %+v has wrong device", n)
+		}
+		events = append(events, n.Event)
+	}
+	want := []string{EventDeviceOffline, EventDeviceOnline}
+	if !equalStrings(events, want) {
+		t.Errorf("queued events = %v, want %v", events, want)
+	}
+}
@@ -0,0 +1,231 @@
+package notifications
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kanaltypen
+const (
+	ChannelSMTP    = "smtp"
+	ChannelWebhook = "webhook"
+	ChannelTeams   = "teams"
+	ChannelSlack   = "slack"
+)
+
+// Ereignisse, die eine Benachrichtigung auslösen
+const (
+	EventDeviceOffline = "device_offline"
+	EventDeviceOnline  = "device_online"
+	EventAlarmActive   = "alarm_active"
+	EventAlarmCleared  = "alarm_cleared"
+	EventTest          = "test"
+)
+
+// Zustellstatus im Log
+const (
+	StatusSent        = "sent"
+	StatusFailed      = "failed"
+	StatusRateLimited = "rate_limited"
+)
+
+// maskedSecret ersetzt Passwörter in API-Antworten
+const maskedSecret = "********"
+
+var (
+	ErrChannelNotFound = errors.New("notification channel not found")
+	ErrRuleNotFound    = errors.New("notification rule not found")
+	ErrInvalidConfig   = errors.New("invalid notification configuration")
+)
+
+// Channel ist ein konfigurierter Benachrichtigungskanal
+type Channel struct {
+	ID               int64         `json:"id"`
+	Name             string        `json:"name"`
+	Type             string        `json:"type"`
+	Config           ChannelConfig `json:"config"`
+	RateLimitPerHour int           `json:"rateLimitPerHour"` // 0 = unbegrenzt
+	DedupSeconds     int           `json:"dedupSeconds"`     // Gleiche Meldung innerhalb dieses Zeitraums nur einmal senden
+	Enabled          bool          `json:"enabled"`
+}
+
+// ChannelConfig enthält die typabhängigen Einstellungen eines Kanals
+type ChannelConfig struct {
+	// smtp
+	Host     string   `json:"host,omitempty"`
+	Port     int      `json:"port,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+
+	// webhook, teams, slack
+	URL          string            `json:"url,omitempty"`
+	Method       string            `json:"method,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	BodyTemplate string            `json:"bodyTemplate,omitempty"` // text/template, Standard: Notification als JSON
+}
+
+// Rule legt fest, welche Ereignisse über einen Kanal gemeldet werden
+type Rule struct {
+	ID          int64    `json:"id"`
+	ChannelID   int64    `json:"channelId"`
+	Events      []string `json:"events"`             // leer = alle
+	DeviceID    *int64   `json:"deviceId,omitempty"` // nil = alle Geräte
+	MinSeverity int      `json:"minSeverity"`
+	TimeFrom    string   `json:"timeFrom,omitempty"` // HH:MM, Ortszeit
+	TimeTo      string   `json:"timeTo,omitempty"`
+	Weekdays    []int    `json:"weekdays,omitempty"` // 0 = Sonntag, leer = alle
+	Enabled     bool     `json:"enabled"`
+}
+
+// Notification ist eine zu versendende Meldung. Die Felder stehen auch in Webhook-Templates zur Verfügung.
+type Notification struct {
+	Event      string    `json:"event"`
+	Title      string    `json:"title"`
+	Message    string    `json:"message"`
+	Severity   int       `json:"severity"`
+	DeviceID   int64     `json:"deviceId,omitempty"`
+	DeviceName string    `json:"deviceName,omitempty"`
+	DeviceType string    `json:"deviceType,omitempty"`
+	Source     string    `json:"source,omitempty"`
+	Value      string    `json:"value,omitempty"`
+	Status     string    `json:"status,omitempty"`
+	Time       time.Time `json:"time"`
+
+	dedupKey string
+}
+
+// LogEntry ist ein Eintrag im Zustellprotokoll
+type LogEntry struct {
+	ID          int64  `json:"id"`
+	ChannelID   int64  `json:"channelId"`
+	ChannelName string `json:"channelName"`
+	Event       string `json:"event"`
+	Title       string `json:"title"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+	CreatedAt   string `json:"createdAt"`
+}
+
+// Validate prüft einen Kanal auf vollständige Angaben
+func (ch *Channel) Validate() error {
+	if ch.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidConfig)
+	}
+	switch ch.Type {
+	case ChannelSMTP:
+		if ch.Config.Host == "" || ch.Config.From == "" || len(ch.Config.To) == 0 {
+			return fmt.Errorf("%w: smtp requires host, from and to", ErrInvalidConfig)
+		}
+	case ChannelWebhook, ChannelTeams, ChannelSlack:
+		if ch.Config.URL == "" {
+			return fmt.Errorf("%w: %s requires url", ErrInvalidConfig, ch.Type)
+		}
+		if ch.Config.BodyTemplate != "" {
+			if _, err := parseTemplate(ch.Config.BodyTemplate); err != nil {
+				return fmt.Errorf("%w: bodyTemplate: %v", ErrInvalidConfig, err)
+			}
+		}
+	default:
+		return fmt.Errorf("%w: unknown channel type %q", ErrInvalidConfig, ch.Type)
+	}
+	if ch.RateLimitPerHour < 0 || ch.DedupSeconds < 0 {
+		return fmt.Errorf("%w: rateLimitPerHour and dedupSeconds must not be negative", ErrInvalidConfig)
+	}
+	return nil
+}
+
+// Validate prüft eine Routing-Regel
+func (r *Rule) Validate() error {
+	for _, event := range r.Events {
+		switch event {
+		case EventDeviceOffline, EventDeviceOnline, EventAlarmActive, EventAlarmCleared:
+		default:
+			return fmt.Errorf("%w: unknown event %q", ErrInvalidConfig, event)
+		}
+	}
+	if (r.TimeFrom == "") != (r.TimeTo == "") {
+		return fmt.Errorf("%w: timeFrom and timeTo must be set together", ErrInvalidConfig)
+	}
+	if r.TimeFrom != "" {
+		if _, err := parseClock(r.TimeFrom); err != nil {
+			return err
+		}
+		if _, err := parseClock(r.TimeTo); err != nil {
+			return err
+		}
+	}
+	for _, day := range r.Weekdays {
+		if day < 0 || day > 6 {
+			return fmt.Errorf("%w: weekdays must be between 0 (Sunday) and 6 (Saturday)", ErrInvalidConfig)
+		}
+	}
+	return nil
+}
+
+// matches prüft, ob eine Meldung zu dieser Regel passt
+func (r *Rule) matches(n *Notification, now time.Time) bool {
+	if !r.Enabled {
+		return false
+	}
+	if len(r.Events) > 0 && !contains(r.Events, n.Event) {
+		return false
+	}
+	if r.DeviceID != nil && *r.DeviceID != n.DeviceID {
+		return false
+	}
+	if n.Severity < r.MinSeverity {
+		return false
+	}
+	if len(r.Weekdays) > 0 {
+		found := false
+		for _, day := range r.Weekdays {
+			if time.Weekday(day) == now.Weekday() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.TimeFrom != "" {
+		from, _ := parseClock(r.TimeFrom)
+		to, _ := parseClock(r.TimeTo)
+		minute := now.Hour()*60 + now.Minute()
+		if from <= to {
+			if minute < from || minute >= to {
+				return false
+			}
+		} else if minute < from && minute >= to { // Zeitfenster über Mitternacht
+			return false
+		}
+	}
+	return true
+}
+
+// parseClock wandelt HH:MM in Minuten seit Mitternacht um
+func parseClock(value string) (int, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) == 2 {
+		h, errH := strconv.Atoi(parts[0])
+		m, errM := strconv.Atoi(parts[1])
+		if errH == nil && errM == nil && h >= 0 && h < 24 && m >= 0 && m < 60 {
+			return h*60 + m, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: invalid time %q, expected HH:MM", ErrInvalidConfig, value)
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package notifications
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRuleMatchesTimeWindow(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2026, 10, 19, hour, minute, 0, 0, time.Local) // Montag
+	}
+	tests := []struct {
+		from, to string
+		now      time.Time
+		want     bool
+	}{
+		{"08:00", "17:00", at(7, 59), false},
+		{"08:00", "17:00", at(8, 0), true},
+		{"08:00", "17:00", at(16, 59), true},
+		{"08:00", "17:00", at(17, 0), false},
+		// Über Mitternacht
+		{"22:00", "06:00", at(21, 59), false},
+		{"22:00", "06:00", at(22, 0), true},
+		{"22:00", "06:00", at(23, 30), true},
+		{"22:00", "06:00", at(0, 0), true},
+		{"22:00", "06:00", at(5, 59), true},
+		{"22:00", "06:00", at(6, 0), false},
+		{"22:00", "06:00", at(12, 0), false},
+	}
+	for _, tt := range tests {
+		r := Rule{TimeFrom: tt.from, TimeTo: tt.to, Enabled: true}
+		if err := r.Validate(); err != nil {
+			t.Fatalf("%s-%s: %v", tt.from, tt.to, err)
+		}
+		if got := r.matches(&Notification{Event: EventAlarmActive}, tt.now); got != tt.want {
+			t.Errorf("%s-%s at %s: matches = %v, want %v", tt.from, tt.to, tt.now.Format("15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestRuleMatchesWeekdays(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2026, 10, 18+d, 12, 0, 0, 0, time.Local) // 18.10.2026 ist ein Sonntag
+	}
+	workdays := []int{1, 2, 3, 4, 5}
+	tests := []struct {
+		name     string
+		weekdays []int
+		from, to string
+		now      time.Time
+		want     bool
+	}{
+		{"monday", workdays, "", "", day(1), true},
+		{"friday", workdays, "", "", day(5), true},
+		{"saturday", workdays, "", "", day(6), false},
+		{"sunday", workdays, "", "", day(0), false},
+		{"sunday only", []int{0}, "", "", day(0), true},
+		{"all days", nil, "", "", day(6), true},
+		{"workday in window", workdays, "08:00", "17:00", day(2), true},
+		{"workday outside window", workdays, "13:00", "17:00", day(2), false},
+		{"weekend in window", workdays, "08:00", "17:00", day(6), false},
+	}
+	for _, tt := range tests {
+		r := Rule{Weekdays: tt.weekdays, TimeFrom: tt.from, TimeTo: tt.to, Enabled: true}
+		if got := r.matches(&Notification{Event: EventAlarmActive}, tt.now); got != tt.want {
+			t.Errorf("%s: matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	for _, r := range []Rule{
+		{TimeFrom: "08:00"},
+		{TimeFrom: "08:00", TimeTo: "24:00"},
+		{TimeFrom: "8", TimeTo: "17:00"},
+		{Weekdays: []int{7}},
+		{Weekdays: []int{-1}},
+		{Events: []string{"device_deleted"}},
+	} {
+		if err := r.Validate(); err == nil {
+			t.Errorf("invalid rule accepted: %+v", r)
+		}
+	}
+	valid := Rule{Events: []string{EventDeviceOffline}, TimeFrom: "00:00", TimeTo: "23:59", Weekdays: []int{0, 6}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("valid rule rejected: %v", err)
+	}
+}
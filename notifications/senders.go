@@ -0,0 +1,188 @@
+package notifications
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// send stellt eine Meldung über den Kanal zu
+func send(ch *Channel, n *Notification) error {
+	switch ch.Type {
+	case ChannelSMTP:
+		return sendMail(ch.Config, n)
+	case ChannelWebhook:
+		return sendWebhook(ch.Config, n)
+	case ChannelTeams:
+		return postJSON(ch.Config.URL, "POST", nil, teamsPayload(n))
+	case ChannelSlack:
+		return postJSON(ch.Config.URL, "POST", nil, slackPayload(n))
+	}
+	return fmt.Errorf("unknown channel type %q", ch.Type)
+}
+
+// sendMail versendet eine Text-Mail. Bei Angabe von Benutzername und Passwort wird PLAIN-Auth verwendet,
+// net/smtp nutzt STARTTLS automatisch, wenn der Server es anbietet.
+func sendMail(cfg ChannelConfig, n *Notification) error {
+	port := cfg.Port
+	if port == 0 {
+		port = 25
+	}
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(port))
+
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mailSubject(n.Title))
+	fmt.Fprintf(&msg, "Date: %s\r\n", n.Time.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(plainText(n))
+
+	return smtp.SendMail(addr, auth, cfg.From, cfg.To, msg.Bytes())
+}
+
+// headerBreaks entfernt Zeilenumbrüche aus Header-Werten (z.B. aus Geräte- oder Regelnamen im Titel)
+var headerBreaks = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// mailSubject erzeugt den Betreff ohne Zeilenumbrüche, Nicht-ASCII-Zeichen als RFC-2047-Encoded-Word
+func mailSubject(title string) string {
+	return mime.QEncoding.Encode("utf-8", "[IoT-Gateway] "+headerBreaks.Replace(title))
+}
+
+// sendWebhook sendet die Meldung an einen generischen Webhook. Ohne Template wird die Notification als JSON gesendet.
+func sendWebhook(cfg ChannelConfig, n *Notification) error {
+	var body []byte
+	if cfg.BodyTemplate == "" {
+		var err error
+		if body, err = json.Marshal(n); err != nil {
+			return err
+		}
+	} else {
+		tmpl, err := parseTemplate(cfg.BodyTemplate)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, n); err != nil {
+			return fmt.Errorf("error rendering body template: %v", err)
+		}
+		body = buf.Bytes()
+	}
+
+	method := cfg.Method
+	if method == "" {
+		method = "POST"
+	}
+	return postJSON(cfg.URL, method, cfg.Headers, body)
+}
+
+// postJSON sendet einen JSON-Body und wertet Statuscodes >= 300 als Fehler
+func postJSON(url, method string, headers map[string]string, payload interface{}) error {
+	body, ok := payload.([]byte)
+	if !ok {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		text, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(text)))
+	}
+	return nil
+}
+
+// parseTemplate übersetzt ein Body-Template. Die Funktion "json" kodiert einen Wert als JSON (z.B. {{json .Message}}).
+func parseTemplate(text string) (*template.Template, error) {
+	return template.New("body").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+}
+
+// teamsPayload erzeugt eine MessageCard für Microsoft-Teams-Incoming-Webhooks
+func teamsPayload(n *Notification) map[string]interface{} {
+	return map[string]interface{}{
+		"@type":      "MessageCard",
+		"@context":   "http://schema.org/extensions",
+		"summary":    n.Title,
+		"themeColor": themeColor(n),
+		"title":      n.Title,
+		"text":       strings.ReplaceAll(plainText(n), "\n", "<br>"),
+	}
+}
+
+// slackPayload erzeugt eine Nachricht für Slack-Incoming-Webhooks
+func slackPayload(n *Notification) map[string]interface{} {
+	return map[string]interface{}{
+		"text": fmt.Sprintf("*%s*\n%s", n.Title, plainText(n)),
+	}
+}
+
+// plainText formatiert eine Meldung als Text
+func plainText(n *Notification) string {
+	var sb strings.Builder
+	sb.WriteString(n.Message)
+	sb.WriteString("\n\n")
+	if n.DeviceName != "" {
+		fmt.Fprintf(&sb, "Device: %s (%s, ID %d)\n", n.DeviceName, n.DeviceType, n.DeviceID)
+	}
+	if n.Source != "" {
+		fmt.Fprintf(&sb, "Source: %s\n", n.Source)
+	}
+	if n.Value != "" {
+		fmt.Fprintf(&sb, "Value: %s\n", n.Value)
+	}
+	if n.Status != "" {
+		fmt.Fprintf(&sb, "Status: %s\n", n.Status)
+	}
+	fmt.Fprintf(&sb, "Severity: %d\n", n.Severity)
+	fmt.Fprintf(&sb, "Time: %s\n", n.Time.Format(time.RFC3339))
+	return sb.String()
+}
+
+// themeColor wählt die Farbe einer Teams-Karte nach Ereignis und Severity
+func themeColor(n *Notification) string {
+	switch {
+	case n.Event == EventDeviceOnline || n.Event == EventAlarmCleared:
+		return "2EB886"
+	case n.Severity >= 700:
+		return "D9534F"
+	default:
+		return "F0AD4E"
+	}
+}
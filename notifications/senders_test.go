@@ -0,0 +1,354 @@
+package notifications
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpStub ist ein lokaler SMTP-Server, der eine Mail pro Verbindung annimmt und Umschlag und Inhalt aufzeichnet
+type smtpStub struct {
+	mu    sync.Mutex
+	from  string
+	rcpts []string
+	auth  string // Dekodierte PLAIN-Credentials
+	data  string
+	done  chan struct{}
+	host  string
+	port  int
+}
+
+func newSMTPStub(t *testing.T) *smtpStub {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	addr := l.Addr().(*net.TCPAddr)
+	stub := &smtpStub{done: make(chan struct{}), host: addr.IP.String(), port: addr.Port}
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		defer close(stub.done)
+		stub.serve(textproto.NewConn(conn))
+	}()
+	return stub
+}
+
+func (s *smtpStub) serve(c *textproto.Conn) {
+	c.PrintfLine("220 localhost ESMTP stub")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(cmd) {
+		case "EHLO", "HELO":
+			c.PrintfLine("250-localhost\r\n250 AUTH PLAIN")
+		case "AUTH":
+			_, encoded, _ := strings.Cut(arg, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(encoded)
+			s.mu.Lock()
+			s.auth = string(decoded)
+			s.mu.Unlock()
+			c.PrintfLine("235 Authentication successful")
+		case "MAIL":
+			s.mu.Lock()
+			s.from = arg
+			s.mu.Unlock()
+			c.PrintfLine("250 OK")
+		case "RCPT":
+			s.mu.Lock()
+			s.rcpts = append(s.rcpts, arg)
+			s.mu.Unlock()
+			c.PrintfLine("250 OK")
+		case "DATA":
+			c.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := io.ReadAll(c.DotReader())
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.data = string(data)
+			s.mu.Unlock()
+			c.PrintfLine("250 OK")
+		case "QUIT":
+			c.PrintfLine("221 Bye")
+			return
+		default:
+			c.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func (s *smtpStub) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case <-s.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the SMTP session")
+	}
+}
+
+func TestSendMail(t *testing.T) {
+	stub := newSMTPStub(t)
+	cfg := ChannelConfig{
+		Host:     stub.host,
+		Port:     stub.port,
+		Username: "gateway",
+		Password: "secret",
+		From:     "gateway@example.com",
+		To:       []string{"ops@example.com", "oncall@example.com"},
+	}
+	n := &Notification{
+		Event:      EventDeviceOffline,
+		Title:      "Device Pumpe\r\nBcc: attacker@example.com",
+		Message:    "Device Pumpe (ID 3) lost its connection: 6 (connection lost)",
+		Severity:   800,
+		DeviceID:   3,
+		DeviceName: "Pumpe",
+		DeviceType: "s7",
+		Time:       time.Date(2026, 10, 19, 8, 30, 0, 0, time.UTC),
+	}
+	if err := sendMail(cfg, n); err != nil {
+		t.Fatalf("sendMail: %v", err)
+	}
+	stub.wait(t)
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if stub.from != "FROM:<gateway@example.com>" {
+		t.Errorf("MAIL %s, want FROM:<gateway@example.com>", stub.from)
+	}
+	if want := []string{"TO:<ops@example.com>", "TO:<oncall@example.com>"}; !equalStrings(stub.rcpts, want) {
+		t.Errorf("RCPT %v, want %v", stub.rcpts, want)
+	}
+	if stub.auth != "\x00gateway\x00secret" {
+		t.Errorf("AUTH PLAIN credentials %q", stub.auth)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(stub.data))
+	if err != nil {
+		t.Fatalf("invalid message: %v\n%s", err, stub.data)
+	}
+	for key, want := range map[string]string{
+		"From":         "gateway@example.com",
+		"To":           "ops@example.com, oncall@example.com",
+		"Date":         "Mon, 19 Oct 2026 08:30:00 +0000",
+		"Content-Type": "text/plain; charset=utf-8",
+		"Bcc":          "",
+	} {
+		if got := msg.Header.Get(key); got != want {
+			t.Errorf("header %s = %q, want %q", key, got, want)
+		}
+	}
+	if got := msg.Header.Get("Subject"); got != "[IoT-Gateway] Device Pumpe Bcc: attacker@example.com" {
+		t.Errorf("Subject = %q", got)
+	}
+
+	body, _ := io.ReadAll(msg.Body)
+	for _, want := range []string{n.Message, "Device: Pumpe (s7, ID 3)", "Severity: 800", "Time: 2026-10-19T08:30:00Z"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("body misses %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestMailSubject(t *testing.T) {
+	tests := []struct {
+		title, want string
+	}{
+		{"Alarm: Druck", "[IoT-Gateway] Alarm: Druck"},
+		{"Alarm: Überdruck", "[IoT-Gateway] Alarm: Überdruck"},
+		{"a\nb\rc\r\nd", "[IoT-Gateway] a b c d"},
+	}
+	for _, tt := range tests {
+		encoded := mailSubject(tt.title)
+		if strings.ContainsAny(encoded, "\r\n") {
+			t.Errorf("mailSubject(%q) = %q contains a line break", tt.title, encoded)
+		}
+		decoded, err := new(mime.WordDecoder).DecodeHeader(encoded)
+		if err != nil || decoded != tt.want {
+			t.Errorf("mailSubject(%q) decodes to %q (%v), want %q", tt.title, decoded, err, tt.want)
+		}
+	}
+}
+
+// httpRecorder zeichnet die Anfragen an einen Webhook auf
+type httpRecorder struct {
+	mu       sync.Mutex
+	requests []recordedRequest
+	server   *httptest.Server
+}
+
+type recordedRequest struct {
+	method string
+	header http.Header
+	body   []byte
+}
+
+func newHTTPRecorder(t *testing.T) *httpRecorder {
+	t.Helper()
+	rec := &httpRecorder{}
+	rec.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec.mu.Lock()
+		rec.requests = append(rec.requests, recordedRequest{method: r.Method, header: r.Header.Clone(), body: body})
+		rec.mu.Unlock()
+	}))
+	t.Cleanup(rec.server.Close)
+	return rec
+}
+
+func (r *httpRecorder) last(t *testing.T) recordedRequest {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.requests) == 0 {
+		t.Fatal("no request received")
+	}
+	return r.requests[len(r.requests)-1]
+}
+
+func TestSendTeamsPayload(t *testing.T) {
+	rec := newHTTPRecorder(t)
+	ch := &Channel{Type: ChannelTeams, Config: ChannelConfig{URL: rec.server.URL}}
+
+	tests := []struct {
+		n     Notification
+		color string
+	}{
+		{Notification{Event: EventAlarmActive, Title: "Alarm: Druck", Message: "Druck zu hoch", Severity: 900}, "D9534F"},
+		{Notification{Event: EventAlarmActive, Title: "Alarm: Füllstand", Message: "Füllstand niedrig", Severity: 300}, "F0AD4E"},
+		{Notification{Event: EventAlarmCleared, Title: "Alarm cleared: Druck", Message: "Druck normal", Severity: 900}, "2EB886"},
+		{Notification{Event: EventDeviceOnline, Title: "Device Pumpe online", Message: "connected", Severity: 800}, "2EB886"},
+	}
+	for _, tt := range tests {
+		if err := send(ch, &tt.n); err != nil {
+			t.Fatalf("%s: %v", tt.n.Title, err)
+		}
+		req := rec.last(t)
+		if req.method != http.MethodPost || req.header.Get("Content-Type") != "application/json" {
+			t.Errorf("%s: %s with Content-Type %q", tt.n.Title, req.method, req.header.Get("Content-Type"))
+		}
+		var card map[string]string
+		if err := json.Unmarshal(req.body, &card); err != nil {
+			t.Fatalf("%s: invalid payload: %v", tt.n.Title, err)
+		}
+		if card["@type"] != "MessageCard" || card["@context"] != "http://schema.org/extensions" {
+			t.Errorf("%s: not a MessageCard: %s", tt.n.Title, req.body)
+		}
+		if card["title"] != tt.n.Title || card["summary"] != tt.n.Title {
+			t.Errorf("%s: title %q, summary %q", tt.n.Title, card["title"], card["summary"])
+		}
+		if card["themeColor"] != tt.color {
+			t.Errorf("%s: themeColor %s, want %s", tt.n.Title, card["themeColor"], tt.color)
+		}
+		if !strings.HasPrefix(card["text"], tt.n.Message+"<br><br>") || strings.Contains(card["text"], "\n") {
+			t.Errorf("%s: text %q", tt.n.Title, card["text"])
+		}
+	}
+}
+
+func TestSendSlackPayload(t *testing.T) {
+	rec := newHTTPRecorder(t)
+	ch := &Channel{Type: ChannelSlack, Config: ChannelConfig{URL: rec.server.URL}}
+	n := &Notification{Event: EventAlarmActive, Title: "Alarm: Druck", Message: "Druck zu hoch", Value: "12.5", Severity: 900,
+		Time: time.Date(2026, 10, 19, 8, 30, 0, 0, time.UTC)}
+
+	if err := send(ch, n); err != nil {
+		t.Fatal(err)
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(rec.last(t).body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if len(payload) != 1 {
+		t.Errorf("payload has fields %v, want only text", payload)
+	}
+	want := "*Alarm: Druck*\nDruck zu hoch\n\nValue: 12.5\nSeverity: 900\nTime: 2026-10-19T08:30:00Z\n"
+	if payload["text"] != want {
+		t.Errorf("text = %q, want %q", payload["text"], want)
+	}
+}
+
+func TestSendWebhookBodyTemplate(t *testing.T) {
+	rec := newHTTPRecorder(t)
+	ch := &Channel{Name: "template", Type: ChannelWebhook, Config: ChannelConfig{
+		URL:          rec.server.URL,
+		Method:       "PUT",
+		Headers:      map[string]string{"X-Api-Key": "abc"},
+		BodyTemplate: `{"summary": {{json .Title}}, "severity": {{.Severity}}, "device": {{json .DeviceName}}, "event": "{{.Event}}"}`,
+	}}
+	if err := ch.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	n := &Notification{Event: EventDeviceOffline, Title: `Device "Kessel" offline`, DeviceName: `Kessel\1`, Severity: 800}
+
+	if err := send(ch, n); err != nil {
+		t.Fatal(err)
+	}
+	req := rec.last(t)
+	if req.method != "PUT" || req.header.Get("X-Api-Key") != "abc" {
+		t.Errorf("request %s with X-Api-Key %q", req.method, req.header.Get("X-Api-Key"))
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(req.body, &body); err != nil {
+		t.Fatalf("template output is no valid JSON: %v\n%s", err, req.body)
+	}
+	want := map[string]interface{}{"summary": `Device "Kessel" offline`, "severity": float64(800), "device": `Kessel\1`, "event": EventDeviceOffline}
+	for key, value := range want {
+		if body[key] != value {
+			t.Errorf("%s = %v, want %v", key, body[key], value)
+		}
+	}
+
+	// Ohne Template wird die Notification als JSON gesendet
+	ch.Config.BodyTemplate = ""
+	if err := send(ch, n); err != nil {
+		t.Fatal(err)
+	}
+	var sent Notification
+	if err := json.Unmarshal(rec.last(t).body, &sent); err != nil || sent.Title != n.Title || sent.Severity != n.Severity {
+		t.Errorf("default body = %s (%v)", rec.last(t).body, err)
+	}
+
+	// Fehler beim Rendern werden gemeldet
+	ch.Config.BodyTemplate = `{{.Unknown}}`
+	if err := send(ch, n); err == nil || !strings.Contains(err.Error(), "body template") {
+		t.Errorf("rendering an unknown field: err = %v", err)
+	}
+	ch.Config.BodyTemplate = `{{.Title`
+	if err := ch.Validate(); err == nil {
+		t.Error("invalid template accepted")
+	}
+}
+
+func TestSendReportsHTTPErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid webhook", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	err := send(&Channel{Type: ChannelSlack, Config: ChannelConfig{URL: server.URL}}, &Notification{Title: "x"})
+	if err == nil || !strings.Contains(err.Error(), strconv.Itoa(http.StatusBadRequest)) || !strings.Contains(err.Error(), "invalid webhook") {
+		t.Errorf("err = %v, want status and response text", err)
+	}
+}
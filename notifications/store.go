@@ -0,0 +1,310 @@
+package notifications
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// logRetention bestimmt, wie lange Einträge im Zustellprotokoll aufbewahrt werden
+const logRetention = 30 * 24 * time.Hour
+
+// ListChannels liefert alle Kanäle. Passwörter werden maskiert.
+func ListChannels() ([]Channel, error) {
+	channels, err := queryChannels("")
+	if err != nil {
+		return nil, err
+	}
+	for i := range channels {
+		maskChannel(&channels[i])
+	}
+	return channels, nil
+}
+
+// queryChannels liest Kanäle mit optionaler WHERE-Klausel
+func queryChannels(where string, args ...interface{}) ([]Channel, error) {
+	rows, err := db.Query(`SELECT id, name, channel_type, config, COALESCE(rate_limit_per_hour, 0), COALESCE(dedup_seconds, 0), enabled
+		FROM notification_channels `+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	channels := []Channel{}
+	for rows.Next() {
+		var ch Channel
+		var config string
+		if err := rows.Scan(&ch.ID, &ch.Name, &ch.Type, &config, &ch.RateLimitPerHour, &ch.DedupSeconds, &ch.Enabled); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(config), &ch.Config); err != nil {
+			logrus.Warnf("NOTIFY: Invalid config for channel %s: %v", ch.Name, err)
+		}
+		channels = append(channels, ch)
+	}
+	return channels, rows.Err()
+}
+
+// getChannel liest einen Kanal (unmaskiert)
+func getChannel(id int64) (*Channel, error) {
+	channels, err := queryChannels("WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(channels) == 0 {
+		return nil, ErrChannelNotFound
+	}
+	return &channels[0], nil
+}
+
+// CreateChannel legt einen Kanal an
+func CreateChannel(ch *Channel) error {
+	if err := ch.Validate(); err != nil {
+		return err
+	}
+	config, err := json.Marshal(ch.Config)
+	if err != nil {
+		return err
+	}
+	now := formatTime(time.Now())
+	res, err := db.Exec(`INSERT INTO notification_channels (name, channel_type, config, rate_limit_per_hour, dedup_seconds, enabled, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, ch.Name, ch.Type, string(config), ch.RateLimitPerHour, ch.DedupSeconds, ch.Enabled, now, now)
+	if err != nil {
+		return err
+	}
+	ch.ID, _ = res.LastInsertId()
+	maskChannel(ch)
+	return nil
+}
+
+// UpdateChannel ändert einen Kanal. Ein maskiertes Passwort behält den gespeicherten Wert.
+func UpdateChannel(ch *Channel) error {
+	existing, err := getChannel(ch.ID)
+	if err != nil {
+		return err
+	}
+	if ch.Config.Password == maskedSecret {
+		ch.Config.Password = existing.Config.Password
+	}
+	if err := ch.Validate(); err != nil {
+		return err
+	}
+	config, err := json.Marshal(ch.Config)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`UPDATE notification_channels SET name = ?, channel_type = ?, config = ?, rate_limit_per_hour = ?, dedup_seconds = ?, enabled = ?, updated_at = ?
+		WHERE id = ?`, ch.Name, ch.Type, string(config), ch.RateLimitPerHour, ch.DedupSeconds, ch.Enabled, formatTime(time.Now()), ch.ID)
+	if err != nil {
+		return err
+	}
+	maskChannel(ch)
+	return nil
+}
+
+// DeleteChannel löscht einen Kanal samt seiner Regeln
+func DeleteChannel(id int64) error {
+	res, err := db.Exec(`DELETE FROM notification_channels WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrChannelNotFound
+	}
+	_, err = db.Exec(`DELETE FROM notification_rules WHERE channel_id = ?`, id)
+	return err
+}
+
+// maskChannel ersetzt das Passwort für die Ausgabe
+func maskChannel(ch *Channel) {
+	if ch.Config.Password != "" {
+		ch.Config.Password = maskedSecret
+	}
+}
+
+// ListRules liefert alle Routing-Regeln
+func ListRules() ([]Rule, error) {
+	return queryRules("")
+}
+
+// queryRules liest Regeln mit optionaler WHERE-Klausel
+func queryRules(where string, args ...interface{}) ([]Rule, error) {
+	rows, err := db.Query(`SELECT id, channel_id, COALESCE(events, ''), device_id, COALESCE(min_severity, 0), COALESCE(time_from, ''), COALESCE(time_to, ''),
+		COALESCE(weekdays, ''), enabled FROM notification_rules `+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []Rule{}
+	for rows.Next() {
+		var rule Rule
+		var events, weekdays string
+		var deviceID sql.NullInt64
+		if err := rows.Scan(&rule.ID, &rule.ChannelID, &events, &deviceID, &rule.MinSeverity, &rule.TimeFrom, &rule.TimeTo, &weekdays, &rule.Enabled); err != nil {
+			return nil, err
+		}
+		rule.Events = splitList(events)
+		if deviceID.Valid {
+			rule.DeviceID = &deviceID.Int64
+		}
+		rule.Weekdays = []int{}
+		for _, day := range splitList(weekdays) {
+			if d, err := strconv.Atoi(day); err == nil {
+				rule.Weekdays = append(rule.Weekdays, d)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// checkChannel prüft, ob der Kanal einer Regel existiert
+func checkChannel(id int64) error {
+	if _, err := getChannel(id); err == ErrChannelNotFound {
+		return fmt.Errorf("%w: channel %d does not exist", ErrInvalidConfig, id)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// CreateRule legt eine Routing-Regel an
+func CreateRule(rule *Rule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	if err := checkChannel(rule.ChannelID); err != nil {
+		return err
+	}
+	res, err := db.Exec(`INSERT INTO notification_rules (channel_id, events, device_id, min_severity, time_from, time_to, weekdays, enabled)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, rule.ChannelID, strings.Join(rule.Events, ","), rule.DeviceID, rule.MinSeverity,
+		nullString(rule.TimeFrom), nullString(rule.TimeTo), joinInts(rule.Weekdays), rule.Enabled)
+	if err != nil {
+		return err
+	}
+	rule.ID, _ = res.LastInsertId()
+	return nil
+}
+
+// UpdateRule ändert eine Routing-Regel
+func UpdateRule(rule *Rule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	if err := checkChannel(rule.ChannelID); err != nil {
+		return err
+	}
+	res, err := db.Exec(`UPDATE notification_rules SET channel_id = ?, events = ?, device_id = ?, min_severity = ?, time_from = ?, time_to = ?, weekdays = ?, enabled = ?
+		WHERE id = ?`, rule.ChannelID, strings.Join(rule.Events, ","), rule.DeviceID, rule.MinSeverity,
+		nullString(rule.TimeFrom), nullString(rule.TimeTo), joinInts(rule.Weekdays), rule.Enabled, rule.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrRuleNotFound
+	}
+	return nil
+}
+
+// DeleteRule löscht eine Routing-Regel
+func DeleteRule(id int64) error {
+	res, err := db.Exec(`DELETE FROM notification_rules WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrRuleNotFound
+	}
+	return nil
+}
+
+// ListLog liefert die neuesten Einträge des Zustellprotokolls, optional für einen Kanal
+func ListLog(channelID int64, limit int) ([]LogEntry, error) {
+	query := `SELECT id, COALESCE(channel_id, 0), COALESCE(channel_name, ''), event, COALESCE(title, ''), status, COALESCE(error, ''), created_at FROM notification_log`
+	var args []interface{}
+	if channelID > 0 {
+		query += " WHERE channel_id = ?"
+		args = append(args, channelID)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []LogEntry{}
+	for rows.Next() {
+		var e LogEntry
+		if err := rows.Scan(&e.ID, &e.ChannelID, &e.ChannelName, &e.Event, &e.Title, &e.Status, &e.Error, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// writeLog protokolliert einen Zustellversuch
+func writeLog(ch *Channel, n *Notification, status string, sendErr error) {
+	errText := ""
+	if sendErr != nil {
+		errText = sendErr.Error()
+	}
+	_, err := db.Exec(`INSERT INTO notification_log (channel_id, channel_name, event, title, status, error, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		ch.ID, ch.Name, n.Event, n.Title, status, errText, formatTime(time.Now()))
+	if err != nil {
+		logrus.Errorf("NOTIFY: Error writing delivery log: %v", err)
+	}
+}
+
+// pruneLog löscht alte Einträge des Zustellprotokolls
+func pruneLog() {
+	if _, err := db.Exec(`DELETE FROM notification_log WHERE created_at < ?`, formatTime(time.Now().Add(-logRetention))); err != nil {
+		logrus.Errorf("NOTIFY: Error pruning delivery log: %v", err)
+	}
+}
+
+// deviceInfo liest Name und Typ eines Geräts
+func deviceInfo(deviceID int64) (name, deviceType string) {
+	if err := db.QueryRow(`SELECT name, type FROM devices WHERE id = ?`, deviceID).Scan(&name, &deviceType); err != nil {
+		logrus.Debugf("NOTIFY: Device %d not found: %v", deviceID, err)
+	}
+	return name, deviceType
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func nullString(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+func splitList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func joinInts(values []int) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,29 @@
+package topics
+
+import "sync/atomic"
+
+// Feste IDs der Inline-Subscriptions der Gateway-Dienste. mochi unterscheidet Inline-Subscriptions
+// eines Filters nur über die ID, daher werden alle IDs hier zentral vergeben.
+const (
+	MethodCommandSubscriptionID     = 4711 // OPC-UA-Methodenaufrufe
+	AlarmSubscriptionID             = 4712 // Alarmsystem (data/#)
+	NotifyStateSubscriptionID       = 4713 // Benachrichtigungen (driver/states/#)
+	NotifyAlarmSubscriptionID       = 4714 // Benachrichtigungen (alarms/#)
+	HistorianDataSubscriptionID     = 4720 // Historian (data/#)
+	HistorianBackfillSubscriptionID = 4721 // Historian (backfill/#)
+)
+
+// dynamicSubscriptionBase liegt oberhalb aller festen IDs
+const dynamicSubscriptionBase = 100000
+
+var nextSubscriptionID atomic.Int64
+
+func init() {
+	nextSubscriptionID.Store(dynamicSubscriptionBase)
+}
+
+// NextSubscriptionID vergibt eine eindeutige ID für Inline-Subscriptions, die zur Laufzeit
+// entstehen (Treiber pro Gerät, WebSockets, Taps, InfluxDB-Writer).
+func NextSubscriptionID() int {
+	return int(nextSubscriptionID.Add(1))
+}
@@ -0,0 +1,22 @@
+// Package topics enthält Hilfsfunktionen für die internen MQTT-Topics des Gateways.
+package topics
+
+import "strings"
+
+// DataSource wandelt data/<type>/<deviceId>/[<dpId>] <name> in <type>/<deviceId>/<dpId> um.
+// Ohne [..]-Präfix (z.B. MQTT-Geräte) wird der restliche Topic-Pfad verwendet.
+// Für andere Topics wird ein leerer String geliefert.
+func DataSource(topic string) string {
+	parts := strings.SplitN(topic, "/", 4)
+	if len(parts) < 4 || parts[0] != "data" {
+		return ""
+	}
+
+	datapoint := parts[3]
+	if strings.HasPrefix(datapoint, "[") {
+		if end := strings.Index(datapoint, "]"); end > 0 {
+			datapoint = datapoint[1:end]
+		}
+	}
+	return parts[1] + "/" + parts[2] + "/" + datapoint
+}
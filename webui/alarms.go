@@ -0,0 +1,176 @@
+package webui
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"iot-gateway/alarms"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// getAlarms liefert Alarme. Query-Parameter: state (active, acknowledged, shelved, cleared, open), limit
+func getAlarms(c *gin.Context) {
+	limit := 100
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+
+	list, err := alarms.ListAlarms(c.Query("state"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"alarms": list})
+}
+
+// acknowledgeAlarm quittiert einen Alarm
+func acknowledgeAlarm(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Comment string `json:"comment"`
+	}
+	_ = c.ShouldBindJSON(&req)
+
+	if err := alarms.AcknowledgeAlarm(id, sessionUser(c), req.Comment); err != nil {
+		respondAlarmError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "success"})
+}
+
+// shelveAlarm stellt einen Alarm zurück (Body: {"durationMinutes": 60})
+func shelveAlarm(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		DurationMinutes int `json:"durationMinutes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.DurationMinutes <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "durationMinutes must be greater than 0"})
+		return
+	}
+
+	if err := alarms.ShelveAlarm(id, time.Duration(req.DurationMinutes)*time.Minute, sessionUser(c)); err != nil {
+		respondAlarmError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "success"})
+}
+
+// unshelveAlarm hebt das Zurückstellen eines Alarms auf
+func unshelveAlarm(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	if err := alarms.UnshelveAlarm(id); err != nil {
+		respondAlarmError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "success"})
+}
+
+// getAlarmRules liefert alle Alarmregeln
+func getAlarmRules(c *gin.Context) {
+	rules, err := alarms.ListRules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// addAlarmRule legt eine Alarmregel an
+func addAlarmRule(c *gin.Context) {
+	rule := alarms.Rule{Enabled: true, ExpectedState: true, Severity: 500}
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if err := alarms.CreateRule(&rule); err != nil {
+		respondAlarmError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rule": rule})
+}
+
+// updateAlarmRule ändert eine Alarmregel
+func updateAlarmRule(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	var rule alarms.Rule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	rule.ID = id
+
+	if err := alarms.UpdateRule(&rule); err != nil {
+		respondAlarmError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rule": rule})
+}
+
+// deleteAlarmRule löscht eine Alarmregel
+func deleteAlarmRule(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	if err := alarms.DeleteRule(id); err != nil {
+		respondAlarmError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Alarm rule deleted successfully"})
+}
+
+// respondAlarmError bildet Fehler des Alarmsystems auf HTTP-Statuscodes ab
+func respondAlarmError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, alarms.ErrAlarmNotFound), errors.Is(err, alarms.ErrRuleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, alarms.ErrInvalidState):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, alarms.ErrInvalidRule):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// parseIDParam liest den numerischen Pfadparameter :id
+func parseIDParam(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		return 0, false
+	}
+	return id, true
+}
+
+// sessionUser liefert den angemeldeten Benutzer der Session
+func sessionUser(c *gin.Context) string {
+	if user := sessions.Default(c).Get("user"); user != nil {
+		return fmt.Sprint(user)
+	}
+	return ""
+}
@@ -12,7 +12,8 @@ const STATUS_COLORS = {
     initializing: 'white',
     noDatapoints: 'white',
     noConnection: 'white',
-    connectionLost: 'orange'
+    connectionLost: 'orange',
+    stale: 'yellow'
 };
 
 // Funktion zum Erstellen eines Status-Icons
@@ -35,6 +36,7 @@ function createStatusIcon(status) {
             case 4: status = '4 (no datapoints)'; break;
             case 5: status = '5 (no connection)'; break;
             case 6: status = '6 (connection lost)'; break;
+            case 7: status = '7 (stale)'; break;
         }
     }
 
@@ -70,6 +72,10 @@ function createStatusIcon(status) {
             statusIcon.style.backgroundColor = STATUS_COLORS.connectionLost;
             statusIcon.title = 'connection lost';
             break;
+        case '7 (stale)':
+            statusIcon.style.backgroundColor = STATUS_COLORS.stale;
+            statusIcon.title = 'stale (no messages)';
+            break;
         default:
             console.warn('Unknown status:', status);
             statusIcon.style.backgroundColor = STATUS_COLORS.error;
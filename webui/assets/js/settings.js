@@ -387,6 +387,7 @@ function displaySettings(settings) {
         'mqtt': { name: 'MQTT Broker', icon: 'fas fa-broadcast-tower' },
         'system': { name: 'System', icon: 'fas fa-cog' },
         'backup': { name: 'Backup & Retention', icon: 'fas fa-database' },
+        'influxdb': { name: 'InfluxDB Retention & Downsampling', icon: 'fas fa-chart-line' },
         'docker': { name: 'Docker Configuration', icon: 'fab fa-docker' }
     };
 
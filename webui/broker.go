@@ -5,6 +5,8 @@ import (
 	"net/http"
 	"os"
 
+	"iot-gateway/mqtt_broker"
+
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
@@ -245,6 +247,9 @@ func addBrokerUser(c *gin.Context) {
 		}
 	}
 
+	// Broker übernimmt den Benutzer ohne Neustart
+	mqtt_broker.InvalidateAuth()
+
 	c.JSON(http.StatusOK, gin.H{"message": "User added successfully"})
 }
 
@@ -301,6 +306,9 @@ func deleteBrokerUser(c *gin.Context) {
 		return
 	}
 
+	// Verbundene Clients des Benutzers trennen
+	mqtt_broker.InvalidateAuth()
+
 	// Erfolgreiche Antwort
 	c.JSON(http.StatusOK, gin.H{
 		"message":  "Benutzer erfolgreich gelöscht",
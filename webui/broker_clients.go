@@ -0,0 +1,59 @@
+package webui
+
+import (
+	"errors"
+	"net/http"
+
+	"iot-gateway/mqtt_broker"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// getBrokerClients liefert alle Clients mit Subscriptions, Inflight Messages und Zählern.
+// Mit ?sort=messagesReceived|bytesReceived|receiveRate stehen die aktivsten Clients oben.
+func getBrokerClients(c *gin.Context) {
+	server, err := getMQTTServer(c)
+	if err != nil || server == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "MQTT broker not running"})
+		return
+	}
+	c.JSON(http.StatusOK, mqtt_broker.ListClients(server, c.Query("sort")))
+}
+
+// getBrokerClient liefert einen Client inklusive seiner Subscriptions
+func getBrokerClient(c *gin.Context) {
+	server, err := getMQTTServer(c)
+	if err != nil || server == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "MQTT broker not running"})
+		return
+	}
+	client, err := mqtt_broker.GetClient(server, c.Param("id"))
+	if errors.Is(err, mqtt_broker.ErrClientNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, client)
+}
+
+// disconnectBrokerClient trennt einen Client (MQTT 5: Reason Code "administrative action")
+func disconnectBrokerClient(c *gin.Context) {
+	server, err := getMQTTServer(c)
+	if err != nil || server == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "MQTT broker not running"})
+		return
+	}
+	clientID := c.Param("id")
+	if err := mqtt_broker.KickClient(server, clientID); err != nil {
+		if errors.Is(err, mqtt_broker.ErrClientNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		logrus.Errorf("Failed to disconnect MQTT client %s: %v", clientID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	logrus.Infof("MQTT client %s disconnected by %v", clientID, sessions.Default(c).Get("user"))
+	c.JSON(http.StatusOK, gin.H{"message": "Client disconnected"})
+}
@@ -0,0 +1,144 @@
+package webui
+
+import (
+	"errors"
+	"net/http"
+
+	"iot-gateway/mqtt_broker"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// listenerRequest ist der Body für das Anlegen und Ändern eines Listeners
+type listenerRequest struct {
+	ID         string `json:"id"`
+	Type       string `json:"type" binding:"required"`
+	Address    string `json:"address" binding:"required"`
+	TLSProfile string `json:"tlsProfile"`
+	Enabled    *bool  `json:"enabled"` // Standard: true
+}
+
+func (r listenerRequest) config() mqtt_broker.ListenerConfig {
+	enabled := r.Enabled == nil || *r.Enabled
+	return mqtt_broker.ListenerConfig{
+		ID:         r.ID,
+		Type:       r.Type,
+		Address:    r.Address,
+		TLSProfile: r.TLSProfile,
+		Enabled:    enabled,
+	}
+}
+
+// listenerError bildet die Fehler der Listener-Verwaltung auf HTTP-Statuscodes ab
+func listenerError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, mqtt_broker.ErrListenerNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, mqtt_broker.ErrInvalidListener):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, mqtt_broker.ErrListenerExists), errors.Is(err, mqtt_broker.ErrListenerUnavailable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		logrus.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// getBrokerListeners liefert alle Listener mit ihrem aktuellen Zustand
+func getBrokerListeners(c *gin.Context) {
+	db, err := getDBConnection(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	server, err := getMQTTServer(c)
+	if err != nil || server == nil {
+		configs, err := mqtt_broker.ListListeners(db)
+		if err != nil {
+			listenerError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, configs)
+		return
+	}
+
+	status, err := mqtt_broker.ListenerStatuses(server, db)
+	if err != nil {
+		listenerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// getBrokerListener liefert einen Listener
+func getBrokerListener(c *gin.Context) {
+	db, err := getDBConnection(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	cfg, err := mqtt_broker.GetListener(db, c.Param("id"))
+	if err != nil {
+		listenerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, cfg)
+}
+
+// createBrokerListener legt einen Listener an und startet ihn ohne Neustart des Brokers
+func createBrokerListener(c *gin.Context) {
+	db, err := getDBConnection(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var req listenerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	cfg, err := mqtt_broker.CreateListener(db, req.config())
+	if err != nil {
+		listenerError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, cfg)
+}
+
+// updateBrokerListener ändert einen Listener, der laufende Listener wird ersetzt
+func updateBrokerListener(c *gin.Context) {
+	db, err := getDBConnection(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var req listenerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	cfg, err := mqtt_broker.UpdateListener(db, c.Param("id"), req.config())
+	if err != nil {
+		listenerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, cfg)
+}
+
+// deleteBrokerListener schließt einen Listener und löscht ihn
+func deleteBrokerListener(c *gin.Context) {
+	db, err := getDBConnection(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := mqtt_broker.DeleteListener(db, c.Param("id")); err != nil {
+		listenerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Listener deleted"})
+}
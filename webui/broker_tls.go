@@ -0,0 +1,120 @@
+package webui
+
+import (
+	"errors"
+	"net/http"
+
+	"iot-gateway/mqtt_broker"
+
+	"github.com/gin-gonic/gin"
+)
+
+// brokerCerts liefert die Zertifikatsverwaltung oder meldet einen Fehler
+func brokerCerts(c *gin.Context) (*mqtt_broker.CertManager, bool) {
+	certs := mqtt_broker.Certificates()
+	if certs == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "MQTT broker not running"})
+		return nil, false
+	}
+	return certs, true
+}
+
+// certError antwortet mit 409, wenn die Zertifikate per Datei konfiguriert sind, sonst mit 400
+func certError(c *gin.Context, err error) {
+	if errors.Is(err, mqtt_broker.ErrCertsFromFiles) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+// getBrokerTLS liefert Serverzertifikat, Client-CAs und mTLS-Modus
+func getBrokerTLS(c *gin.Context) {
+	certs, ok := brokerCerts(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, certs.Status())
+}
+
+// downloadBrokerCertificate liefert das Serverzertifikat (PEM) zum Pinnen in Clients
+func downloadBrokerCertificate(c *gin.Context) {
+	certs, ok := brokerCerts(c)
+	if !ok {
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="mqtt-broker.crt"`)
+	c.Data(http.StatusOK, "application/x-pem-file", certs.ServerCertificatePEM())
+}
+
+// uploadBrokerCertificate tauscht Serverzertifikat und Schlüssel ohne Neustart aus
+func uploadBrokerCertificate(c *gin.Context) {
+	certs, ok := brokerCerts(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Certificate string `json:"certificate" binding:"required"`
+		Key         string `json:"key" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	info, err := certs.SetServerCertificate([]byte(req.Certificate), []byte(req.Key))
+	if err != nil {
+		certError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"server": info})
+}
+
+// regenerateBrokerCertificate erzeugt ein neues selbstsigniertes Serverzertifikat
+func regenerateBrokerCertificate(c *gin.Context) {
+	certs, ok := brokerCerts(c)
+	if !ok {
+		return
+	}
+	info, err := certs.RegenerateServerCertificate()
+	if err != nil {
+		certError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"server": info})
+}
+
+// uploadBrokerClientCA setzt die CA, gegen die Client-Zertifikate geprüft werden
+func uploadBrokerClientCA(c *gin.Context) {
+	certs, ok := brokerCerts(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Certificate string `json:"certificate" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	cas, err := certs.SetClientCA([]byte(req.Certificate))
+	if err != nil {
+		certError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"clientCAs": cas})
+}
+
+// deleteBrokerClientCA entfernt die Client-CA
+func deleteBrokerClientCA(c *gin.Context) {
+	certs, ok := brokerCerts(c)
+	if !ok {
+		return
+	}
+	if err := certs.RemoveClientCA(); err != nil {
+		certError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Client CA removed"})
+}
@@ -0,0 +1,197 @@
+package webui
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"iot-gateway/mqtt_broker"
+	"iot-gateway/topics"
+
+	"github.com/gin-gonic/gin"
+	MQTT "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	tapDefaultRate = 20   // Nachrichten pro Sekunde
+	tapMaxRate     = 200  // Obergrenze für ?rate=
+	tapBufferSize  = 256  // Gepufferte Nachrichten, bevor verworfen wird
+	tapMaxPayload  = 4096 // Gesendete Bytes je Payload
+)
+
+// getBrokerTopics liefert den Topic-Baum aus beobachteten Topics und Retained Messages
+func getBrokerTopics(c *gin.Context) {
+	server, err := getMQTTServer(c)
+	if err != nil || server == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "MQTT broker not running"})
+		return
+	}
+	c.JSON(http.StatusOK, mqtt_broker.TopicTree(server, c.Query("prefix")))
+}
+
+// tapMessage ist eine an den Browser weitergeleitete MQTT-Nachricht
+type tapMessage struct {
+	Type            string    `json:"type"`
+	Topic           string    `json:"topic"`
+	Payload         string    `json:"payload"`
+	PayloadEncoding string    `json:"payloadEncoding"`
+	Truncated       bool      `json:"truncated,omitempty"`
+	Size            int       `json:"size"`
+	Qos             byte      `json:"qos"`
+	Retain          bool      `json:"retain"`
+	Timestamp       time.Time `json:"timestamp"`
+}
+
+// tapLimiter begrenzt die Nachrichten pro Sekunde (Token Bucket, Burst = rate)
+type tapLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTapLimiter(rate int) *tapLimiter {
+	return &tapLimiter{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+func (l *tapLimiter) allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// brokerTapWebSocket streamt Nachrichten, die auf ?filter= passen, über den Inline-Client.
+// ?rate= begrenzt die Nachrichten pro Sekunde, verworfene Nachrichten werden sekündlich gemeldet.
+func brokerTapWebSocket(c *gin.Context) {
+	// Token-Überprüfung
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token is required"})
+		return
+	}
+	wsTokenStore.RLock()
+	expiration, exists := wsTokenStore.tokens[token]
+	wsTokenStore.RUnlock()
+	if !exists || expiration.Before(time.Now()) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		return
+	}
+
+	filter := c.Query("filter")
+	if filter == "" || !MQTT.IsValidFilter(filter, false) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid topic filter"})
+		return
+	}
+	rate := tapDefaultRate
+	if val := c.Query("rate"); val != "" {
+		r, err := strconv.Atoi(val)
+		if err != nil || r < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rate"})
+			return
+		}
+		rate = min(r, tapMaxRate)
+	}
+
+	server, err := getMQTTServer(c)
+	if err != nil || server == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "MQTT broker not running"})
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		logrus.Errorf("Error upgrading to WebSocket: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	messages := make(chan tapMessage, tapBufferSize)
+	var dropped atomic.Int64
+	limiter := newTapLimiter(rate)
+
+	callbackFn := func(cl *MQTT.Client, sub packets.Subscription, pk packets.Packet) {
+		if !limiter.allow() {
+			dropped.Add(1)
+			return
+		}
+		payload := pk.Payload
+		if len(payload) > tapMaxPayload {
+			payload = payload[:tapMaxPayload]
+		}
+		msg := tapMessage{
+			Type:      "message",
+			Topic:     pk.TopicName,
+			Truncated: len(pk.Payload) > tapMaxPayload,
+			Size:      len(pk.Payload),
+			Qos:       pk.FixedHeader.Qos,
+			Retain:    pk.FixedHeader.Retain,
+			Timestamp: time.Now(),
+		}
+		msg.Payload, msg.PayloadEncoding = mqtt_broker.EncodePayload(payload)
+		select {
+		case messages <- msg:
+		default:
+			dropped.Add(1)
+		}
+	}
+
+	subscriptionID := topics.NextSubscriptionID()
+	if err := server.Subscribe(filter, subscriptionID, callbackFn); err != nil {
+		logrus.Errorf("Error subscribing to topic %s: %v", filter, err)
+		conn.WriteJSON(gin.H{"type": "error", "error": err.Error()})
+		return
+	}
+	defer server.Unsubscribe(filter, subscriptionID)
+	logrus.Infof("MQTT tap on %s started (max %d msg/s)", filter, rate)
+
+	if err := conn.WriteJSON(gin.H{"type": "subscribed", "filter": filter, "rate": rate}); err != nil {
+		return
+	}
+
+	// Verbindungsabbruch erkennen, Nachrichten des Browsers werden ignoriert
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			logrus.Infof("MQTT tap on %s stopped", filter)
+			return
+		case msg := <-messages:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if n := dropped.Swap(0); n > 0 {
+				conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+				if err := conn.WriteJSON(gin.H{"type": "dropped", "count": n}); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
@@ -4,7 +4,12 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	mqtt_driver "iot-gateway/driver/mqtt"
+	"iot-gateway/driver/opcua"
+	"iot-gateway/driver/virtual"
 	"iot-gateway/logic"
+	"iot-gateway/mqtt_broker"
+	"iot-gateway/topics"
 	"net/http"
 	"sort"
 	"strconv"
@@ -18,7 +23,6 @@ import (
 	MQTT "github.com/mochi-mqtt/server/v2"
 	"github.com/mochi-mqtt/server/v2/packets"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/exp/rand"
 )
 
 type Device struct {
@@ -26,6 +30,8 @@ type Device struct {
 	DeviceType      string         `json:"deviceType"`
 	DeviceName      string         `json:"deviceName"`
 	Status          string         `json:"status"`
+	StatusMessage   string         `json:"statusMessage,omitempty"` // z.B. Zuordnungsfehler bei MQTT-Geräten
+	LastSeen        string         `json:"lastSeen,omitempty"`      // Nur MQTT: letzte Aktivität (RFC3339)
 	Value           string         `json:"value"`
 	Connected       bool           `json:"connected"`
 	Address         string         `json:"address,omitempty"`
@@ -33,15 +39,22 @@ type Device struct {
 	SecurityMode    sql.NullString `json:"securityMode,omitempty"`
 	SecurityPolicy  sql.NullString `json:"securityPolicy,omitempty"`
 	DataPoint       []struct {
-		DatapointId string `json:"datapointId"`
-		Name        string `json:"name"`
-		Datatype    string `json:"datatype,omitempty"`
-		Address     string `json:"address"`
+		DatapointId string                 `json:"datapointId"`
+		Name        string                 `json:"name"`
+		Datatype    string                 `json:"datatype,omitempty"`
+		Address     string                 `json:"address"`
+		Publish     *opcua.PublishSettings `json:"publish,omitempty"`
+		ScanGroup   int64                  `json:"scanGroup,omitempty"`
+		Topic       string                 `json:"topic,omitempty"`
 	} `json:"datapoint,omitempty"`
-	Rack     sql.NullString `json:"rack,omitempty"`
-	Slot     sql.NullString `json:"slot,omitempty"`
-	Username sql.NullString `json:"username,omitempty"`
-	Password sql.NullString `json:"password,omitempty"`
+	Rack              sql.NullString    `json:"rack,omitempty"`
+	Slot              sql.NullString    `json:"slot,omitempty"`
+	Username          sql.NullString    `json:"username,omitempty"`
+	Password          sql.NullString    `json:"password,omitempty"`
+	HistoryBackfill   sql.NullBool      `json:"historyBackfill,omitempty"`
+	EventSubscription sql.NullBool      `json:"eventSubscription,omitempty"`
+	EventFields       sql.NullString    `json:"eventFields,omitempty"`
+	ScanGroups        []opcua.ScanGroup `json:"scanGroups,omitempty"`
 }
 
 type Datapoint struct {
@@ -77,7 +90,7 @@ func getDevices(c *gin.Context) {
 	}
 
 	var devices []Device
-	rows, err := db.Query("SELECT id, name, type, address, acquisition_time, status FROM devices")
+	rows, err := db.Query("SELECT id, name, type, address, acquisition_time, status, COALESCE(status_message, ''), COALESCE(last_seen, '') FROM devices")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -86,7 +99,7 @@ func getDevices(c *gin.Context) {
 
 	for rows.Next() {
 		var device Device
-		err := rows.Scan(&device.ID, &device.DeviceName, &device.DeviceType, &device.Address, &device.AcquisitionTime, &device.Status)
+		err := rows.Scan(&device.ID, &device.DeviceName, &device.DeviceType, &device.Address, &device.AcquisitionTime, &device.Status, &device.StatusMessage, &device.LastSeen)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -115,8 +128,8 @@ func getDevice(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
 		return
 	}
-	query := `SELECT name, type, address, acquisition_time, rack, slot, security_mode, security_policy, username, password FROM devices WHERE id = ?`
-	err = db.QueryRow(query, device.ID).Scan(&device.DeviceName, &device.DeviceType, &device.Address, &device.AcquisitionTime, &device.Rack, &device.Slot, &device.SecurityMode, &device.SecurityPolicy, &device.Username, &device.Password)
+	query := `SELECT name, type, address, acquisition_time, status, COALESCE(status_message, ''), COALESCE(last_seen, ''), rack, slot, security_mode, security_policy, username, password, history_backfill, event_subscription, event_fields FROM devices WHERE id = ?`
+	err = db.QueryRow(query, device.ID).Scan(&device.DeviceName, &device.DeviceType, &device.Address, &device.AcquisitionTime, &device.Status, &device.StatusMessage, &device.LastSeen, &device.Rack, &device.Slot, &device.SecurityMode, &device.SecurityPolicy, &device.Username, &device.Password, &device.HistoryBackfill, &device.EventSubscription, &device.EventFields)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		logrus.Info(err)
@@ -125,11 +138,13 @@ func getDevice(c *gin.Context) {
 
 	// Fetch datapoints for the device
 	if device.DeviceType == "opc-ua" {
-		query = `SELECT datapointId, name, node_identifier FROM opcua_datanodes WHERE device_id = ?`
+		query = `SELECT datapointId, name, node_identifier, COALESCE(publish_settings, ''), COALESCE(scan_group_id, 0) FROM opcua_datanodes WHERE device_id = ?`
 	} else if device.DeviceType == "s7" {
-		query = `SELECT datapointId, name, datatype, address FROM s7_datapoints WHERE device_id = ?`
+		query = `SELECT datapointId, name, datatype, address, COALESCE(publish_settings, ''), COALESCE(scan_group_id, 0) FROM s7_datapoints WHERE device_id = ?`
+	} else if device.DeviceType == "virtual" {
+		query = `SELECT datapointId, name, expression, '', 0 FROM virtual_datapoints WHERE device_id = ?`
 	} else if device.DeviceType == "mqtt" {
-		// do nothing
+		query = `SELECT datapointId, name, datatype, expression, COALESCE(publish_settings, ''), topic FROM mqtt_mappings WHERE device_id = ?`
 	} else {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported device type"})
 		return
@@ -145,27 +160,53 @@ func getDevice(c *gin.Context) {
 
 	for rows.Next() {
 		var node struct {
-			DatapointId string `json:"datapointId"`
-			Name        string `json:"name"`
-			Datatype    string `json:"datatype,omitempty"`
-			Address     string `json:"address"`
+			DatapointId string                 `json:"datapointId"`
+			Name        string                 `json:"name"`
+			Datatype    string                 `json:"datatype,omitempty"`
+			Address     string                 `json:"address"`
+			Publish     *opcua.PublishSettings `json:"publish,omitempty"`
+			ScanGroup   int64                  `json:"scanGroup,omitempty"`
+			Topic       string                 `json:"topic,omitempty"`
 		}
-		if device.DeviceType == "opc-ua" {
-			if err := rows.Scan(&node.DatapointId, &node.Name, &node.Address); err != nil {
+		var publishSettings string
+		if device.DeviceType == "opc-ua" || device.DeviceType == "virtual" {
+			if err := rows.Scan(&node.DatapointId, &node.Name, &node.Address, &publishSettings, &node.ScanGroup); err != nil {
 			}
-			if err := rows.Scan(&node.DatapointId, &node.Name, &node.Address); err != nil {
+			if err := rows.Scan(&node.DatapointId, &node.Name, &node.Address, &publishSettings, &node.ScanGroup); err != nil {
 				logrus.Error("Error scanning OPC-UA node:", err)
 				continue
 			}
+			if publishSettings != "" {
+				settings := opcua.ParsePublishSettings(publishSettings)
+				node.Publish = &settings
+			}
 			device.DataPoint = append(device.DataPoint, node)
 		} else if device.DeviceType == "s7" {
-			if err := rows.Scan(&node.DatapointId, &node.Name, &node.Datatype, &node.Address); err != nil {
+			if err := rows.Scan(&node.DatapointId, &node.Name, &node.Datatype, &node.Address, &publishSettings, &node.ScanGroup); err != nil {
 				logrus.Error("Error scanning S7 point:", err)
 				continue
 			}
+			if publishSettings != "" {
+				settings := opcua.ParsePublishSettings(publishSettings)
+				node.Publish = &settings
+			}
 			device.DataPoint = append(device.DataPoint, node)
 		} else if device.DeviceType == "mqtt" {
-			// do nothing
+			if err := rows.Scan(&node.DatapointId, &node.Name, &node.Datatype, &node.Address, &publishSettings, &node.Topic); err != nil {
+				logrus.Error("Error scanning MQTT mapping:", err)
+				continue
+			}
+			if publishSettings != "" {
+				settings := opcua.ParsePublishSettings(publishSettings)
+				node.Publish = &settings
+			}
+			device.DataPoint = append(device.DataPoint, node)
+		}
+	}
+
+	if device.DeviceType == "s7" || device.DeviceType == "opc-ua" {
+		if device.ScanGroups, err = readScanGroups(db, strconv.Itoa(device.ID)); err != nil {
+			logrus.Error("Error querying scan groups:", err)
 		}
 	}
 
@@ -312,15 +353,19 @@ func deviceDataWebSocket(c *gin.Context) {
 	server := c.MustGet("server").(*MQTT.Server)
 
 	// Abonniere die MQTT-Topics
-	if err := server.Subscribe("data/#", rand.Intn(100), callbackFn); err != nil {
+	dataSubscriptionID := topics.NextSubscriptionID()
+	if err := server.Subscribe("data/#", dataSubscriptionID, callbackFn); err != nil {
 		logrus.Errorf("Error subscribing to topic data/#: %v", err)
 		return
 	}
+	defer server.Unsubscribe("data/#", dataSubscriptionID)
 
-	if err := server.Subscribe("driver/states/#", rand.Intn(100), callbackFn); err != nil {
+	stateSubscriptionID := topics.NextSubscriptionID()
+	if err := server.Subscribe("driver/states/#", stateSubscriptionID, callbackFn); err != nil {
 		logrus.Errorf("Error subscribing to topic driver/states/#: %v", err)
 		return
 	}
+	defer server.Unsubscribe("driver/states/#", stateSubscriptionID)
 
 	// Goroutine für Ping-Nachrichten
 	go func() {
@@ -570,6 +615,11 @@ func addDevice(c *gin.Context) {
 		Slot      string   `json:"slot,omitempty"`
 		Username  string   `json:"username"`
 		Password  string   `json:"password"`
+		// Nur OPC-UA: Ausfallzeitraum nach Reconnect per HistoryRead nachholen
+		HistoryBackfill bool `json:"historyBackfill,omitempty"`
+		// Nur OPC-UA: Alarms & Conditions abonnieren
+		EventSubscription bool   `json:"eventSubscription,omitempty"`
+		EventFields       string `json:"eventFields,omitempty"`
 	}
 	var deviceData Device
 
@@ -596,13 +646,21 @@ func addDevice(c *gin.Context) {
 		return
 	}
 
+	// Der Name eines MQTT-Geräts ist Teil seiner Topics und Broker-Berechtigungen
+	if deviceData.DeviceType == "mqtt" {
+		if err := mqtt_driver.ValidateDeviceName(deviceData.DeviceName, ""); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+	}
+
 	// Füge das Gerät direkt in die 'devices'-Tabelle ein
 	query := `
-		INSERT INTO devices (type, name, address, acquisition_time, security_mode, security_policy, rack, slot, username, password, status)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO devices (type, name, address, acquisition_time, security_mode, security_policy, rack, slot, username, password, history_backfill, event_subscription, event_fields, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 	_, err = db.Exec(query, deviceData.DeviceType, deviceData.DeviceName, deviceData.Address,
-		deviceData.AcquisitionTime, deviceData.SecurityMode, deviceData.SecurityPolicy, deviceData.Rack, deviceData.Slot, deviceData.Username, deviceData.Password, logic.Initializing)
+		deviceData.AcquisitionTime, deviceData.SecurityMode, deviceData.SecurityPolicy, deviceData.Rack, deviceData.Slot, deviceData.Username, deviceData.Password, deviceData.HistoryBackfill, deviceData.EventSubscription, deviceData.EventFields, logic.Initializing)
 	if err != nil {
 		logrus.Println("Error inserting device data into the database:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error inserting device data"})
@@ -674,28 +732,35 @@ func deleteDevice(c *gin.Context) {
 
 // DeviceDatapoint-Struktur für bessere Wiederverwendbarkeit
 type DeviceDatapoint struct {
-	DatapointId string `json:"datapointId"`
-	Name        string `json:"name"`
-	Datatype    string `json:"datatype"`
-	Address     string `json:"address"`
+	DatapointId string                 `json:"datapointId"`
+	Name        string                 `json:"name"`
+	Datatype    string                 `json:"datatype"`
+	Address     string                 `json:"address"`
+	Publish     *opcua.PublishSettings `json:"publish,omitempty"`   // Nur S7 und OPC-UA, fehlt das Feld, bleiben die gespeicherten Einstellungen erhalten
+	ScanGroup   *int64                 `json:"scanGroup,omitempty"` // Nur S7 und OPC-UA, 0 = Zykluszeit des Geräts, fehlt das Feld, bleibt die Gruppe erhalten
+	Topic       string                 `json:"topic,omitempty"`     // Nur MQTT: Topic-Filter relativ zu data/mqtt/<name>/, Address = Feldausdruck
 }
 
 // Device-Struktur für Update-Requests
 type UpdateDeviceRequest struct {
-	DeviceType      string            `json:"deviceType"`
-	DeviceName      string            `json:"deviceName"`
-	Status          string            `json:"status"`
-	Value           string            `json:"value"`
-	Connected       bool              `json:"connected"`
-	Address         string            `json:"address,omitempty"`
-	AcquisitionTime int               `json:"acquisitionTime,omitempty"`
-	SecurityMode    string            `json:"securityMode,omitempty"`
-	SecurityPolicy  string            `json:"securityPolicy,omitempty"`
-	DataPoints      []DeviceDatapoint `json:"datapoints,omitempty"`
-	Rack            string            `json:"rack,omitempty"`
-	Slot            string            `json:"slot,omitempty"`
-	Username        string            `json:"username,omitempty"`
-	Password        string            `json:"password,omitempty"`
+	DeviceType        string            `json:"deviceType"`
+	DeviceName        string            `json:"deviceName"`
+	Status            string            `json:"status"`
+	Value             string            `json:"value"`
+	Connected         bool              `json:"connected"`
+	Address           string            `json:"address,omitempty"`
+	AcquisitionTime   int               `json:"acquisitionTime,omitempty"`
+	SecurityMode      string            `json:"securityMode,omitempty"`
+	SecurityPolicy    string            `json:"securityPolicy,omitempty"`
+	DataPoints        []DeviceDatapoint `json:"datapoints,omitempty"`
+	Rack              string            `json:"rack,omitempty"`
+	Slot              string            `json:"slot,omitempty"`
+	Username          string            `json:"username,omitempty"`
+	Password          string            `json:"password,omitempty"`
+	HistoryBackfill   *bool             `json:"historyBackfill,omitempty"`   // Nur OPC-UA, fehlt das Feld, bleibt die Einstellung unverändert
+	EventSubscription *bool             `json:"eventSubscription,omitempty"` // Nur OPC-UA, fehlt das Feld, bleibt die Einstellung unverändert
+	EventFields       *string           `json:"eventFields,omitempty"`       // Nur OPC-UA, fehlt das Feld, bleibt die Einstellung unverändert
+	ScanGroups        []opcua.ScanGroup `json:"scanGroups"`                  // Nur S7 und OPC-UA, fehlt das Feld, bleiben die Gruppen unverändert
 }
 
 // Hilfsfunktion: Validiert S7-Datenpunkte
@@ -724,6 +789,81 @@ func validateOpcUaDatapoints(datapoints []DeviceDatapoint) []DeviceDatapoint {
 	return validDatapoints
 }
 
+// Hilfsfunktion: Prüft die Veröffentlichungs-Einstellungen der Datenpunkte
+func validatePublishSettings(datapoints []DeviceDatapoint) error {
+	for _, dp := range datapoints {
+		if dp.Publish == nil {
+			continue
+		}
+		if err := dp.Publish.Validate(); err != nil {
+			return fmt.Errorf("invalid publish settings for datapoint %s: %v", dp.Name, err)
+		}
+	}
+	return nil
+}
+
+// Hilfsfunktion: Serialisiert die Veröffentlichungs-Einstellungen (NULL = Standardverhalten)
+func publishSettingsValue(dp DeviceDatapoint) interface{} {
+	if dp.Publish == nil || *dp.Publish == (opcua.PublishSettings{}) {
+		return nil
+	}
+	raw, err := json.Marshal(dp.Publish)
+	if err != nil {
+		return nil
+	}
+	return string(raw)
+}
+
+// storedDatapoint sind die gespeicherten Einstellungen eines Datenpunkts, die das Formular nicht sendet
+type storedDatapoint struct {
+	publish   sql.NullString
+	scanGroup int64
+}
+
+// Hilfsfunktion: Liest Veröffentlichungs-Einstellungen und Erfassungsgruppe der Datenpunkte eines Geräts
+// (DatapointId -> Einstellungen), damit fehlende Felder beim Speichern erhalten bleiben. Gruppen, die es
+// nach dem Speichern der Erfassungsgruppen nicht mehr gibt, werden als Standardgruppe 0 geliefert.
+func storedDatapoints(db *sql.DB, table, deviceId string) (map[string]storedDatapoint, error) {
+	rows, err := db.Query(`SELECT datapointId, publish_settings,
+		CASE WHEN scan_group_id IN (SELECT group_id FROM scan_groups WHERE device_id = ?) THEN scan_group_id ELSE 0 END
+		FROM `+table+` WHERE device_id = ?`, deviceId, deviceId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stored := make(map[string]storedDatapoint)
+	for rows.Next() {
+		var id string
+		var dp storedDatapoint
+		if err := rows.Scan(&id, &dp.publish, &dp.scanGroup); err != nil {
+			return nil, err
+		}
+		stored[id] = dp
+	}
+	return stored, rows.Err()
+}
+
+// Hilfsfunktion: Veröffentlichungs-Einstellungen für das Speichern. Fehlt das Feld im Request,
+// bleibt der gespeicherte Wert erhalten.
+func mergedPublishSettings(dp DeviceDatapoint, stored map[string]storedDatapoint) interface{} {
+	if dp.Publish == nil {
+		if old, ok := stored[dp.DatapointId]; ok && old.publish.Valid {
+			return old.publish.String
+		}
+	}
+	return publishSettingsValue(dp)
+}
+
+// Hilfsfunktion: Erfassungsgruppe für das Speichern. Fehlt das Feld im Request,
+// bleibt die gespeicherte Gruppe erhalten.
+func mergedScanGroup(dp DeviceDatapoint, stored map[string]storedDatapoint) int64 {
+	if dp.ScanGroup != nil {
+		return *dp.ScanGroup
+	}
+	return stored[dp.DatapointId].scanGroup
+}
+
 // Hilfsfunktion: Generiert DatapointId für S7
 func generateS7DatapointId(db *sql.DB, deviceId int) (string, error) {
 	var nextId int
@@ -746,8 +886,13 @@ func generateOpcUaDatapointId(db *sql.DB, deviceId int) (string, error) {
 
 // Hilfsfunktion: Aktualisiert S7-Datenpunkte
 func updateS7Datapoints(db *sql.DB, deviceId string, datapoints []DeviceDatapoint) error {
+	stored, err := storedDatapoints(db, "s7_datapoints", deviceId)
+	if err != nil {
+		return fmt.Errorf("error reading stored S7 datapoints: %v", err)
+	}
+
 	// IMMER alle alten Datenpunkte löschen (auch wenn keine neuen kommen)
-	_, err := db.Exec(`DELETE FROM s7_datapoints WHERE device_id = (SELECT id FROM devices WHERE id = ?)`, deviceId)
+	_, err = db.Exec(`DELETE FROM s7_datapoints WHERE device_id = (SELECT id FROM devices WHERE id = ?)`, deviceId)
 	if err != nil {
 		return fmt.Errorf("error clearing old S7 datapoints: %v", err)
 	}
@@ -772,8 +917,8 @@ func updateS7Datapoints(db *sql.DB, deviceId string, datapoints []DeviceDatapoin
 				logrus.Debugf("Generated S7 DatapointId: %s", dp.DatapointId)
 			}
 
-			_, err = db.Exec(`INSERT INTO s7_datapoints (device_id, datapointId, name, datatype, address) VALUES ((SELECT id FROM devices WHERE id = ?), ?, ?, ?, ?)`,
-				deviceId, dp.DatapointId, dp.Name, dp.Datatype, dp.Address)
+			_, err = db.Exec(`INSERT INTO s7_datapoints (device_id, datapointId, name, datatype, address, publish_settings, scan_group_id) VALUES ((SELECT id FROM devices WHERE id = ?), ?, ?, ?, ?, ?, ?)`,
+				deviceId, dp.DatapointId, dp.Name, dp.Datatype, dp.Address, mergedPublishSettings(dp, stored), mergedScanGroup(dp, stored))
 			if err != nil {
 				return fmt.Errorf("error inserting S7 datapoint: %v", err)
 			}
@@ -787,8 +932,13 @@ func updateS7Datapoints(db *sql.DB, deviceId string, datapoints []DeviceDatapoin
 
 // Hilfsfunktion: Aktualisiert OPC-UA-Datenpunkte
 func updateOpcUaDatapoints(db *sql.DB, deviceId string, datapoints []DeviceDatapoint) error {
+	stored, err := storedDatapoints(db, "opcua_datanodes", deviceId)
+	if err != nil {
+		return fmt.Errorf("error reading stored OPC-UA nodes: %v", err)
+	}
+
 	// IMMER alle alten Datenpunkte löschen (auch wenn keine neuen kommen)
-	_, err := db.Exec(`DELETE FROM opcua_datanodes WHERE device_id = (SELECT id FROM devices WHERE id = ?)`, deviceId)
+	_, err = db.Exec(`DELETE FROM opcua_datanodes WHERE device_id = (SELECT id FROM devices WHERE id = ?)`, deviceId)
 	if err != nil {
 		return fmt.Errorf("error clearing old OPC-UA nodes: %v", err)
 	}
@@ -812,8 +962,8 @@ func updateOpcUaDatapoints(db *sql.DB, deviceId string, datapoints []DeviceDatap
 				logrus.Debugf("Generated OPC-UA DatapointId: %s", dp.DatapointId)
 			}
 
-			_, err = db.Exec(`INSERT INTO opcua_datanodes (device_id, datapointId, name, node_identifier) VALUES (?, ?, ?, ?)`,
-				devId, dp.DatapointId, dp.Name, dp.Address)
+			_, err = db.Exec(`INSERT INTO opcua_datanodes (device_id, datapointId, name, node_identifier, publish_settings, scan_group_id) VALUES (?, ?, ?, ?, ?, ?)`,
+				devId, dp.DatapointId, dp.Name, dp.Address, mergedPublishSettings(dp, stored), mergedScanGroup(dp, stored))
 			if err != nil {
 				return fmt.Errorf("error inserting OPC-UA datapoint: %v", err)
 			}
@@ -858,8 +1008,10 @@ func updateDevice(c *gin.Context) {
 		err = updateS7Device(db, device_id, &updatedDevice)
 	case "opc-ua":
 		err = updateOpcUaDevice(db, device_id, &updatedDevice)
+	case "virtual":
+		err = updateVirtualDevice(db, device_id, &updatedDevice)
 	case "mqtt":
-		err = updateMqttDevice(db, &updatedDevice)
+		err = updateMqttDevice(db, device_id, &updatedDevice)
 	default:
 		logrus.Warnf("Unknown device type: %s", updatedDevice.DeviceType)
 	}
@@ -886,6 +1038,13 @@ func updateDevice(c *gin.Context) {
 
 // Hilfsfunktion: Aktualisiert S7-Gerät
 func updateS7Device(db *sql.DB, deviceId string, device *UpdateDeviceRequest) error {
+	if err := validatePublishSettings(device.DataPoints); err != nil {
+		return err
+	}
+	if err := updateScanGroups(db, deviceId, device); err != nil {
+		return err
+	}
+
 	// Aktualisiere die S7-spezifischen Felder
 	query := `UPDATE devices SET rack = ?, slot = ? WHERE id = ?`
 	_, err := db.Exec(query, device.Rack, device.Slot, deviceId)
@@ -908,9 +1067,16 @@ func updateS7Device(db *sql.DB, deviceId string, device *UpdateDeviceRequest) er
 
 // Hilfsfunktion: Aktualisiert OPC-UA-Gerät
 func updateOpcUaDevice(db *sql.DB, deviceId string, device *UpdateDeviceRequest) error {
+	if err := validatePublishSettings(device.DataPoints); err != nil {
+		return err
+	}
+	if err := updateScanGroups(db, deviceId, device); err != nil {
+		return err
+	}
+
 	// Aktualisiere die OPC-UA-spezifischen Felder
-	query := `UPDATE devices SET security_mode = ?, security_policy = ?, username = ?, password = ? WHERE id = ?`
-	_, err := db.Exec(query, device.SecurityMode, device.SecurityPolicy, device.Username, device.Password, deviceId)
+	query := `UPDATE devices SET security_mode = ?, security_policy = ?, username = ?, password = ?, history_backfill = COALESCE(?, history_backfill), event_subscription = COALESCE(?, event_subscription), event_fields = COALESCE(?, event_fields) WHERE id = ?`
+	_, err := db.Exec(query, device.SecurityMode, device.SecurityPolicy, device.Username, device.Password, device.HistoryBackfill, device.EventSubscription, device.EventFields, deviceId)
 	if err != nil {
 		return fmt.Errorf("error updating OPC-UA-specific fields: %v", err)
 	}
@@ -928,8 +1094,86 @@ func updateOpcUaDevice(db *sql.DB, deviceId string, device *UpdateDeviceRequest)
 	return nil
 }
 
-// Hilfsfunktion: Aktualisiert MQTT-Gerät
-func updateMqttDevice(db *sql.DB, device *UpdateDeviceRequest) error {
+// Hilfsfunktion: Aktualisiert virtuelles Gerät. Address enthält den Ausdruck des Datenpunkts.
+func updateVirtualDevice(db *sql.DB, deviceId string, device *UpdateDeviceRequest) error {
+	validDatapoints := make([]DeviceDatapoint, 0)
+	for _, dp := range device.DataPoints {
+		if dp.Name == "" || dp.Address == "" {
+			logrus.Debugf("Skipping invalid virtual datapoint: %+v", dp)
+			continue
+		}
+		if _, err := virtual.Compile(dp.Address); err != nil {
+			return fmt.Errorf("invalid expression for datapoint %s: %v", dp.Name, err)
+		}
+		validDatapoints = append(validDatapoints, dp)
+	}
+
+	_, err := db.Exec(`DELETE FROM virtual_datapoints WHERE device_id = ?`, deviceId)
+	if err != nil {
+		return fmt.Errorf("error clearing old virtual datapoints: %v", err)
+	}
+
+	devId, err := strconv.Atoi(deviceId)
+	if err != nil {
+		return fmt.Errorf("error converting device_id to int: %v", err)
+	}
+	for _, dp := range validDatapoints {
+		if dp.DatapointId == "" {
+			dp.DatapointId, err = generateVirtualDatapointId(db, devId)
+			if err != nil {
+				return fmt.Errorf("error generating virtual datapoint ID: %v", err)
+			}
+		}
+		_, err = db.Exec(`INSERT INTO virtual_datapoints (device_id, datapointId, name, expression) VALUES (?, ?, ?, ?)`,
+			devId, dp.DatapointId, dp.Name, dp.Address)
+		if err != nil {
+			return fmt.Errorf("error inserting virtual datapoint: %v", err)
+		}
+	}
+
+	logrus.Infof("Virtual device and %d datapoints updated successfully for %s", len(validDatapoints), device.DeviceName)
+	return nil
+}
+
+// Hilfsfunktion: Generiert DatapointId für virtuelle Datenpunkte
+func generateVirtualDatapointId(db *sql.DB, deviceId int) (string, error) {
+	var nextId int
+	err := db.QueryRow(`SELECT COALESCE(MAX(CAST(SUBSTR(datapointId, -3) AS INTEGER)), 0) + 1 FROM virtual_datapoints WHERE device_id = ?`, deviceId).Scan(&nextId)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("1%03d%03d", deviceId, nextId), nil
+}
+
+// validateExpression prüft einen Ausdruck für virtuelle Datenpunkte und liefert die verwendeten Quellen
+func validateExpression(c *gin.Context) {
+	var req struct {
+		Expression string `json:"expression"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Expression == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expression is required"})
+		return
+	}
+
+	expr, err := virtual.Compile(req.Expression)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"valid": false, "error": err.Error()})
+		return
+	}
+	sources := expr.Sources()
+	sort.Strings(sources)
+	c.JSON(http.StatusOK, gin.H{"valid": true, "sources": sources, "boolean": expr.IsBoolean()})
+}
+
+// Hilfsfunktion: Aktualisiert MQTT-Gerät (Broker-Benutzer, ACL und Zuordnungen der Payloads)
+func updateMqttDevice(db *sql.DB, deviceId string, device *UpdateDeviceRequest) error {
+	if err := mqtt_driver.ValidateDeviceName(device.DeviceName, deviceId); err != nil {
+		return err
+	}
+	if err := updateMqttMappings(db, deviceId, device); err != nil {
+		return err
+	}
+
 	// Überprüfen, ob der Benutzer bereits existiert
 	var existingUser bool
 	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM auth WHERE username = ?)", device.DeviceName).Scan(&existingUser)
@@ -967,6 +1211,62 @@ func updateMqttDevice(db *sql.DB, device *UpdateDeviceRequest) error {
 		return fmt.Errorf("error adding ACL entries: %v, %v", err1, err2)
 	}
 
+	mqtt_broker.InvalidateAuth()
 	logrus.Infof("MQTT device and user updated successfully for %s", device.DeviceName)
 	return nil
 }
+
+// Hilfsfunktion: Aktualisiert die Zuordnungen eines MQTT-Geräts. Address enthält den Feldausdruck.
+func updateMqttMappings(db *sql.DB, deviceId string, device *UpdateDeviceRequest) error {
+	validDatapoints := make([]DeviceDatapoint, 0)
+	for _, dp := range device.DataPoints {
+		if dp.Name == "" {
+			logrus.Debugf("Skipping invalid MQTT mapping: %+v", dp)
+			continue
+		}
+		mapping := opcua.Datapoint{Name: dp.Name, Datatype: dp.Datatype, Address: dp.Address, Topic: dp.Topic}
+		if dp.Publish != nil {
+			mapping.Publish = *dp.Publish
+		}
+		if err := mqtt_driver.ValidateMapping(mapping); err != nil {
+			return fmt.Errorf("invalid mapping for datapoint %s: %v", dp.Name, err)
+		}
+		validDatapoints = append(validDatapoints, dp)
+	}
+
+	_, err := db.Exec(`DELETE FROM mqtt_mappings WHERE device_id = ?`, deviceId)
+	if err != nil {
+		return fmt.Errorf("error clearing old MQTT mappings: %v", err)
+	}
+
+	devId, err := strconv.Atoi(deviceId)
+	if err != nil {
+		return fmt.Errorf("error converting device_id to int: %v", err)
+	}
+	for _, dp := range validDatapoints {
+		if dp.DatapointId == "" {
+			dp.DatapointId, err = generateMqttDatapointId(db, devId)
+			if err != nil {
+				return fmt.Errorf("error generating MQTT datapoint ID: %v", err)
+			}
+		}
+		_, err = db.Exec(`INSERT INTO mqtt_mappings (device_id, datapointId, name, topic, expression, datatype, publish_settings) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			devId, dp.DatapointId, dp.Name, dp.Topic, dp.Address, dp.Datatype, publishSettingsValue(dp))
+		if err != nil {
+			return fmt.Errorf("error inserting MQTT mapping: %v", err)
+		}
+	}
+
+	logrus.Infof("%d MQTT mappings updated successfully for %s", len(validDatapoints), device.DeviceName)
+	return nil
+}
+
+// Hilfsfunktion: Generiert DatapointId für Zuordnungen von MQTT-Geräten
+func generateMqttDatapointId(db *sql.DB, deviceId int) (string, error) {
+	var nextId int
+	err := db.QueryRow(`SELECT COALESCE(MAX(CAST(SUBSTR(datapointId, -3) AS INTEGER)), 0) + 1 FROM mqtt_mappings WHERE device_id = ?`, deviceId).Scan(&nextId)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("1%03d%03d", deviceId, nextId), nil
+}
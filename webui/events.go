@@ -0,0 +1,202 @@
+package webui
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	opcua "iot-gateway/driver/opcua"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// StoredEvent ist ein gespeichertes OPC-UA-Ereignis (Alarms & Conditions)
+type StoredEvent struct {
+	ID             int                    `json:"id"`
+	DeviceID       int                    `json:"deviceId"`
+	EventID        string                 `json:"eventId"`
+	ConditionID    string                 `json:"conditionId,omitempty"`
+	EventType      string                 `json:"eventType,omitempty"`
+	SourceName     string                 `json:"sourceName,omitempty"`
+	Severity       *int64                 `json:"severity,omitempty"`
+	Message        string                 `json:"message,omitempty"`
+	ActiveState    *bool                  `json:"activeState,omitempty"`
+	AckedState     *bool                  `json:"ackedState,omitempty"`
+	ConfirmedState *bool                  `json:"confirmedState,omitempty"`
+	Fields         map[string]interface{} `json:"fields,omitempty"`
+	EventTime      string                 `json:"eventTime"`
+	ReceivedAt     string                 `json:"receivedAt"`
+	AcknowledgedAt string                 `json:"acknowledgedAt,omitempty"`
+	AcknowledgedBy string                 `json:"acknowledgedBy,omitempty"`
+	AckComment     string                 `json:"ackComment,omitempty"`
+	ConfirmedAt    string                 `json:"confirmedAt,omitempty"`
+}
+
+// getEvents liefert gespeicherte Ereignisse, optional gefiltert nach Gerät, Mindest-Severity und Zeitraum.
+// Query-Parameter: deviceId, minSeverity, since (RFC3339), unacked=true, limit (Standard 100)
+func getEvents(c *gin.Context) {
+	db, err := getDBConnection(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var conditions []string
+	var args []interface{}
+	if deviceID := c.Query("deviceId"); deviceID != "" {
+		conditions = append(conditions, "device_id = ?")
+		args = append(args, deviceID)
+	}
+	if minSeverity := c.Query("minSeverity"); minSeverity != "" {
+		value, err := strconv.Atoi(minSeverity)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid minSeverity"})
+			return
+		}
+		conditions = append(conditions, "severity >= ?")
+		args = append(args, value)
+	}
+	if since := c.Query("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since, expected RFC3339"})
+			return
+		}
+		conditions = append(conditions, "event_time >= ?")
+		args = append(args, t.UTC().Format(time.RFC3339Nano))
+	}
+	if c.Query("unacked") == "true" {
+		conditions = append(conditions, "acked_state = 0")
+	}
+
+	limit := 100
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+
+	query := `SELECT id, device_id, event_id, condition_id, event_type, source_name, severity, message, active_state, acked_state, confirmed_state,
+		fields, event_time, received_at, acknowledged_at, acknowledged_by, ack_comment, confirmed_at FROM events`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY event_time DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	events := []StoredEvent{}
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			logrus.Errorf("Error scanning event: %v", err)
+			continue
+		}
+		events = append(events, *event)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
+
+// acknowledgeEvent quittiert die Condition eines Ereignisses am OPC-UA-Server
+func acknowledgeEvent(c *gin.Context) {
+	respondConditionAction(c, false)
+}
+
+// confirmEvent bestätigt die Condition eines Ereignisses am OPC-UA-Server
+func confirmEvent(c *gin.Context) {
+	respondConditionAction(c, true)
+}
+
+// respondConditionAction ruft Acknowledge bzw. Confirm auf und vermerkt das Ergebnis in der events-Tabelle
+func respondConditionAction(c *gin.Context, confirm bool) {
+	db, err := getDBConnection(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var req struct {
+		Comment string `json:"comment"`
+	}
+	// Body ist optional
+	_ = c.ShouldBindJSON(&req)
+
+	var deviceID, eventID string
+	var conditionID sql.NullString
+	err = db.QueryRow(`SELECT device_id, event_id, condition_id FROM events WHERE id = ?`, c.Param("id")).Scan(&deviceID, &eventID, &conditionID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := opcua.AcknowledgeCondition(deviceID, conditionID.String, eventID, req.Comment, confirm); err != nil {
+		respondBrowseError(c, deviceID, err)
+		return
+	}
+
+	user := sessionUser(c)
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	if confirm {
+		_, err = db.Exec(`UPDATE events SET confirmed_state = 1, confirmed_at = ? WHERE id = ?`, now, c.Param("id"))
+	} else {
+		_, err = db.Exec(`UPDATE events SET acked_state = 1, acknowledged_at = ?, acknowledged_by = ?, ack_comment = ? WHERE id = ?`,
+			now, user, req.Comment, c.Param("id"))
+	}
+	if err != nil {
+		logrus.Errorf("Error updating event %s: %v", c.Param("id"), err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "success"})
+}
+
+// scanEvent liest eine Zeile der events-Tabelle
+func scanEvent(rows *sql.Rows) (*StoredEvent, error) {
+	var event StoredEvent
+	var conditionID, eventType, sourceName, message, fields sql.NullString
+	var ackedAt, ackedBy, ackComment, confirmedAt sql.NullString
+	var severity sql.NullInt64
+	var active, acked, confirmed sql.NullBool
+
+	err := rows.Scan(&event.ID, &event.DeviceID, &event.EventID, &conditionID, &eventType, &sourceName, &severity, &message,
+		&active, &acked, &confirmed, &fields, &event.EventTime, &event.ReceivedAt, &ackedAt, &ackedBy, &ackComment, &confirmedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	event.ConditionID = conditionID.String
+	event.EventType = eventType.String
+	event.SourceName = sourceName.String
+	event.Message = message.String
+	event.AcknowledgedAt = ackedAt.String
+	event.AcknowledgedBy = ackedBy.String
+	event.AckComment = ackComment.String
+	event.ConfirmedAt = confirmedAt.String
+	if severity.Valid {
+		event.Severity = &severity.Int64
+	}
+	if active.Valid {
+		event.ActiveState = &active.Bool
+	}
+	if acked.Valid {
+		event.AckedState = &acked.Bool
+	}
+	if confirmed.Valid {
+		event.ConfirmedState = &confirmed.Bool
+	}
+	if fields.Valid && fields.String != "" {
+		json.Unmarshal([]byte(fields.String), &event.Fields)
+	}
+	return &event, nil
+}
@@ -0,0 +1,686 @@
+package webui
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/parquet-go/parquet-go"
+	"github.com/sirupsen/logrus"
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	exportMaxRunning      = 2                // Gleichzeitig laufende Exporte, weitere warten
+	exportCleanupInterval = 10 * time.Minute // Intervall für das Löschen abgelaufener Exporte
+	exportProgressRows    = 1000             // Zeilen zwischen zwei Aktualisierungen des Fortschritts
+	exportChunk           = 24 * time.Hour   // Zeitbereich je Abfrage
+)
+
+// Status eines Exports
+const (
+	ExportQueued    = "queued"
+	ExportRunning   = "running"
+	ExportDone      = "done"
+	ExportFailed    = "failed"
+	ExportCancelled = "cancelled"
+)
+
+// exportFormats ordnet die Formate ihren Dateiendungen zu
+var exportFormats = map[string]string{
+	"csv":     ".csv",
+	"xlsx":    ".xlsx",
+	"parquet": ".parquet",
+}
+
+// ExportRequest ist ein Auftrag für den Export historischer Daten. Ohne aggregate werden die Rohwerte
+// exportiert, für Aggregationen ist window erforderlich.
+type ExportRequest struct {
+	HistoryQuery
+	Format string `json:"format"` // csv, xlsx oder parquet
+}
+
+// ExportJob ist ein Export historischer Daten in eine Datei
+type ExportJob struct {
+	ID         string        `json:"id"`
+	Status     string        `json:"status"`
+	Format     string        `json:"format"`
+	Request    ExportRequest `json:"request"`
+	Progress   float64       `json:"progress"` // Anteil des exportierten Zeitbereichs (0-1)
+	Rows       int64         `json:"rows"`
+	Size       int64         `json:"size,omitempty"` // Dateigröße in Bytes, sobald der Export fertig ist
+	Error      string        `json:"error,omitempty"`
+	User       string        `json:"user,omitempty"`
+	CreatedAt  time.Time     `json:"createdAt"`
+	FinishedAt *time.Time    `json:"finishedAt,omitempty"`
+	ExpiresAt  *time.Time    `json:"expiresAt,omitempty"` // Datei und Auftrag werden danach gelöscht
+
+	plan    *historyPlan
+	backend historyBackend
+	file    string
+	cancel  context.CancelFunc
+}
+
+// exportStore hält alle Exporte. Exporte überdauern keinen Neustart, übrig gebliebene Dateien
+// werden beim Start gelöscht (siehe removeStaleExports).
+var exportStore = struct {
+	sync.Mutex
+	jobs    map[string]*ExportJob
+	dir     string
+	ttl     time.Duration
+	running chan struct{}
+}{
+	jobs:    make(map[string]*ExportJob),
+	running: make(chan struct{}, exportMaxRunning),
+}
+
+// initExports lädt die Konfiguration (EXPORT_DIR, EXPORT_RETENTION_HOURS), löscht übrig gebliebene
+// Exportdateien und startet das Löschen abgelaufener Exporte
+func initExports() {
+	exportStore.dir = filepath.Join(os.TempDir(), "iot-gateway-exports")
+	if val := os.Getenv("EXPORT_DIR"); val != "" {
+		exportStore.dir = val
+	}
+	exportStore.ttl = 24 * time.Hour
+	if val := os.Getenv("EXPORT_RETENTION_HOURS"); val != "" {
+		if intVal, err := strconv.Atoi(val); err == nil && intVal > 0 {
+			exportStore.ttl = time.Duration(intVal) * time.Hour
+		}
+	}
+
+	if err := os.MkdirAll(exportStore.dir, 0700); err != nil {
+		logrus.Errorf("Failed to create export directory %s: %v", exportStore.dir, err)
+	}
+	removeStaleExports(exportStore.dir)
+
+	go func() {
+		ticker := time.NewTicker(exportCleanupInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			cleanupExports(now)
+		}
+	}()
+}
+
+// cleanupExports löscht abgelaufene Exporte und ihre Dateien
+func cleanupExports(now time.Time) {
+	exportStore.Lock()
+	var expired []*ExportJob
+	for id, job := range exportStore.jobs {
+		if job.ExpiresAt != nil && now.After(*job.ExpiresAt) {
+			expired = append(expired, job)
+			delete(exportStore.jobs, id)
+		}
+	}
+	exportStore.Unlock()
+
+	for _, job := range expired {
+		removeExportFile(job)
+		logrus.Infof("Export %s expired and was removed", job.ID)
+	}
+}
+
+// removeStaleExports löscht die Exportdateien eines früheren Laufs. EXPORT_DIR kann auf ein
+// gemeinsam genutztes Verzeichnis zeigen, daher werden nur Dateien mit dem Namensschema der
+// Exporte (<32 Hex-Zeichen>.<Format>) gelöscht.
+func removeStaleExports(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logrus.Warnf("Failed to read export directory %s: %v", dir, err)
+		return
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !isExportFileName(entry.Name()) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			logrus.Warnf("Failed to remove stale export file %s: %v", entry.Name(), err)
+		}
+	}
+}
+
+// isExportFileName prüft, ob ein Dateiname von einem Export stammt (siehe createExport)
+func isExportFileName(name string) bool {
+	ext := filepath.Ext(name)
+	known := false
+	for _, formatExt := range exportFormats {
+		known = known || ext == formatExt
+	}
+	id := strings.TrimSuffix(name, ext)
+	if !known || len(id) != 32 || strings.ToLower(id) != id {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func removeExportFile(job *ExportJob) {
+	if job.file == "" {
+		return
+	}
+	if err := os.Remove(job.file); err != nil && !os.IsNotExist(err) {
+		logrus.Warnf("Failed to remove export file %s: %v", job.file, err)
+	}
+}
+
+// planExport prüft einen Exportauftrag. Anders als bei Abfragen wird die Anzahl der Punkte nicht begrenzt.
+func planExport(req ExportRequest, now time.Time) (*historyPlan, error) {
+	if _, ok := exportFormats[req.Format]; !ok {
+		return nil, fmt.Errorf("unknown format %q, expected csv, xlsx or parquet", req.Format)
+	}
+	q := req.HistoryQuery
+	q.MaxPoints = 0
+	if q.Aggregate == "" {
+		q.Aggregate = "none"
+	}
+	p, err := planHistoryRange(q, now)
+	if err != nil {
+		return nil, err
+	}
+	if p.aggregate != "none" && p.window == 0 {
+		return nil, fmt.Errorf("window is required for aggregate %q", p.aggregate)
+	}
+	return p, nil
+}
+
+// exportChunks teilt den Zeitbereich eines Exports an Mitternacht in der Zeitzone des Exports.
+// Aggregationsfenster, die nicht in einen Tag aufgehen, würden dabei geteilt und werden in
+// einer Abfrage exportiert. Innerhalb eines Abschnitts folgen die Datenpunkte nacheinander,
+// da Flux Zeitreihen mit Zahlen und Texten nicht in einer Tabelle zusammenführen kann.
+func exportChunks(p *historyPlan) [][2]time.Time {
+	if p.aggregate != "none" && exportChunk%p.window != 0 {
+		return [][2]time.Time{{p.start, p.stop}}
+	}
+	var chunks [][2]time.Time
+	start := p.start
+	for start.Before(p.stop) {
+		local := start.In(p.location)
+		stop := time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, p.location)
+		if stop.After(p.stop) {
+			stop = p.stop
+		}
+		chunks = append(chunks, [2]time.Time{start, stop})
+		start = stop
+	}
+	return chunks
+}
+
+var exportHeader = []string{"time", "deviceId", "datapointId", "measurement", "value"}
+
+// exportWriter schreibt die Zeilen eines Exports in eine Datei
+type exportWriter interface {
+	Write(row historyRecord) error
+	Close() error
+}
+
+func newExportWriter(format, path string) (exportWriter, error) {
+	switch format {
+	case "csv":
+		return newCSVExportWriter(path)
+	case "xlsx":
+		return newXLSXExportWriter(path)
+	case "parquet":
+		return newParquetExportWriter(path)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// csvExportWriter schreibt Zeilen als CSV, Zeitstempel im Format RFC3339 mit Offset
+type csvExportWriter struct {
+	file *os.File
+	buf  *bufio.Writer
+	csv  *csv.Writer
+}
+
+func newCSVExportWriter(path string) (*csvExportWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &csvExportWriter{file: file, buf: bufio.NewWriter(file)}
+	w.csv = csv.NewWriter(w.buf)
+	if err := w.csv.Write(exportHeader); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *csvExportWriter) Write(row historyRecord) error {
+	var value string
+	switch v := row.value.(type) {
+	case float64:
+		value = strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+	default:
+		value = fmt.Sprint(v)
+	}
+	return w.csv.Write([]string{row.time.Format(time.RFC3339Nano), row.deviceID, row.datapointID, row.measurement, value})
+}
+
+func (w *csvExportWriter) Close() error {
+	w.csv.Flush()
+	err := w.csv.Error()
+	if err == nil {
+		err = w.buf.Flush()
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// xlsxExportWriter schreibt Zeilen in eine Excel-Datei. Da ein Tabellenblatt höchstens
+// excelize.TotalRows Zeilen hat, werden bei Bedarf weitere Blätter angelegt.
+type xlsxExportWriter struct {
+	path      string
+	file      *excelize.File
+	stream    *excelize.StreamWriter
+	timeStyle int
+	sheets    int
+	row       int
+}
+
+func newXLSXExportWriter(path string) (*xlsxExportWriter, error) {
+	w := &xlsxExportWriter{path: path, file: excelize.NewFile()}
+	numFmt := "yyyy-mm-dd hh:mm:ss.000"
+	style, err := w.file.NewStyle(&excelize.Style{CustomNumFmt: &numFmt})
+	if err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	w.timeStyle = style
+	if err := w.nextSheet(); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *xlsxExportWriter) nextSheet() error {
+	w.sheets++
+	name := "Data"
+	if w.sheets == 1 {
+		if err := w.file.SetSheetName("Sheet1", name); err != nil {
+			return err
+		}
+	} else {
+		if err := w.stream.Flush(); err != nil {
+			return err
+		}
+		name = fmt.Sprintf("Data %d", w.sheets)
+		if _, err := w.file.NewSheet(name); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if w.stream, err = w.file.NewStreamWriter(name); err != nil {
+		return err
+	}
+	if err := w.stream.SetColWidth(1, 1, 24); err != nil {
+		return err
+	}
+	header := make([]interface{}, len(exportHeader))
+	for i, h := range exportHeader {
+		header[i] = h
+	}
+	w.row = 1
+	return w.stream.SetRow("A1", header)
+}
+
+func (w *xlsxExportWriter) Write(row historyRecord) error {
+	if w.row >= excelize.TotalRows {
+		if err := w.nextSheet(); err != nil {
+			return err
+		}
+	}
+	w.row++
+	cell, err := excelize.CoordinatesToCellName(1, w.row)
+	if err != nil {
+		return err
+	}
+	return w.stream.SetRow(cell, []interface{}{
+		excelize.Cell{StyleID: w.timeStyle, Value: row.time},
+		row.deviceID,
+		row.datapointID,
+		row.measurement,
+		row.value,
+	})
+}
+
+func (w *xlsxExportWriter) Close() error {
+	err := w.stream.Flush()
+	if err == nil {
+		err = w.file.SaveAs(w.path)
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// parquetRow ist eine Zeile einer Parquet-Datei. Zahlen und Booleans (0/1) stehen in value, Texte in text.
+type parquetRow struct {
+	Time        time.Time `parquet:"time,timestamp(millisecond)"`
+	DeviceID    string    `parquet:"deviceId,dict"`
+	DatapointID string    `parquet:"datapointId,dict"`
+	Measurement string    `parquet:"measurement,dict"`
+	Value       *float64  `parquet:"value,optional"`
+	Text        *string   `parquet:"text,optional"`
+}
+
+// parquetExportWriter schreibt Zeilen in eine Parquet-Datei
+type parquetExportWriter struct {
+	file   *os.File
+	writer *parquet.GenericWriter[parquetRow]
+	rows   []parquetRow
+}
+
+func newParquetExportWriter(path string) (*parquetExportWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &parquetExportWriter{
+		file:   file,
+		writer: parquet.NewGenericWriter[parquetRow](file, parquet.Compression(&parquet.Snappy)),
+		rows:   make([]parquetRow, 0, exportProgressRows),
+	}, nil
+}
+
+func (w *parquetExportWriter) Write(row historyRecord) error {
+	r := parquetRow{Time: row.time.UTC(), DeviceID: row.deviceID, DatapointID: row.datapointID, Measurement: row.measurement}
+	switch v := row.value.(type) {
+	case float64:
+		r.Value = &v
+	case int64:
+		f := float64(v)
+		r.Value = &f
+	case uint64:
+		f := float64(v)
+		r.Value = &f
+	case bool:
+		f := 0.0
+		if v {
+			f = 1
+		}
+		r.Value = &f
+	case nil:
+	default:
+		s := fmt.Sprint(v)
+		r.Text = &s
+	}
+	w.rows = append(w.rows, r)
+	if len(w.rows) == cap(w.rows) {
+		return w.flush()
+	}
+	return nil
+}
+
+func (w *parquetExportWriter) flush() error {
+	_, err := w.writer.Write(w.rows)
+	w.rows = w.rows[:0]
+	return err
+}
+
+func (w *parquetExportWriter) Close() error {
+	err := w.flush()
+	if err == nil {
+		err = w.writer.Close()
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// runExport führt einen Export aus: Die Daten werden tageweise aus der InfluxDB bzw. dem
+// eingebetteten Historian gelesen und direkt in die Datei geschrieben
+func runExport(ctx context.Context, job *ExportJob) {
+	select {
+	case exportStore.running <- struct{}{}:
+		defer func() { <-exportStore.running }()
+	case <-ctx.Done():
+		finishExport(job, ctx.Err())
+		return
+	}
+	updateExport(job, func() { job.Status = ExportRunning })
+	logrus.Infof("Export %s started (%s, %d series)", job.ID, job.Format, len(job.plan.query.Series))
+
+	writer, err := newExportWriter(job.Format, job.file)
+	if err != nil {
+		finishExport(job, err)
+		return
+	}
+	err = writeExport(ctx, job, writer)
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	finishExport(job, err)
+}
+
+func writeExport(ctx context.Context, job *ExportJob, writer exportWriter) error {
+	p := job.plan
+	total := p.stop.Sub(p.start)
+	var rows int64
+	for _, chunk := range exportChunks(p) {
+		chunkPlan := *p
+		chunkPlan.start, chunkPlan.stop = chunk[0], chunk[1]
+
+		err := job.backend.Query(ctx, &chunkPlan, func(row historyRecord) error {
+			row.time = row.time.In(p.location)
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+			if rows++; rows%exportProgressRows == 0 {
+				done := row.time.Sub(p.start)
+				updateExport(job, func() {
+					job.Rows = rows
+					job.Progress = float64(done) / float64(total)
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		updateExport(job, func() {
+			job.Rows = rows
+			job.Progress = float64(chunk[1].Sub(p.start)) / float64(total)
+		})
+	}
+	return nil
+}
+
+func updateExport(job *ExportJob, update func()) {
+	exportStore.Lock()
+	update()
+	exportStore.Unlock()
+}
+
+// finishExport setzt das Ergebnis eines Exports. Fehlgeschlagene und abgebrochene Exporte
+// behalten keine Datei.
+func finishExport(job *ExportJob, err error) {
+	now := time.Now()
+	expires := now.Add(exportStore.ttl)
+
+	exportStore.Lock()
+	defer exportStore.Unlock()
+	job.FinishedAt = &now
+	job.ExpiresAt = &expires
+	if job.cancel != nil {
+		job.cancel()
+		job.cancel = nil
+	}
+	switch {
+	case err == nil:
+		job.Status = ExportDone
+		job.Progress = 1
+		if info, statErr := os.Stat(job.file); statErr == nil {
+			job.Size = info.Size()
+		}
+		logrus.Infof("Export %s finished with %d rows", job.ID, job.Rows)
+		return
+	case errors.Is(err, context.Canceled):
+		job.Status = ExportCancelled
+		logrus.Infof("Export %s cancelled", job.ID)
+	default:
+		job.Status = ExportFailed
+		job.Error = err.Error()
+		logrus.Errorf("Export %s failed: %v", job.ID, err)
+	}
+	removeExportFile(job)
+}
+
+// snapshot liefert eine Kopie des Exports für die Ausgabe, exportStore muss gesperrt sein
+func (job *ExportJob) snapshot() ExportJob {
+	s := *job
+	s.plan, s.backend, s.cancel = nil, nil, nil
+	return s
+}
+
+func getExportJob(c *gin.Context) (*ExportJob, bool) {
+	exportStore.Lock()
+	job, ok := exportStore.jobs[c.Param("id")]
+	exportStore.Unlock()
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Export not found"})
+	}
+	return job, ok
+}
+
+// createExport legt einen Export an und startet ihn im Hintergrund
+func createExport(c *gin.Context) {
+	var req ExportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	plan, err := planExport(req, time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, err := getDBConnection(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to SQLite database"})
+		return
+	}
+	backend, err := getHistoryBackend(db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch InfluxDB configuration"})
+		return
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create export id"})
+		return
+	}
+	id := hex.EncodeToString(b)
+	user, _ := sessions.Default(c).Get("user").(string)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &ExportJob{
+		ID:        id,
+		Status:    ExportQueued,
+		Format:    req.Format,
+		Request:   req,
+		User:      user,
+		CreatedAt: time.Now(),
+		plan:      plan,
+		backend:   backend,
+		file:      filepath.Join(exportStore.dir, id+exportFormats[req.Format]),
+		cancel:    cancel,
+	}
+	job.Request.HistoryQuery = plan.query
+
+	exportStore.Lock()
+	exportStore.jobs[id] = job
+	snapshot := job.snapshot()
+	exportStore.Unlock()
+
+	go runExport(ctx, job)
+	c.JSON(http.StatusAccepted, snapshot)
+}
+
+// getExports liefert alle Exporte, die neuesten zuerst
+func getExports(c *gin.Context) {
+	exportStore.Lock()
+	jobs := make([]ExportJob, 0, len(exportStore.jobs))
+	for _, job := range exportStore.jobs {
+		jobs = append(jobs, job.snapshot())
+	}
+	exportStore.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+	c.JSON(http.StatusOK, jobs)
+}
+
+// getExport liefert Status und Fortschritt eines Exports
+func getExport(c *gin.Context) {
+	job, ok := getExportJob(c)
+	if !ok {
+		return
+	}
+	exportStore.Lock()
+	snapshot := job.snapshot()
+	exportStore.Unlock()
+	c.JSON(http.StatusOK, snapshot)
+}
+
+// downloadExport liefert die Datei eines fertigen Exports
+func downloadExport(c *gin.Context) {
+	job, ok := getExportJob(c)
+	if !ok {
+		return
+	}
+	exportStore.Lock()
+	status, createdAt := job.Status, job.CreatedAt
+	exportStore.Unlock()
+	if status != ExportDone {
+		c.JSON(http.StatusConflict, gin.H{"error": "Export is not finished", "status": status})
+		return
+	}
+	name := fmt.Sprintf("export-%s-%s%s", createdAt.Format("20060102-150405"), job.ID[:8], exportFormats[job.Format])
+	c.FileAttachment(job.file, name)
+}
+
+// deleteExport bricht einen laufenden Export ab bzw. löscht einen Export und seine Datei
+func deleteExport(c *gin.Context) {
+	job, ok := getExportJob(c)
+	if !ok {
+		return
+	}
+	exportStore.Lock()
+	delete(exportStore.jobs, job.ID)
+	cancel := job.cancel
+	exportStore.Unlock()
+
+	if cancel != nil {
+		// Die Datei wird von finishExport entfernt
+		cancel()
+	} else {
+		removeExportFile(job)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Export deleted"})
+}
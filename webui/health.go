@@ -0,0 +1,251 @@
+package webui
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	dataforwarding "iot-gateway/data-forwarding"
+	"iot-gateway/historian"
+	"iot-gateway/logic"
+	"iot-gateway/mqtt_broker"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Zustände der Health-Checks
+const (
+	healthOK       = "ok"
+	healthDegraded = "degraded"
+	healthDown     = "down"
+)
+
+// healthComponent ist das Ergebnis der Prüfung eines Subsystems.
+// Ist eine kritische Komponente down, antworten /healthz und /readyz mit 503.
+type healthComponent struct {
+	Status   string      `json:"status"`
+	Critical bool        `json:"critical"`
+	Error    string      `json:"error,omitempty"`
+	Details  interface{} `json:"details,omitempty"`
+}
+
+// healthzHandler prüft nur die kritischen Komponenten (SQLite, MQTT-Broker) für Liveness-Checks
+func healthzHandler(c *gin.Context) {
+	components := map[string]healthComponent{
+		"database": checkDatabase(c),
+		"mqtt":     checkBroker(c),
+	}
+	writeHealth(c, components)
+}
+
+// readyzHandler prüft alle Subsysteme inklusive InfluxDB, Node-RED und Gerätetreiber
+func readyzHandler(c *gin.Context) {
+	components := map[string]healthComponent{
+		"database": checkDatabase(c),
+		"mqtt":     checkBroker(c),
+	}
+	if historian.Enabled() {
+		components["historian"] = checkHistorian()
+	} else {
+		components["influxdb"] = checkInfluxWriter()
+	}
+	if db, err := getDBConnection(c); err == nil {
+		components["nodeRed"] = checkNodeRed(c.Request.Context(), db)
+		components["devices"] = checkDevices(db)
+	}
+	writeHealth(c, components)
+}
+
+// writeHealth fasst die Komponenten zu einem Gesamtstatus zusammen
+func writeHealth(c *gin.Context, components map[string]healthComponent) {
+	status := healthOK
+	for _, comp := range components {
+		switch {
+		case comp.Status == healthDown && comp.Critical:
+			status = healthDown
+		case comp.Status != healthOK && status == healthOK:
+			status = healthDegraded
+		}
+	}
+
+	code := http.StatusOK
+	if status == healthDown {
+		code = http.StatusServiceUnavailable
+	}
+	c.JSON(code, gin.H{
+		"status":     status,
+		"timestamp":  time.Now().UTC().Format(time.RFC3339),
+		"components": components,
+	})
+}
+
+// checkDatabase prüft die SQLite-Verbindung
+func checkDatabase(c *gin.Context) healthComponent {
+	comp := healthComponent{Status: healthOK, Critical: true}
+	db, err := getDBConnection(c)
+	if err != nil {
+		comp.Status, comp.Error = healthDown, err.Error()
+		return comp
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+	defer cancel()
+	var one int
+	if err := db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		comp.Status, comp.Error = healthDown, err.Error()
+	}
+	return comp
+}
+
+// checkBroker prüft die Listener des MQTT-Brokers. Down, wenn kein Listener erreichbar ist.
+func checkBroker(c *gin.Context) healthComponent {
+	comp := healthComponent{Status: healthOK, Critical: true}
+	server, err := getMQTTServer(c)
+	if err != nil || server == nil {
+		comp.Status, comp.Error = healthDown, "MQTT broker not available"
+		return comp
+	}
+
+	listeners := mqtt_broker.CheckListeners(server)
+	listening := 0
+	for _, l := range listeners {
+		if l.Listening {
+			listening++
+		}
+	}
+	switch {
+	case listening == 0:
+		comp.Status, comp.Error = healthDown, "no listener is accepting connections"
+	case listening < len(listeners):
+		comp.Status, comp.Error = healthDegraded, "some listeners are not accepting connections"
+	}
+	comp.Details = gin.H{
+		"listeners":        listeners,
+		"clientsConnected": server.Info.Clone().ClientsConnected,
+	}
+	return comp
+}
+
+// checkInfluxWriter prüft den InfluxDB-Writer (Selbstheilung und Circuit Breaker)
+func checkInfluxWriter() healthComponent {
+	comp := healthComponent{Status: healthOK}
+	stats := dataforwarding.GetWriterStats()
+
+	breaker := "closed"
+	switch stats.BreakerState {
+	case dataforwarding.Open:
+		breaker = "open"
+	case dataforwarding.HalfOpen:
+		breaker = "half-open"
+	}
+
+	switch {
+	case !stats.Running:
+		comp.Status, comp.Error = healthDegraded, "InfluxDB writer not started"
+	case !stats.Healthy:
+		comp.Status, comp.Error = healthDegraded, "InfluxDB writer unhealthy"
+	case stats.BreakerState != dataforwarding.Closed:
+		comp.Status, comp.Error = healthDegraded, "circuit breaker "+breaker
+	}
+	comp.Details = gin.H{
+		"healthy":           stats.Healthy,
+		"circuitBreaker":    breaker,
+		"consecutiveErrors": stats.ConsecutiveErrors,
+		"bufferSize":        stats.BufferSize,
+		"retryQueueSize":    stats.RetryQueueSize,
+	}
+	return comp
+}
+
+// checkHistorian prüft den eingebetteten Historian
+func checkHistorian() healthComponent {
+	comp := healthComponent{Status: healthOK}
+	stats := historian.GetStats()
+
+	switch {
+	case !stats.Running:
+		comp.Status, comp.Error = healthDegraded, "historian not started"
+	case stats.LastError != "":
+		comp.Status, comp.Error = healthDegraded, stats.LastError
+	}
+	details := gin.H{
+		"pending":        stats.Pending,
+		"storedSamples":  stats.StoredSamples,
+		"droppedSamples": stats.DroppedSamples,
+	}
+	if !stats.LastFlush.IsZero() {
+		details["lastFlush"] = stats.LastFlush
+	}
+	comp.Details = details
+	return comp
+}
+
+// checkNodeRed prüft, ob Node-RED unter node_red_url antwortet
+func checkNodeRed(ctx context.Context, db *sql.DB) healthComponent {
+	comp := healthComponent{Status: healthOK}
+	nodeRedURL, err := GetSystemSetting(db, "node_red_url")
+	if err != nil {
+		comp.Status, comp.Error = healthDegraded, err.Error()
+		return comp
+	}
+	comp.Details = gin.H{"url": nodeRedURL}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(nodeRedURL, "/")+"/", nil)
+	if err != nil {
+		comp.Status, comp.Error = healthDegraded, err.Error()
+		return comp
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		comp.Status, comp.Error = healthDegraded, err.Error()
+		return comp
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		comp.Status, comp.Error = healthDegraded, resp.Status
+	}
+	return comp
+}
+
+// checkDevices liefert den Treiberstatus aller Geräte. Degraded, wenn ein Treiber nicht läuft.
+func checkDevices(db *sql.DB) healthComponent {
+	comp := healthComponent{Status: healthOK}
+	rows, err := db.Query(`SELECT id, name, type, status FROM devices ORDER BY id`)
+	if err != nil {
+		comp.Status, comp.Error = healthDegraded, err.Error()
+		return comp
+	}
+	defer rows.Close()
+
+	type deviceHealth struct {
+		ID     int64  `json:"id"`
+		Name   string `json:"name"`
+		Type   string `json:"type"`
+		Status string `json:"status"`
+	}
+	devices := []deviceHealth{}
+	failed := 0
+	for rows.Next() {
+		var d deviceHealth
+		if err := rows.Scan(&d.ID, &d.Name, &d.Type, &d.Status); err != nil {
+			comp.Status, comp.Error = healthDegraded, err.Error()
+			return comp
+		}
+		switch d.Status {
+		case logic.Running, logic.Initializing, logic.Stopped:
+		default:
+			failed++
+		}
+		devices = append(devices, d)
+	}
+
+	if failed > 0 {
+		comp.Status = healthDegraded
+	}
+	comp.Details = gin.H{"devices": devices, "failed": failed}
+	return comp
+}
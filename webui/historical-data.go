@@ -2,14 +2,12 @@ package webui
 
 import (
 	"database/sql"
-	"fmt"
 	"net/http"
 	"time"
 
 	dataforwarding "iot-gateway/data-forwarding"
 
 	"github.com/gin-gonic/gin"
-	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/sirupsen/logrus"
 )
 
@@ -34,33 +32,9 @@ func ensureInfluxConfig(db *sql.DB) error {
 	return nil
 }
 
+// queryDataHandler liefert die Rohdaten bzw. bei langen Zeiträumen die gemittelten Werte eines Measurements.
+// Ältere Schnittstelle der Seite historical-data, siehe queryHistory für mehrere Datenpunkte.
 func queryDataHandler(c *gin.Context) {
-	// Verbindung zur SQLite-Datenbank herstellen
-	db, err := getDBConnection(c)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to SQLite database"})
-		return
-	}
-
-	// Stelle sicher, dass die InfluxDB-Konfiguration geladen ist
-	if err := ensureInfluxConfig(db); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch InfluxDB configuration"})
-		return
-	}
-
-	client := influxdb2.NewClient(influxConfig.URL, influxConfig.Token)
-	defer client.Close()
-
-	queryAPI := client.QueryAPI(influxConfig.Org)
-
-	location, err := time.LoadLocation("Europe/Berlin")
-
-	if err != nil {
-		logrus.Errorf("Failed to load location: %s", err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load location"})
-		return
-	}
-
 	// Anfrage-Parameter auslesen
 	var requestData struct {
 		Start       string `json:"start" binding:"required"`
@@ -72,63 +46,63 @@ func queryDataHandler(c *gin.Context) {
 		return
 	}
 
-	// Startzeit mit dem erwarteten Format (ISO-8601 mit T) parsen
-	const inputFormat = "2006-01-02T15:04" // Format des Eingabewerts
-	startTime, err := time.ParseInLocation(inputFormat, requestData.Start, location)
+	duration, err := time.ParseDuration(requestData.Duration + "m")
+	if err != nil {
+		logrus.Errorf("Failed to parse duration: %s", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid duration format"})
+		return
+	}
+
+	// Startzeit mit dem erwarteten Format (ISO-8601 mit T) in der Zeitzone des Gateways parsen
+	location, err := time.LoadLocation(defaultTimezone())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load location"})
+		return
+	}
+	startTime, err := time.ParseInLocation("2006-01-02T15:04", requestData.Start, location)
 	if err != nil {
 		logrus.Errorf("Failed to parse start time: %s. Ensure it is in the correct format.", err.Error())
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start time format. Expected format (e.g., 2025-01-26T15:50)."})
 		return
 	}
 
-	duration, err := time.ParseDuration(requestData.Duration + "m")
+	plan, err := planHistoryQuery(HistoryQuery{
+		Series:   []HistorySeries{{Measurement: requestData.Measurement}},
+		Start:    startTime.Format(time.RFC3339),
+		Stop:     startTime.Add(duration).Format(time.RFC3339),
+		Timezone: location.String(),
+	}, time.Now())
 	if err != nil {
-		logrus.Errorf("Failed to parse duration: %s", err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid duration format"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Korrekte Berechnung der stopTime: startTime + duration
-	stopTime := startTime.Add(duration)
-
-	// Flux-Query erstellen
-	query := fmt.Sprintf(`
-		from(bucket: "%s")
-		|> range(start: %s, stop: %s)
-		|> filter(fn: (r) => r["_measurement"] == "%s")
-		|> filter(fn: (r) => r["_field"] == "value")
-	`, influxConfig.Bucket, startTime.UTC().Format(time.RFC3339), stopTime.UTC().Format(time.RFC3339), requestData.Measurement)
-
-	// Query ausführen
-	result, err := queryAPI.Query(c, query)
+	// Verbindung zur SQLite-Datenbank herstellen
+	db, err := getDBConnection(c)
 	if err != nil {
-		logrus.Errorf("Failed to execute query: %s", err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query data from InfluxDB"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to SQLite database"})
 		return
 	}
 
-	// Ergebnisse verarbeiten
-	var data []map[string]interface{}
-	for result.Next() {
-		record := result.Record()
-		data = append(data, map[string]interface{}{
-			"x": record.Time(),
-			"y": record.Value(),
-		})
+	// InfluxDB bzw. eingebetteter Historian
+	backend, err := getHistoryBackend(db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch InfluxDB configuration"})
+		return
 	}
 
-	// Fehler während der Verarbeitung prüfen
-	if result.Err() != nil {
-		logrus.Errorf("Error processing query results: %s", result.Err().Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Err().Error()})
+	result, err := runHistoryQuery(c, backend, plan)
+	if err != nil {
+		logrus.Errorf("Failed to execute query: %s", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query historical data"})
 		return
 	}
 
 	// Daten als JSON zurückgeben
-	c.JSON(http.StatusOK, data)
+	c.JSON(http.StatusOK, result.Series[0].Points)
 }
 
-// Funktion, die Measurements aus der InfluxDB abruft
+// Funktion, die die Measurements eines Geräts aus der InfluxDB bzw. dem eingebetteten Historian abruft
 func getMeasurements(c *gin.Context) {
 	var request struct {
 		DeviceID string `json:"deviceId"`
@@ -146,49 +120,18 @@ func getMeasurements(c *gin.Context) {
 		return
 	}
 
-	// Stelle sicher, dass die InfluxDB-Konfiguration geladen ist
-	if err := ensureInfluxConfig(db); err != nil {
+	// InfluxDB bzw. eingebetteter Historian
+	backend, err := getHistoryBackend(db)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch InfluxDB configuration"})
 		return
 	}
 
-	// Verbindung zur InfluxDB
-	client := influxdb2.NewClient(influxConfig.URL, influxConfig.Token)
-	defer client.Close()
-
-	queryAPI := client.QueryAPI(influxConfig.Org)
-
-	// Flux-Query: Hole alle Measurements für die angegebene deviceId
-	query := fmt.Sprintf(`
-	from(bucket: "%s")
-		|> range(start: -30d)  // Zeitfenster der letzten 30 Tage
-		|> filter(fn: (r) => r["deviceId"] == "%s")
-		|> group(columns: ["_measurement"])
-		|> distinct(column: "_measurement")
-		|> keep(columns: ["_measurement"])
-	`, influxConfig.Bucket, request.DeviceID)
-
-	// Query ausführen
-	result, err := queryAPI.Query(c, query)
+	// Alle Measurements des Geräts der letzten 30 Tage
+	measurements, err := backend.Measurements(c, request.DeviceID)
 	if err != nil {
 		logrus.Errorf("Error while querying measurements: %s", err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query measurements from InfluxDB"})
-		return
-	}
-
-	// Measurements sammeln
-	var measurements []string
-
-	// Gefilterte Measurements sammeln
-	for result.Next() {
-		measurement := result.Record().ValueByKey("_measurement").(string)
-		measurements = append(measurements, measurement)
-	}
-
-	// Fehler beim Querying prüfen
-	if result.Err() != nil {
-		logrus.Errorf("Error processing filtered query results: %s", result.Err().Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Err().Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query measurements"})
 		return
 	}
 
@@ -204,43 +147,18 @@ func getInfluxDevices(c *gin.Context) {
 		return
 	}
 
-	// Stelle sicher, dass die InfluxDB-Konfiguration geladen ist
-	if err := ensureInfluxConfig(db); err != nil {
+	// InfluxDB bzw. eingebetteter Historian
+	backend, err := getHistoryBackend(db)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch InfluxDB configuration"})
 		return
 	}
 
-	// Verbindung zur InfluxDB
-	client := influxdb2.NewClient(influxConfig.URL, influxConfig.Token)
-	defer client.Close()
-
-	// Flux-Query: Hole alle deviceIds aus der InfluxDB
-	query := fmt.Sprintf(`
-	from(bucket: "%s")
-		|> range(start: -30d)
-		|> distinct(column: ["deviceId"])
-		|> keep(columns: ["deviceId"])
-	`, influxConfig.Bucket)
-
-	// Query ausführen
-	result, err := client.QueryAPI(influxConfig.Org).Query(c, query)
+	// Alle deviceIds der letzten 30 Tage
+	deviceIDs, err := backend.Devices(c)
 	if err != nil {
 		logrus.Errorf("Error while querying devices: %s", err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query devices from InfluxDB"})
-		return
-	}
-
-	// Devices sammeln
-	var deviceIDs []string
-	for result.Next() {
-		device := result.Record().ValueByKey("deviceId").(string)
-		deviceIDs = append(deviceIDs, device)
-	}
-
-	// Fehler beim Querying prüfen
-	if result.Err() != nil {
-		logrus.Errorf("Error processing query results: %s", result.Err().Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Err().Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query devices"})
 		return
 	}
 
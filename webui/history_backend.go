@@ -0,0 +1,222 @@
+package webui
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	dataforwarding "iot-gateway/data-forwarding"
+	"iot-gateway/historian"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// historyLookback ist der Zeitraum, in dem nach Geräten und Measurements gesucht wird
+const historyLookback = 30 * 24 * time.Hour
+
+// historyRecord ist ein Wert bzw. ein aggregiertes Fenster einer Zeitreihe
+type historyRecord struct {
+	time        time.Time
+	deviceID    string
+	datapointID string
+	measurement string
+	value       interface{}
+}
+
+// historyBackend ist die Datenquelle der historischen Daten: die InfluxDB oder der eingebettete Historian
+type historyBackend interface {
+	Devices(ctx context.Context) ([]string, error)
+	Measurements(ctx context.Context, deviceID string) ([]string, error)
+	// Query übergibt die Werte eines geprüften Plans je Zeitreihe nach Zeit sortiert an fn
+	Query(ctx context.Context, p *historyPlan, fn func(historyRecord) error) error
+}
+
+// getHistoryBackend liefert den eingebetteten Historian, falls er aktiv ist, sonst die InfluxDB
+func getHistoryBackend(db *sql.DB) (historyBackend, error) {
+	if historian.Enabled() {
+		return localHistoryBackend{}, nil
+	}
+	if err := ensureInfluxConfig(db); err != nil {
+		return nil, err
+	}
+	tiers := dataforwarding.LoadRetentionConfig(db).Tiers(influxConfig.Bucket)
+	return influxHistoryBackend{config: influxConfig, tiers: tiers}, nil
+}
+
+// influxHistoryBackend fragt die InfluxDB mit Flux ab
+type influxHistoryBackend struct {
+	config *dataforwarding.InfluxConfig
+	tiers  []dataforwarding.BucketTier // Rohdaten und Downsampling-Stufen, von fein nach grob
+}
+
+// selectHistoryTier wählt den Bucket einer Abfrage wie der eingebettete Historian: die feinste Stufe,
+// in deren Intervalle das Fenster aufgeht und die ab dem Beginn der Abfrage Daten enthält. Sonst die
+// feinste Stufe, die ab dem Beginn Daten enthält, und reicht keine so weit zurück, die Stufe mit den
+// ältesten Daten. Rohwerte (aggregate=none) kommen immer aus dem Bucket der Rohdaten.
+func selectHistoryTier(tiers []dataforwarding.BucketTier, p *historyPlan, now time.Time) dataforwarding.BucketTier {
+	if p.aggregate == "none" {
+		return tiers[0]
+	}
+	for _, aligned := range []bool{true, false} {
+		for _, t := range tiers {
+			if aligned && t.Every != 0 && p.window%t.Every != 0 {
+				continue
+			}
+			if !p.start.Before(tierDataStart(t, now)) {
+				return t
+			}
+		}
+	}
+	best := tiers[0]
+	for _, t := range tiers[1:] {
+		if tierDataStart(t, now).Before(tierDataStart(best, now)) {
+			best = t
+		}
+	}
+	return best
+}
+
+// tierDataStart liefert, ab wann eine Stufe Daten enthält: nach Ablauf der Aufbewahrung und bei
+// Downsampling-Stufen frühestens ab dem Start ihres Tasks (ohne Task gar nicht)
+func tierDataStart(t dataforwarding.BucketTier, now time.Time) time.Time {
+	var start time.Time
+	if t.Retention != 0 {
+		start = now.Add(-t.Retention)
+	}
+	if t.Every != 0 {
+		if t.Since.IsZero() {
+			return now
+		}
+		if t.Since.After(start) {
+			start = t.Since
+		}
+	}
+	return start
+}
+
+// tiersWithStarts ergänzt die Downsampling-Stufen um den Start ihrer Tasks. Lässt sich dieser nicht
+// ermitteln, werden nur die Rohdaten verwendet.
+func (b influxHistoryBackend) tiersWithStarts(ctx context.Context) []dataforwarding.BucketTier {
+	if len(b.tiers) == 1 {
+		return b.tiers
+	}
+	starts, err := dataforwarding.DownsamplingStarts(ctx, b.config)
+	if err != nil {
+		logrus.Warnf("Failed to read downsampling tasks, querying raw data: %v", err)
+		return b.tiers[:1]
+	}
+	tiers := append([]dataforwarding.BucketTier(nil), b.tiers...)
+	for i := range tiers {
+		if tiers[i].Every != 0 {
+			tiers[i].Since = starts[tiers[i].Bucket]
+		}
+	}
+	return tiers
+}
+
+func (b influxHistoryBackend) Devices(ctx context.Context) ([]string, error) {
+	params := newFluxParams()
+	query := fmt.Sprintf(`
+	from(bucket: %s)
+		|> range(start: %s)
+		|> distinct(column: ["deviceId"])
+		|> keep(columns: ["deviceId"])
+	`, params.String("bucket", b.config.Bucket), params.Duration("lookback", -historyLookback))
+	return b.queryStrings(ctx, params.Record()+query, "deviceId")
+}
+
+func (b influxHistoryBackend) Measurements(ctx context.Context, deviceID string) ([]string, error) {
+	params := newFluxParams()
+	query := fmt.Sprintf(`
+	from(bucket: %s)
+		|> range(start: %s)
+		|> filter(fn: (r) => r["deviceId"] == %s)
+		|> group(columns: ["_measurement"])
+		|> distinct(column: "_measurement")
+		|> keep(columns: ["_measurement"])
+	`, params.String("bucket", b.config.Bucket), params.Duration("lookback", -historyLookback), params.String("deviceId", deviceID))
+	return b.queryStrings(ctx, params.Record()+query, "_measurement")
+}
+
+func (b influxHistoryBackend) queryStrings(ctx context.Context, query, column string) ([]string, error) {
+	client := influxdb2.NewClient(b.config.URL, b.config.Token)
+	defer client.Close()
+
+	result, err := client.QueryAPI(b.config.Org).Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for result.Next() {
+		if v, ok := result.Record().ValueByKey(column).(string); ok {
+			values = append(values, v)
+		}
+	}
+	return values, result.Err()
+}
+
+func (b influxHistoryBackend) Query(ctx context.Context, p *historyPlan, fn func(historyRecord) error) error {
+	client := influxdb2.NewClient(b.config.URL, b.config.Token)
+	defer client.Close()
+
+	tier := selectHistoryTier(b.tiersWithStarts(ctx), p, time.Now())
+	result, err := client.QueryAPI(b.config.Org).Query(ctx, buildHistoryFlux(tier, p))
+	if err != nil {
+		return err
+	}
+	defer result.Close()
+	for result.Next() {
+		record := result.Record()
+		deviceID, _ := record.ValueByKey("deviceId").(string)
+		datapointID, _ := record.ValueByKey("datapointId").(string)
+		if err := fn(historyRecord{
+			time:        record.Time(),
+			deviceID:    deviceID,
+			datapointID: datapointID,
+			measurement: record.Measurement(),
+			value:       record.Value(),
+		}); err != nil {
+			return err
+		}
+	}
+	return result.Err()
+}
+
+// localHistoryBackend fragt den eingebetteten Historian ab
+type localHistoryBackend struct{}
+
+func (localHistoryBackend) Devices(ctx context.Context) ([]string, error) {
+	return historian.Devices(ctx, time.Now().Add(-historyLookback))
+}
+
+func (localHistoryBackend) Measurements(ctx context.Context, deviceID string) ([]string, error) {
+	return historian.Measurements(ctx, deviceID, time.Now().Add(-historyLookback))
+}
+
+func (localHistoryBackend) Query(ctx context.Context, p *historyPlan, fn func(historyRecord) error) error {
+	q := historian.Query{
+		Start:     p.start,
+		Stop:      p.stop,
+		Aggregate: p.aggregate,
+		Window:    p.window,
+		Location:  p.location,
+	}
+	// Wie bei Flux ein Wert mehr als erlaubt, um abgeschnittene Zeitreihen zu erkennen
+	if p.maxPoints > 0 {
+		q.Limit = p.maxPoints + 1
+	}
+	for _, s := range p.query.Series {
+		q.Series = append(q.Series, historian.SeriesFilter{DeviceID: s.DeviceID, DatapointID: s.DatapointID, Measurement: s.Measurement})
+	}
+	return historian.Run(ctx, q, func(point historian.Point) error {
+		return fn(historyRecord{
+			time:        point.Time,
+			deviceID:    point.DeviceID,
+			datapointID: point.DatapointID,
+			measurement: point.Measurement,
+			value:       point.Value,
+		})
+	})
+}
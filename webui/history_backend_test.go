@@ -0,0 +1,81 @@
+package webui
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	dataforwarding "iot-gateway/data-forwarding"
+)
+
+func TestSelectHistoryTier(t *testing.T) {
+	day := 24 * time.Hour
+	tiers := func(minuteSince, hourSince time.Time) []dataforwarding.BucketTier {
+		return []dataforwarding.BucketTier{
+			{Bucket: "raw", Retention: 7 * day},
+			{Bucket: "1m", Every: time.Minute, Retention: 90 * day, Since: minuteSince},
+			{Bucket: "1h", Every: time.Hour, Retention: 5 * 365 * day, Since: hourSince},
+		}
+	}
+	longAgo := historyNow.Add(-400 * day)
+
+	tests := []struct {
+		name      string
+		tiers     []dataforwarding.BucketTier
+		start     time.Duration
+		window    time.Duration
+		aggregate string
+		want      string
+	}{
+		{"raw data covers start", tiers(longAgo, longAgo), -2 * day, time.Hour, "mean", "raw"},
+		{"minute tier covers start", tiers(longAgo, longAgo), -30 * day, time.Hour, "mean", "1m"},
+		{"window not aligned to minutes", tiers(longAgo, longAgo), -30 * day, 90 * time.Second, "mean", "1m"},
+		{"beyond minute retention", tiers(longAgo, longAgo), -200 * day, 6 * time.Hour, "mean", "1h"},
+		{"hour tier for unaligned windows", tiers(longAgo, longAgo), -200 * day, 90 * time.Minute, "mean", "1h"},
+		// Downsampling erst vor drei Tagen eingeschaltet: die Stufen enthalten nichts Älteres
+		{"tiers started after start", tiers(historyNow.Add(-3*day), historyNow.Add(-3*day)), -30 * day, time.Hour, "mean", "raw"},
+		{"minute tier started before start", tiers(historyNow.Add(-40*day), historyNow.Add(-3*day)), -30 * day, time.Hour, "mean", "1m"},
+		{"hour tier reaches furthest back", tiers(historyNow.Add(-3*day), historyNow.Add(-60*day)), -30 * day, time.Hour, "mean", "1h"},
+		{"no downsampling tasks", tiers(time.Time{}, time.Time{}), -30 * day, time.Hour, "mean", "raw"},
+		{"raw values", tiers(longAgo, longAgo), -30 * day, 0, "none", "raw"},
+	}
+	for _, tt := range tests {
+		p := &historyPlan{start: historyNow.Add(tt.start), stop: historyNow, window: tt.window, aggregate: tt.aggregate}
+		if got := selectHistoryTier(tt.tiers, p, historyNow); got.Bucket != tt.want {
+			t.Errorf("%s: selected %s, want %s", tt.name, got.Bucket, tt.want)
+		}
+	}
+}
+
+func TestInfluxHistoryBackendUsesTiersFromTaskStart(t *testing.T) {
+	stub := newInfluxStub(t, historyCSV)
+	created := time.Now().Add(-10 * 24 * time.Hour)
+	stub.tasks["iot-gateway downsampling gateway_1m"] = created
+	stub.tasks["iot-gateway downsampling gateway_1h"] = created
+
+	config := dataforwarding.RetentionConfig{
+		Manage:          true,
+		Downsampling:    true,
+		Retention:       7 * 24 * time.Hour,
+		MinuteRetention: 90 * 24 * time.Hour,
+		HourRetention:   5 * 365 * 24 * time.Hour,
+	}
+	b := influxHistoryBackend{
+		config: &dataforwarding.InfluxConfig{URL: stub.server.URL, Token: "test-token", Org: "test-org", Bucket: "gateway"},
+		tiers:  config.Tiers("gateway"),
+	}
+
+	p, err := planHistoryQuery(HistoryQuery{Series: []HistorySeries{{Measurement: "temp"}}, Start: "-8d"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Query(context.Background(), p, func(historyRecord) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if len(stub.queries) != 1 || !strings.Contains(stub.queries[0], `bucket: "gateway_1m"`) {
+		t.Errorf("query does not use the minute tier:\n%v", stub.queries)
+	}
+}
@@ -0,0 +1,426 @@
+package webui
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dataforwarding "iot-gateway/data-forwarding"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	historyMaxSeries        = 20    // Datenpunkte je Abfrage
+	historyDefaultMaxPoints = 1000  // Punkte je Datenpunkt, wenn maxPoints fehlt
+	historyMaxPoints        = 10000 // Obergrenze für maxPoints
+)
+
+// historyAggregates ordnet die erlaubten Aggregationen den Flux-Funktionen zu ("none" = Rohdaten)
+var historyAggregates = map[string]string{
+	"mean":  "mean",
+	"min":   "min",
+	"max":   "max",
+	"last":  "last",
+	"count": "count",
+	"none":  "",
+}
+
+// historyWindows sind die Fenstergrößen für das automatische Downsampling
+var historyWindows = []time.Duration{
+	time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second, 15 * time.Second, 30 * time.Second,
+	time.Minute, 2 * time.Minute, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 2 * time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour,
+	24 * time.Hour, 2 * 24 * time.Hour, 7 * 24 * time.Hour, 30 * 24 * time.Hour,
+}
+
+// HistorySeries wählt einen Datenpunkt aus: über deviceId und datapointId oder über das Measurement
+type HistorySeries struct {
+	DeviceID    string `json:"deviceId,omitempty"`
+	DatapointID string `json:"datapointId,omitempty"`
+	Measurement string `json:"measurement,omitempty"`
+}
+
+// HistoryQuery ist eine Abfrage historischer Daten
+type HistoryQuery struct {
+	Series    []HistorySeries `json:"series"`
+	Start     string          `json:"start"`               // Relativ (-24h, -7d) oder absolut (RFC3339 bzw. 2006-01-02T15:04 in timezone)
+	Stop      string          `json:"stop,omitempty"`      // Wie start, Standard now
+	Timezone  string          `json:"timezone,omitempty"`  // IANA-Zeitzone, Standard TZ des Gateways bzw. UTC
+	Aggregate string          `json:"aggregate,omitempty"` // mean (Standard), min, max, last, count, none
+	Window    string          `json:"window,omitempty"`    // Aggregationsfenster, wird bei zu vielen Punkten vergrößert
+	MaxPoints int             `json:"maxPoints,omitempty"` // Maximale Punkte je Datenpunkt
+}
+
+// HistoryPoint ist ein Wert einer Zeitreihe
+type HistoryPoint struct {
+	X time.Time   `json:"x"`
+	Y interface{} `json:"y"`
+}
+
+// HistorySeriesResult ist die Zeitreihe eines angefragten Datenpunkts
+type HistorySeriesResult struct {
+	HistorySeries
+	Points    []HistoryPoint `json:"points"`
+	Truncated bool           `json:"truncated,omitempty"` // Nur bei aggregate=none: mehr als maxPoints Rohwerte
+}
+
+// HistoryResult ist das Ergebnis einer Abfrage historischer Daten
+type HistoryResult struct {
+	Start     time.Time             `json:"start"`
+	Stop      time.Time             `json:"stop"`
+	Timezone  string                `json:"timezone"`
+	Aggregate string                `json:"aggregate"`
+	Window    string                `json:"window,omitempty"`
+	Series    []HistorySeriesResult `json:"series"`
+}
+
+// historyPlan ist eine geprüfte Abfrage
+type historyPlan struct {
+	query     HistoryQuery
+	location  *time.Location
+	start     time.Time
+	stop      time.Time
+	aggregate string
+	window    time.Duration
+	maxPoints int // Bei aggregate=none: Rohwerte je Datenpunkt (+1 zur Erkennung), 0 = alle
+}
+
+// defaultTimezone liefert die Zeitzone des Gateways (TZ), sonst UTC
+func defaultTimezone() string {
+	if tz := os.Getenv("TZ"); tz != "" {
+		if _, err := time.LoadLocation(tz); err == nil {
+			return tz
+		}
+	}
+	return "UTC"
+}
+
+// parseHistoryDuration liest Dauern wie 90s, 15m, 1h30m, 7d oder 2w
+func parseHistoryDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if n := len(s); n > 1 && (s[n-1] == 'd' || s[n-1] == 'w') {
+		count, err := strconv.Atoi(s[:n-1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		unit := 24 * time.Hour
+		if s[n-1] == 'w' {
+			unit *= 7
+		}
+		return time.Duration(count) * unit, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// parseHistoryTime liest einen relativen (-24h, now) oder absoluten Zeitpunkt. Zeitpunkte ohne
+// Offset werden in der Zeitzone der Abfrage interpretiert.
+func parseHistoryTime(s string, now time.Time, loc *time.Location) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "now" {
+		return now, nil
+	}
+	if strings.HasPrefix(s, "-") {
+		d, err := parseHistoryDuration(s[1:])
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected RFC3339, 2006-01-02T15:04 or a relative time like -24h", s)
+}
+
+// planHistoryQuery prüft eine Abfrage und bestimmt Zeitbereich und Aggregationsfenster
+func planHistoryQuery(q HistoryQuery, now time.Time) (*historyPlan, error) {
+	if q.MaxPoints == 0 {
+		q.MaxPoints = historyDefaultMaxPoints
+	}
+	if q.MaxPoints < 1 || q.MaxPoints > historyMaxPoints {
+		return nil, fmt.Errorf("maxPoints must be between 1 and %d", historyMaxPoints)
+	}
+	if q.Aggregate == "" {
+		q.Aggregate = "mean"
+	}
+	p, err := planHistoryRange(q, now)
+	if err != nil || p.aggregate == "none" {
+		return p, err
+	}
+	// Fenster so wählen, dass höchstens maxPoints Punkte je Datenpunkt entstehen
+	if minWindow := p.stop.Sub(p.start) / time.Duration(p.maxPoints); p.window < minWindow {
+		p.window = niceWindow(minWindow)
+	}
+	return p, nil
+}
+
+// planHistoryRange prüft Datenpunkte, Zeitzone, Zeitbereich, Aggregation und ein angegebenes Fenster
+func planHistoryRange(q HistoryQuery, now time.Time) (*historyPlan, error) {
+	if len(q.Series) == 0 {
+		return nil, fmt.Errorf("at least one series is required")
+	}
+	if len(q.Series) > historyMaxSeries {
+		return nil, fmt.Errorf("at most %d series per query", historyMaxSeries)
+	}
+	for _, s := range q.Series {
+		if s.DatapointID == "" && s.Measurement == "" {
+			return nil, fmt.Errorf("each series needs a datapointId or measurement")
+		}
+	}
+
+	p := &historyPlan{query: q, maxPoints: q.MaxPoints, aggregate: q.Aggregate}
+	if q.Timezone == "" {
+		p.query.Timezone = defaultTimezone()
+	}
+	var err error
+	if p.location, err = time.LoadLocation(p.query.Timezone); err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", p.query.Timezone)
+	}
+
+	if q.Start == "" {
+		return nil, fmt.Errorf("start is required")
+	}
+	if p.start, err = parseHistoryTime(q.Start, now, p.location); err != nil {
+		return nil, err
+	}
+	if p.stop, err = parseHistoryTime(q.Stop, now, p.location); err != nil {
+		return nil, err
+	}
+	if !p.start.Before(p.stop) {
+		return nil, fmt.Errorf("start must be before stop")
+	}
+
+	if _, ok := historyAggregates[p.aggregate]; !ok {
+		return nil, fmt.Errorf("unknown aggregate %q, expected mean, min, max, last, count or none", p.aggregate)
+	}
+	if p.aggregate == "none" {
+		return p, nil
+	}
+
+	if q.Window != "" {
+		if p.window, err = parseHistoryDuration(q.Window); err != nil {
+			return nil, err
+		}
+		if p.window <= 0 {
+			return nil, fmt.Errorf("window must be positive")
+		}
+	}
+	return p, nil
+}
+
+// niceWindow rundet eine Fenstergröße auf den nächsten Wert aus historyWindows auf
+func niceWindow(d time.Duration) time.Duration {
+	for _, w := range historyWindows {
+		if w >= d {
+			return w
+		}
+	}
+	last := historyWindows[len(historyWindows)-1]
+	return (d + last - 1) / last * last
+}
+
+// fluxParams sammelt die Werte einer Abfrage als Flux-Literale im Record params am Anfang der Abfrage,
+// die Pipeline verweist nur auf params.<name>. Benutzereingaben stehen also im Abfragetext, aber nur
+// als Literale: Strings escapt über FluxString, Zeiten, Dauern und Zahlen aus geprüften Go-Werten.
+// (Die params-Option der InfluxDB-API wird von InfluxDB OSS nicht unterstützt.)
+type fluxParams struct {
+	names  []string
+	values map[string]string
+}
+
+func newFluxParams() *fluxParams {
+	return &fluxParams{values: make(map[string]string)}
+}
+
+func (p *fluxParams) set(name, literal string) string {
+	if _, ok := p.values[name]; !ok {
+		p.names = append(p.names, name)
+	}
+	p.values[name] = literal
+	return "params." + name
+}
+
+// String setzt einen String-Parameter
+func (p *fluxParams) String(name, value string) string {
+	return p.set(name, dataforwarding.FluxString(value))
+}
+
+// Time setzt einen Zeitpunkt-Parameter
+func (p *fluxParams) Time(name string, value time.Time) string {
+	return p.set(name, value.UTC().Format(time.RFC3339Nano))
+}
+
+// Duration setzt einen Dauer-Parameter
+func (p *fluxParams) Duration(name string, value time.Duration) string {
+	return p.set(name, dataforwarding.FluxDuration(value))
+}
+
+// Int setzt einen Ganzzahl-Parameter
+func (p *fluxParams) Int(name string, value int) string {
+	return p.set(name, strconv.Itoa(value))
+}
+
+// Record liefert die Zuweisung des Records params für den Anfang der Abfrage
+func (p *fluxParams) Record() string {
+	fields := make([]string, 0, len(p.names))
+	for _, name := range p.names {
+		fields = append(fields, name+": "+p.values[name])
+	}
+	return "params = {" + strings.Join(fields, ", ") + "}\n"
+}
+
+// buildHistoryFlux erzeugt die Flux-Abfrage für einen geprüften Plan auf dem Bucket einer Stufe
+func buildHistoryFlux(tier dataforwarding.BucketTier, p *historyPlan) string {
+	params := newFluxParams()
+	bucketParam := params.String("bucket", tier.Bucket)
+	startParam := params.Time("start", p.start)
+	stopParam := params.Time("stop", p.stop)
+	tzParam := params.String("timezone", p.query.Timezone)
+
+	predicates := make([]string, 0, len(p.query.Series))
+	for i, s := range p.query.Series {
+		var conds []string
+		if s.DeviceID != "" {
+			conds = append(conds, "r.deviceId == "+params.String(fmt.Sprintf("device%d", i), s.DeviceID))
+		}
+		if s.DatapointID != "" {
+			conds = append(conds, "r.datapointId == "+params.String(fmt.Sprintf("datapoint%d", i), s.DatapointID))
+		} else {
+			conds = append(conds, "r._measurement == "+params.String(fmt.Sprintf("measurement%d", i), s.Measurement))
+		}
+		predicates = append(predicates, "("+strings.Join(conds, " and ")+")")
+	}
+
+	var pipeline strings.Builder
+	fmt.Fprintf(&pipeline, "from(bucket: %s)\n", bucketParam)
+	fmt.Fprintf(&pipeline, "\t|> range(start: %s, stop: %s)\n", startParam, stopParam)
+	field, fn := "value", historyAggregates[p.aggregate]
+	if tier.Every != 0 {
+		// Downsampling-Stufen enthalten je Fenster ein Feld je Aggregation, Anzahlen werden summiert
+		field = p.aggregate
+		if p.aggregate == "count" {
+			fn = "sum"
+		}
+	}
+	fmt.Fprintf(&pipeline, "\t|> filter(fn: (r) => r._field == %s)\n", params.String("field", field))
+	fmt.Fprintf(&pipeline, "\t|> filter(fn: (r) => %s)\n", strings.Join(predicates, " or "))
+
+	switch {
+	case p.aggregate == "none":
+		// Ein Wert mehr als erlaubt, um abgeschnittene Zeitreihen zu erkennen
+		if p.maxPoints > 0 {
+			fmt.Fprintf(&pipeline, "\t|> limit(n: %s)\n", params.Int("limit", p.maxPoints+1))
+		}
+	case tier.Every == 0 && (p.aggregate == "mean" || p.aggregate == "min" || p.aggregate == "max"):
+		// Texte lassen sich nicht aggregieren, Booleans werden als 0/1 gemittelt
+		pipeline.WriteString("\t|> filter(fn: (r) => not types.isType(v: r._value, type: \"string\"))\n")
+		pipeline.WriteString("\t|> toFloat()\n")
+		fallthrough
+	default:
+		fmt.Fprintf(&pipeline, "\t|> aggregateWindow(every: %s, fn: %s, createEmpty: false, timeSrc: \"_start\", location: timezone.location(name: %s))\n",
+			params.Duration("window", p.window), fn, tzParam)
+	}
+	pipeline.WriteString("\t|> keep(columns: [\"_time\", \"_value\", \"_measurement\", \"deviceId\", \"datapointId\"])\n")
+
+	return "import \"timezone\"\nimport \"types\"\n\n" + params.Record() + "\n" + pipeline.String()
+}
+
+// matches prüft, ob ein Datensatz zu einem angefragten Datenpunkt gehört
+func (s HistorySeries) matches(deviceID, datapointID, measurement string) bool {
+	if s.DeviceID != "" && s.DeviceID != deviceID {
+		return false
+	}
+	if s.DatapointID != "" {
+		return s.DatapointID == datapointID
+	}
+	return s.Measurement == measurement
+}
+
+// runHistoryQuery führt eine geprüfte Abfrage aus
+func runHistoryQuery(ctx context.Context, backend historyBackend, p *historyPlan) (*HistoryResult, error) {
+	res := &HistoryResult{
+		Start:     p.start.In(p.location),
+		Stop:      p.stop.In(p.location),
+		Timezone:  p.query.Timezone,
+		Aggregate: p.aggregate,
+		Series:    make([]HistorySeriesResult, len(p.query.Series)),
+	}
+	if p.aggregate != "none" {
+		res.Window = dataforwarding.FluxDuration(p.window)
+	}
+	for i, s := range p.query.Series {
+		res.Series[i] = HistorySeriesResult{HistorySeries: s, Points: []HistoryPoint{}}
+	}
+
+	err := backend.Query(ctx, p, func(record historyRecord) error {
+		point := HistoryPoint{X: record.time.In(p.location), Y: record.value}
+		for i := range res.Series {
+			if res.Series[i].matches(record.deviceID, record.datapointID, record.measurement) {
+				res.Series[i].Points = append(res.Series[i].Points, point)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range res.Series {
+		series := &res.Series[i]
+		sort.SliceStable(series.Points, func(a, b int) bool { return series.Points[a].X.Before(series.Points[b].X) })
+		if len(series.Points) > p.maxPoints {
+			series.Points = series.Points[:p.maxPoints]
+			series.Truncated = true
+		}
+	}
+	return res, nil
+}
+
+// queryHistory liefert die historischen Daten mehrerer Datenpunkte, bei Bedarf aggregiert
+func queryHistory(c *gin.Context) {
+	var q HistoryQuery
+	if err := c.ShouldBindJSON(&q); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	plan, err := planHistoryQuery(q, time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, err := getDBConnection(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to SQLite database"})
+		return
+	}
+	backend, err := getHistoryBackend(db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch InfluxDB configuration"})
+		return
+	}
+
+	result, err := runHistoryQuery(c, backend, plan)
+	if err != nil {
+		logrus.Errorf("Failed to query historical data: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to query historical data: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
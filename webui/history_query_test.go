@@ -0,0 +1,417 @@
+package webui
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dataforwarding "iot-gateway/data-forwarding"
+	"iot-gateway/logic"
+
+	"github.com/gin-gonic/gin"
+)
+
+var historyNow = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+func TestPlanHistoryQueryTimezone(t *testing.T) {
+	series := []HistorySeries{{DeviceID: "1", DatapointID: "DP1"}}
+
+	// Umstellung auf Winterzeit: der Tag hat in Berlin 25 Stunden
+	p, err := planHistoryQuery(HistoryQuery{
+		Series: series, Start: "2026-10-25", Stop: "2026-10-26", Timezone: "Europe/Berlin",
+	}, historyNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, 10, 24, 22, 0, 0, 0, time.UTC); !p.start.Equal(want) {
+		t.Errorf("start = %v, want %v", p.start.UTC(), want)
+	}
+	if want := time.Date(2026, 10, 25, 23, 0, 0, 0, time.UTC); !p.stop.Equal(want) {
+		t.Errorf("stop = %v, want %v", p.stop.UTC(), want)
+	}
+
+	// Zeitpunkte mit Offset gelten unabhängig von der Zeitzone der Abfrage
+	p, err = planHistoryQuery(HistoryQuery{
+		Series: series, Start: "2026-10-18T08:00:00Z", Stop: "2026-10-18T10:00", Timezone: "America/New_York",
+	}, historyNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, 10, 18, 8, 0, 0, 0, time.UTC); !p.start.Equal(want) {
+		t.Errorf("start = %v, want %v", p.start.UTC(), want)
+	}
+	if want := time.Date(2026, 10, 18, 14, 0, 0, 0, time.UTC); !p.stop.Equal(want) {
+		t.Errorf("stop = %v, want %v", p.stop.UTC(), want)
+	}
+
+	t.Setenv("TZ", "")
+	p, err = planHistoryQuery(HistoryQuery{Series: series, Start: "2026-10-18T00:00"}, historyNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.query.Timezone != "UTC" || !p.start.Equal(time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("default timezone: got %s, start %v", p.query.Timezone, p.start)
+	}
+
+	if _, err := planHistoryQuery(HistoryQuery{Series: series, Start: "-1h", Timezone: "Mars/Olympus"}, historyNow); err == nil {
+		t.Error("unknown timezone accepted")
+	}
+}
+
+func TestPlanHistoryQueryRelativeRanges(t *testing.T) {
+	series := []HistorySeries{{Measurement: "temp"}}
+	tests := []struct {
+		start, stop string
+		wantStart   time.Duration // relativ zu historyNow
+		wantStop    time.Duration
+	}{
+		{"-24h", "", -24 * time.Hour, 0},
+		{"-7d", "now", -7 * 24 * time.Hour, 0},
+		{"-2w", "-1w", -14 * 24 * time.Hour, -7 * 24 * time.Hour},
+		{"-1h30m", "-15m", -90 * time.Minute, -15 * time.Minute},
+	}
+	for _, tt := range tests {
+		p, err := planHistoryQuery(HistoryQuery{Series: series, Start: tt.start, Stop: tt.stop}, historyNow)
+		if err != nil {
+			t.Errorf("%s..%s: %v", tt.start, tt.stop, err)
+			continue
+		}
+		if want := historyNow.Add(tt.wantStart); !p.start.Equal(want) {
+			t.Errorf("%s: start = %v, want %v", tt.start, p.start, want)
+		}
+		if want := historyNow.Add(tt.wantStop); !p.stop.Equal(want) {
+			t.Errorf("%s: stop = %v, want %v", tt.stop, p.stop, want)
+		}
+	}
+
+	for _, q := range []HistoryQuery{
+		{Series: series, Start: "-1h", Stop: "-2h"},
+		{Series: series, Start: "-1x"},
+		{Series: series, Start: "yesterday"},
+		{Series: series},
+		{Start: "-1h"},
+		{Series: []HistorySeries{{DeviceID: "1"}}, Start: "-1h"},
+	} {
+		if _, err := planHistoryQuery(q, historyNow); err == nil {
+			t.Errorf("invalid query accepted: %+v", q)
+		}
+	}
+}
+
+func TestPlanHistoryQueryDownsampling(t *testing.T) {
+	series := []HistorySeries{{Measurement: "temp"}}
+	tests := []struct {
+		name       string
+		query      HistoryQuery
+		wantWindow time.Duration
+		wantMax    int
+	}{
+		// 24h / 1000 = 86,4s -> nächstgrößeres Fenster 2m
+		{"default maxPoints", HistoryQuery{Start: "-24h"}, 2 * time.Minute, 1000},
+		// 24h / 100 = 14m24s -> 15m
+		{"maxPoints", HistoryQuery{Start: "-24h", MaxPoints: 100}, 15 * time.Minute, 100},
+		{"window too small", HistoryQuery{Start: "-24h", MaxPoints: 100, Window: "1m"}, 15 * time.Minute, 100},
+		{"window large enough", HistoryQuery{Start: "-24h", MaxPoints: 100, Window: "1h"}, time.Hour, 100},
+		// 365d / 10 = 36,5d -> Vielfaches von 30d
+		{"beyond largest window", HistoryQuery{Start: "-365d", MaxPoints: 10}, 60 * 24 * time.Hour, 10},
+		{"raw values", HistoryQuery{Start: "-24h", MaxPoints: 50, Aggregate: "none"}, 0, 50},
+	}
+	for _, tt := range tests {
+		tt.query.Series = series
+		p, err := planHistoryQuery(tt.query, historyNow)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if p.window != tt.wantWindow {
+			t.Errorf("%s: window = %v, want %v", tt.name, p.window, tt.wantWindow)
+		}
+		if p.maxPoints != tt.wantMax {
+			t.Errorf("%s: maxPoints = %d, want %d", tt.name, p.maxPoints, tt.wantMax)
+		}
+		if p.window > 0 {
+			if points := p.stop.Sub(p.start) / p.window; int(points) > p.maxPoints {
+				t.Errorf("%s: %d windows exceed maxPoints %d", tt.name, points, p.maxPoints)
+			}
+		}
+	}
+
+	for _, q := range []HistoryQuery{
+		{Series: series, Start: "-1h", MaxPoints: historyMaxPoints + 1},
+		{Series: series, Start: "-1h", MaxPoints: -1},
+		{Series: series, Start: "-1h", Aggregate: "median"},
+		{Series: series, Start: "-1h", Window: "-5m"},
+	} {
+		if _, err := planHistoryQuery(q, historyNow); err == nil {
+			t.Errorf("invalid query accepted: %+v", q)
+		}
+	}
+}
+
+func TestBuildHistoryFlux(t *testing.T) {
+	p, err := planHistoryQuery(HistoryQuery{
+		Series: []HistorySeries{
+			{DeviceID: "1", DatapointID: "DP1"},
+			{Measurement: `temp") |> drop() //${x}`},
+		},
+		Start:     "2026-10-18T00:00:00Z",
+		Stop:      "2026-10-18T12:00:00Z",
+		Timezone:  "Europe/Berlin",
+		MaxPoints: 100,
+	}, historyNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := buildHistoryFlux(dataforwarding.BucketTier{Bucket: "gateway"}, p)
+	for _, want := range []string{
+		`params = {bucket: "gateway", start: 2026-10-18T00:00:00Z, stop: 2026-10-18T12:00:00Z, timezone: "Europe/Berlin", ` +
+			`device0: "1", datapoint0: "DP1", measurement1: "temp\") |> drop() //\${x}", field: "value", window: 10m}`,
+		`from(bucket: params.bucket)`,
+		`|> range(start: params.start, stop: params.stop)`,
+		`|> filter(fn: (r) => r._field == params.field)`,
+		`|> filter(fn: (r) => (r.deviceId == params.device0 and r.datapointId == params.datapoint0) or (r._measurement == params.measurement1))`,
+		`|> toFloat()`,
+		`|> aggregateWindow(every: params.window, fn: mean, createEmpty: false, timeSrc: "_start", location: timezone.location(name: params.timezone))`,
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query misses %q:\n%s", want, query)
+		}
+	}
+	// Die Eingabe steht nur als Literal im Record params
+	if n := strings.Count(query, "drop()"); n != 1 {
+		t.Errorf("measurement appears %d times in the query:\n%s", n, query)
+	}
+
+	// Downsampling-Stufen: Feld der Aggregation, Anzahlen werden summiert
+	p.aggregate = "count"
+	query = buildHistoryFlux(dataforwarding.BucketTier{Bucket: "gateway_1m", Every: time.Minute}, p)
+	for _, want := range []string{`bucket: "gateway_1m"`, `field: "count"`, `fn: sum,`} {
+		if !strings.Contains(query, want) {
+			t.Errorf("tier query misses %q:\n%s", want, query)
+		}
+	}
+	if strings.Contains(query, "toFloat") {
+		t.Errorf("tier query converts values:\n%s", query)
+	}
+
+	// Rohwerte: ein Wert mehr als maxPoints zur Erkennung abgeschnittener Zeitreihen
+	p, err = planHistoryQuery(HistoryQuery{
+		Series: []HistorySeries{{Measurement: "temp"}}, Start: "-1h", Aggregate: "none", MaxPoints: 500,
+	}, historyNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query = buildHistoryFlux(dataforwarding.BucketTier{Bucket: "gateway"}, p)
+	if !strings.Contains(query, "limit: 501") || !strings.Contains(query, "|> limit(n: params.limit)") {
+		t.Errorf("raw query misses limit:\n%s", query)
+	}
+	if strings.Contains(query, "aggregateWindow") {
+		t.Errorf("raw query aggregates:\n%s", query)
+	}
+}
+
+// influxStub beantwortet Flux-Abfragen wie die Query-API der InfluxDB mit einem festen CSV-Ergebnis
+// und liefert die Organisation sowie Tasks mit ihrem Anlagezeitpunkt
+type influxStub struct {
+	mu      sync.Mutex
+	queries []string
+	csv     string
+	tasks   map[string]time.Time // Task-Name -> createdAt
+	server  *httptest.Server
+}
+
+func newInfluxStub(t *testing.T, csv string) *influxStub {
+	t.Helper()
+	stub := &influxStub{csv: csv, tasks: make(map[string]time.Time)}
+	stub.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v2/orgs":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"orgs": [{"id": "org1", "name": "test-org"}]}`)
+			return
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v2/tasks":
+			name := r.URL.Query().Get("name")
+			tasks := []map[string]string{}
+			stub.mu.Lock()
+			if created, ok := stub.tasks[name]; ok {
+				tasks = append(tasks, map[string]string{"id": "task1", "orgID": "org1", "name": name, "flux": "", "createdAt": created.Format(time.RFC3339)})
+			}
+			stub.mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"tasks": tasks})
+			return
+		case r.Method != http.MethodPost || r.URL.Path != "/api/v2/query":
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("org") != "test-org" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body struct {
+			Query string `json:"query"`
+		}
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		stub.mu.Lock()
+		stub.queries = append(stub.queries, body.Query)
+		stub.mu.Unlock()
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		io.WriteString(w, stub.csv)
+	}))
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+// setupHistoryRouter stellt den Handler mit einer leeren Datenbank auf den InfluxDB-Stub ein
+func setupHistoryRouter(t *testing.T, stub *influxStub) *gin.Engine {
+	t.Helper()
+	db, err := logic.InitDB(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	t.Setenv("HISTORY_BACKEND", "influxdb")
+	prev := influxConfig
+	influxConfig = &dataforwarding.InfluxConfig{URL: stub.server.URL, Token: "test-token", Org: "test-org", Bucket: "gateway"}
+	t.Cleanup(func() { influxConfig = prev })
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("db", db) })
+	r.POST("/api/v1/history/query", queryHistory)
+	return r
+}
+
+func postHistoryQuery(r *gin.Engine, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/history/query", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+const historyCSV = "#datatype,string,long,dateTime:RFC3339,double,string,string,string\r\n" +
+	"#group,false,false,false,false,true,true,true\r\n" +
+	"#default,_result,,,,,,\r\n" +
+	",result,table,_time,_value,_measurement,deviceId,datapointId\r\n" +
+	",,0,2026-10-18T09:00:00Z,21.5,temp,1,DP1\r\n" +
+	",,0,2026-10-18T08:00:00Z,20.5,temp,1,DP1\r\n" +
+	",,0,2026-10-18T10:00:00Z,22.5,temp,1,DP1\r\n" +
+	",,1,2026-10-18T08:00:00Z,3,pressure,2,DP7\r\n" +
+	",,2,2026-10-18T08:00:00Z,99,other,3,DP9\r\n" +
+	"\r\n"
+
+func TestQueryHistory(t *testing.T) {
+	stub := newInfluxStub(t, historyCSV)
+	r := setupHistoryRouter(t, stub)
+
+	w := postHistoryQuery(r, `{
+		"series": [{"deviceId": "1", "datapointId": "DP1"}, {"measurement": "pressure"}],
+		"start": "2026-10-18T10:00", "stop": "2026-10-18T13:00",
+		"timezone": "Europe/Berlin", "maxPoints": 2
+	}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+
+	var res struct {
+		Start     string `json:"start"`
+		Timezone  string `json:"timezone"`
+		Aggregate string `json:"aggregate"`
+		Window    string `json:"window"`
+		Series    []struct {
+			DeviceID    string `json:"deviceId"`
+			Measurement string `json:"measurement"`
+			Truncated   bool   `json:"truncated"`
+			Points      []struct {
+				X string  `json:"x"`
+				Y float64 `json:"y"`
+			} `json:"points"`
+		} `json:"series"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Start != "2026-10-18T10:00:00+02:00" || res.Timezone != "Europe/Berlin" || res.Aggregate != "mean" {
+		t.Errorf("unexpected header: start %s, timezone %s, aggregate %s", res.Start, res.Timezone, res.Aggregate)
+	}
+	// 3h / 2 Punkte = 90m -> 2h
+	if res.Window != "2h" {
+		t.Errorf("window = %s, want 2h", res.Window)
+	}
+	if len(res.Series) != 2 {
+		t.Fatalf("got %d series, want 2", len(res.Series))
+	}
+
+	dp1 := res.Series[0]
+	if len(dp1.Points) != 2 || !dp1.Truncated {
+		t.Fatalf("DP1: %d points (truncated=%v), want 2 truncated", len(dp1.Points), dp1.Truncated)
+	}
+	// Nach Zeit sortiert und in der Zeitzone der Abfrage
+	if dp1.Points[0].X != "2026-10-18T10:00:00+02:00" || dp1.Points[0].Y != 20.5 ||
+		dp1.Points[1].X != "2026-10-18T11:00:00+02:00" || dp1.Points[1].Y != 21.5 {
+		t.Errorf("DP1 points = %+v", dp1.Points)
+	}
+
+	pressure := res.Series[1]
+	if pressure.Measurement != "pressure" || len(pressure.Points) != 1 || pressure.Points[0].Y != 3 || pressure.Truncated {
+		t.Errorf("pressure series = %+v", pressure)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if len(stub.queries) != 1 {
+		t.Fatalf("sent %d queries, want 1", len(stub.queries))
+	}
+	for _, want := range []string{`bucket: "gateway"`, `start: 2026-10-18T08:00:00Z`, `stop: 2026-10-18T11:00:00Z`, `window: 2h`} {
+		if !strings.Contains(stub.queries[0], want) {
+			t.Errorf("query misses %q:\n%s", want, stub.queries[0])
+		}
+	}
+}
+
+func TestQueryHistoryRejectsInvalidQueries(t *testing.T) {
+	stub := newInfluxStub(t, historyCSV)
+	r := setupHistoryRouter(t, stub)
+
+	for _, body := range []string{
+		`not json`,
+		`{"series": [], "start": "-1h"}`,
+		`{"series": [{"measurement": "temp"}], "start": "-1h", "timezone": "Nowhere/City"}`,
+		`{"series": [{"measurement": "temp"}], "start": "-1h", "maxPoints": 100000}`,
+	} {
+		if w := postHistoryQuery(r, body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, w.Code)
+		}
+	}
+	if len(stub.queries) != 0 {
+		t.Errorf("invalid queries reached InfluxDB: %v", stub.queries)
+	}
+}
+
+func TestQueryHistoryReportsInfluxErrors(t *testing.T) {
+	stub := newInfluxStub(t, historyCSV)
+	r := setupHistoryRouter(t, stub)
+	influxConfig.Token = "wrong"
+
+	w := postHistoryQuery(r, `{"series": [{"measurement": "temp"}], "start": "-1h"}`)
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("status %d, want 502: %s", w.Code, w.Body.String())
+	}
+}
@@ -9,13 +9,14 @@ import (
 	"strconv"
 	"time"
 
+	"iot-gateway/topics"
+
 	"github.com/gin-gonic/gin"
 	_ "github.com/glebarez/go-sqlite"
 	"github.com/gorilla/websocket"
 	MQTT "github.com/mochi-mqtt/server/v2"
 	"github.com/mochi-mqtt/server/v2/packets"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/exp/rand"
 )
 
 type BrokerStatus struct {
@@ -69,6 +70,8 @@ func brokerStatusWebSocket(c *gin.Context) {
 	server := c.MustGet("server").(*MQTT.Server)
 
 	// Starte eine Goroutine, um den Broker-Status regelmäßig zu lesen
+	messagesSubscriptionID := topics.NextSubscriptionID()
+	uptimeSubscriptionID := topics.NextSubscriptionID()
 	go func() {
 		// Subscribe to a filter and handle any received messages via a callback function.
 		callbackFn := func(cl *MQTT.Client, sub packets.Subscription, pk packets.Packet) {
@@ -99,10 +102,11 @@ func brokerStatusWebSocket(c *gin.Context) {
 			}
 		}
 
-		_ = server.Subscribe("$SYS/broker/messages/received", rand.Intn(100), callbackFn)
-		// _ = server.Subscribe("driver/states/#", rand.Intn(100), callbackFn)
-		_ = server.Subscribe("$SYS/broker/uptime", rand.Intn(100), callbackFn)
+		_ = server.Subscribe("$SYS/broker/messages/received", messagesSubscriptionID, callbackFn)
+		_ = server.Subscribe("$SYS/broker/uptime", uptimeSubscriptionID, callbackFn)
 	}()
+	defer server.Unsubscribe("$SYS/broker/messages/received", messagesSubscriptionID)
+	defer server.Unsubscribe("$SYS/broker/uptime", uptimeSubscriptionID)
 
 	httpClient := &http.Client{
 		Timeout: 5 * time.Second,
@@ -663,12 +663,13 @@ func executeImageCaptureProcess(c *gin.Context) {
 		return
 	}
 
-	// Kanal für das Bild erstellen
-	imageChan := make(chan *ImageCaptureResult)
+	// Kanal für das Bild erstellen (gepuffert, damit die Go-Routine nach einem Timeout nicht hängen bleibt)
+	imageChan := make(chan *ImageCaptureResult, 1)
 
 	// Einmalige Ausführung in einer Go-Routine
 	go func() {
 		result, err := executeSingleImageCapture(db, &process, true) // true = manuelle Ausführung
+		recordImageCaptureOutcome(db, process.ID, err)
 		if err != nil {
 			imageChan <- &ImageCaptureResult{Error: err}
 		} else {
@@ -817,9 +818,28 @@ func callNodeRedImageCaptureAPI(db *sql.DB, payload map[string]interface{}) erro
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("node-RED antwortete mit Status %d", resp.StatusCode)
+	}
 	return nil
 }
 
+// recordImageCaptureOutcome zählt eine Ausführung als Erfolg oder Fehler (upload_success_count,
+// upload_failure_count) und merkt sich den letzten Status für /metrics und die Prozessübersicht
+func recordImageCaptureOutcome(db *sql.DB, processID int, captureErr error) {
+	query := `UPDATE image_capture_processes SET upload_success_count = COALESCE(upload_success_count, 0) + 1,
+		last_upload_status = 'success', last_upload_error = NULL WHERE id = ?`
+	args := []interface{}{processID}
+	if captureErr != nil {
+		query = `UPDATE image_capture_processes SET upload_failure_count = COALESCE(upload_failure_count, 0) + 1,
+			last_upload_status = 'failed', last_upload_error = ? WHERE id = ?`
+		args = []interface{}{captureErr.Error(), processID}
+	}
+	if _, err := logic.SafeDBExec(db, query, args...); err != nil {
+		logrus.Errorf("Fehler beim Zählen der Image Capture Ausführung für Prozess %d: %v", processID, err)
+	}
+}
+
 // StartProcess startet einen Image Capture Prozess
 func (pm *ProcessManager) StartProcess(db *sql.DB, process *ImageCaptureProcess) error {
 	pm.mutex.Lock()
@@ -917,6 +937,7 @@ func (pm *ProcessManager) runProcess(db *sql.DB, runningProcess *RunningProcess)
 
 			// Image Capture mit der gemeinsamen Funktion ausführen
 			result, err := executeSingleImageCapture(db, process, false) // false = automatische Ausführung
+			recordImageCaptureOutcome(db, processID, err)
 			if err != nil {
 				runningProcess.LastError = err.Error()
 				logrus.Errorf("Fehler beim Image Capture für Prozess %d: %v", processID, err)
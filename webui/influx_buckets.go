@@ -0,0 +1,51 @@
+package webui
+
+import (
+	"net/http"
+
+	dataforwarding "iot-gateway/data-forwarding"
+	"iot-gateway/historian"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// getInfluxBuckets liefert Aufbewahrung, Größe und Downsampling-Tasks der InfluxDB-Buckets
+func getInfluxBuckets(c *gin.Context) {
+	if historian.Enabled() {
+		c.JSON(http.StatusConflict, gin.H{"error": "InfluxDB is not used, historical data is stored by the embedded historian"})
+		return
+	}
+	db, err := getDBConnection(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection failed"})
+		return
+	}
+
+	status, err := dataforwarding.GetRetentionStatus(c, db)
+	if err != nil {
+		logrus.Errorf("Error getting InfluxDB bucket status: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to get bucket status: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// applyInfluxBuckets gleicht Buckets und Downsampling-Tasks sofort mit den Einstellungen ab
+func applyInfluxBuckets(c *gin.Context) {
+	if historian.Enabled() {
+		c.JSON(http.StatusConflict, gin.H{"error": "InfluxDB is not used, historical data is stored by the embedded historian"})
+		return
+	}
+	db, err := getDBConnection(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection failed"})
+		return
+	}
+
+	if err := dataforwarding.ApplyRetention(c, db); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to apply bucket settings: " + err.Error()})
+		return
+	}
+	getInfluxBuckets(c)
+}
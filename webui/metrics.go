@@ -0,0 +1,134 @@
+package webui
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strconv"
+
+	dataforwarding "iot-gateway/data-forwarding"
+	"iot-gateway/historian"
+	"iot-gateway/metrics"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// metricsHandler liefert die Kennzahlen des Gateways im Prometheus-Textformat.
+// Zugriff nur mit Anmeldung an der Web-UI oder mit METRICS_TOKEN als Bearer-Token;
+// METRICS_PUBLIC=true gibt den Endpunkt ohne Anmeldung frei.
+func metricsHandler(c *gin.Context) {
+	if !metricsAuthorized(c) {
+		c.String(http.StatusUnauthorized, "unauthorized\n")
+		return
+	}
+
+	var e metrics.Exposition
+	metrics.WriteDevices(&e)
+	writeBrokerMetrics(c, &e)
+	if historian.Enabled() {
+		writeHistorianMetrics(&e)
+	} else {
+		writeInfluxMetrics(&e)
+	}
+	writeImageCaptureMetrics(c, &e)
+	metrics.WriteRuntime(&e)
+
+	c.Data(http.StatusOK, metrics.ContentType, []byte(e.String()))
+}
+
+// metricsAuthorized prüft den Zugriff auf /metrics
+func metricsAuthorized(c *gin.Context) bool {
+	if public, err := strconv.ParseBool(os.Getenv("METRICS_PUBLIC")); err == nil && public {
+		return true
+	}
+	if token := os.Getenv("METRICS_TOKEN"); token != "" &&
+		subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte("Bearer "+token)) == 1 {
+		return true
+	}
+	return sessions.Default(c).Get("user") != nil
+}
+
+// writeBrokerMetrics schreibt die Kennzahlen des MQTT-Brokers
+func writeBrokerMetrics(c *gin.Context, e *metrics.Exposition) {
+	server, err := getMQTTServer(c)
+	if err != nil || server == nil {
+		return
+	}
+	info := server.Info.Clone()
+
+	e.Gauge("mqtt_broker_clients_connected", "Currently connected MQTT clients.", float64(info.ClientsConnected))
+	e.Gauge("mqtt_broker_clients_disconnected", "Persistent MQTT sessions that are currently disconnected.", float64(info.ClientsDisconnected))
+	e.Gauge("mqtt_broker_subscriptions", "Active subscriptions.", float64(info.Subscriptions))
+	e.Gauge("mqtt_broker_retained_messages", "Retained messages held by the broker.", float64(info.Retained))
+	e.Gauge("mqtt_broker_inflight_messages", "Messages currently in flight.", float64(info.Inflight))
+	e.Counter("mqtt_broker_messages_received_total", "PUBLISH messages received.", float64(info.MessagesReceived))
+	e.Counter("mqtt_broker_messages_sent_total", "PUBLISH messages sent.", float64(info.MessagesSent))
+	e.Counter("mqtt_broker_messages_dropped_total", "PUBLISH messages dropped for slow subscribers.", float64(info.MessagesDropped))
+	e.Counter("mqtt_broker_bytes_received_total", "Bytes received.", float64(info.BytesReceived))
+	e.Counter("mqtt_broker_bytes_sent_total", "Bytes sent.", float64(info.BytesSent))
+	e.Gauge("mqtt_broker_uptime_seconds", "Broker uptime.", float64(info.Uptime))
+}
+
+// writeInfluxMetrics schreibt die Kennzahlen des InfluxDB-Writers
+func writeInfluxMetrics(e *metrics.Exposition) {
+	stats := dataforwarding.GetWriterStats()
+
+	e.Counter("influx_writer_points_processed_total", "Points handed to the InfluxDB writer.", float64(stats.ProcessedPoints))
+	e.Counter("influx_writer_points_failed_total", "Points that could not be written.", float64(stats.FailedPoints))
+	e.Counter("influx_writer_flushes_total", "Buffer flush operations.", float64(stats.FlushOperations))
+	e.Gauge("influx_writer_buffer_points", "Points waiting in the write buffer.", float64(stats.BufferSize))
+	e.Gauge("influx_writer_retry_queue_points", "Points waiting in the retry queue.", float64(stats.RetryQueueSize))
+	e.Gauge("influx_writer_circuit_breaker_state", "Circuit breaker state (0 = closed, 1 = open, 2 = half-open).", float64(stats.BreakerState))
+	e.Gauge("influx_writer_write_latency_seconds", "Duration of the last successful flush.", stats.WriteLatency.Seconds())
+	e.Counter("influx_writer_write_duration_seconds_total", "Total duration of successful flushes.", stats.WriteLatencyTotal.Seconds())
+	e.Counter("influx_writer_writes_total", "Successful flushes.", float64(stats.WriteCount))
+}
+
+// writeHistorianMetrics schreibt die Kennzahlen des eingebetteten Historians
+func writeHistorianMetrics(e *metrics.Exposition) {
+	stats := historian.GetStats()
+
+	e.Counter("historian_samples_stored_total", "Values stored by the embedded historian.", float64(stats.StoredSamples))
+	e.Counter("historian_samples_dropped_total", "Values dropped because the buffer was full.", float64(stats.DroppedSamples))
+	e.Counter("historian_flushes_total", "Buffer flush operations.", float64(stats.Flushes))
+	e.Gauge("historian_pending_samples", "Values waiting in the buffer.", float64(stats.Pending))
+}
+
+// writeImageCaptureMetrics schreibt die Erfolgs- und Fehlerzähler der Bildaufnahme-Prozesse
+func writeImageCaptureMetrics(c *gin.Context, e *metrics.Exposition) {
+	db, err := getDBConnection(c)
+	if err != nil {
+		return
+	}
+	rows, err := db.Query(`SELECT id, name, COALESCE(upload_success_count, 0), COALESCE(upload_failure_count, 0) FROM image_capture_processes ORDER BY id`)
+	if err != nil {
+		logrus.Errorf("Error querying image capture processes for metrics: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	type process struct {
+		id, name         string
+		success, failure int64
+	}
+	var processes []process
+	for rows.Next() {
+		var p process
+		var id int64
+		if err := rows.Scan(&id, &p.name, &p.success, &p.failure); err != nil {
+			logrus.Errorf("Error scanning image capture process for metrics: %v", err)
+			return
+		}
+		p.id = strconv.FormatInt(id, 10)
+		processes = append(processes, p)
+	}
+
+	for _, p := range processes {
+		e.Counter("image_capture_success_total", "Image capture executions accepted by Node-RED.", float64(p.success), "process", p.id, "name", p.name)
+	}
+	for _, p := range processes {
+		e.Counter("image_capture_failure_total", "Image capture executions that failed or were rejected by Node-RED.", float64(p.failure), "process", p.id, "name", p.name)
+	}
+}
@@ -0,0 +1,177 @@
+package webui
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"iot-gateway/notifications"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getNotificationChannels liefert alle Benachrichtigungskanäle
+func getNotificationChannels(c *gin.Context) {
+	channels, err := notifications.ListChannels()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"channels": channels})
+}
+
+// addNotificationChannel legt einen Kanal an
+func addNotificationChannel(c *gin.Context) {
+	channel := notifications.Channel{Enabled: true, DedupSeconds: 300}
+	if err := c.ShouldBindJSON(&channel); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if err := notifications.CreateChannel(&channel); err != nil {
+		respondNotificationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"channel": channel})
+}
+
+// updateNotificationChannel ändert einen Kanal
+func updateNotificationChannel(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	var channel notifications.Channel
+	if err := c.ShouldBindJSON(&channel); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	channel.ID = id
+
+	if err := notifications.UpdateChannel(&channel); err != nil {
+		respondNotificationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"channel": channel})
+}
+
+// deleteNotificationChannel löscht einen Kanal samt Regeln
+func deleteNotificationChannel(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	if err := notifications.DeleteChannel(id); err != nil {
+		respondNotificationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Notification channel deleted successfully"})
+}
+
+// testNotificationChannel sendet eine Testmeldung über einen Kanal
+func testNotificationChannel(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	if err := notifications.SendTest(id); err != nil {
+		if errors.Is(err, notifications.ErrChannelNotFound) {
+			respondNotificationError(c, err)
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "success"})
+}
+
+// getNotificationRules liefert alle Routing-Regeln
+func getNotificationRules(c *gin.Context) {
+	rules, err := notifications.ListRules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// addNotificationRule legt eine Routing-Regel an
+func addNotificationRule(c *gin.Context) {
+	rule := notifications.Rule{Enabled: true}
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if err := notifications.CreateRule(&rule); err != nil {
+		respondNotificationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rule": rule})
+}
+
+// updateNotificationRule ändert eine Routing-Regel
+func updateNotificationRule(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	var rule notifications.Rule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	rule.ID = id
+
+	if err := notifications.UpdateRule(&rule); err != nil {
+		respondNotificationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rule": rule})
+}
+
+// deleteNotificationRule löscht eine Routing-Regel
+func deleteNotificationRule(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	if err := notifications.DeleteRule(id); err != nil {
+		respondNotificationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Notification rule deleted successfully"})
+}
+
+// getNotificationLog liefert das Zustellprotokoll. Query-Parameter: channelId, limit
+func getNotificationLog(c *gin.Context) {
+	limit := 100
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+		limit = l
+	}
+	channelID, _ := strconv.ParseInt(c.Query("channelId"), 10, 64)
+
+	entries, err := notifications.ListLog(channelID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"log": entries})
+}
+
+// respondNotificationError bildet Fehler des Benachrichtigungssystems auf HTTP-Statuscodes ab
+func respondNotificationError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, notifications.ErrChannelNotFound), errors.Is(err, notifications.ErrRuleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, notifications.ErrInvalidConfig):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
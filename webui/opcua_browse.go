@@ -0,0 +1,175 @@
+package webui
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	opcua "iot-gateway/driver/opcua"
+	"iot-gateway/logic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// browseChildrenHandler liefert eine Seite von Kind-Knoten über die Session des laufenden Treibers.
+// Query-Parameter: nodeId (Standard: Objects-Ordner), continuationPoint, max
+func browseChildrenHandler(c *gin.Context) {
+	deviceID := c.Param("id")
+
+	var maxReferences uint32
+	if maxParam := c.Query("max"); maxParam != "" {
+		value, err := strconv.ParseUint(maxParam, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid max parameter"})
+			return
+		}
+		maxReferences = uint32(value)
+	}
+
+	page, err := opcua.BrowseChildren(deviceID, c.Query("nodeId"), c.Query("continuationPoint"), maxReferences)
+	if err != nil {
+		respondBrowseError(c, deviceID, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// browseAttributesHandler liefert die Detail-Attribute eines Knotens
+func browseAttributesHandler(c *gin.Context) {
+	deviceID := c.Param("id")
+	nodeID := c.Query("nodeId")
+	if nodeID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "nodeId is required"})
+		return
+	}
+
+	attrs, err := opcua.ReadNodeAttributes(deviceID, nodeID)
+	if err != nil {
+		respondBrowseError(c, deviceID, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, attrs)
+}
+
+// browseSearchHandler sucht Knoten nach Name unterhalb eines Startknotens
+func browseSearchHandler(c *gin.Context) {
+	deviceID := c.Param("id")
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+
+	maxDepth, _ := strconv.Atoi(c.Query("maxDepth"))
+	maxResults, _ := strconv.Atoi(c.Query("maxResults"))
+
+	nodes, err := opcua.SearchNodes(deviceID, c.Query("startNodeId"), query, maxDepth, maxResults)
+	if err != nil {
+		respondBrowseError(c, deviceID, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": nodes, "count": len(nodes)})
+}
+
+// clearBrowseCacheHandler verwirft den Browse-Cache eines Geräts
+func clearBrowseCacheHandler(c *gin.Context) {
+	opcua.InvalidateBrowseCache(c.Param("id"))
+	c.JSON(http.StatusOK, gin.H{"message": "Browse cache cleared"})
+}
+
+// addOpcUaDataNodes übernimmt im Browser ausgewählte Knoten als Datenpunkte des Geräts.
+// Bereits konfigurierte Knoten werden übersprungen.
+func addOpcUaDataNodes(c *gin.Context) {
+	deviceID := c.Param("id")
+	db, err := getDBConnection(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var req struct {
+		Nodes []struct {
+			NodeID string `json:"nodeId"`
+			Name   string `json:"name"`
+		} `json:"nodes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Nodes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	var devId int
+	var deviceType string
+	err = db.QueryRow(`SELECT id, type FROM devices WHERE id = ?`, deviceID).Scan(&devId, &deviceType)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+	if deviceType != "opc-ua" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Device is not an OPC-UA device"})
+		return
+	}
+
+	added := []gin.H{}
+	skipped := []string{}
+	for _, node := range req.Nodes {
+		node.NodeID = strings.TrimSpace(node.NodeID)
+		if node.NodeID == "" {
+			continue
+		}
+
+		var exists int
+		err = db.QueryRow(`SELECT COUNT(*) FROM opcua_datanodes WHERE device_id = ? AND node_identifier = ?`, devId, node.NodeID).Scan(&exists)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if exists > 0 {
+			skipped = append(skipped, node.NodeID)
+			continue
+		}
+
+		name := strings.TrimSpace(node.Name)
+		if name == "" {
+			name = node.NodeID
+		}
+
+		datapointId, err := generateOpcUaDatapointId(db, devId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		_, err = db.Exec(`INSERT INTO opcua_datanodes (device_id, datapointId, name, node_identifier) VALUES (?, ?, ?, ?)`,
+			devId, datapointId, name, node.NodeID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		added = append(added, gin.H{"datapointId": datapointId, "name": name, "nodeId": node.NodeID})
+	}
+
+	logrus.Infof("Added %d OPC-UA datanodes to device %s (%d skipped)", len(added), deviceID, len(skipped))
+
+	// Treiber neu starten, damit die neuen Knoten gelesen werden
+	if len(added) > 0 {
+		go logic.RestartDevice(db, deviceID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"added": added, "skipped": skipped})
+}
+
+// respondBrowseError bildet Browse-Fehler auf HTTP-Statuscodes ab
+func respondBrowseError(c *gin.Context, deviceID string, err error) {
+	if errors.Is(err, opcua.ErrNoSession) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Device " + deviceID + " has no active OPC-UA session"})
+		return
+	}
+	logrus.Errorf("Error browsing OPC-UA device %s: %v", deviceID, err)
+	c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+}
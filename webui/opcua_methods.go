@@ -0,0 +1,53 @@
+package webui
+
+import (
+	"errors"
+	"net/http"
+
+	opcua "iot-gateway/driver/opcua"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// getMethodSignatureHandler liefert die Ein- und Ausgabeargumente einer Methode (Query: methodId)
+func getMethodSignatureHandler(c *gin.Context) {
+	deviceID := c.Param("id")
+	methodID := c.Query("methodId")
+	if methodID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "methodId is required"})
+		return
+	}
+
+	signature, err := opcua.GetMethodSignature(deviceID, methodID)
+	if err != nil {
+		respondBrowseError(c, deviceID, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, signature)
+}
+
+// callMethodHandler ruft eine beliebige OPC-UA-Methode über die Session des Treibers auf
+func callMethodHandler(c *gin.Context) {
+	deviceID := c.Param("id")
+
+	var req opcua.MethodCallRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	result, err := opcua.CallMethod(deviceID, req)
+	if err != nil {
+		if errors.Is(err, opcua.ErrInvalidMethodInput) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		respondBrowseError(c, deviceID, err)
+		return
+	}
+
+	logrus.Infof("Called OPC-UA method %s on device %s: %s", req.MethodID, deviceID, result.StatusCode)
+	c.JSON(http.StatusOK, result)
+}
@@ -36,12 +36,21 @@ func setupRoutes(r *gin.Engine) {
 	r.GET("/logout", logout)
 	r.GET("/api/ws-broker-status", brokerStatusWebSocket)
 	r.GET("/api/ws-device-data", deviceDataWebSocket)
+	r.GET("/api/ws-broker-tap", brokerTapWebSocket)
 	// r.POST("/api/img-process", captureImage)
 
 	// Files
 	r.POST("/api/save-image", saveImage)
 
 	r.GET("/api/get-influx-devices", getInfluxDevices)
+
+	// Prometheus-Metriken (Sitzung oder METRICS_TOKEN, siehe metricsHandler)
+	r.GET("/metrics", metricsHandler)
+
+	// Health-Checks für Docker/Kubernetes (503, wenn SQLite oder der MQTT-Broker down ist)
+	r.GET("/healthz", healthzHandler)
+	r.GET("/readyz", readyzHandler)
+
 	// Protected routes
 	authorized := r.Group("/")
 	authorized.Use(AuthRequired)
@@ -54,6 +63,29 @@ func setupRoutes(r *gin.Engine) {
 		authorized.PUT("/api/update-broker-user/:username", addBrokerUser)
 		authorized.DELETE("/api/delete-broker-user/:username", deleteBrokerUser)
 
+		// Broker-Zertifikate (TLS/mTLS)
+		authorized.GET("/api/v1/broker/tls", getBrokerTLS)
+		authorized.GET("/api/v1/broker/tls/server.crt", downloadBrokerCertificate)
+		authorized.PUT("/api/v1/broker/tls/server", uploadBrokerCertificate)
+		authorized.POST("/api/v1/broker/tls/server/regenerate", regenerateBrokerCertificate)
+		authorized.PUT("/api/v1/broker/tls/client-ca", uploadBrokerClientCA)
+		authorized.DELETE("/api/v1/broker/tls/client-ca", deleteBrokerClientCA)
+
+		// MQTT-Clients (Zähler, Subscriptions, Trennen)
+		authorized.GET("/api/broker/clients", getBrokerClients)
+		authorized.GET("/api/broker/clients/:id", getBrokerClient)
+		authorized.POST("/api/broker/clients/:id/disconnect", disconnectBrokerClient)
+
+		// Topic-Explorer
+		authorized.GET("/api/broker/topics", getBrokerTopics)
+
+		// MQTT-Listener (broker_listeners), Änderungen greifen ohne Neustart
+		authorized.GET("/api/v1/broker/listeners", getBrokerListeners)
+		authorized.GET("/api/v1/broker/listeners/:id", getBrokerListener)
+		authorized.POST("/api/v1/broker/listeners", createBrokerListener)
+		authorized.PUT("/api/v1/broker/listeners/:id", updateBrokerListener)
+		authorized.DELETE("/api/v1/broker/listeners/:id", deleteBrokerListener)
+
 		// Logs Routes
 		authorized.GET("/api/logs", grabLogs)
 		authorized.POST("/api/logs/clear", clearLogs)
@@ -68,9 +100,65 @@ func setupRoutes(r *gin.Engine) {
 		authorized.POST("/api/restart-device/:device_id", restartDevice)
 		authorized.GET("/api/browseNodes/:deviceID", browseNodes)
 
+		// OPC-UA Browse (lazy, über die Session des Treibers)
+		authorized.GET("/api/v1/devices/:id/browse", browseChildrenHandler)
+		authorized.GET("/api/v1/devices/:id/browse/attributes", browseAttributesHandler)
+		authorized.GET("/api/v1/devices/:id/browse/search", browseSearchHandler)
+		authorized.DELETE("/api/v1/devices/:id/browse/cache", clearBrowseCacheHandler)
+		authorized.POST("/api/v1/devices/:id/datanodes", addOpcUaDataNodes)
+		authorized.GET("/api/v1/devices/:id/methods", getMethodSignatureHandler)
+		authorized.POST("/api/v1/devices/:id/methods", callMethodHandler)
+		authorized.GET("/api/v1/devices/:id/scan-groups", getScanGroups)
+		authorized.GET("/api/v1/devices/:id/triggers", getTriggerRules)
+		authorized.POST("/api/v1/devices/:id/triggers", addTriggerRule)
+		authorized.PUT("/api/v1/devices/:id/triggers/:triggerId", updateTriggerRule)
+		authorized.DELETE("/api/v1/devices/:id/triggers/:triggerId", deleteTriggerRule)
+
+		// Virtuelle Datenpunkte
+		authorized.POST("/api/v1/virtual/validate", validateExpression)
+
+		// OPC-UA Alarms & Conditions
+		authorized.GET("/api/v1/events", getEvents)
+		authorized.POST("/api/v1/events/:id/acknowledge", acknowledgeEvent)
+		authorized.POST("/api/v1/events/:id/confirm", confirmEvent)
+
+		// Alarme
+		authorized.GET("/api/v1/alarms", getAlarms)
+		authorized.POST("/api/v1/alarms/:id/acknowledge", acknowledgeAlarm)
+		authorized.POST("/api/v1/alarms/:id/shelve", shelveAlarm)
+		authorized.POST("/api/v1/alarms/:id/unshelve", unshelveAlarm)
+		authorized.GET("/api/v1/alarm-rules", getAlarmRules)
+		authorized.POST("/api/v1/alarm-rules", addAlarmRule)
+		authorized.PUT("/api/v1/alarm-rules/:id", updateAlarmRule)
+		authorized.DELETE("/api/v1/alarm-rules/:id", deleteAlarmRule)
+
+		// Benachrichtigungen
+		authorized.GET("/api/v1/notification-channels", getNotificationChannels)
+		authorized.POST("/api/v1/notification-channels", addNotificationChannel)
+		authorized.PUT("/api/v1/notification-channels/:id", updateNotificationChannel)
+		authorized.DELETE("/api/v1/notification-channels/:id", deleteNotificationChannel)
+		authorized.POST("/api/v1/notification-channels/:id/test", testNotificationChannel)
+		authorized.GET("/api/v1/notification-rules", getNotificationRules)
+		authorized.POST("/api/v1/notification-rules", addNotificationRule)
+		authorized.PUT("/api/v1/notification-rules/:id", updateNotificationRule)
+		authorized.DELETE("/api/v1/notification-rules/:id", deleteNotificationRule)
+		authorized.GET("/api/v1/notification-log", getNotificationLog)
+
 		// Historical Data Routes
 		authorized.POST("/api/get-measurements", getMeasurements)
 		authorized.POST("/api/query-data", queryDataHandler)
+		authorized.POST("/api/v1/history/query", queryHistory)
+
+		// Exporte historischer Daten (CSV, Excel, Parquet)
+		authorized.POST("/api/exports", createExport)
+		authorized.GET("/api/exports", getExports)
+		authorized.GET("/api/exports/:id", getExport)
+		authorized.GET("/api/exports/:id/download", downloadExport)
+		authorized.DELETE("/api/exports/:id", deleteExport)
+
+		// InfluxDB-Buckets: Aufbewahrung, Downsampling und Größen
+		authorized.GET("/api/influxdb/buckets", getInfluxBuckets)
+		authorized.POST("/api/influxdb/buckets/apply", applyInfluxBuckets)
 
 		// Data Forwarding Routes
 		authorized.GET("/api/images", getImages)
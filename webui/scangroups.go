@@ -0,0 +1,103 @@
+package webui
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"iot-gateway/driver/opcua"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// getScanGroups liefert die Erfassungsgruppen eines Geräts mit den Zyklusstatistiken des laufenden Treibers
+func getScanGroups(c *gin.Context) {
+	deviceID := c.Param("id")
+	db, err := getDBConnection(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	groups, err := readScanGroups(db, deviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"scanGroups": groups, "stats": opcua.GetScanGroupStats(deviceID)})
+}
+
+// readScanGroups liest die Erfassungsgruppen eines Geräts
+func readScanGroups(db *sql.DB, deviceID string) ([]opcua.ScanGroup, error) {
+	rows, err := db.Query(`SELECT group_id, name, interval_ms FROM scan_groups WHERE device_id = ? ORDER BY group_id`, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []opcua.ScanGroup{}
+	for rows.Next() {
+		var g opcua.ScanGroup
+		if err := rows.Scan(&g.ID, &g.Name, &g.Interval); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// updateScanGroups ersetzt die Erfassungsgruppen eines Geräts, sofern sie im Request enthalten sind,
+// und prüft, ob die Datenpunkte nur existierende Gruppen referenzieren.
+func updateScanGroups(db *sql.DB, deviceId string, device *UpdateDeviceRequest) error {
+	groups := device.ScanGroups
+	if groups == nil {
+		var err error
+		if groups, err = readScanGroups(db, deviceId); err != nil {
+			return fmt.Errorf("error reading scan groups: %v", err)
+		}
+	}
+
+	// Gruppen ohne Nummer erhalten die nächste freie Nummer
+	known := make(map[int64]bool)
+	var maxID int64
+	for _, g := range groups {
+		if g.ID > maxID {
+			maxID = g.ID
+		}
+	}
+	for i := range groups {
+		g := &groups[i]
+		if g.ID == 0 {
+			maxID++
+			g.ID = maxID
+		}
+		if g.ID < 0 || known[g.ID] {
+			return fmt.Errorf("invalid or duplicate scan group id %d", g.ID)
+		}
+		if g.Name == "" || g.Interval <= 0 {
+			return fmt.Errorf("scan group %d requires a name and a positive interval", g.ID)
+		}
+		known[g.ID] = true
+	}
+	for _, dp := range device.DataPoints {
+		if dp.ScanGroup != nil && *dp.ScanGroup != 0 && !known[*dp.ScanGroup] {
+			return fmt.Errorf("datapoint %s references unknown scan group %d", dp.Name, *dp.ScanGroup)
+		}
+	}
+
+	if device.ScanGroups == nil {
+		return nil
+	}
+	if _, err := db.Exec(`DELETE FROM scan_groups WHERE device_id = ?`, deviceId); err != nil {
+		return fmt.Errorf("error clearing old scan groups: %v", err)
+	}
+	for _, g := range groups {
+		_, err := db.Exec(`INSERT INTO scan_groups (device_id, group_id, name, interval_ms) VALUES (?, ?, ?, ?)`, deviceId, g.ID, g.Name, g.Interval)
+		if err != nil {
+			return fmt.Errorf("error inserting scan group: %v", err)
+		}
+	}
+	logrus.Infof("Saved %d scan groups for device %s", len(groups), deviceId)
+	return nil
+}
@@ -1,6 +1,7 @@
 package webui
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"net/http"
@@ -8,6 +9,8 @@ import (
 	"strings"
 	"time"
 
+	dataforwarding "iot-gateway/data-forwarding"
+	"iot-gateway/historian"
 	"iot-gateway/logic"
 
 	"github.com/gin-gonic/gin"
@@ -183,6 +186,11 @@ func updateSystemSetting(c *gin.Context) {
 	}
 
 	logrus.Infof("Setting \"%s\" updated to: \"%s\"", request.Key, request.Value)
+
+	// Buckets und Downsampling-Tasks an geänderte Aufbewahrungen anpassen
+	if strings.HasPrefix(request.Key, "influxdb_") && request.Key != "influxdb_url" && !historian.Enabled() {
+		go dataforwarding.ApplyRetention(context.Background(), db)
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Setting updated successfully"})
 }
 
@@ -197,9 +205,18 @@ func validateSettingValue(db *sql.DB, key, value string) error {
 
 	switch settingType {
 	case "integer":
-		if _, err := strconv.Atoi(value); err != nil {
+		// Leer = Aufbewahrung des bestehenden Buckets beibehalten
+		if key == dataforwarding.SettingRetentionDays && value == "" {
+			return nil
+		}
+		intVal, err := strconv.Atoi(value)
+		if err != nil {
 			return fmt.Errorf("value must be an integer")
 		}
+		// Aufbewahrungen in Tagen
+		if strings.HasSuffix(key, "_days") && intVal < 0 {
+			return fmt.Errorf("value must not be negative")
+		}
 	case "boolean":
 		if value != "true" && value != "false" {
 			return fmt.Errorf("value must be 'true' or 'false'")
@@ -302,6 +319,11 @@ func resetSystemSettings(c *gin.Context) {
 	}{
 		{"node_red_url", "http://node-red:1880", "string", "Node-RED Web-Interface URL", "integration"},
 		{"influxdb_url", "http://influxdb:8086", "string", "InfluxDB Server URL", "integration"},
+		{"influxdb_manage_buckets", "false", "boolean", "InfluxDB-Buckets und Downsampling-Tasks vom Gateway verwalten", "influxdb"},
+		{"influxdb_retention_days", "", "integer", "Aufbewahrung der Rohdaten in Tagen (0 = unbegrenzt, leer = bestehenden Bucket nicht ändern, neue mit 7 Tagen)", "influxdb"},
+		{"influxdb_downsampling", "false", "boolean", "Werte zusätzlich in 1-Minuten- und 1-Stunden-Buckets zusammenfassen", "influxdb"},
+		{"influxdb_downsampling_1m_days", "90", "integer", "Aufbewahrung der 1-Minuten-Werte in Tagen (0 = unbegrenzt)", "influxdb"},
+		{"influxdb_downsampling_1h_days", "1825", "integer", "Aufbewahrung der 1-Stunden-Werte in Tagen (0 = unbegrenzt)", "influxdb"},
 	}
 
 	for _, setting := range defaultSettings {
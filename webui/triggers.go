@@ -0,0 +1,195 @@
+package webui
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"iot-gateway/driver/opcua"
+	"iot-gateway/logic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getTriggerRules liefert die Trigger-Regeln eines Geräts
+func getTriggerRules(c *gin.Context) {
+	db, err := getDBConnection(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	rules, err := queryTriggerRules(db, "WHERE device_id = ?", c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"triggers": rules})
+}
+
+// addTriggerRule legt eine Trigger-Regel an und startet den Treiber neu
+func addTriggerRule(c *gin.Context) {
+	db, err := getDBConnection(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule := opcua.TriggerRule{Enabled: true}
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	deviceID := c.Param("id")
+	if err := validateTriggerRule(db, deviceID, &rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	res, err := db.Exec(`INSERT INTO trigger_rules (device_id, name, trigger_datapoint, mode, datapoints, ack_address, enabled) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		deviceID, rule.Name, rule.Trigger, rule.Mode, strings.Join(rule.Datapoints, ","), rule.AckAddress, rule.Enabled)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	rule.ID, _ = res.LastInsertId()
+	rule.DeviceID, _ = strconv.ParseInt(deviceID, 10, 64)
+
+	go logic.RestartDevice(db, deviceID)
+	c.JSON(http.StatusOK, gin.H{"trigger": rule})
+}
+
+// updateTriggerRule ändert eine Trigger-Regel und startet den Treiber neu
+func updateTriggerRule(c *gin.Context) {
+	db, err := getDBConnection(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("triggerId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		return
+	}
+	var rule opcua.TriggerRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	deviceID := c.Param("id")
+	if err := validateTriggerRule(db, deviceID, &rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	res, err := db.Exec(`UPDATE trigger_rules SET name = ?, trigger_datapoint = ?, mode = ?, datapoints = ?, ack_address = ?, enabled = ? WHERE id = ? AND device_id = ?`,
+		rule.Name, rule.Trigger, rule.Mode, strings.Join(rule.Datapoints, ","), rule.AckAddress, rule.Enabled, id, deviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Trigger rule not found"})
+		return
+	}
+	rule.ID = id
+	rule.DeviceID, _ = strconv.ParseInt(deviceID, 10, 64)
+
+	go logic.RestartDevice(db, deviceID)
+	c.JSON(http.StatusOK, gin.H{"trigger": rule})
+}
+
+// deleteTriggerRule löscht eine Trigger-Regel und startet den Treiber neu
+func deleteTriggerRule(c *gin.Context) {
+	db, err := getDBConnection(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	deviceID := c.Param("id")
+	res, err := db.Exec(`DELETE FROM trigger_rules WHERE id = ? AND device_id = ?`, c.Param("triggerId"), deviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Trigger rule not found"})
+		return
+	}
+
+	go logic.RestartDevice(db, deviceID)
+	c.JSON(http.StatusOK, gin.H{"message": "Trigger rule deleted successfully"})
+}
+
+// queryTriggerRules liest Trigger-Regeln mit optionaler WHERE-Klausel
+func queryTriggerRules(db *sql.DB, where string, args ...interface{}) ([]opcua.TriggerRule, error) {
+	rows, err := db.Query(`SELECT id, device_id, name, trigger_datapoint, mode, datapoints, COALESCE(ack_address, ''), enabled
+		FROM trigger_rules `+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []opcua.TriggerRule{}
+	for rows.Next() {
+		var r opcua.TriggerRule
+		var datapoints string
+		if err := rows.Scan(&r.ID, &r.DeviceID, &r.Name, &r.Trigger, &r.Mode, &datapoints, &r.AckAddress, &r.Enabled); err != nil {
+			return nil, err
+		}
+		r.Datapoints = []string{}
+		for _, id := range strings.Split(datapoints, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				r.Datapoints = append(r.Datapoints, id)
+			}
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// validateTriggerRule prüft eine Regel gegen die Datenpunkte des Geräts
+func validateTriggerRule(db *sql.DB, deviceID string, rule *opcua.TriggerRule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+
+	var deviceType string
+	if err := db.QueryRow(`SELECT type FROM devices WHERE id = ?`, deviceID).Scan(&deviceType); err != nil {
+		return fmt.Errorf("device %s not found", deviceID)
+	}
+	var table string
+	switch deviceType {
+	case "s7":
+		table = "s7_datapoints"
+	case "opc-ua":
+		table = "opcua_datanodes"
+	default:
+		return fmt.Errorf("trigger rules are only supported for S7 and OPC-UA devices")
+	}
+
+	rows, err := db.Query(`SELECT datapointId FROM `+table+` WHERE device_id = ?`, deviceID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	known := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		known[id] = true
+	}
+
+	for _, id := range append([]string{rule.Trigger}, rule.Datapoints...) {
+		if !known[id] {
+			return fmt.Errorf("datapoint %s does not belong to device %s", id, deviceID)
+		}
+	}
+	return nil
+}
@@ -5,7 +5,6 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"errors"
-	dataforwarding "iot-gateway/data-forwarding"
 	"iot-gateway/logic"
 	"net/http"
 	"os"
@@ -111,37 +110,28 @@ func monitorWebSocket(conn *websocket.Conn) {
 
 func restartGatewayHandler(c *gin.Context) {
 	// restart the gateway
-	RestartGateway(c)
+	if err := RestartGateway(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Gateway restarted successfully"})
 }
 
-// RestartGateway accepts either *gin.Context or *sql.DB as argument
-func RestartGateway(c *gin.Context) {
-	var db *sql.DB
-	var server *MQTT.Server
-
-	db = c.MustGet("db").(*sql.DB)
-	server = c.MustGet("server").(*MQTT.Server)
-
-	// Stop InfluxDB Writer
-	dataforwarding.StopInfluxDBWriter()
-
-	// Restart MQTT Broker
-	// server = mqtt_broker.RestartBroker(db)
-
-	// update server in context
-	// c.Set("server", server)
-
-	// Restart All Drivers
-	logic.RestartAllDrivers(db, server)
-
-	dataforwarding.StartInfluxDBWriter(db, server)
-
-	logrus.Info("Gateway restarted successfully")
+// RestartGateway startet die neustartbaren Komponenten (InfluxDB-Writer, Historian, Treiber und Image Capture)
+// über den Lifecycle-Manager geordnet neu. Der Writer schreibt vor dem Neustart seinen Buffer.
+func RestartGateway() error {
+	if gatewayLifecycle == nil {
+		return errors.New("lifecycle manager not initialized")
+	}
+	if err := gatewayLifecycle.Restart(); err != nil {
+		logrus.Errorf("Gateway restart failed: %v", err)
+		return err
+	}
 
 	// Manual trigger to run Garbage Collector
 	logrus.Info("Running garbage collector after restart.")
 	runtime.GC()
+	return nil
 }
 
 func RestartDriver(c *gin.Context) {
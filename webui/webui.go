@@ -1,12 +1,15 @@
 package webui
 
 import (
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"net/http"
 	"os"
 	"strconv"
 
+	"iot-gateway/lifecycle"
+
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
@@ -30,6 +33,8 @@ type Config struct {
 var server *MQTT.Server
 var stateConnection bool
 var num_images_db int
+var httpServer *http.Server
+var gatewayLifecycle *lifecycle.Manager
 
 // Main function to start the web server
 func Main(db *sql.DB, serverF *MQTT.Server) {
@@ -44,6 +49,9 @@ func Main(db *sql.DB, serverF *MQTT.Server) {
 		logrus.Fatal("Database connection is not initalized.")
 	}
 
+	// Export-Verzeichnis leeren und abgelaufene Exporte regelmäßig löschen
+	initExports()
+
 	// Lade die Konfiguration
 	config, err := loadConfigFromEnv()
 	if err != nil {
@@ -96,8 +104,9 @@ func Main(db *sql.DB, serverF *MQTT.Server) {
 			logrus.Fatal("TLS certificate and key must be specified for HTTPS.")
 		}
 		logrus.Infof("Starting HTTPS server on port %s", config.WebUI.HTTPSPort)
-		err = r.RunTLS(":"+config.WebUI.HTTPSPort, config.WebUI.TLSCert, config.WebUI.TLSKey)
-		if err != nil {
+		httpServer = &http.Server{Addr: ":" + config.WebUI.HTTPSPort, Handler: r}
+		err = httpServer.ListenAndServeTLS(config.WebUI.TLSCert, config.WebUI.TLSKey)
+		if err != nil && err != http.ErrServerClosed {
 			logrus.Fatal("Failed to start HTTPS server: ", err)
 		}
 	} else {
@@ -107,13 +116,23 @@ func Main(db *sql.DB, serverF *MQTT.Server) {
 			port = "8080" // Fallback auf den Standardport
 		}
 		logrus.Infof("Starting HTTP server on port %s", port)
-		err = r.Run(":" + port)
-		if err != nil {
+		httpServer = &http.Server{Addr: ":" + port, Handler: r}
+		err = httpServer.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
 			logrus.Fatal("Failed to start HTTP server: ", err)
 		}
 	}
 }
 
-func StopWebUI() {
-	server.Close()
+// StopWebUI beendet den Webserver, laufende Requests werden bis zum Ablauf von ctx abgearbeitet
+func StopWebUI(ctx context.Context) error {
+	if httpServer == nil {
+		return nil
+	}
+	return httpServer.Shutdown(ctx)
+}
+
+// SetLifecycle übergibt den Lifecycle-Manager, über den /api/restart das Gateway neu startet
+func SetLifecycle(m *lifecycle.Manager) {
+	gatewayLifecycle = m
 }